@@ -13,6 +13,7 @@ import (
 	"github.com/fiducia/backend/internal/database"
 	"github.com/fiducia/backend/internal/handlers"
 	"github.com/fiducia/backend/internal/middleware"
+	"github.com/fiducia/backend/internal/queue"
 )
 
 func main() {
@@ -59,8 +60,16 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start Campaign Worker
-	router.StartCampaignWorker(ctx)
+	// Start Campaign Worker (can be disabled when running cmd/worker separately)
+	if cfg.CampaignWorkerEnabled {
+		router.StartCampaignWorker(ctx)
+	}
+
+	// Start the message workers sending queued messages
+	var messageWorkers *queue.Pool
+	if cfg.MessageWorkerEnabled {
+		messageWorkers = router.StartMessageWorkers(ctx)
+	}
 
 	// Create server
 	server := &http.Server{
@@ -85,7 +94,6 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	slog.Info("shutting down server...")
 	slog.Info("shutting down server...")
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
@@ -94,5 +102,13 @@ func main() {
 		slog.Error("server forced to shutdown", "error", err)
 	}
 
+	// Stop the background workers and let the messages being sent finish
+	cancel()
+	if messageWorkers != nil {
+		if err := messageWorkers.Drain(shutdownCtx); err != nil {
+			slog.Error("message workers not drained", "error", err)
+		}
+	}
+
 	slog.Info("server stopped")
 }
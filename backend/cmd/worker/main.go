@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fiducia/backend/internal/config"
+	"github.com/fiducia/backend/internal/database"
+	"github.com/fiducia/backend/internal/queue"
+	"github.com/fiducia/backend/internal/repository"
+	"github.com/fiducia/backend/internal/services"
+	"github.com/fiducia/backend/pkg/telephony"
+)
+
+// Standalone campaign and message worker. Run one or more instances alongside API
+// replicas started with CAMPAIGN_WORKER_ENABLED=false and MESSAGE_WORKER_ENABLED=false.
+func main() {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+	slog.SetDefault(logger)
+
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		slog.Error("failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := db.Migrate(); err != nil {
+		slog.Error("failed to run migrations", "error", err)
+		os.Exit(1)
+	}
+
+	voiceSvc := services.NewVoiceService(cfg.ElevenLabsAPIKey, "/tmp/fiducia/voice", cfg.BaseURL)
+	lineRepo := repository.NewPendingLineRepository(db.Pool)
+	msgRepo := repository.NewMessageRepository(db.Pool)
+
+	// Messages only send with a configured provider, chosen per cabinet
+	providers := services.WhatsAppProviders(cfg)
+	taskRepo := repository.NewTaskRepository(db.Pool)
+	clientRepo := repository.NewClientRepository(db.Pool)
+	cabinetRepo := repository.NewCabinetRepository(db)
+	var callSvc *services.PhoneCallService
+	provider, err := telephony.NewProvider(cfg.TelephonyProvider, cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioVoiceNumber)
+	if err != nil {
+		slog.Error("invalid telephony configuration", "error", err)
+		os.Exit(1)
+	}
+	if provider != nil {
+		callSvc = services.NewPhoneCallService(provider, voiceSvc, cfg.ElevenLabsVoiceID, cfg.BaseURL,
+			msgRepo, lineRepo, clientRepo, taskRepo)
+	}
+	policy, err := services.AntiBanDefaults(cfg)
+	if err != nil {
+		slog.Error("invalid anti-ban configuration", "error", err)
+		os.Exit(1)
+	}
+	msgQueue, err := queue.Open(queue.Options{
+		Backend:           cfg.QueueBackend,
+		RedisURL:          cfg.RedisURL,
+		Pool:              db.Pool,
+		VisibilityTimeout: cfg.MessageVisibilityTimeout,
+		SenderLimits:      policy.Sender,
+	})
+	if err != nil {
+		slog.Error("failed to connect to the message queue", "error", err)
+		os.Exit(1)
+	}
+	defer msgQueue.Close()
+	messageSvc := services.NewMessageService(providers, callSvc, msgRepo, lineRepo, clientRepo, cabinetRepo, msgQueue)
+	messageSvc.SetDefaultPolicy(policy)
+	dispatcher := services.NewCampaignDispatcher(messageSvc, voiceSvc, cfg.ElevenLabsVoiceID, msgRepo, lineRepo, taskRepo)
+	rules := services.NewRuleEvaluator(msgRepo, repository.NewDocumentRepository(db.Pool))
+
+	engine := services.NewCampaignEngine(
+		db.Pool,
+		repository.NewCampaignRepository(db.Pool),
+		lineRepo,
+		repository.NewCampaignExecutionRepository(db.Pool),
+		cabinetRepo,
+		repository.NewCampaignCycleRepository(db.Pool),
+		dispatcher,
+		rules,
+	)
+	engine.SetConcurrency(cfg.CampaignWorkerConcurrency)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool := queue.NewPool(msgQueue, messageSvc.ProcessQueuedMessage, messageSvc.FailQueuedMessage,
+		cfg.MessageWorkerConcurrency, cfg.MessageMaxAttempts)
+	go pool.Run(ctx)
+
+	services.NewCampaignWorker(engine, cfg.CampaignWorkerInterval).Run(ctx)
+
+	// Let the messages being sent finish
+	drainCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := pool.Drain(drainCtx); err != nil {
+		slog.Error("message workers not drained", "error", err)
+	}
+	slog.Info("worker stopped")
+}
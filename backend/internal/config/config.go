@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -23,9 +25,25 @@ type Config struct {
 	RedisURL string
 
 	// WhatsApp (Twilio)
-	TwilioAccountSID  string
-	TwilioAuthToken   string
-	TwilioPhoneNumber string
+	TwilioAccountSID      string
+	TwilioAuthToken       string
+	TwilioPhoneNumber     string
+	TwilioSessionTemplate string // Content SID sent outside the 24h session window
+
+	// WhatsApp (Meta Cloud API)
+	WhatsAppProvider     string // Provider of cabinets that did not choose one: twilio or meta
+	MetaAccessToken      string
+	MetaPhoneNumberID    string
+	MetaPhoneNumber      string // Number of MetaPhoneNumberID, throttled by the queue
+	MetaAPIVersion       string
+	MetaTemplateLanguage string
+	MetaVerifyToken      string // Webhook verification handshake
+	MetaAppSecret        string // Key of the webhook signatures
+	MetaSessionTemplate  string // Template sent outside the 24h session window
+
+	// Phone calls
+	TelephonyProvider string // twilio or fake; calls are disabled when empty
+	TwilioVoiceNumber string // Caller ID of phone calls
 
 	// ElevenLabs
 	ElevenLabsAPIKey  string
@@ -36,6 +54,28 @@ type Config struct {
 
 	// Auth
 	JWTSecret string
+
+	// Campaign worker
+	CampaignWorkerEnabled     bool          // Run the worker inside the API process
+	CampaignWorkerInterval    time.Duration // Delay between two campaign cycles
+	CampaignWorkerConcurrency int           // Executions processed in parallel per worker
+
+	// Message workers
+	QueueBackend             string        // redis or postgres
+	OpsCabinetIDs            []string      // Cabinets whose admins see the whole queue
+	MessageWorkerEnabled     bool          // Send queued messages inside the API process
+	MessageWorkerConcurrency int           // Messages sent in parallel per process
+	MessageMaxAttempts       int           // Sends attempted before a message is marked failed
+	MessageVisibilityTimeout time.Duration // Lease of a job being sent before it is re-queued
+
+	// Sender number limits (0 disables a limit)
+	SenderPerMinute int
+	SenderPerHour   int
+	SenderPerDay    int
+	SenderWarmUp    string // Daily limits of a new number, "day:limit,...", "none" to disable
+
+	// Messages a client may receive over 7 days, manual and campaign (0 disables)
+	MaxMessagesPerClientPerWeek int
 }
 
 // Load reads configuration from environment variables
@@ -44,19 +84,51 @@ func Load() (*Config, error) {
 	_ = godotenv.Load()
 
 	cfg := &Config{
-		Port:              getEnv("PORT", "8080"),
-		Environment:       getEnv("ENVIRONMENT", "development"),
-		AllowedOrigins:    strings.Split(getEnv("ALLOWED_ORIGINS", "http://localhost:3000"), ","),
-		BaseURL:           getEnv("BASE_URL", "http://localhost:8080"),
-		DatabaseURL:       getEnv("DATABASE_URL", ""),
-		RedisURL:          getEnv("REDIS_URL", "redis://localhost:6379"),
-		TwilioAccountSID:  getEnv("TWILIO_ACCOUNT_SID", ""),
-		TwilioAuthToken:   getEnv("TWILIO_AUTH_TOKEN", ""),
-		TwilioPhoneNumber: getEnv("TWILIO_PHONE_NUMBER", ""),
+		Port:                  getEnv("PORT", "8080"),
+		Environment:           getEnv("ENVIRONMENT", "development"),
+		AllowedOrigins:        strings.Split(getEnv("ALLOWED_ORIGINS", "http://localhost:3000"), ","),
+		BaseURL:               getEnv("BASE_URL", "http://localhost:8080"),
+		DatabaseURL:           getEnv("DATABASE_URL", ""),
+		RedisURL:              getEnv("REDIS_URL", "redis://localhost:6379"),
+		TwilioAccountSID:      getEnv("TWILIO_ACCOUNT_SID", ""),
+		TwilioAuthToken:       getEnv("TWILIO_AUTH_TOKEN", ""),
+		TwilioPhoneNumber:     getEnv("TWILIO_PHONE_NUMBER", ""),
+		TwilioSessionTemplate: getEnv("TWILIO_SESSION_TEMPLATE", ""),
+		TelephonyProvider:     getEnv("TELEPHONY_PROVIDER", ""),
+
+		WhatsAppProvider:     getEnv("WHATSAPP_PROVIDER", "twilio"),
+		MetaAccessToken:      getEnv("META_ACCESS_TOKEN", ""),
+		MetaPhoneNumberID:    getEnv("META_PHONE_NUMBER_ID", ""),
+		MetaPhoneNumber:      getEnv("META_PHONE_NUMBER", ""),
+		MetaAPIVersion:       getEnv("META_API_VERSION", "v21.0"),
+		MetaTemplateLanguage: getEnv("META_TEMPLATE_LANGUAGE", "fr"),
+		MetaVerifyToken:      getEnv("META_VERIFY_TOKEN", ""),
+		MetaAppSecret:        getEnv("META_APP_SECRET", ""),
+		MetaSessionTemplate:  getEnv("META_SESSION_TEMPLATE", ""),
+
+		TwilioVoiceNumber: getEnv("TWILIO_VOICE_NUMBER", getEnv("TWILIO_PHONE_NUMBER", "")),
 		ElevenLabsAPIKey:  getEnv("ELEVENLABS_API_KEY", ""),
 		ElevenLabsVoiceID: getEnv("ELEVENLABS_VOICE_ID", ""), // Can be set after cloning
 		OpenAIAPIKey:      getEnv("OPENAI_API_KEY", ""),
 		JWTSecret:         getEnv("JWT_SECRET", "fiducia-secret-dev-key-change-in-prod"),
+
+		CampaignWorkerEnabled:     getEnvBool("CAMPAIGN_WORKER_ENABLED", true),
+		CampaignWorkerInterval:    getEnvDuration("CAMPAIGN_WORKER_INTERVAL", time.Minute),
+		CampaignWorkerConcurrency: getEnvInt("CAMPAIGN_WORKER_CONCURRENCY", 4),
+
+		QueueBackend:             getEnv("QUEUE_BACKEND", "redis"),
+		OpsCabinetIDs:            getEnvList("OPS_CABINET_IDS"),
+		MessageWorkerEnabled:     getEnvBool("MESSAGE_WORKER_ENABLED", true),
+		MessageWorkerConcurrency: getEnvInt("MESSAGE_WORKER_CONCURRENCY", 4),
+		MessageMaxAttempts:       getEnvInt("MESSAGE_MAX_ATTEMPTS", 5),
+		MessageVisibilityTimeout: getEnvDuration("MESSAGE_VISIBILITY_TIMEOUT", 5*time.Minute),
+
+		SenderPerMinute: getEnvInt("SENDER_PER_MINUTE", 20),
+		SenderPerHour:   getEnvInt("SENDER_PER_HOUR", 300),
+		SenderPerDay:    getEnvInt("SENDER_PER_DAY", 1000),
+		SenderWarmUp:    getEnv("SENDER_WARMUP", "1:50,4:150,8:400,15:1000"),
+
+		MaxMessagesPerClientPerWeek: getEnvInt("MAX_MESSAGES_PER_CLIENT_PER_WEEK", 5),
 	}
 
 	// Validate required config in production
@@ -79,9 +151,50 @@ func (c *Config) IsProduction() bool {
 	return c.Environment == "production"
 }
 
+// IsOpsCabinet returns true if the admins of the cabinet operate the whole platform
+func (c *Config) IsOpsCabinet(cabinetID string) bool {
+	for _, id := range c.OpsCabinetIDs {
+		if id == cabinetID {
+			return true
+		}
+	}
+	return false
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
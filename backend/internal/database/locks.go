@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Advisory lock keys used for leader election between replicas
+const (
+	LockCampaignEnrollment int64 = 0x46494401 // "FID" + 1
+)
+
+// TryAdvisoryLock attempts to take a session-level advisory lock on a dedicated
+// connection. When acquired, the returned unlock function must be called to
+// release both the lock and the connection.
+func TryAdvisoryLock(ctx context.Context, pool *pgxpool.Pool, key int64) (func(), bool, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Release()
+		return nil, false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Release()
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		// Use a fresh context: the caller's one may already be cancelled
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			slog.Warn("failed to release advisory lock", "key", key, "error", err)
+			// Drop the connection so the session (and its lock) goes away
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}
+
+	return unlock, true, nil
+}
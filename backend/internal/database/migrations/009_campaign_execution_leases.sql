@@ -0,0 +1,16 @@
+-- Leases so that several worker replicas never process the same execution twice
+ALTER TABLE campaign_executions ADD COLUMN IF NOT EXISTS locked_by VARCHAR(255);
+ALTER TABLE campaign_executions ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+-- A line is enrolled at most once per campaign. Existing duplicates are kept for their
+-- history but stopped, the oldest execution of the line carrying on.
+UPDATE campaign_executions a
+SET status = 'stopped', stop_reason = 'duplicate', next_step_scheduled_at = NULL, updated_at = NOW()
+FROM campaign_executions b
+WHERE a.campaign_id = b.campaign_id
+  AND a.pending_line_id = b.pending_line_id
+  AND (a.created_at > b.created_at OR (a.created_at = b.created_at AND a.id > b.id));
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_campaign_executions_campaign_line ON campaign_executions(campaign_id, pending_line_id)
+    WHERE stop_reason IS DISTINCT FROM 'duplicate';
+CREATE INDEX IF NOT EXISTS idx_campaign_executions_due ON campaign_executions(next_step_scheduled_at) WHERE status IN ('pending', 'running');
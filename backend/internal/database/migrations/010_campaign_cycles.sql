@@ -0,0 +1,18 @@
+-- Last campaign cycle of each worker, for monitoring
+CREATE TABLE IF NOT EXISTS campaign_cycles (
+    worker_id VARCHAR(255) PRIMARY KEY,
+    leader BOOLEAN NOT NULL DEFAULT false,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    enrolled BIGINT NOT NULL DEFAULT 0,
+    stopped BIGINT NOT NULL DEFAULT 0,
+    claimed BIGINT NOT NULL DEFAULT 0,
+    executed BIGINT NOT NULL DEFAULT 0,
+    completed BIGINT NOT NULL DEFAULT 0,
+    rescheduled BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaign_cycles_finished_at ON campaign_cycles(finished_at);
@@ -0,0 +1,30 @@
+-- Conditional rules on steps and escalation when a campaign is exhausted
+ALTER TABLE campaign_steps ADD COLUMN IF NOT EXISTS rules JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS escalate_on_exhaustion BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS escalate_after_hours INTEGER NOT NULL DEFAULT 48;
+
+-- Link outbound messages to the campaign step that sent them
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS campaign_execution_id UUID REFERENCES campaign_executions(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS campaign_step_order INTEGER;
+CREATE INDEX IF NOT EXISTS idx_messages_campaign_execution ON messages(campaign_execution_id);
+
+-- Manual actions for collaborators
+CREATE TABLE IF NOT EXISTS tasks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    cabinet_id UUID NOT NULL REFERENCES cabinets(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'open',
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    pending_line_id UUID REFERENCES pending_lines(id) ON DELETE CASCADE,
+    client_id UUID REFERENCES clients(id) ON DELETE SET NULL,
+    assigned_to UUID REFERENCES collaborators(id) ON DELETE SET NULL,
+    campaign_execution_id UUID REFERENCES campaign_executions(id) ON DELETE SET NULL,
+    completed_by UUID,
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_cabinet_status ON tasks(cabinet_id, status);
+CREATE INDEX IF NOT EXISTS idx_tasks_assigned_to ON tasks(assigned_to);
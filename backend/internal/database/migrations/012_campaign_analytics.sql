@@ -0,0 +1,5 @@
+-- Channel actually used by a campaign step (rules may switch it)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS campaign_channel VARCHAR(50);
+
+CREATE INDEX IF NOT EXISTS idx_messages_client_direction ON messages(client_id, direction, created_at);
+CREATE INDEX IF NOT EXISTS idx_documents_pending_line_created ON documents(pending_line_id, created_at);
@@ -0,0 +1,26 @@
+-- Campaigns may relance a client once for all its open lines instead of once per line
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS group_by VARCHAR(20) NOT NULL DEFAULT 'line';
+
+-- Executions grouped by client have a client instead of a single line
+ALTER TABLE campaign_executions ALTER COLUMN pending_line_id DROP NOT NULL;
+ALTER TABLE campaign_executions ADD COLUMN IF NOT EXISTS client_id UUID REFERENCES clients(id) ON DELETE CASCADE;
+
+-- At most one active execution per client and campaign
+CREATE UNIQUE INDEX IF NOT EXISTS uq_campaign_executions_active_client
+    ON campaign_executions(campaign_id, client_id)
+    WHERE client_id IS NOT NULL AND status IN ('pending', 'running');
+
+-- Lines covered by a client execution; resolved lines drop out of the next relances
+CREATE TABLE IF NOT EXISTS campaign_execution_lines (
+    execution_id UUID NOT NULL REFERENCES campaign_executions(id) ON DELETE CASCADE,
+    campaign_id UUID NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    pending_line_id UUID NOT NULL REFERENCES pending_lines(id) ON DELETE CASCADE,
+    added_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    resolution pending_line_status,
+    PRIMARY KEY (execution_id, pending_line_id)
+);
+
+-- A line is enrolled at most once per campaign
+CREATE UNIQUE INDEX IF NOT EXISTS uq_campaign_execution_lines_campaign_line ON campaign_execution_lines(campaign_id, pending_line_id);
+CREATE INDEX IF NOT EXISTS idx_campaign_execution_lines_open ON campaign_execution_lines(execution_id) WHERE resolved_at IS NULL;
@@ -0,0 +1,26 @@
+-- Executions can be paused by a collaborator, optionally until a given date
+ALTER TABLE campaign_executions ADD COLUMN IF NOT EXISTS paused_until TIMESTAMP WITH TIME ZONE;
+
+-- A paused client execution still covers the client
+DROP INDEX IF EXISTS uq_campaign_executions_active_client;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_campaign_executions_active_client
+    ON campaign_executions(campaign_id, client_id)
+    WHERE client_id IS NOT NULL AND status IN ('pending', 'running', 'paused');
+
+CREATE INDEX IF NOT EXISTS idx_campaign_executions_paused ON campaign_executions(paused_until) WHERE status = 'paused';
+
+-- Audit trail of manual actions on executions
+CREATE TABLE IF NOT EXISTS campaign_execution_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    execution_id UUID NOT NULL REFERENCES campaign_executions(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL, -- pause, resume, skip, restart, stop
+    reason TEXT,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL, -- NULL when done by the worker
+    from_status VARCHAR(50) NOT NULL,
+    to_status VARCHAR(50) NOT NULL,
+    from_step INTEGER NOT NULL,
+    to_step INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaign_execution_events_execution ON campaign_execution_events(execution_id, created_at);
@@ -0,0 +1,5 @@
+-- Weighted message variants of a step for A/B testing
+ALTER TABLE campaign_steps ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]';
+
+-- Variant used by a campaign message
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS campaign_variant VARCHAR(50);
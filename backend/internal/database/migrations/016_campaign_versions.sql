@@ -0,0 +1,31 @@
+-- Immutable versions of campaign steps; campaign_steps holds the current version
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS campaign_versions (
+    campaign_id UUID NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    steps JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (campaign_id, version)
+);
+
+-- Existing campaigns start at version 1 with their current steps
+INSERT INTO campaign_versions (campaign_id, version, steps)
+SELECT c.id, 1, COALESCE((
+    SELECT jsonb_agg(jsonb_build_object(
+        'step_order', s.step_order,
+        'delay_hours', s.delay_hours,
+        'channel', s.channel,
+        'template_id', s.template_id,
+        'config', s.config,
+        'rules', s.rules,
+        'variants', s.variants
+    ) ORDER BY s.step_order)
+    FROM campaign_steps s WHERE s.campaign_id = c.id
+), '[]')
+FROM campaigns c
+ON CONFLICT DO NOTHING;
+
+-- Executions are pinned to the version they were enrolled on
+ALTER TABLE campaign_executions ADD COLUMN IF NOT EXISTS campaign_version INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_campaign_executions_version ON campaign_executions(campaign_id, campaign_version);
@@ -0,0 +1,10 @@
+-- Campaign targeting filters and priority between campaigns matching the same line
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS targeting JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_enrollment ON campaigns(cabinet_id, priority DESC, created_at)
+    WHERE is_active = true AND trigger_type = 'on_pending';
+
+-- Free tags used to target clients
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS idx_clients_tags ON clients USING GIN (tags);
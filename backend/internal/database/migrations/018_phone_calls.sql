@@ -0,0 +1,7 @@
+-- Automated phone calls are stored as messages of type call
+ALTER TYPE message_type ADD VALUE IF NOT EXISTS 'call';
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS call_status VARCHAR(20);      -- completed, busy, no-answer, failed, canceled
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS call_duration INTEGER;        -- Seconds
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS recording_url TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS dtmf_response VARCHAR(10);    -- Key pressed by the callee
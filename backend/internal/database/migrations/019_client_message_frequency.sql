@@ -0,0 +1,4 @@
+-- Weekly frequency cap per client: outbound messages of a client over 7 days
+CREATE INDEX IF NOT EXISTS idx_messages_client_outbound
+    ON messages (client_id, created_at)
+    WHERE direction = 'outbound';
@@ -0,0 +1,38 @@
+-- Postgres message queue (QUEUE_BACKEND=postgres), an alternative to Redis
+CREATE TABLE IF NOT EXISTS message_jobs (
+    id VARCHAR(64) PRIMARY KEY,                    -- Message ID
+    cabinet_id VARCHAR(64),
+    payload JSONB NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'queued',   -- queued, leased, dead
+    scheduled_at TIMESTAMPTZ NOT NULL,
+    lease_expires_at TIMESTAMPTZ,
+    deliveries INTEGER NOT NULL DEFAULT 0,         -- Times the job was dequeued
+    last_error TEXT,
+    failed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_jobs_ready ON message_jobs (scheduled_at, created_at) WHERE state = 'queued';
+CREATE INDEX IF NOT EXISTS idx_message_jobs_leases ON message_jobs (lease_expires_at) WHERE state = 'leased';
+CREATE INDEX IF NOT EXISTS idx_message_jobs_dead ON message_jobs (cabinet_id, failed_at DESC) WHERE state = 'dead';
+
+-- Messages per recipient phone and day
+CREATE TABLE IF NOT EXISTS message_phone_counts (
+    phone VARCHAR(32) NOT NULL,
+    day DATE NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (phone, day)
+);
+
+-- Token buckets of the sender numbers
+CREATE TABLE IF NOT EXISTS message_sender_buckets (
+    key VARCHAR(100) PRIMARY KEY,                  -- sender:minute, sender:hour, sender:day
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- First use of each sender number, for the warm-up
+CREATE TABLE IF NOT EXISTS message_senders (
+    sender VARCHAR(32) PRIMARY KEY,
+    since TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
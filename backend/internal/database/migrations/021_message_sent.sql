@@ -0,0 +1,9 @@
+-- Messages sent per minute by cabinet and sender, for the queue report of the
+-- Postgres queue (QUEUE_BACKEND=postgres). Rows older than 2 hours are purged.
+CREATE TABLE IF NOT EXISTS message_sent (
+    minute TIMESTAMPTZ NOT NULL,
+    cabinet_id VARCHAR(64) NOT NULL DEFAULT '',
+    sender VARCHAR(32) NOT NULL DEFAULT '',
+    sent INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (minute, cabinet_id, sender)
+);
@@ -0,0 +1,2 @@
+-- Provider error code of messages that failed after being sent (delivery status callbacks)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS error_code VARCHAR(20);
@@ -0,0 +1,11 @@
+-- Webhook events already received, to reject replays of signed provider webhooks.
+-- A message and each of its delivery statuses are separate events. Rows older than
+-- 7 days are purged.
+CREATE TABLE IF NOT EXISTS webhook_events (
+    provider VARCHAR(20) NOT NULL,
+    event_key VARCHAR(255) NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, event_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_received_at ON webhook_events(received_at);
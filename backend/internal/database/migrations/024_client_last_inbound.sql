@@ -0,0 +1,12 @@
+-- Time of the last message received from a client, opening its 24-hour WhatsApp
+-- session window: outside of it, messages are sent as approved templates
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS last_inbound_at TIMESTAMPTZ;
+
+UPDATE clients c SET last_inbound_at = m.last_inbound_at
+FROM (
+    SELECT client_id, MAX(created_at) AS last_inbound_at
+    FROM messages
+    WHERE direction = 'inbound' AND client_id IS NOT NULL
+    GROUP BY client_id
+) m
+WHERE c.id = m.client_id AND c.last_inbound_at IS NULL;
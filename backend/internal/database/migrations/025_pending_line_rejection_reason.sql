@@ -0,0 +1,3 @@
+-- Why a pending line was rejected, e.g. a personal expense reported by the client
+-- with the quick-reply buttons of a relance
+ALTER TABLE pending_lines ADD COLUMN IF NOT EXISTS rejection_reason TEXT;
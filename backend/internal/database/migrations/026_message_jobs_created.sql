@@ -0,0 +1,2 @@
+-- Oldest jobs of the queue report (QUEUE_BACKEND=postgres)
+CREATE INDEX IF NOT EXISTS idx_message_jobs_created ON message_jobs (created_at) WHERE state <> 'dead';
@@ -0,0 +1,3 @@
+-- Automatic answers to a client, e.g. the acknowledgment of a relance button, are
+-- not relances: they are left out of the weekly frequency cap
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS auto_reply BOOLEAN NOT NULL DEFAULT FALSE;
@@ -7,6 +7,7 @@ import (
 	"github.com/fiducia/backend/internal/middleware"
 	"github.com/fiducia/backend/internal/models"
 	"github.com/fiducia/backend/internal/repository"
+	"github.com/fiducia/backend/internal/services"
 	"github.com/google/uuid"
 )
 
@@ -97,6 +98,12 @@ func (r *Router) updateCabinet(w http.ResponseWriter, req *http.Request) {
 		Phone               *string `json:"phone"`
 		Email               *string `json:"email"`
 		OnboardingCompleted *bool   `json:"onboarding_completed"`
+		// Sending windows, timezone and holiday calendar used by campaigns
+		Sending *models.SendingSettings `json:"sending"`
+		// Anti-ban policy overrides of the cabinet and of its sender numbers
+		AntiBan *models.AntiBanSettings `json:"anti_ban"`
+		// WhatsApp provider of the cabinet
+		WhatsApp *models.WhatsAppSettings `json:"whatsapp"`
 	}
 	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
 		writeError(w, http.StatusBadRequest, "Invalid body")
@@ -129,6 +136,36 @@ func (r *Router) updateCabinet(w http.ResponseWriter, req *http.Request) {
 	if body.OnboardingCompleted != nil {
 		cab.OnboardingCompleted = *body.OnboardingCompleted
 	}
+	if body.Sending != nil {
+		if _, err := services.NewSendingSchedule(*body.Sending); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid sending settings: "+err.Error())
+			return
+		}
+		if cab.Settings == nil {
+			cab.Settings = map[string]any{}
+		}
+		cab.Settings["sending"] = body.Sending
+	}
+	if body.AntiBan != nil {
+		if err := services.ValidateAntiBanSettings(*body.AntiBan, r.messageSvc.DefaultPolicy()); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid anti-ban settings: "+err.Error())
+			return
+		}
+		if cab.Settings == nil {
+			cab.Settings = map[string]any{}
+		}
+		cab.Settings["anti_ban"] = body.AntiBan
+	}
+	if body.WhatsApp != nil {
+		if err := r.messageSvc.ValidateWhatsAppSettings(*body.WhatsApp); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid WhatsApp settings: "+err.Error())
+			return
+		}
+		if cab.Settings == nil {
+			cab.Settings = map[string]any{}
+		}
+		cab.Settings["whatsapp"] = body.WhatsApp
+	}
 
 	if err := repo.Update(req.Context(), cab); err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to update cabinet")
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/middleware"
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/repository"
+	"github.com/fiducia/backend/internal/services"
+)
+
+// listCampaignExecutions handles GET /api/v1/campaign-executions
+// Filters: campaign_id, pending_line_id, client_id, status, limit, offset
+func (r *Router) listCampaignExecutions(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	filter := repository.ExecutionFilter{CabinetID: cabinetID}
+	query := req.URL.Query()
+
+	if v := query.Get("campaign_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid campaign ID")
+			return
+		}
+		filter.CampaignID = &id
+	}
+	if v := query.Get("pending_line_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pending line ID")
+			return
+		}
+		filter.PendingLineID = &id
+	}
+	if v := query.Get("client_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid client ID")
+			return
+		}
+		filter.ClientID = &id
+	}
+	if status := query.Get("status"); status != "" {
+		s := models.ExecutionStatus(status)
+		filter.Status = &s
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil {
+			filter.Limit = n
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if n, err := strconv.Atoi(offset); err == nil {
+			filter.Offset = n
+		}
+	}
+
+	executions, err := r.executionRepo.List(req.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list campaign executions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"executions": executions,
+		"total":      len(executions),
+	})
+}
+
+// getCampaignExecution handles GET /api/v1/campaign-executions/{id}
+func (r *Router) getCampaignExecution(w http.ResponseWriter, req *http.Request) {
+	ex, _, ok := r.loadExecution(w, req)
+	if !ok {
+		return
+	}
+
+	events, err := r.executionRepo.ListEvents(req.Context(), ex.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get execution history")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"execution": ex,
+		"events":    events,
+	})
+}
+
+// controlCampaignExecution handles POST /api/v1/campaign-executions/{id}/{action}
+// where action is pause, resume, skip, restart or stop
+func (r *Router) controlCampaignExecution(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Reason    string     `json:"reason"`
+		StepOrder int        `json:"step_order"` // skip
+		Until     *time.Time `json:"until"`      // pause
+	}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	ex, campaign, ok := r.loadExecution(w, req)
+	if !ok {
+		return
+	}
+
+	cmd := services.ExecutionCommand{
+		Action:    models.ExecutionAction(req.PathValue("action")),
+		Reason:    body.Reason,
+		StepOrder: body.StepOrder,
+		Until:     body.Until,
+	}
+	if uid, ok := middleware.GetUserID(req.Context()); ok {
+		cmd.ActorID = &uid
+	}
+
+	err := r.engine.Control(req.Context(), ex, campaign, cmd)
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrInvalidCommand):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, services.ErrInvalidTransition):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, repository.ErrExecutionLeased):
+		writeError(w, http.StatusConflict, "Execution is being processed, please retry")
+		return
+	case errors.Is(err, repository.ErrAlreadyEnrolled):
+		writeError(w, http.StatusConflict, "Client already has an active execution in this campaign")
+		return
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to update campaign execution")
+		return
+	}
+
+	ex, err = r.executionRepo.GetByID(req.Context(), ex.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get campaign execution")
+		return
+	}
+	writeJSON(w, http.StatusOK, ex)
+}
+
+// loadExecution loads the execution of the request and its campaign, checking it
+// belongs to the cabinet of the user. It writes the error response when it fails.
+func (r *Router) loadExecution(w http.ResponseWriter, req *http.Request) (*models.CampaignExecution, *models.Campaign, bool) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid execution ID")
+		return nil, nil, false
+	}
+
+	ex, err := r.executionRepo.GetByID(req.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get campaign execution")
+		return nil, nil, false
+	}
+	if ex == nil {
+		writeError(w, http.StatusNotFound, "Execution not found")
+		return nil, nil, false
+	}
+
+	campaign, err := r.campaignRepo.GetByID(req.Context(), ex.CampaignID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get campaign")
+		return nil, nil, false
+	}
+	cabinetID, _ := middleware.GetCabinetID(req.Context())
+	if campaign == nil || campaign.CabinetID != cabinetID {
+		writeError(w, http.StatusNotFound, "Execution not found")
+		return nil, nil, false
+	}
+
+	return ex, campaign, true
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/middleware"
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/services"
+)
+
+// listCampaignVersions handles GET /api/v1/campaigns/{id}/versions
+func (r *Router) listCampaignVersions(w http.ResponseWriter, req *http.Request) {
+	c, ok := r.loadCabinetCampaign(w, req)
+	if !ok {
+		return
+	}
+
+	versions, err := r.campaignRepo.ListVersions(req.Context(), c.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list campaign versions")
+		return
+	}
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, "Campaign not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"versions": versions,
+		"total":    len(versions),
+	})
+}
+
+// getCampaignVersion handles GET /api/v1/campaigns/{id}/versions/{version}
+func (r *Router) getCampaignVersion(w http.ResponseWriter, req *http.Request) {
+	c, ok := r.loadCabinetCampaign(w, req)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(req.PathValue("version"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid version")
+		return
+	}
+
+	v, err := r.campaignRepo.GetVersion(req.Context(), c.ID, version)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get campaign version")
+		return
+	}
+	if v == nil {
+		writeError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+// migrateCampaignExecutions handles POST /api/v1/campaigns/{id}/migrate
+// Moves the active executions of from_version (all older versions when omitted) to the
+// current version. step_mapping maps old step orders to new ones; unmapped steps keep
+// their order. Executions being processed by the worker are skipped.
+func (r *Router) migrateCampaignExecutions(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		FromVersion *int           `json:"from_version"`
+		StepMapping map[string]int `json:"step_mapping"`
+		Reason      string         `json:"reason"`
+	}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	c, ok := r.loadCabinetCampaign(w, req)
+	if !ok {
+		return
+	}
+	if body.FromVersion != nil && *body.FromVersion >= c.Version {
+		writeError(w, http.StatusBadRequest, "from_version must be older than the current version")
+		return
+	}
+
+	mapping := make(map[int]int, len(body.StepMapping))
+	for k, v := range body.StepMapping {
+		from, err := strconv.Atoi(k)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid step mapping")
+			return
+		}
+		mapping[from] = v
+	}
+	if err := services.ValidateStepMapping(c, mapping); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var actorID *uuid.UUID
+	if uid, ok := middleware.GetUserID(req.Context()); ok {
+		actorID = &uid
+	}
+	var reason *string
+	if s := strings.TrimSpace(body.Reason); s != "" {
+		reason = &s
+	}
+
+	migrated, skipped, err := r.executionRepo.MigrateVersion(req.Context(), c.ID, body.FromVersion, c.Version, mapping, actorID, reason)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to migrate campaign executions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version":  c.Version,
+		"migrated": migrated,
+		"skipped":  skipped,
+	})
+}
+
+// exportCampaign handles GET /api/v1/campaigns/{id}/export
+func (r *Router) exportCampaign(w http.ResponseWriter, req *http.Request) {
+	c, ok := r.loadCabinetCampaign(w, req)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="campaign-`+c.ID.String()+`.json"`)
+	writeJSON(w, http.StatusOK, services.ExportCampaign(c))
+}
+
+// importCampaign handles POST /api/v1/campaigns/import
+// The campaign is created inactive in the cabinet of the user.
+func (r *Router) importCampaign(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var doc models.CampaignExport
+	if err := json.NewDecoder(req.Body).Decode(&doc); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	c, err := services.ImportCampaign(doc, cabinetID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := r.campaignRepo.Create(req.Context(), c); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create campaign")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// loadCabinetCampaign loads the campaign of the request, checking it belongs to the
+// cabinet of the user. It writes the error response when it fails.
+func (r *Router) loadCabinetCampaign(w http.ResponseWriter, req *http.Request) (*models.Campaign, bool) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid campaign ID")
+		return nil, false
+	}
+
+	c, err := r.campaignRepo.GetByID(req.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get campaign")
+		return nil, false
+	}
+	cabinetID, _ := middleware.GetCabinetID(req.Context())
+	if c == nil || c.CabinetID != cabinetID {
+		writeError(w, http.StatusNotFound, "Campaign not found")
+		return nil, false
+	}
+	return c, true
+}
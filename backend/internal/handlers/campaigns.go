@@ -3,10 +3,12 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/services"
 )
 
 // listCampaigns handles GET /api/v1/campaigns
@@ -44,6 +46,17 @@ func (r *Router) createCampaign(w http.ResponseWriter, req *http.Request) {
 		// Default to demo cabinet
 		c.CabinetID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
 	}
+	if c.EscalateAfterHours == 0 {
+		c.EscalateAfterHours = 48
+	}
+	if c.GroupBy == "" {
+		c.GroupBy = models.GroupByLine
+	}
+
+	if err := services.ValidateCampaign(&c); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if err := r.campaignRepo.Create(req.Context(), &c); err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to create campaign")
@@ -105,10 +118,24 @@ func (r *Router) updateCampaign(w http.ResponseWriter, req *http.Request) {
 	c.TriggerType = update.TriggerType
 	c.IsActive = update.IsActive
 	c.QuietHoursEnabled = update.QuietHoursEnabled
+	c.EscalateOnExhaustion = update.EscalateOnExhaustion
+	if update.EscalateAfterHours > 0 {
+		c.EscalateAfterHours = update.EscalateAfterHours
+	}
+	if update.GroupBy != "" {
+		c.GroupBy = update.GroupBy
+	}
+	c.Priority = update.Priority
+	c.Targeting = update.Targeting
 	if update.Steps != nil {
 		c.Steps = update.Steps
 	}
 
+	if err := services.ValidateCampaign(c); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if err := r.campaignRepo.Update(req.Context(), c); err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to update campaign")
 		return
@@ -133,3 +160,99 @@ func (r *Router) deleteCampaign(w http.ResponseWriter, req *http.Request) {
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
+
+// simulateCampaign handles POST /api/v1/campaigns/{id}/simulate
+// It is a dry run: nothing is sent and nothing is stored.
+func (r *Router) simulateCampaign(w http.ResponseWriter, req *http.Request) {
+	c, ok := r.loadCabinetCampaign(w, req)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		StartAt *time.Time `json:"start_at"` // Virtual clock start, defaults to now
+		Limit   int        `json:"limit"`
+	}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	start := time.Now()
+	if body.StartAt != nil {
+		start = *body.StartAt
+	}
+	if body.Limit <= 0 {
+		body.Limit = 100
+	}
+	if body.Limit > 1000 {
+		body.Limit = 1000
+	}
+
+	sim, err := r.engine.Simulate(req.Context(), c, start, body.Limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to simulate campaign")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sim)
+}
+
+// getCampaignAnalytics handles GET /api/v1/campaigns/{id}/analytics
+// Optional from and to (YYYY-MM-DD, inclusive) filter executions by enrollment date.
+func (r *Router) getCampaignAnalytics(w http.ResponseWriter, req *http.Request) {
+	c, ok := r.loadCabinetCampaign(w, req)
+	if !ok {
+		return
+	}
+
+	from, to, ok := parseDateRange(w, req)
+	if !ok {
+		return
+	}
+
+	analytics, err := r.analyticsRepo.Get(req.Context(), c.ID, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to compute campaign analytics")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, analytics)
+}
+
+// getCampaignVariants handles GET /api/v1/campaigns/{id}/variants
+// Optional from and to (YYYY-MM-DD, inclusive) filter executions by enrollment date.
+func (r *Router) getCampaignVariants(w http.ResponseWriter, req *http.Request) {
+	c, ok := r.loadCabinetCampaign(w, req)
+	if !ok {
+		return
+	}
+	from, to, ok := parseDateRange(w, req)
+	if !ok {
+		return
+	}
+
+	results, err := r.analyticsRepo.Variants(req.Context(), c.ID, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to compute variant results")
+		return
+	}
+	services.ScoreVariants(results)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"campaign_id": c.ID,
+		"variants":    results,
+	})
+}
+
+// listCampaignCycles handles GET /api/v1/campaign-worker/cycles
+func (r *Router) listCampaignCycles(w http.ResponseWriter, req *http.Request) {
+	cycles, err := r.cycleRepo.List(req.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list campaign cycles")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cycles)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 
@@ -79,14 +80,15 @@ func (r *Router) getClient(w http.ResponseWriter, req *http.Request) {
 
 // CreateClientRequest represents the create request body
 type CreateClientRequest struct {
-	Name        string  `json:"name"`
-	SIREN       *string `json:"siren,omitempty"`
-	SIRET       *string `json:"siret,omitempty"`
-	Phone       *string `json:"phone,omitempty"`
-	Email       *string `json:"email,omitempty"`
-	ContactName *string `json:"contact_name,omitempty"`
-	Address     *string `json:"address,omitempty"`
-	Notes       *string `json:"notes,omitempty"`
+	Name        string   `json:"name"`
+	SIREN       *string  `json:"siren,omitempty"`
+	SIRET       *string  `json:"siret,omitempty"`
+	Phone       *string  `json:"phone,omitempty"`
+	Email       *string  `json:"email,omitempty"`
+	ContactName *string  `json:"contact_name,omitempty"`
+	Address     *string  `json:"address,omitempty"`
+	Notes       *string  `json:"notes,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
 }
 
 // createClient handles POST /api/v1/cabinets/{cabinet_id}/clients
@@ -119,6 +121,7 @@ func (r *Router) createClient(w http.ResponseWriter, req *http.Request) {
 		ContactName: payload.ContactName,
 		Address:     payload.Address,
 		Notes:       payload.Notes,
+		Tags:        normalizeTags(payload.Tags),
 	}
 
 	if err := r.clientRepo.Create(req.Context(), client); err != nil {
@@ -131,15 +134,16 @@ func (r *Router) createClient(w http.ResponseWriter, req *http.Request) {
 
 // UpdateClientRequest represents the update request body
 type UpdateClientRequest struct {
-	Name            *string `json:"name,omitempty"`
-	SIREN           *string `json:"siren,omitempty"`
-	SIRET           *string `json:"siret,omitempty"`
-	Phone           *string `json:"phone,omitempty"`
-	Email           *string `json:"email,omitempty"`
-	ContactName     *string `json:"contact_name,omitempty"`
-	Address         *string `json:"address,omitempty"`
-	Notes           *string `json:"notes,omitempty"`
-	WhatsAppOptedIn *bool   `json:"whatsapp_opted_in,omitempty"`
+	Name            *string  `json:"name,omitempty"`
+	SIREN           *string  `json:"siren,omitempty"`
+	SIRET           *string  `json:"siret,omitempty"`
+	Phone           *string  `json:"phone,omitempty"`
+	Email           *string  `json:"email,omitempty"`
+	ContactName     *string  `json:"contact_name,omitempty"`
+	Address         *string  `json:"address,omitempty"`
+	Notes           *string  `json:"notes,omitempty"`
+	WhatsAppOptedIn *bool    `json:"whatsapp_opted_in,omitempty"`
+	Tags            []string `json:"tags,omitempty"` // Replaces all the tags when set
 }
 
 // updateClient handles PUT /api/v1/clients/{id}
@@ -195,6 +199,9 @@ func (r *Router) updateClient(w http.ResponseWriter, req *http.Request) {
 	if payload.WhatsAppOptedIn != nil {
 		client.WhatsAppOptedIn = *payload.WhatsAppOptedIn
 	}
+	if payload.Tags != nil {
+		client.Tags = normalizeTags(payload.Tags)
+	}
 
 	if err := r.clientRepo.Update(req.Context(), client); err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to update client")
@@ -220,3 +227,18 @@ func (r *Router) deleteClient(w http.ResponseWriter, req *http.Request) {
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
+
+// normalizeTags trims tags and removes empty and duplicate ones, ignoring case
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		result = append(result, t)
+	}
+	return result
+}
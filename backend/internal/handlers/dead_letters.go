@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fiducia/backend/internal/middleware"
+	"github.com/fiducia/backend/internal/queue"
+	"github.com/fiducia/backend/internal/services"
+)
+
+// listDeadLetters handles GET /api/v1/admin/dead-letters
+// Filters: limit (default 50), offset
+func (r *Router) listDeadLetters(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset := 50, 0
+	if n, err := strconv.Atoi(req.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	if n, err := strconv.Atoi(req.URL.Query().Get("offset")); err == nil && n >= 0 {
+		offset = n
+	}
+
+	jobs, total, err := r.messageSvc.DeadLetters(req.Context(), cabinetID, offset, limit)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"dead_letters": jobs,
+		"total":        total,
+	})
+}
+
+// getDeadLetter handles GET /api/v1/admin/dead-letters/{id}
+func (r *Router) getDeadLetter(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	job, err := r.messageSvc.DeadLetter(req.Context(), cabinetID, req.PathValue("id"))
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// replayDeadLetter handles POST /api/v1/admin/dead-letters/{id}/replay
+func (r *Router) replayDeadLetter(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := r.messageSvc.ReplayDeadLetter(req.Context(), cabinetID, req.PathValue("id")); err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "replayed"})
+}
+
+// discardDeadLetter handles DELETE /api/v1/admin/dead-letters/{id}
+func (r *Router) discardDeadLetter(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := r.messageSvc.DiscardDeadLetter(req.Context(), cabinetID, req.PathValue("id")); err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeDeadLetterError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, queue.ErrJobNotFound):
+		writeError(w, http.StatusNotFound, "Dead letter not found")
+	case errors.Is(err, services.ErrQueueUnavailable):
+		writeError(w, http.StatusServiceUnavailable, "Message queue not available")
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to access dead letters")
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -22,3 +23,26 @@ func decimalFromFloat(f float64) decimal.Decimal {
 func ptrTo[T any](v T) *T {
 	return &v
 }
+
+// parseDateRange reads the optional from and to query parameters (YYYY-MM-DD, both
+// inclusive) as a [from, to) time range. It writes the error response when invalid.
+func parseDateRange(w http.ResponseWriter, req *http.Request) (from, to *time.Time, ok bool) {
+	if v := req.URL.Query().Get("from"); v != "" {
+		d, err := parseDate(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid from date (expected YYYY-MM-DD)")
+			return nil, nil, false
+		}
+		from = &d
+	}
+	if v := req.URL.Query().Get("to"); v != "" {
+		d, err := parseDate(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid to date (expected YYYY-MM-DD)")
+			return nil, nil, false
+		}
+		d = d.AddDate(0, 0, 1)
+		to = &d
+	}
+	return from, to, true
+}
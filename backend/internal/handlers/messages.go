@@ -50,9 +50,8 @@ func (h *MessageHandler) List(w http.ResponseWriter, r *http.Request) {
 
 // SendRelanceRequest represents the request to send a relance
 type SendRelanceRequest struct {
-	MessageType   string `json:"message_type"` // text, voice, template
+	MessageType   string `json:"message_type"` // text, voice, template, interactive
 	CustomMessage string `json:"custom_message,omitempty"`
-	Immediate     bool   `json:"immediate,omitempty"`
 }
 
 // Send handles POST /api/v1/pending-lines/{id}/messages
@@ -84,7 +83,6 @@ func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
 		PendingLineID: pendingLineID,
 		MessageType:   req.MessageType,
 		CustomMessage: req.CustomMessage,
-		Immediate:     req.Immediate,
 	})
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
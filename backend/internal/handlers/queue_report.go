@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fiducia/backend/internal/middleware"
+	"github.com/fiducia/backend/internal/services"
+)
+
+// getQueueReport handles GET /api/v1/admin/queue
+// Admins of the operations cabinets (OPS_CABINET_IDS) see every cabinet, other
+// admins their own.
+func (r *Router) getQueueReport(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	report, err := r.messageSvc.QueueReport(req.Context(), cabinetID, r.cfg.IsOpsCabinet(cabinetID.String()))
+	if err != nil {
+		if errors.Is(err, services.ErrQueueUnavailable) {
+			writeError(w, http.StatusServiceUnavailable, "Message queue not available")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to build queue report")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
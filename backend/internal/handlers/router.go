@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
@@ -21,8 +24,10 @@ import (
 	"github.com/fiducia/backend/internal/database"
 	"github.com/fiducia/backend/internal/middleware"
 	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/queue"
 	"github.com/fiducia/backend/internal/repository"
 	"github.com/fiducia/backend/internal/services"
+	"github.com/fiducia/backend/pkg/telephony"
 	"github.com/fiducia/backend/pkg/whatsapp"
 )
 
@@ -33,7 +38,7 @@ type Router struct {
 	mux           *http.ServeMux
 	importer      *services.CSVImporter
 	lineRepo      *repository.PendingLineRepository
-	waClient      *whatsapp.TwilioClient
+	providers     *whatsapp.Providers
 	voiceSvc      *services.VoiceService
 	ocrSvc        *services.OCRService
 	matchingSvc   *services.MatchingService
@@ -43,6 +48,13 @@ type Router struct {
 	voiceRepo     *repository.VoiceSettingsRepository
 	campaignRepo  *repository.CampaignRepository
 	executionRepo *repository.CampaignExecutionRepository
+	cycleRepo     *repository.CampaignCycleRepository
+	taskRepo      *repository.TaskRepository
+	analyticsRepo *repository.CampaignAnalyticsRepository
+	callSvc       *services.PhoneCallService
+	messageSvc    *services.MessageService
+	replySvc      *services.ButtonReplyService
+	msgQueue      queue.Queue
 	engine        *services.CampaignEngine
 	authSvc       *services.AuthService
 }
@@ -60,7 +72,43 @@ func NewRouter(db *database.DB, cfg *config.Config) *Router {
 	ocrSvc := services.NewOCRService(cfg.OpenAIAPIKey, "/tmp/fiducia/documents")
 	matchingSvc := services.NewMatchingService(docRepo, lineRepo)
 	voiceSvc := services.NewVoiceService(cfg.ElevenLabsAPIKey, "/tmp/fiducia/voice", cfg.BaseURL)
-	engine := services.NewCampaignEngine(db.Pool, campaignRepo, lineRepo, executionRepo, voiceSvc)
+	cabinetRepo := repository.NewCabinetRepository(db)
+	cycleRepo := repository.NewCampaignCycleRepository(db.Pool)
+	taskRepo := repository.NewTaskRepository(db.Pool)
+
+	// Messages only send with a configured provider, chosen per cabinet
+	providers := services.WhatsAppProviders(cfg)
+	// Phone calls only when a telephony provider is configured
+	var callSvc *services.PhoneCallService
+	provider, err := telephony.NewProvider(cfg.TelephonyProvider, cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioVoiceNumber)
+	if err != nil {
+		slog.Warn("phone calls disabled", "error", err)
+	} else if provider != nil {
+		callSvc = services.NewPhoneCallService(provider, voiceSvc, cfg.ElevenLabsVoiceID, cfg.BaseURL, msgRepo, lineRepo, clientRepo, taskRepo)
+	}
+	policy, err := services.AntiBanDefaults(cfg)
+	if err != nil {
+		slog.Warn("invalid anti-ban configuration, using defaults", "error", err)
+		policy = services.AntiBanPolicy{Policy: queue.DefaultPolicy}
+	}
+	// Outbound messages go through the queue; without it they are marked failed
+	msgQueue, err := queue.Open(queue.Options{
+		Backend:           cfg.QueueBackend,
+		RedisURL:          cfg.RedisURL,
+		Pool:              db.Pool,
+		VisibilityTimeout: cfg.MessageVisibilityTimeout,
+		SenderLimits:      policy.Sender,
+	})
+	if err != nil {
+		slog.Warn("message queue unavailable, messages will not be sent", "error", err)
+		msgQueue = nil
+	}
+	messageSvc := services.NewMessageService(providers, callSvc, msgRepo, lineRepo, clientRepo, cabinetRepo, msgQueue)
+	messageSvc.SetDefaultPolicy(policy)
+	dispatcher := services.NewCampaignDispatcher(messageSvc, voiceSvc, cfg.ElevenLabsVoiceID, msgRepo, lineRepo, taskRepo)
+	rules := services.NewRuleEvaluator(msgRepo, docRepo)
+	engine := services.NewCampaignEngine(db.Pool, campaignRepo, lineRepo, executionRepo, cabinetRepo, cycleRepo, dispatcher, rules)
+	engine.SetConcurrency(cfg.CampaignWorkerConcurrency)
 	authSvc := services.NewAuthService(db, cfg)
 
 	r := &Router{
@@ -69,7 +117,7 @@ func NewRouter(db *database.DB, cfg *config.Config) *Router {
 		mux:           http.NewServeMux(),
 		importer:      services.NewCSVImporter(),
 		lineRepo:      lineRepo,
-		waClient:      whatsapp.NewTwilioClient(cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioPhoneNumber),
+		providers:     providers,
 		voiceSvc:      voiceSvc,
 		ocrSvc:        ocrSvc,
 		matchingSvc:   matchingSvc,
@@ -79,6 +127,13 @@ func NewRouter(db *database.DB, cfg *config.Config) *Router {
 		voiceRepo:     voiceRepo,
 		campaignRepo:  campaignRepo,
 		executionRepo: executionRepo,
+		cycleRepo:     cycleRepo,
+		taskRepo:      taskRepo,
+		analyticsRepo: repository.NewCampaignAnalyticsRepository(db.Pool),
+		callSvc:       callSvc,
+		messageSvc:    messageSvc,
+		replySvc:      services.NewButtonReplyService(messageSvc, engine, msgRepo, lineRepo, clientRepo, taskRepo, executionRepo),
+		msgQueue:      msgQueue,
 		engine:        engine,
 		authSvc:       authSvc,
 	}
@@ -94,27 +149,20 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 // StartCampaignWorker starts the background task for campaign execution
 func (r *Router) StartCampaignWorker(ctx context.Context) {
-	slog.Info("Starting Campaign Worker...")
-	ticker := time.NewTicker(1 * time.Minute)
-	go func() {
-		defer ticker.Stop()
-		// Run immediately on start
-		if err := r.engine.ExecuteCampaignCycle(ctx); err != nil {
-			slog.Error("Campaign Cycle Error", "error", err)
-		}
+	worker := services.NewCampaignWorker(r.engine, r.cfg.CampaignWorkerInterval)
+	go worker.Run(ctx)
+}
 
-		for {
-			select {
-			case <-ctx.Done():
-				slog.Info("Stopping Campaign Worker")
-				return
-			case <-ticker.C:
-				if err := r.engine.ExecuteCampaignCycle(ctx); err != nil {
-					slog.Error("Campaign Cycle Error", "error", err)
-				}
-			}
-		}
-	}()
+// StartMessageWorkers starts the workers sending queued messages. It returns nil
+// when the queue is unavailable; otherwise drain the pool after cancelling ctx.
+func (r *Router) StartMessageWorkers(ctx context.Context) *queue.Pool {
+	if r.msgQueue == nil {
+		return nil
+	}
+	pool := queue.NewPool(r.msgQueue, r.messageSvc.ProcessQueuedMessage, r.messageSvc.FailQueuedMessage,
+		r.cfg.MessageWorkerConcurrency, r.cfg.MessageMaxAttempts)
+	go pool.Run(ctx)
+	return pool
 }
 
 // GetPool returns the database pool
@@ -166,9 +214,29 @@ func (r *Router) registerRoutes() {
 	r.mux.HandleFunc("GET /api/v1/pending-lines/{id}/messages", r.listMessages)
 	r.mux.HandleFunc("POST /api/v1/pending-lines/{id}/messages", r.sendMessage)
 
-	// Webhooks (both with and without api prefix for Twilio convenience)
-	r.mux.HandleFunc("POST /api/v1/webhook/whatsapp", r.whatsappWebhook)
-	r.mux.HandleFunc("POST /webhook/whatsapp", r.whatsappWebhook)
+	// Webhooks (both with and without api prefix for Twilio convenience), signed by
+	// their provider; replayed events are acknowledged without processing
+	guard := middleware.NewWebhookGuard(r.cfg, repository.NewWebhookEventRepository(r.db.Pool))
+	twilio := func(h http.HandlerFunc) http.Handler {
+		return middleware.Chain(h, guard.Twilio, guard.RejectReplays(whatsapp.ProviderTwilio))
+	}
+	meta := func(h http.HandlerFunc) http.Handler {
+		return middleware.Chain(h, guard.Meta, guard.RejectReplays(whatsapp.ProviderMeta))
+	}
+	r.mux.Handle("POST /api/v1/webhook/whatsapp", twilio(r.whatsappWebhook))
+	r.mux.Handle("POST /webhook/whatsapp", twilio(r.whatsappWebhook))
+	r.mux.Handle("POST /api/v1/webhook/whatsapp/status", twilio(r.twilioStatusWebhook))
+	r.mux.Handle("POST /webhook/whatsapp/status", twilio(r.twilioStatusWebhook))
+	r.mux.HandleFunc("GET /api/v1/webhook/whatsapp/meta", r.metaWebhookVerify)
+	r.mux.HandleFunc("GET /webhook/whatsapp/meta", r.metaWebhookVerify)
+	r.mux.Handle("POST /api/v1/webhook/whatsapp/meta", meta(r.metaWebhook))
+	r.mux.Handle("POST /webhook/whatsapp/meta", meta(r.metaWebhook))
+	voice := func(h http.HandlerFunc) http.Handler {
+		return middleware.Chain(h, guard.Twilio, guard.RejectReplays(middleware.ProviderTwilioVoice))
+	}
+	r.mux.Handle("POST /webhook/voice/gather", voice(r.voiceGatherWebhook))
+	r.mux.Handle("POST /webhook/voice/status", voice(r.voiceStatusWebhook))
+	r.mux.Handle("POST /webhook/voice/recording", voice(r.voiceRecordingWebhook))
 
 	// Documents
 	r.mux.HandleFunc("GET /api/v1/pending-lines/{id}/documents", r.listDocuments)
@@ -197,6 +265,36 @@ func (r *Router) registerRoutes() {
 	r.mux.HandleFunc("GET /api/v1/campaigns/{id}", r.getCampaign)
 	r.mux.HandleFunc("PATCH /api/v1/campaigns/{id}", r.updateCampaign)
 	r.mux.HandleFunc("DELETE /api/v1/campaigns/{id}", r.deleteCampaign)
+	r.mux.Handle("POST /api/v1/campaigns/{id}/simulate", middleware.Auth(r.cfg)(http.HandlerFunc(r.simulateCampaign)))
+	r.mux.Handle("GET /api/v1/campaigns/{id}/analytics", middleware.Auth(r.cfg)(http.HandlerFunc(r.getCampaignAnalytics)))
+	r.mux.Handle("GET /api/v1/campaigns/{id}/variants", middleware.Auth(r.cfg)(http.HandlerFunc(r.getCampaignVariants)))
+	r.mux.Handle("GET /api/v1/campaigns/{id}/versions", middleware.Auth(r.cfg)(http.HandlerFunc(r.listCampaignVersions)))
+	r.mux.Handle("GET /api/v1/campaigns/{id}/versions/{version}", middleware.Auth(r.cfg)(http.HandlerFunc(r.getCampaignVersion)))
+	r.mux.Handle("POST /api/v1/campaigns/{id}/migrate", middleware.Auth(r.cfg)(http.HandlerFunc(r.migrateCampaignExecutions)))
+	r.mux.Handle("GET /api/v1/campaigns/{id}/export", middleware.Auth(r.cfg)(http.HandlerFunc(r.exportCampaign)))
+	r.mux.Handle("POST /api/v1/campaigns/import", middleware.Auth(r.cfg)(http.HandlerFunc(r.importCampaign)))
+	r.mux.Handle("GET /api/v1/campaign-worker/cycles", middleware.Auth(r.cfg)(http.HandlerFunc(r.listCampaignCycles)))
+
+	// Campaign executions (manual control)
+	r.mux.Handle("GET /api/v1/campaign-executions", middleware.Auth(r.cfg)(http.HandlerFunc(r.listCampaignExecutions)))
+	r.mux.Handle("GET /api/v1/campaign-executions/{id}", middleware.Auth(r.cfg)(http.HandlerFunc(r.getCampaignExecution)))
+	r.mux.Handle("POST /api/v1/campaign-executions/{id}/{action}", middleware.Auth(r.cfg)(http.HandlerFunc(r.controlCampaignExecution)))
+
+	// Tasks (escalations to collaborators)
+	r.mux.Handle("GET /api/v1/tasks", middleware.Auth(r.cfg)(http.HandlerFunc(r.listTasks)))
+	r.mux.Handle("PATCH /api/v1/tasks/{id}", middleware.Auth(r.cfg)(http.HandlerFunc(r.updateTask)))
+
+	// Dead-lettered messages (admins)
+	admin := func(h http.HandlerFunc) http.Handler {
+		return middleware.Auth(r.cfg)(middleware.RequireRole(models.RoleAdmin)(h))
+	}
+	r.mux.Handle("GET /api/v1/admin/dead-letters", admin(r.listDeadLetters))
+	r.mux.Handle("GET /api/v1/admin/dead-letters/{id}", admin(r.getDeadLetter))
+	r.mux.Handle("POST /api/v1/admin/dead-letters/{id}/replay", admin(r.replayDeadLetter))
+	r.mux.Handle("DELETE /api/v1/admin/dead-letters/{id}", admin(r.discardDeadLetter))
+
+	// Queue operations dashboard (admins)
+	r.mux.Handle("GET /api/v1/admin/queue", admin(r.getQueueReport))
 }
 
 // ============================================
@@ -728,7 +826,6 @@ func (r *Router) sendMessage(w http.ResponseWriter, req *http.Request) {
 	var body struct {
 		MessageType   string `json:"message_type"`
 		CustomMessage string `json:"custom_message"`
-		Immediate     bool   `json:"immediate"`
 	}
 	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
 		body.MessageType = "text"
@@ -790,83 +887,42 @@ func (r *Router) sendMessage(w http.ResponseWriter, req *http.Request) {
 		Status:        models.MsgStatusQueued,
 	}
 
-	if err := msgRepo.Create(req.Context(), msg); err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to create message: "+err.Error())
-		return
-	}
-
-	// Send via Twilio if immediate mode or in production
-	var waMessageID string
+	// Generate the voice note before queueing, the workers only send it
 	var audioURL string
-	if body.Immediate && r.waClient != nil && r.cfg.TwilioAccountSID != "" {
-		// Update status to sending
-		msgRepo.UpdateStatus(req.Context(), msg.ID, models.MsgStatusSending, nil)
-
-		var resp *whatsapp.MessageResponse
-		var err error
-
-		// Handle voice messages
-		if body.MessageType == "voice" && r.voiceSvc != nil && r.cfg.ElevenLabsAPIKey != "" {
-			// Generate voice message
-			amount := line.Amount.StringFixed(2)
-			date := line.TransactionDate.Format("02/01/2006")
-			label := "une opération"
-			if line.BankLabel != nil {
-				label = *line.BankLabel
-			}
+	if body.MessageType == "voice" && r.voiceSvc != nil && r.cfg.ElevenLabsAPIKey != "" {
+		amount := line.Amount.StringFixed(2)
+		date := line.TransactionDate.Format("02/01/2006")
+		label := "une opération"
+		if line.BankLabel != nil {
+			label = *line.BankLabel
+		}
 
-			// Determine Voice ID (use cloned voice if available)
-			voiceID := r.cfg.ElevenLabsVoiceID // Default
+		// Determine Voice ID (use cloned voice if available)
+		voiceID := r.cfg.ElevenLabsVoiceID // Default
 
-			// For this MVP, we use the test collaborator ID used in frontend
-			testCollaboratorID, _ := uuid.Parse("22222222-2222-2222-2222-222222222222")
-			if voiceSetting, _ := r.voiceRepo.GetByCollaboratorID(req.Context(), testCollaboratorID); voiceSetting != nil {
-				voiceID = voiceSetting.VoiceID
-				slog.Info("using cloned voice", "voice_id", voiceID, "name", voiceSetting.Name)
-			}
+		// For this MVP, we use the test collaborator ID used in frontend
+		testCollaboratorID, _ := uuid.Parse("22222222-2222-2222-2222-222222222222")
+		if voiceSetting, _ := r.voiceRepo.GetByCollaboratorID(req.Context(), testCollaboratorID); voiceSetting != nil {
+			voiceID = voiceSetting.VoiceID
+			slog.Info("using cloned voice", "voice_id", voiceID, "name", voiceSetting.Name)
+		}
 
-			voiceResult, voiceErr := r.voiceSvc.GenerateRelanceVoice(
-				req.Context(),
-				voiceID, // Use determined voice ID
-				client.Name,
-				date,
-				amount,
-				label,
-				pendingLineID,
-			)
-			if voiceErr != nil {
-				errMsg := "Voice generation failed: " + voiceErr.Error()
+		voiceResult, voiceErr := r.voiceSvc.GenerateRelanceVoice(
+			req.Context(),
+			voiceID, // Use determined voice ID
+			client.Name,
+			date,
+			amount,
+			label,
+			pendingLineID,
+		)
+		if voiceErr != nil {
+			errMsg := "Voice generation failed: " + voiceErr.Error()
+			if err := msgRepo.Create(req.Context(), msg); err == nil {
 				msgRepo.SetError(req.Context(), msg.ID, errMsg)
-				writeJSON(w, http.StatusCreated, map[string]any{
-					"message": "Génération vocale échouée",
-					"id":      msg.ID.String(),
-					"status":  "failed",
-					"error":   errMsg,
-				})
-				return
-			}
-
-			audioURL = voiceResult.AudioURL
-			// In development with Twilio Sandbox, MediaUrl doesn't work well
-			// Send text message instead, but keep the generated audio for future use
-			if r.cfg.IsDevelopment() {
-				// Fallback to text message in sandbox mode
-				resp, err = r.waClient.SendText(*client.Phone, content+" (🎙️ Audio: "+audioURL+")")
-			} else {
-				// In production, send voice note via Twilio
-				resp, err = r.waClient.SendVoice(*client.Phone, audioURL)
 			}
-		} else {
-			// Send text via Twilio
-			resp, err = r.waClient.SendText(*client.Phone, content)
-		}
-
-		if err != nil {
-			// Mark as failed but don't return error - still log the message
-			errMsg := err.Error()
-			msgRepo.SetError(req.Context(), msg.ID, errMsg)
 			writeJSON(w, http.StatusCreated, map[string]any{
-				"message": "Envoi échoué",
+				"message": "Génération vocale échouée",
 				"id":      msg.ID.String(),
 				"status":  "failed",
 				"error":   errMsg,
@@ -874,10 +930,32 @@ func (r *Router) sendMessage(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// Update with WhatsApp message ID
-		waMessageID = resp.MessageSID
-		msgRepo.UpdateStatus(req.Context(), msg.ID, models.MsgStatusSent, &waMessageID)
-		msg.Status = models.MsgStatusSent
+		audioURL = voiceResult.AudioURL
+		if r.cfg.IsDevelopment() {
+			// In development with Twilio Sandbox, MediaUrl doesn't work well:
+			// send a text message with a link to the generated audio instead
+			textWithAudio := content + " (🎙️ Audio: " + audioURL + ")"
+			msg.MessageType = models.TypeText
+			msg.Content = &textWithAudio
+		} else {
+			msg.MediaURL = &audioURL
+		}
+	}
+
+	if err := msgRepo.Create(req.Context(), msg); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create message: "+err.Error())
+		return
+	}
+
+	// Sent by the message workers after the anti-ban delay
+	if err := r.messageSvc.QueueMessage(req.Context(), line.CabinetID, msg, *client.Phone, false); err != nil {
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"message": "Envoi échoué",
+			"id":      msg.ID.String(),
+			"status":  "failed",
+			"error":   err.Error(),
+		})
+		return
 	}
 
 	// Update pending line status (only if not already in later state)
@@ -890,15 +968,15 @@ func (r *Router) sendMessage(w http.ResponseWriter, req *http.Request) {
 	r.lineRepo.Update(req.Context(), line)
 
 	writeJSON(w, http.StatusCreated, map[string]any{
-		"message":       "Relance " + string(msg.Status),
-		"id":            msg.ID.String(),
-		"status":        msg.Status,
-		"wa_message_id": waMessageID,
-		"audio_url":     audioURL,
-		"content":       content,
+		"message":   "Relance " + string(msg.Status),
+		"id":        msg.ID.String(),
+		"status":    msg.Status,
+		"audio_url": audioURL,
+		"content":   content,
 	})
 }
 
+// whatsappWebhook handles POST /webhook/whatsapp, the inbound messages of Twilio
 func (r *Router) whatsappWebhook(w http.ResponseWriter, req *http.Request) {
 	// Parse form data
 	if err := req.ParseForm(); err != nil {
@@ -907,30 +985,121 @@ func (r *Router) whatsappWebhook(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Extract Twilio payload
-	messageSid := req.FormValue("MessageSid")
-	from := strings.TrimPrefix(req.FormValue("From"), "whatsapp:")
-	body := req.FormValue("Body")
-	numMedia := req.FormValue("NumMedia")
-	mediaUrl0 := req.FormValue("MediaUrl0")
-	mediaType0 := req.FormValue("MediaContentType0")
+	if err := r.receiveMessage(req.Context(), whatsapp.ParseTwilioMessage(req.Form)); err != nil {
+		// Twilio retries the calls that failed
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with TwiML
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`)
+}
+
+// twilioStatusWebhook handles POST /webhook/whatsapp/status, the delivery status
+// callbacks of the messages sent through Twilio
+func (r *Router) twilioStatusWebhook(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		slog.Error("failed to parse status callback form", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.receiveStatus(req.Context(), whatsapp.ParseTwilioStatus(req.Form)); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// metaWebhookVerify handles GET /webhook/whatsapp/meta, the verification handshake
+// of the Meta webhook subscription
+func (r *Router) metaWebhookVerify(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	token := q.Get("hub.verify_token")
+	if q.Get("hub.mode") != "subscribe" || r.cfg.MetaVerifyToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(r.cfg.MetaVerifyToken)) != 1 {
+		slog.Warn("Meta webhook verification rejected", "mode", q.Get("hub.mode"))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, q.Get("hub.challenge"))
+}
+
+// metaWebhook handles POST /webhook/whatsapp/meta, the inbound messages and
+// delivery statuses of the Meta Cloud API
+func (r *Router) metaWebhook(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	events, err := whatsapp.ParseMetaWebhook(body)
+	if err != nil {
+		slog.Error("failed to parse Meta webhook", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// A retry carries the events processed before: only the failed ones are processed again
+	failed := false
+	for _, msg := range events.Messages {
+		if middleware.ProcessedMessage(req.Context(), msg) {
+			continue
+		}
+		if err := r.receiveMessage(req.Context(), msg); err != nil {
+			middleware.FailMessage(req.Context(), msg)
+			failed = true
+		}
+	}
+	for _, status := range events.Statuses {
+		if middleware.ProcessedStatus(req.Context(), status) {
+			continue
+		}
+		if err := r.receiveStatus(req.Context(), status); err != nil {
+			middleware.FailStatus(req.Context(), status)
+			failed = true
+		}
+	}
+
+	// Meta retries the calls not acknowledged with a 200
+	if failed {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
 
-	slog.Info("received WhatsApp webhook",
-		"message_sid", messageSid,
-		"from", from,
-		"body_length", len(body),
-		"num_media", numMedia,
+// receiveMessage stores an inbound message of any provider and processes its media.
+// It fails when the message could not be saved, for the provider to retry it.
+func (r *Router) receiveMessage(ctx context.Context, in whatsapp.InboundMessage) error {
+	slog.Info("received WhatsApp message",
+		"provider", in.Provider,
+		"message_id", in.ID,
+		"from", in.From,
+		"body_length", len(in.Body),
+		"num_media", len(in.Media),
 	)
 
+	// Each message opens the WhatsApp session window of the client
+	if err := r.clientRepo.TouchInbound(ctx, in.From, in.Timestamp); err != nil {
+		slog.Warn("failed to record inbound message time", "from", in.From, "error", err)
+	}
+
 	// Find client by phone
-	client, _ := r.clientRepo.GetByPhoneGlobal(req.Context(), from)
+	client, _ := r.clientRepo.GetByPhoneGlobal(ctx, in.From)
 	var clientID *uuid.UUID
 	if client != nil {
 		clientID = &client.ID
 	}
 
 	// Save incoming message
-	waID := messageSid
+	waID := in.ID
+	body := in.Body
 	msg := &models.Message{
 		ClientID:    clientID,
 		Direction:   models.DirectionInbound,
@@ -940,51 +1109,151 @@ func (r *Router) whatsappWebhook(w http.ResponseWriter, req *http.Request) {
 		WAMessageID: &waID,
 	}
 
-	if numMedia != "0" && numMedia != "" {
+	if len(in.Media) > 0 {
 		msg.MessageType = models.TypeMedia
-		msg.MediaURL = &mediaUrl0
+		if in.Media[0].URL != "" {
+			msg.MediaURL = &in.Media[0].URL
+		}
 	}
 
-	if err := r.msgRepo.Create(req.Context(), msg); err != nil {
-		slog.Error("failed to save incoming message", "error", err)
+	if err := r.msgRepo.Create(ctx, msg); err != nil {
+		slog.Error("failed to save incoming message", "message_id", in.ID, "error", err)
+		return err
 	}
 
-	// Process media if present (use background context for async processing)
-	if numMedia != "0" && numMedia != "" && mediaUrl0 != "" {
-		go r.processIncomingMedia(context.Background(), mediaUrl0, mediaType0, clientID, msg.ID)
+	// Answers to the quick-reply buttons of a relance
+	if clientID != nil {
+		if _, err := r.replySvc.HandleReply(ctx, in, *clientID); err != nil {
+			slog.Error("failed to apply relance button", "button_id", in.ButtonID, "error", err)
+		}
 	}
 
-	// Respond with TwiML
-	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`)
+	// Process each media as its own document, one after the other so that a batch of
+	// receipts does not burst the OCR provider (use background contexts for async processing)
+	if len(in.Media) > 0 {
+		go func() {
+			for i, media := range in.Media {
+				r.processMediaItem(context.Background(), in.Provider, media, i, clientID, msg.ID)
+			}
+		}()
+	}
+	return nil
 }
 
-// processIncomingMedia handles OCR processing in background
-func (r *Router) processIncomingMedia(ctx context.Context, mediaURL, mediaType string, clientID *uuid.UUID, messageID uuid.UUID) {
-	slog.Info("processing incoming media", "url", mediaURL, "type", mediaType)
+// errUnknownMessage is returned for a provider callback about a message not saved yet
+var errUnknownMessage = errors.New("unknown provider message ID")
+
+// receiveStatus updates the status of an outbound message from a provider status.
+// Callbacks may arrive out of order: a status never downgrades the message.
+// It fails when the status could not be saved, for the provider to retry it, and when
+// the message is unknown: the callback may arrive before the worker saves the send.
+func (r *Router) receiveStatus(ctx context.Context, update whatsapp.StatusUpdate) error {
+	if update.MessageID == "" {
+		return nil
+	}
+	var status models.MessageStatus
+	switch update.Status {
+	case "queued", "accepted", "scheduled":
+		status = models.MsgStatusQueued
+	case "sending":
+		status = models.MsgStatusSending
+	case "sent":
+		status = models.MsgStatusSent
+	case "delivered":
+		status = models.MsgStatusDelivered
+	case "read":
+		status = models.MsgStatusRead
+	case "failed", "undelivered", "canceled":
+		status = models.MsgStatusFailed
+	default:
+		return nil
+	}
 
-	// Download and process with OCR
-	ocrResult, filePath, err := r.ocrSvc.DownloadAndProcess(
-		ctx,
-		mediaURL,
-		r.cfg.TwilioAccountSID,
-		r.cfg.TwilioAuthToken,
-	)
+	var errorCode, errorMessage *string
+	if status == models.MsgStatusFailed {
+		if update.ErrorCode != "" {
+			errorCode = &update.ErrorCode
+		}
+		if desc := whatsapp.ErrorDescription(update.Provider, update.ErrorCode, update.ErrorMessage); desc != "" {
+			errorMessage = &desc
+		}
+	}
+
+	updated, err := r.msgRepo.UpdateStatusByWAID(ctx, update.MessageID, status, update.Timestamp, errorCode, errorMessage)
+	if err != nil {
+		slog.Error("failed to update message status", "wa_message_id", update.MessageID, "error", err)
+		return err
+	}
+	if !updated {
+		slog.Warn("status of an unknown message, left for the provider to retry", "wa_message_id", update.MessageID, "status", update.Status)
+		return errUnknownMessage
+	}
+	if status == models.MsgStatusFailed {
+		slog.Warn("message delivery failed",
+			"provider", update.Provider,
+			"wa_message_id", update.MessageID,
+			"error_code", update.ErrorCode,
+		)
+	}
+	return nil
+}
+
+// mediaItemTimeout bounds the download, OCR and matching of one inbound media
+const mediaItemTimeout = 2 * time.Minute
+
+// processMediaItem processes the media at index of an inbound message within its own
+// timeout, a panic being logged so that the other media of the message are still processed
+func (r *Router) processMediaItem(ctx context.Context, provider string, media whatsapp.Media, index int, clientID *uuid.UUID, messageID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(ctx, mediaItemTimeout)
+	defer cancel()
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error("panic while processing incoming media",
+				"message_id", messageID,
+				"index", index,
+				"url", media.URL,
+				"id", media.ID,
+				"error", err,
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+	r.processIncomingMedia(ctx, provider, media, clientID, messageID)
+}
+
+// processIncomingMedia handles OCR processing in background. Failures are logged and
+// recorded on the document, without affecting the other media of the message.
+func (r *Router) processIncomingMedia(ctx context.Context, provider string, media whatsapp.Media, clientID *uuid.UUID, messageID uuid.UUID) {
+	slog.Info("processing incoming media", "provider", provider, "url", media.URL, "id", media.ID, "type", media.ContentType)
+
+	// Download with the provider credentials and process with OCR
+	var ocrResult *services.OCRResult
+	var filePath string
+	downloader, err := r.providers.Downloader(provider)
+	if err == nil {
+		var data []byte
+		var contentType string
+		if data, contentType, err = downloader.DownloadMedia(ctx, media); err == nil {
+			media.ContentType = contentType
+			ocrResult, filePath, err = r.ocrSvc.ProcessMedia(ctx, data, contentType)
+		}
+	}
 
 	// Create document record
 	doc := &repository.Document{
 		ClientID:        clientID,
 		MessageID:       &messageID,
 		FilePath:        filePath,
-		FileType:        &mediaType,
-		TwilioMediaURL:  &mediaURL,
+		FileType:        &media.ContentType,
 		MatchConfidence: decimal.Zero,
 		MatchStatus:     "pending",
 	}
+	if media.URL != "" {
+		doc.TwilioMediaURL = &media.URL
+	}
 
 	if err != nil {
-		slog.Error("OCR processing failed", "error", err)
+		slog.Error("OCR processing failed", "message_id", messageID, "url", media.URL, "id", media.ID, "error", err)
 		doc.OCRStatus = "failed"
 		errStr := err.Error()
 		doc.OCRError = &errStr
@@ -996,17 +1265,17 @@ func (r *Router) processIncomingMedia(ctx context.Context, mediaURL, mediaType s
 	}
 
 	if err := r.docRepo.Create(ctx, doc); err != nil {
-		slog.Error("failed to save document", "error", err)
+		slog.Error("failed to save document", "message_id", messageID, "url", media.URL, "id", media.ID, "error", err)
 		return
 	}
 
-	slog.Info("document saved", "doc_id", doc.ID, "ocr_status", doc.OCRStatus)
+	slog.Info("document saved", "doc_id", doc.ID, "message_id", messageID, "ocr_status", doc.OCRStatus)
 
 	// Auto-match if OCR succeeded
 	if doc.OCRStatus == "completed" && clientID != nil {
 		proposal, err := r.matchingSvc.AutoMatch(ctx, doc)
 		if err != nil {
-			slog.Error("auto-match failed", "error", err)
+			slog.Error("auto-match failed", "doc_id", doc.ID, "error", err)
 		} else if proposal != nil {
 			slog.Info("match proposal created",
 				"doc_id", doc.ID,
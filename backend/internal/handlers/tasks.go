@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/middleware"
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/repository"
+)
+
+// listTasks handles GET /api/v1/tasks
+func (r *Router) listTasks(w http.ResponseWriter, req *http.Request) {
+	cabinetID, ok := middleware.GetCabinetID(req.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	filter := repository.TaskFilter{CabinetID: cabinetID}
+	query := req.URL.Query()
+
+	if status := query.Get("status"); status != "" {
+		s := models.TaskStatus(status)
+		filter.Status = &s
+	}
+	if assignedTo := query.Get("assigned_to"); assignedTo != "" {
+		id, err := uuid.Parse(assignedTo)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid collaborator ID")
+			return
+		}
+		filter.AssignedTo = &id
+	}
+	if lineID := query.Get("pending_line_id"); lineID != "" {
+		id, err := uuid.Parse(lineID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pending line ID")
+			return
+		}
+		filter.PendingLineID = &id
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil {
+			filter.Limit = n
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if n, err := strconv.Atoi(offset); err == nil {
+			filter.Offset = n
+		}
+	}
+
+	tasks, err := r.taskRepo.List(req.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list tasks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"tasks": tasks,
+		"total": len(tasks),
+	})
+}
+
+// updateTask handles PATCH /api/v1/tasks/{id}
+func (r *Router) updateTask(w http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid task ID")
+		return
+	}
+
+	var body struct {
+		Status models.TaskStatus `json:"status"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	switch body.Status {
+	case models.TaskOpen, models.TaskDone, models.TaskCancelled:
+	default:
+		writeError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	task, err := r.taskRepo.GetByID(req.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+	cabinetID, _ := middleware.GetCabinetID(req.Context())
+	if task == nil || task.CabinetID != cabinetID {
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	var userID *uuid.UUID
+	if uid, ok := middleware.GetUserID(req.Context()); ok {
+		userID = &uid
+	}
+
+	if err := r.taskRepo.UpdateStatus(req.Context(), id, body.Status, userID); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update task")
+		return
+	}
+
+	task, _ = r.taskRepo.GetByID(req.Context(), id)
+	writeJSON(w, http.StatusOK, task)
+}
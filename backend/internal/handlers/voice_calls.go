@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/services"
+	"github.com/fiducia/backend/pkg/telephony"
+)
+
+// voiceGatherWebhook handles POST /webhook/voice/gather?message_id=...
+// Receives the key pressed by the client at the end of a campaign call and answers
+// with the TwiML read back before hanging up.
+func (r *Router) voiceGatherWebhook(w http.ResponseWriter, req *http.Request) {
+	if r.callSvc == nil {
+		http.Error(w, "Phone calls not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	messageID, err := uuid.Parse(req.URL.Query().Get("message_id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	digits := req.FormValue("Digits")
+
+	slog.Info("received call keypress", "call_sid", req.FormValue("CallSid"), "message_id", messageID, "digits", digits)
+
+	reply := "Merci, au revoir."
+	if digits != "" {
+		reply, err = r.callSvc.HandleKeypress(req.Context(), messageID, digits)
+		if err != nil {
+			slog.Error("failed to record call keypress", "message_id", messageID, "error", err)
+			reply = "Merci, au revoir."
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, telephony.SayTwiML(reply, "fr-FR"))
+}
+
+// voiceStatusWebhook handles POST /webhook/voice/status
+// Fails when the outcome could not be saved, for Twilio to retry it, including when
+// the call is not saved yet.
+func (r *Router) voiceStatusWebhook(w http.ResponseWriter, req *http.Request) {
+	if r.callSvc == nil {
+		http.Error(w, "Phone calls not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	callSID := req.FormValue("CallSid")
+	status := req.FormValue("CallStatus")
+	slog.Info("received call status", "call_sid", callSID, "status", status, "duration", req.FormValue("CallDuration"))
+
+	if err := r.callSvc.HandleStatus(req.Context(), callSID, status, formInt(req, "CallDuration")); err != nil {
+		if errors.Is(err, services.ErrUnknownCall) {
+			slog.Warn("status of an unknown call, left for Twilio to retry", "call_sid", callSID, "status", status)
+		} else {
+			slog.Error("failed to update call status", "call_sid", callSID, "error", err)
+		}
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// voiceRecordingWebhook handles POST /webhook/voice/recording
+func (r *Router) voiceRecordingWebhook(w http.ResponseWriter, req *http.Request) {
+	if r.callSvc == nil {
+		http.Error(w, "Phone calls not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	callSID := req.FormValue("CallSid")
+	recordingURL := req.FormValue("RecordingUrl")
+	if req.FormValue("RecordingStatus") != "completed" || recordingURL == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := r.callSvc.HandleRecording(req.Context(), callSID, recordingURL, formInt(req, "RecordingDuration")); err != nil {
+		slog.Error("failed to store call recording", "call_sid", callSID, "error", err)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// formInt returns an integer form value, nil when missing or invalid
+func formInt(req *http.Request, key string) *int {
+	n, err := strconv.Atoi(req.FormValue(key))
+	if err != nil {
+		return nil
+	}
+	return &n
+}
@@ -2,9 +2,6 @@ package handlers
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,6 +9,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -21,6 +19,7 @@ import (
 	"github.com/fiducia/backend/internal/models"
 	"github.com/fiducia/backend/internal/repository"
 	"github.com/fiducia/backend/internal/services"
+	"github.com/fiducia/backend/pkg/whatsapp"
 )
 
 // WebhookHandler handles incoming WhatsApp webhooks
@@ -282,7 +281,7 @@ func (h *WebhookHandler) HandleStatusCallback(w http.ResponseWriter, r *http.Req
 	}
 
 	// Update message status in database
-	if err := h.msgRepo.UpdateStatusByWAID(r.Context(), payload.MessageSid, status); err != nil {
+	if _, err := h.msgRepo.UpdateStatusByWAID(r.Context(), payload.MessageSid, status, time.Now(), nil, nil); err != nil {
 		slog.Error("failed to update message status", "error", err)
 	}
 
@@ -297,31 +296,11 @@ func (h *WebhookHandler) verifyTwilioSignature(r *http.Request) bool {
 		return true
 	}
 
-	signature := r.Header.Get("X-Twilio-Signature")
-	if signature == "" {
+	if err := r.ParseForm(); err != nil {
 		return false
 	}
-
-	// Build the full URL
-	url := "https://" + r.Host + r.URL.Path
-
-	// Get POST parameters sorted and concatenated
-	var params []string
-	for key, values := range r.Form {
-		for _, value := range values {
-			params = append(params, key+value)
-		}
-	}
-
-	// Create HMAC-SHA1 signature
-	mac := hmac.New(sha256.New, []byte(h.cfg.TwilioAuthToken))
-	mac.Write([]byte(url))
-	for _, param := range params {
-		mac.Write([]byte(param))
-	}
-	expectedSig := hex.EncodeToString(mac.Sum(nil))
-
-	return hmac.Equal([]byte(signature), []byte(expectedSig))
+	callURL := strings.TrimRight(h.cfg.BaseURL, "/") + r.URL.RequestURI()
+	return whatsapp.ValidateTwilioSignature(h.cfg.TwilioAuthToken, callURL, r.PostForm, r.Header.Get("X-Twilio-Signature"))
 }
 
 // WebhookResponse for JSON API responses
@@ -80,3 +80,22 @@ func GetCabinetID(ctx context.Context) (uuid.UUID, bool) {
 	id, ok := ctx.Value(CabinetIDKey).(uuid.UUID)
 	return id, ok
 }
+
+// GetRole helper
+func GetRole(ctx context.Context) string {
+	role, _ := ctx.Value(RoleKey).(string)
+	return role
+}
+
+// RequireRole middleware rejects users without the given role. It runs after Auth.
+func RequireRole(role string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetRole(r.Context()) != role {
+				http.Error(w, `{"error": "insufficient permissions"}`, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
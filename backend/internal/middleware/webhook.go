@@ -0,0 +1,398 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiducia/backend/internal/config"
+	"github.com/fiducia/backend/pkg/whatsapp"
+)
+
+const (
+	maxWebhookBody = 1 << 20
+	// Signed events older than this, or further in the future than the clock skew,
+	// are replays
+	maxEventAge  = 24 * time.Hour
+	maxClockSkew = 5 * time.Minute
+	// Event keys are kept longer than maxEventAge, as Twilio events carry no timestamp
+	eventRetention = 7 * 24 * time.Hour
+)
+
+// ProviderTwilioVoice names the events of the Twilio voice callbacks in the EventStore
+const ProviderTwilioVoice = "twilio_voice"
+
+// emptyTwiML answers the replayed voice callbacks: Twilio reads the body of a gather
+// callback, an empty one being an error for the caller
+const emptyTwiML = `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`
+
+// EventStore records the webhook events already received
+type EventStore interface {
+	// Record stores the keys of an event and returns those not stored before
+	Record(ctx context.Context, provider string, keys []string) ([]string, error)
+	// Forget deletes keys stored by Record
+	Forget(ctx context.Context, provider string, keys []string) error
+	// Purge deletes the keys stored before a time
+	Purge(ctx context.Context, before time.Time) error
+}
+
+// WebhookGuard verifies the signature of provider webhooks and rejects replays
+type WebhookGuard struct {
+	cfg       *config.Config
+	events    EventStore
+	lastPurge atomic.Int64
+}
+
+// NewWebhookGuard creates a guard verifying webhooks with the provider secrets of cfg.
+// Outside production, the webhooks of a provider without secret are not verified.
+func NewWebhookGuard(cfg *config.Config, events EventStore) *WebhookGuard {
+	if !cfg.IsProduction() {
+		if cfg.TwilioAuthToken == "" {
+			slog.Warn("Twilio webhook signatures not verified: TWILIO_AUTH_TOKEN is not set")
+		}
+		if cfg.MetaAppSecret == "" {
+			slog.Warn("Meta webhook signatures not verified: META_APP_SECRET is not set")
+		}
+	}
+	return &WebhookGuard{cfg: cfg, events: events}
+}
+
+// Twilio rejects the requests without a valid X-Twilio-Signature
+func (g *WebhookGuard) Twilio(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.skip(g.cfg.TwilioAuthToken) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		var params url.Values
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			params, _ = url.ParseQuery(string(body))
+		}
+		signature := r.Header.Get("X-Twilio-Signature")
+		for _, u := range g.publicURLs(r) {
+			if whatsapp.ValidateTwilioSignature(g.cfg.TwilioAuthToken, u, params, signature) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		slog.Warn("invalid Twilio webhook signature", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// Meta rejects the requests without a valid X-Hub-Signature-256
+func (g *WebhookGuard) Meta(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.skip(g.cfg.MetaAppSecret) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		if !whatsapp.ValidateMetaSignature(g.cfg.MetaAppSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+			slog.Warn("invalid Meta webhook signature", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RejectReplays acknowledges without processing them the webhooks whose events were
+// all received before or are too old. It runs after the signature check, the IDs and
+// timestamps being trusted only once signed.
+//
+// A webhook carrying several events, as Meta sends them, reaches the handler when one
+// of them is new: the handler skips the others with ProcessedMessage and
+// ProcessedStatus. Events whose processing fails are forgotten, so that the retry of
+// the provider goes through: those reported with FailMessage and FailStatus, or all
+// the new events of the request when the handler fails without reporting any.
+func (g *WebhookGuard) RejectReplays(provider string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := readBody(w, r)
+			if !ok {
+				return
+			}
+			keys, at := webhookEvents(provider, body)
+
+			now := time.Now()
+			if !at.IsZero() && (now.Sub(at) > maxEventAge || at.Sub(now) > maxClockSkew) {
+				slog.Warn("stale webhook ignored", "provider", provider, "event_time", at)
+				acknowledgeReplay(w, provider)
+				return
+			}
+
+			state := &webhookState{provider: provider, processed: make(map[string]bool)}
+			var recorded []string
+			if len(keys) > 0 && g.events != nil {
+				var err error
+				recorded, err = g.events.Record(r.Context(), provider, keys)
+				if err != nil {
+					// The signature is valid: a store failure must not lose the event
+					slog.Error("failed to record webhook events", "provider", provider, "error", err)
+				} else if len(recorded) == 0 {
+					slog.Warn("replayed webhook ignored", "provider", provider, "events", keys)
+					acknowledgeReplay(w, provider)
+					return
+				} else {
+					for _, k := range keys {
+						state.processed[k] = true
+					}
+					for _, k := range recorded {
+						delete(state.processed, k)
+					}
+				}
+				g.purge(now)
+			}
+
+			wrapped := wrapResponseWriter(w)
+			defer func() {
+				p := recover()
+				if len(recorded) > 0 {
+					g.forgetFailed(context.WithoutCancel(r.Context()), state, recorded, p != nil || wrapped.status >= http.StatusMultipleChoices)
+				}
+				if p != nil {
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), webhookStateKey{}, state)))
+		})
+	}
+}
+
+// forgetFailed forgets the events of a request whose processing failed: those the
+// handler reported, or all the events it recorded when the handler failed without
+// reporting any
+func (g *WebhookGuard) forgetFailed(ctx context.Context, state *webhookState, recorded []string, handlerFailed bool) {
+	state.mu.Lock()
+	var failed []string
+	for _, k := range recorded {
+		if state.failed[k] {
+			failed = append(failed, k)
+		}
+	}
+	state.mu.Unlock()
+	if len(failed) == 0 && handlerFailed {
+		failed = recorded
+	}
+	if len(failed) == 0 {
+		return
+	}
+	if err := g.events.Forget(ctx, state.provider, failed); err != nil {
+		slog.Error("failed to forget webhook events", "provider", state.provider, "error", err)
+	}
+}
+
+// acknowledgeReplay answers a webhook that is not processed, for the provider not to
+// retry it
+func acknowledgeReplay(w http.ResponseWriter, provider string) {
+	if provider == ProviderTwilioVoice {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, emptyTwiML)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// webhookStateKey is the context key of the events of the webhook being handled
+type webhookStateKey struct{}
+
+// webhookState holds the events of a webhook already processed by an earlier delivery,
+// and those the handler failed to process
+type webhookState struct {
+	provider  string
+	processed map[string]bool
+	mu        sync.Mutex
+	failed    map[string]bool
+}
+
+// ProcessedMessage reports whether an inbound message of the webhook being handled was
+// processed by an earlier delivery of the webhook
+func ProcessedMessage(ctx context.Context, in whatsapp.InboundMessage) bool {
+	state, _ := ctx.Value(webhookStateKey{}).(*webhookState)
+	return state != nil && state.processed[messageKey(state.provider, in.ID)]
+}
+
+// ProcessedStatus reports whether a status of the webhook being handled was processed
+// by an earlier delivery of the webhook
+func ProcessedStatus(ctx context.Context, update whatsapp.StatusUpdate) bool {
+	state, _ := ctx.Value(webhookStateKey{}).(*webhookState)
+	return state != nil && state.processed[statusKey(state.provider, update.MessageID, update.Status)]
+}
+
+// FailMessage reports an inbound message of the webhook being handled that could not
+// be processed, to be processed again on the retry of the provider
+func FailMessage(ctx context.Context, in whatsapp.InboundMessage) {
+	fail(ctx, func(provider string) string { return messageKey(provider, in.ID) })
+}
+
+// FailStatus reports a status of the webhook being handled that could not be
+// processed, to be processed again on the retry of the provider
+func FailStatus(ctx context.Context, update whatsapp.StatusUpdate) {
+	fail(ctx, func(provider string) string { return statusKey(provider, update.MessageID, update.Status) })
+}
+
+func fail(ctx context.Context, key func(provider string) string) {
+	state, _ := ctx.Value(webhookStateKey{}).(*webhookState)
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.failed == nil {
+		state.failed = make(map[string]bool)
+	}
+	state.failed[key(state.provider)] = true
+}
+
+// messageKey returns the event key of an inbound message
+func messageKey(provider, id string) string {
+	if provider == whatsapp.ProviderTwilio {
+		return id + ":received"
+	}
+	return "message:" + id
+}
+
+// statusKey returns the event key of a status of an outbound message
+func statusKey(provider, id, status string) string {
+	if provider == whatsapp.ProviderTwilio {
+		return id + ":" + status
+	}
+	return "status:" + id + ":" + status
+}
+
+// skip returns true when webhooks of a provider without secret are accepted unverified
+func (g *WebhookGuard) skip(secret string) bool {
+	return secret == "" && !g.cfg.IsProduction()
+}
+
+// publicURLs returns the URLs the provider may have called: from BaseURL, then from
+// the host and scheme forwarded by a proxy
+func (g *WebhookGuard) publicURLs(r *http.Request) []string {
+	var urls []string
+	if g.cfg.BaseURL != "" {
+		urls = append(urls, strings.TrimRight(g.cfg.BaseURL, "/")+r.URL.RequestURI())
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if h := firstValue(r.Header.Get("X-Forwarded-Host")); h != "" {
+		host = h
+	}
+	forwarded := scheme + "://" + host + r.URL.RequestURI()
+	if len(urls) == 0 || urls[0] != forwarded {
+		urls = append(urls, forwarded)
+	}
+	return urls
+}
+
+// purge deletes the old event keys, at most once per hour
+func (g *WebhookGuard) purge(now time.Time) {
+	last := g.lastPurge.Load()
+	if now.Unix()-last < int64(time.Hour/time.Second) || !g.lastPurge.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	go func() {
+		if err := g.events.Purge(context.Background(), now.Add(-eventRetention)); err != nil {
+			slog.Error("failed to purge webhook events", "error", err)
+		}
+	}()
+}
+
+// webhookEvents returns the keys identifying the events of a webhook body, and the
+// time of the latest one, zero when the provider gives none
+func webhookEvents(provider string, body []byte) ([]string, time.Time) {
+	var keys []string
+	var latest time.Time
+	switch provider {
+	case whatsapp.ProviderTwilio:
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, latest
+		}
+		// A message and each of its statuses are separate events
+		update := whatsapp.ParseTwilioStatus(form)
+		switch {
+		case update.MessageID == "":
+		case update.Status == "":
+			keys = append(keys, messageKey(provider, update.MessageID))
+		default:
+			keys = append(keys, statusKey(provider, update.MessageID, update.Status))
+		}
+	case ProviderTwilioVoice:
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, latest
+		}
+		// A call is answered once, and reports each status and recording once
+		callSID := form.Get("CallSid")
+		switch {
+		case form.Get("RecordingStatus") != "":
+			keys = append(keys, "recording:"+form.Get("RecordingSid")+":"+form.Get("RecordingStatus"))
+		case callSID == "":
+		case form.Has("Digits"):
+			keys = append(keys, callSID+":gather")
+		case form.Get("CallStatus") != "":
+			keys = append(keys, callSID+":"+form.Get("CallStatus"))
+		}
+	case whatsapp.ProviderMeta:
+		events, err := whatsapp.ParseMetaWebhook(body)
+		if err != nil {
+			return nil, latest
+		}
+		for _, m := range events.Messages {
+			keys = append(keys, messageKey(provider, m.ID))
+			if m.Timestamp.After(latest) {
+				latest = m.Timestamp
+			}
+		}
+		for _, s := range events.Statuses {
+			keys = append(keys, statusKey(provider, s.MessageID, s.Status))
+			if s.Timestamp.After(latest) {
+				latest = s.Timestamp
+			}
+		}
+	}
+	return keys, latest
+}
+
+// readBody reads the request body and restores it for the next handlers
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// firstValue returns the first of comma-separated header values, set by each proxy
+func firstValue(header string) string {
+	v, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiducia/backend/internal/config"
+	"github.com/fiducia/backend/pkg/whatsapp"
+)
+
+// memoryEvents is an in-memory EventStore
+type memoryEvents struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (m *memoryEvents) Record(ctx context.Context, provider string, keys []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var recorded []string
+	for _, k := range keys {
+		if !m.keys[provider+"/"+k] {
+			m.keys[provider+"/"+k] = true
+			recorded = append(recorded, k)
+		}
+	}
+	return recorded, nil
+}
+
+func (m *memoryEvents) Forget(ctx context.Context, provider string, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		delete(m.keys, provider+"/"+k)
+	}
+	return nil
+}
+
+func (m *memoryEvents) Purge(ctx context.Context, before time.Time) error { return nil }
+
+// metaBody returns a Cloud API webhook carrying inbound messages
+func metaBody(ids ...string) string {
+	ts := time.Now().Unix()
+	messages := make([]string, len(ids))
+	for i, id := range ids {
+		messages[i] = fmt.Sprintf(`{"id":%q,"from":"33600000000","timestamp":"%d","type":"text","text":{"body":"Bonjour"}}`, id, ts)
+	}
+	return `{"object":"whatsapp_business_account","entry":[{"changes":[{"field":"messages","value":{"messages":[` +
+		strings.Join(messages, ",") + `]}}]}]}`
+}
+
+func TestRejectReplaysMeta(t *testing.T) {
+	events := &memoryEvents{keys: make(map[string]bool)}
+	guard := NewWebhookGuard(&config.Config{}, events)
+
+	// The handler processes the messages not processed before, failing those in failing
+	var mu sync.Mutex
+	var processed []string
+	failing := map[string]bool{}
+	handler := guard.RejectReplays(whatsapp.ProviderMeta)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		batch, err := whatsapp.ParseMetaWebhook(body)
+		if err != nil {
+			t.Fatalf("handler got an invalid body: %v", err)
+		}
+		failed := false
+		for _, msg := range batch.Messages {
+			if ProcessedMessage(r.Context(), msg) {
+				continue
+			}
+			mu.Lock()
+			processed = append(processed, msg.ID)
+			mu.Unlock()
+			if failing[msg.ID] {
+				FailMessage(r.Context(), msg)
+				failed = true
+			}
+		}
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	deliver := func(body string) int {
+		processed = nil
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhook/whatsapp/meta", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	body := metaBody("wamid.1", "wamid.2")
+	failing["wamid.2"] = true
+	if code := deliver(body); code != http.StatusInternalServerError {
+		t.Fatalf("first delivery answered %d, want 500", code)
+	}
+	if fmt.Sprint(processed) != "[wamid.1 wamid.2]" {
+		t.Fatalf("first delivery processed %v, want both messages", processed)
+	}
+
+	// The retry only processes the message that failed
+	delete(failing, "wamid.2")
+	if code := deliver(body); code != http.StatusOK {
+		t.Fatalf("retry answered %d, want 200", code)
+	}
+	if fmt.Sprint(processed) != "[wamid.2]" {
+		t.Fatalf("retry processed %v, want the failed message only", processed)
+	}
+
+	// Once all processed, a replay does not reach the handler
+	if code := deliver(body); code != http.StatusOK || processed != nil {
+		t.Fatalf("replay answered %d and processed %v, want 200 and nothing", code, processed)
+	}
+}
+
+func TestRejectReplaysForgetsFailedRequests(t *testing.T) {
+	events := &memoryEvents{keys: make(map[string]bool)}
+	guard := NewWebhookGuard(&config.Config{}, events)
+
+	calls := 0
+	status := http.StatusServiceUnavailable
+	handler := guard.RejectReplays(whatsapp.ProviderTwilio)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}))
+	deliver := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/whatsapp/status", strings.NewReader("MessageSid=SM1&MessageStatus=delivered"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A failure without event reported forgets the whole request
+	deliver()
+	status = http.StatusOK
+	if code := deliver(); code != http.StatusOK || calls != 2 {
+		t.Fatalf("retry answered %d after %d calls, want 200 after 2", code, calls)
+	}
+	if deliver(); calls != 2 {
+		t.Fatalf("replay reached the handler")
+	}
+}
+
+func TestRejectReplaysVoice(t *testing.T) {
+	events := &memoryEvents{keys: make(map[string]bool)}
+	guard := NewWebhookGuard(&config.Config{}, events)
+
+	calls := 0
+	handler := guard.RejectReplays(ProviderTwilioVoice)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	deliver := func(form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/voice/gather", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	deliver("CallSid=CA1&CallStatus=in-progress&Digits=2")
+	rec := deliver("CallSid=CA1&CallStatus=in-progress&Digits=2")
+	if calls != 1 {
+		t.Fatalf("replayed keypress reached the handler")
+	}
+	if !strings.Contains(rec.Body.String(), "<Response>") {
+		t.Errorf("replayed keypress answered %q, want TwiML", rec.Body.String())
+	}
+
+	// The statuses and recording of the call are separate events
+	deliver("CallSid=CA1&CallStatus=completed&CallDuration=30&RecordingSid=RE1")
+	deliver("CallSid=CA1&RecordingSid=RE1&RecordingStatus=completed&RecordingUrl=https://example.com/RE1")
+	if calls != 3 {
+		t.Fatalf("%d calls of the handler, want 3", calls)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 )
 
 // CampaignTriggerType represents when a campaign starts
@@ -20,10 +21,19 @@ type CampaignChannel string
 const (
 	ChannelWhatsApp     CampaignChannel = "whatsapp"
 	ChannelEmail        CampaignChannel = "email"
-	ChannelVoice        CampaignChannel = "voice"
+	ChannelVoice        CampaignChannel = "voice" // WhatsApp voice note
+	ChannelCall         CampaignChannel = "call"  // Automated phone call
 	ChannelNotification CampaignChannel = "notification"
 )
 
+// CampaignGroupBy represents what a campaign execution relances
+type CampaignGroupBy string
+
+const (
+	GroupByLine   CampaignGroupBy = "line"   // One execution per pending line
+	GroupByClient CampaignGroupBy = "client" // One execution per client covering all its open lines
+)
+
 // ExecutionStatus represents the state of a campaign execution
 type ExecutionStatus string
 
@@ -33,6 +43,19 @@ const (
 	ExecStatusCompleted ExecutionStatus = "completed"
 	ExecStatusStopped   ExecutionStatus = "stopped"
 	ExecStatusFailed    ExecutionStatus = "failed"
+	ExecStatusPaused    ExecutionStatus = "paused" // Ignored by the worker until resumed
+)
+
+// ExecutionAction is a manual action on a campaign execution
+type ExecutionAction string
+
+const (
+	ExecActionPause   ExecutionAction = "pause"
+	ExecActionResume  ExecutionAction = "resume"
+	ExecActionSkip    ExecutionAction = "skip"    // Run a given step next
+	ExecActionRestart ExecutionAction = "restart" // Start again from the first step
+	ExecActionStop    ExecutionAction = "stop"
+	ExecActionMigrate ExecutionAction = "migrate" // Moved to another campaign version
 )
 
 // StopReason represents why a campaign was stopped
@@ -43,18 +66,73 @@ const (
 	StopManualValidated StopReason = "manual_validaton"
 	StopClientRefusal   StopReason = "client_refusal"
 	StopCompleted       StopReason = "completed"
+	StopEscalated       StopReason = "escalated"
+	StopLinesResolved   StopReason = "lines_resolved" // Every line of a client execution was resolved
+	StopManual          StopReason = "manual_stop"
+	StopDuplicate       StopReason = "duplicate" // Duplicate enrollment of a line, stopped by migration 009
+)
+
+// StepConditionType identifies a condition evaluated when a step is due
+type StepConditionType string
+
+const (
+	// CondNotReadAfter matches if the previous campaign message was sent at least Hours ago and is still unread
+	CondNotReadAfter StepConditionType = "not_read_after"
+	// CondRepliedWithoutDocument matches if the client replied since the previous step without sending a document
+	CondRepliedWithoutDocument StepConditionType = "replied_without_document"
+	// CondAmountAbove matches if the absolute amount of the line (total for a client execution) is above Amount
+	CondAmountAbove StepConditionType = "amount_above"
+	// CondAmountBelow matches if the absolute amount of the line (total for a client execution) is below Amount
+	CondAmountBelow StepConditionType = "amount_below"
 )
 
+// StepActionType identifies what happens when a step rule matches
+type StepActionType string
+
+const (
+	ActionGoto          StepActionType = "goto"           // Run StepOrder instead of this step (forward only)
+	ActionSwitchChannel StepActionType = "switch_channel" // Run this step on Channel
+	ActionSkip          StepActionType = "skip"           // Skip this step
+	ActionEscalate      StepActionType = "escalate"       // Create a task for the collaborator and stop
+)
+
+// StepRule is evaluated before a step is executed; the first matching rule applies
+type StepRule struct {
+	Condition StepCondition `json:"condition"`
+	Action    StepAction    `json:"action"`
+}
+
+// StepCondition describes when a rule matches
+type StepCondition struct {
+	Type   StepConditionType `json:"type"`
+	Hours  int               `json:"hours,omitempty"`
+	Amount *decimal.Decimal  `json:"amount,omitempty"`
+}
+
+// StepAction describes what a matching rule does
+type StepAction struct {
+	Type      StepActionType  `json:"type"`
+	StepOrder int             `json:"step_order,omitempty"`
+	Channel   CampaignChannel `json:"channel,omitempty"`
+	Note      string          `json:"note,omitempty"` // Task description for escalations
+}
+
 // Campaign represents a sequence of automated actions
 type Campaign struct {
-	ID                uuid.UUID           `json:"id"`
-	CabinetID         uuid.UUID           `json:"cabinet_id"`
-	Name              string              `json:"name"`
-	TriggerType       CampaignTriggerType `json:"trigger_type"`
-	IsActive          bool                `json:"is_active"`
-	QuietHoursEnabled bool                `json:"quiet_hours_enabled"`
-	CreatedAt         time.Time           `json:"created_at"`
-	UpdatedAt         time.Time           `json:"updated_at"`
+	ID                   uuid.UUID           `json:"id"`
+	CabinetID            uuid.UUID           `json:"cabinet_id"`
+	Name                 string              `json:"name"`
+	TriggerType          CampaignTriggerType `json:"trigger_type"`
+	GroupBy              CampaignGroupBy     `json:"group_by"`
+	Priority             int                 `json:"priority"`            // Highest priority wins when several campaigns target a line
+	Targeting            *CampaignTargeting  `json:"targeting,omitempty"` // Lines enrolled by on_pending, all when nil
+	Version              int                 `json:"version"`             // Current version of the steps
+	IsActive             bool                `json:"is_active"`
+	QuietHoursEnabled    bool                `json:"quiet_hours_enabled"`
+	EscalateOnExhaustion bool                `json:"escalate_on_exhaustion"` // Create a task when all steps ran without result
+	EscalateAfterHours   int                 `json:"escalate_after_hours"`   // Delay after the last step before escalating
+	CreatedAt            time.Time           `json:"created_at"`
+	UpdatedAt            time.Time           `json:"updated_at"`
 
 	// Relations
 	Steps []CampaignStep `json:"steps,omitempty"`
@@ -69,19 +147,270 @@ type CampaignStep struct {
 	Channel    CampaignChannel `json:"channel"`
 	TemplateID string          `json:"template_id"`
 	Config     map[string]any  `json:"config,omitempty"`
+	Rules      []StepRule      `json:"rules,omitempty"`
+	Variants   []StepVariant   `json:"variants,omitempty"`
 	CreatedAt  time.Time       `json:"created_at"`
 }
 
-// CampaignExecution tracks the progress of a campaign for a specific pending line
+// CampaignTargeting restricts the pending lines a campaign enrolls. Every criterion
+// set must match; list criteria match when any of their values does.
+type CampaignTargeting struct {
+	MinAmount       *decimal.Decimal `json:"min_amount,omitempty"` // Absolute amount of the line
+	MaxAmount       *decimal.Decimal `json:"max_amount,omitempty"`
+	AccountPrefixes []string         `json:"account_prefixes,omitempty"`
+	ClientTags      []string         `json:"client_tags,omitempty"`  // Case insensitive
+	AssignedTo      []uuid.UUID      `json:"assigned_to,omitempty"`  // Collaborators the line is assigned to
+	MinAgeDays      int              `json:"min_age_days,omitempty"` // Days since the transaction date
+}
+
+// StepDefinition is the content of a step without its identity, as stored in campaign
+// versions and exports
+type StepDefinition struct {
+	StepOrder  int             `json:"step_order"`
+	DelayHours int             `json:"delay_hours"`
+	Channel    CampaignChannel `json:"channel"`
+	TemplateID string          `json:"template_id,omitempty"`
+	Config     map[string]any  `json:"config,omitempty"`
+	Rules      []StepRule      `json:"rules,omitempty"`
+	Variants   []StepVariant   `json:"variants,omitempty"`
+}
+
+// Definition returns the content of the step
+func (s CampaignStep) Definition() StepDefinition {
+	return StepDefinition{
+		StepOrder:  s.StepOrder,
+		DelayHours: s.DelayHours,
+		Channel:    s.Channel,
+		TemplateID: s.TemplateID,
+		Config:     s.Config,
+		Rules:      s.Rules,
+		Variants:   s.Variants,
+	}
+}
+
+// Step returns a step of campaignID with this content
+func (d StepDefinition) Step(campaignID uuid.UUID) CampaignStep {
+	return CampaignStep{
+		CampaignID: campaignID,
+		StepOrder:  d.StepOrder,
+		DelayHours: d.DelayHours,
+		Channel:    d.Channel,
+		TemplateID: d.TemplateID,
+		Config:     d.Config,
+		Rules:      d.Rules,
+		Variants:   d.Variants,
+	}
+}
+
+// CampaignVersion is an immutable snapshot of the steps of a campaign.
+// Executions run the version they were enrolled on until explicitly migrated.
+type CampaignVersion struct {
+	CampaignID uuid.UUID        `json:"campaign_id"`
+	Version    int              `json:"version"`
+	Steps      []StepDefinition `json:"steps"`
+	CreatedAt  time.Time        `json:"created_at"`
+
+	// Enriched Fields (populated via joins)
+	ActiveExecutions int64 `json:"active_executions"`
+}
+
+// CampaignExport is the portable JSON form of a campaign, used to copy it between cabinets
+type CampaignExport struct {
+	Format               string              `json:"format"`
+	ExportedAt           time.Time           `json:"exported_at"`
+	Name                 string              `json:"name"`
+	TriggerType          CampaignTriggerType `json:"trigger_type"`
+	GroupBy              CampaignGroupBy     `json:"group_by"`
+	Priority             int                 `json:"priority"`
+	Targeting            *CampaignTargeting  `json:"targeting,omitempty"`
+	QuietHoursEnabled    bool                `json:"quiet_hours_enabled"`
+	EscalateOnExhaustion bool                `json:"escalate_on_exhaustion"`
+	EscalateAfterHours   int                 `json:"escalate_after_hours"`
+	Steps                []StepDefinition    `json:"steps"`
+}
+
+// StepVariant is an alternative content of a step for A/B testing. Its config keys
+// override those of the step. Executions are assigned a variant deterministically,
+// so steps sharing the same keys and weights keep each execution on the same variant.
+type StepVariant struct {
+	Key    string         `json:"key"`
+	Weight int            `json:"weight"`
+	Config map[string]any `json:"config,omitempty"`
+}
+
+// CampaignExecution tracks the progress of a campaign for a specific pending line,
+// or for all the open lines of a client when the campaign is grouped by client
 type CampaignExecution struct {
 	ID                  uuid.UUID       `json:"id"`
 	CampaignID          uuid.UUID       `json:"campaign_id"`
-	PendingLineID       uuid.UUID       `json:"pending_line_id"`
+	CampaignVersion     int             `json:"campaign_version"`          // Steps version the execution runs
+	PendingLineID       *uuid.UUID      `json:"pending_line_id,omitempty"` // Line executions
+	ClientID            *uuid.UUID      `json:"client_id,omitempty"`       // Client executions
 	CurrentStepOrder    int             `json:"current_step_order"`
 	Status              ExecutionStatus `json:"status"`
 	StopReason          *StopReason     `json:"stop_reason,omitempty"`
 	LastStepExecutedAt  *time.Time      `json:"last_step_executed_at,omitempty"`
 	NextStepScheduledAt *time.Time      `json:"next_step_scheduled_at,omitempty"`
+	PausedUntil         *time.Time      `json:"paused_until,omitempty"` // Resumed automatically after this date
+	LockedBy            *string         `json:"locked_by,omitempty"`    // Worker currently holding the lease
+	LockedUntil         *time.Time      `json:"locked_until,omitempty"` // Lease expiry
 	CreatedAt           time.Time       `json:"created_at"`
 	UpdatedAt           time.Time       `json:"updated_at"`
+
+	// Enriched Fields (populated via joins)
+	LineStatus   *PendingLineStatus `json:"line_status,omitempty"`
+	CampaignName *string            `json:"campaign_name,omitempty"`
+}
+
+// CampaignExecutionEvent records a manual action on an execution
+type CampaignExecutionEvent struct {
+	ID          uuid.UUID       `json:"id"`
+	ExecutionID uuid.UUID       `json:"execution_id"`
+	Action      ExecutionAction `json:"action"`
+	Reason      *string         `json:"reason,omitempty"`
+	ActorID     *uuid.UUID      `json:"actor_id,omitempty"` // Nil when done by the worker
+	FromStatus  ExecutionStatus `json:"from_status"`
+	ToStatus    ExecutionStatus `json:"to_status"`
+	FromStep    int             `json:"from_step"`
+	ToStep      int             `json:"to_step"`
+	CreatedAt   time.Time       `json:"created_at"`
+
+	// Enriched Fields (populated via joins)
+	ActorName *string `json:"actor_name,omitempty"`
+}
+
+// CampaignCycle reports the last campaign cycle run by a worker
+type CampaignCycle struct {
+	WorkerID    string    `json:"worker_id"`
+	Leader      bool      `json:"leader"` // Ran the enrollment phase
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DurationMs  int64     `json:"duration_ms"`
+	Enrolled    int64     `json:"enrolled"`
+	Stopped     int64     `json:"stopped"`
+	Claimed     int64     `json:"claimed"`
+	Executed    int64     `json:"executed"`
+	Completed   int64     `json:"completed"`
+	Rescheduled int64     `json:"rescheduled"`
+	Failed      int64     `json:"failed"`
+	Error       *string   `json:"error,omitempty"`
+}
+
+// SimulatedAction is what a dry run plans for a step
+type SimulatedAction string
+
+const (
+	SimSend     SimulatedAction = "send"     // Message sent to the client
+	SimTask     SimulatedAction = "task"     // Task created for the collaborator
+	SimSkip     SimulatedAction = "skip"     // Step skipped by a rule or unsupported channel
+	SimEscalate SimulatedAction = "escalate" // Execution handed over to the collaborator
+)
+
+// SimulatedStep is a planned action in a campaign dry run
+type SimulatedStep struct {
+	StepOrder   int                `json:"step_order"`
+	Channel     CampaignChannel    `json:"channel,omitempty"`
+	Action      SimulatedAction    `json:"action"`
+	ScheduledAt time.Time          `json:"scheduled_at"`
+	Rescheduled bool               `json:"rescheduled"` // Moved by quiet hours or the weekly cap
+	Rule        *StepRule          `json:"rule,omitempty"`
+	Content     string             `json:"content,omitempty"`  // Without variants
+	Variants    []SimulatedVariant `json:"variants,omitempty"` // One is drawn per execution
+	Note        string             `json:"note,omitempty"`
+}
+
+// SimulatedVariant is a message variant of a step that an execution may receive
+type SimulatedVariant struct {
+	Key     string  `json:"key"`
+	Weight  int     `json:"weight"`
+	Share   float64 `json:"share"` // Expected share of the executions, from 0 to 1
+	Content string  `json:"content"`
+}
+
+// SimulatedLine is the timeline planned for one pending line
+type SimulatedLine struct {
+	PendingLineID  uuid.UUID       `json:"pending_line_id"`            // First line of a client execution
+	PendingLineIDs []uuid.UUID     `json:"pending_line_ids,omitempty"` // All lines of a client execution
+	ClientID       *uuid.UUID      `json:"client_id,omitempty"`
+	ClientName     string          `json:"client_name,omitempty"`
+	Phone          *string         `json:"phone,omitempty"`
+	Amount         decimal.Decimal `json:"amount"` // Total for a client execution
+	Timeline       []SimulatedStep `json:"timeline"`
+	Warnings       []string        `json:"warnings,omitempty"`
+}
+
+// CampaignSimulation is the result of a campaign dry run; nothing is sent
+type CampaignSimulation struct {
+	CampaignID  uuid.UUID       `json:"campaign_id"`
+	StartAt     time.Time       `json:"start_at"`
+	Timezone    string          `json:"timezone"`
+	TotalLines  int             `json:"total_lines"` // Lines that would be enrolled
+	Lines       []SimulatedLine `json:"lines"`       // Up to the requested limit
+	Assumptions []string        `json:"assumptions"`
+}
+
+// CampaignFunnel counts executions reaching each stage of a campaign
+type CampaignFunnel struct {
+	Enrolled                  int64    `json:"enrolled"`
+	Contacted                 int64    `json:"contacted"` // At least one message sent
+	Delivered                 int64    `json:"delivered"`
+	Read                      int64    `json:"read"`
+	Replied                   int64    `json:"replied"`
+	DocumentReceived          int64    `json:"document_received"`
+	Validated                 int64    `json:"validated"`
+	MedianTimeToDocumentHours *float64 `json:"median_time_to_document_hours,omitempty"` // From first message
+}
+
+// StepChannelAnalytics counts the messages of a step sent on one channel.
+// Replies and documents are attributed to the last message sent before them.
+type StepChannelAnalytics struct {
+	Channel                   CampaignChannel `json:"channel"`
+	Sent                      int64           `json:"sent"`
+	Delivered                 int64           `json:"delivered"`
+	Read                      int64           `json:"read"`
+	Failed                    int64           `json:"failed"`
+	Replied                   int64           `json:"replied"`
+	DocumentReceived          int64           `json:"document_received"`
+	MedianTimeToDocumentHours *float64        `json:"median_time_to_document_hours,omitempty"` // From this message
+}
+
+// StepAnalytics reports a campaign step
+type StepAnalytics struct {
+	StepOrder int                    `json:"step_order"`
+	Reached   int64                  `json:"reached"`  // Executions that were sent this step
+	Active    int64                  `json:"active"`   // Still waiting for the next step
+	DropOff   int64                  `json:"drop_off"` // Ended after this step without a document
+	ByChannel []StepChannelAnalytics `json:"by_channel"`
+}
+
+// CampaignAnalytics reports the performance of a campaign over the executions
+// enrolled in a date range
+type CampaignAnalytics struct {
+	CampaignID uuid.UUID       `json:"campaign_id"`
+	From       *time.Time      `json:"from,omitempty"`
+	To         *time.Time      `json:"to,omitempty"`
+	Funnel     CampaignFunnel  `json:"funnel"`
+	Steps      []StepAnalytics `json:"steps"`
+}
+
+// VariantResult reports the outcomes of the messages of a step variant. Replies and
+// documents are attributed to the last message sent before them.
+type VariantResult struct {
+	StepOrder                 int      `json:"step_order"`
+	Variant                   string   `json:"variant"`
+	Sent                      int64    `json:"sent"`
+	Read                      int64    `json:"read"`
+	Replied                   int64    `json:"replied"`
+	DocumentReceived          int64    `json:"document_received"`
+	MedianTimeToDocumentHours *float64 `json:"median_time_to_document_hours,omitempty"`
+	ReadRate                  Rate     `json:"read_rate"`
+	ReplyRate                 Rate     `json:"reply_rate"`
+	ConversionRate            Rate     `json:"conversion_rate"` // Document received
+}
+
+// Rate is a proportion with its 95% confidence interval
+type Rate struct {
+	Value float64 `json:"value"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
 }
@@ -21,6 +21,49 @@ type Cabinet struct {
 	UpdatedAt           time.Time      `json:"updated_at"`
 }
 
+// SendingSettings configures when automated messages may be sent for a cabinet.
+// Stored under the "sending" key of Cabinet.Settings.
+type SendingSettings struct {
+	Timezone        string          `json:"timezone"`         // IANA name, e.g. "Europe/Paris"
+	HolidayCalendar string          `json:"holiday_calendar"` // fr, fr-alsace-moselle, fr-974, ma, none
+	Windows         []SendingWindow `json:"windows"`
+	ExtraHolidays   []string        `json:"extra_holidays,omitempty"` // YYYY-MM-DD, e.g. bridge days
+}
+
+// SendingWindow is a daily time range during which sending is allowed
+type SendingWindow struct {
+	Days  []int  `json:"days"`  // ISO weekdays, 1 = Monday ... 7 = Sunday
+	Start string `json:"start"` // "HH:MM", local time
+	End   string `json:"end"`   // "HH:MM", exclusive
+}
+
+// AntiBanSettings overrides the default anti-ban policy for a cabinet, then for
+// each of its sender numbers. Stored under the "anti_ban" key of Cabinet.Settings.
+type AntiBanSettings struct {
+	AntiBanLimits
+	Senders map[string]AntiBanLimits `json:"senders,omitempty"` // By sender number
+}
+
+// AntiBanLimits are anti-ban overrides. Nil fields keep the value inherited.
+type AntiBanLimits struct {
+	MinJitterSeconds    *int    `json:"min_jitter_seconds,omitempty"`
+	MaxJitterSeconds    *int    `json:"max_jitter_seconds,omitempty"`
+	TypingDelayMs       *int    `json:"typing_delay_ms,omitempty"`
+	MaxPerPhonePerDay   *int    `json:"max_per_phone_per_day,omitempty"`   // 0 for no limit
+	MaxPerClientPerWeek *int    `json:"max_per_client_per_week,omitempty"` // 0 for no limit
+	SenderPerMinute     *int    `json:"sender_per_minute,omitempty"`
+	SenderPerHour       *int    `json:"sender_per_hour,omitempty"`
+	SenderPerDay        *int    `json:"sender_per_day,omitempty"`
+	SenderWarmUp        *string `json:"sender_warm_up,omitempty"` // "day:limit,...", "none"
+}
+
+// WhatsAppSettings chooses the WhatsApp provider of a cabinet, the configured
+// default when empty. Stored under the "whatsapp" key of Cabinet.Settings.
+type WhatsAppSettings struct {
+	Provider        string `json:"provider,omitempty"`         // twilio, meta
+	SessionTemplate string `json:"session_template,omitempty"` // Overrides the template of the provider
+}
+
 // Collaborator represents a cabinet employee
 type Collaborator struct {
 	ID             uuid.UUID `json:"id"`
@@ -49,8 +92,30 @@ type Client struct {
 	Notes             *string    `json:"notes,omitempty"`
 	WhatsAppOptedIn   bool       `json:"whatsapp_opted_in"`
 	WhatsAppOptedInAt *time.Time `json:"whatsapp_opted_in_at,omitempty"`
+	LastInboundAt     *time.Time `json:"last_inbound_at,omitempty"`
+	Tags              []string   `json:"tags,omitempty"`
 	CreatedAt         time.Time  `json:"created_at"`
 	UpdatedAt         time.Time  `json:"updated_at"`
+
+	Session WhatsAppSession `json:"whatsapp_session"` // Computed from LastInboundAt
+}
+
+// WhatsAppSession is the customer service window opened by each message of a client:
+// free text and media are only delivered while it is open, templates at any time
+type WhatsAppSession struct {
+	Open      bool       `json:"open"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// SetSession computes the session of the client at now, for a window of the given length
+func (c *Client) SetSession(window time.Duration, now time.Time) {
+	c.Session = WhatsAppSession{}
+	if c.LastInboundAt == nil {
+		return
+	}
+	expires := c.LastInboundAt.Add(window)
+	c.Session.ExpiresAt = &expires
+	c.Session.Open = now.Before(expires)
 }
 
 // PendingLineStatus represents the status of a pending line
@@ -81,6 +146,7 @@ type PendingLine struct {
 	LastContactedAt *time.Time        `json:"last_contacted_at,omitempty"`
 	ContactCount    int               `json:"contact_count"`
 	AssignedTo      *uuid.UUID        `json:"assigned_to,omitempty"`
+	RejectionReason *string           `json:"rejection_reason,omitempty"`
 	CreatedAt       time.Time         `json:"created_at"`
 	UpdatedAt       time.Time         `json:"updated_at"`
 
@@ -126,6 +192,7 @@ const (
 	TypeInteractive MessageType = "interactive"
 	TypeMedia       MessageType = "media"
 	TypeTemplate    MessageType = "template"
+	TypeCall        MessageType = "call" // Automated phone call
 )
 
 // MessageStatus represents the status of a message
@@ -156,11 +223,25 @@ type Message struct {
 	WAConversationID *string          `json:"wa_conversation_id,omitempty"`
 	Status           MessageStatus    `json:"status"`
 	ErrorMessage     *string          `json:"error_message,omitempty"`
+	ErrorCode        *string          `json:"error_code,omitempty"` // Provider error code of a failed delivery
 	ScheduledAt      *time.Time       `json:"scheduled_at,omitempty"`
 	SentAt           *time.Time       `json:"sent_at,omitempty"`
 	DeliveredAt      *time.Time       `json:"delivered_at,omitempty"`
 	ReadAt           *time.Time       `json:"read_at,omitempty"`
 	CreatedAt        time.Time        `json:"created_at"`
+	AutoReply        bool             `json:"auto_reply,omitempty"` // Automatic answer to the client, out of the weekly cap
+
+	// Campaign tracking (outbound messages sent by a campaign step)
+	CampaignExecutionID *uuid.UUID `json:"campaign_execution_id,omitempty"`
+	CampaignStepOrder   *int       `json:"campaign_step_order,omitempty"`
+	CampaignChannel     *string    `json:"campaign_channel,omitempty"`
+	CampaignVariant     *string    `json:"campaign_variant,omitempty"`
+
+	// Phone calls (message type call)
+	CallStatus   *string `json:"call_status,omitempty"`
+	CallDuration *int    `json:"call_duration,omitempty"` // Seconds
+	RecordingURL *string `json:"recording_url,omitempty"`
+	DTMFResponse *string `json:"dtmf_response,omitempty"`
 }
 
 // DocumentType represents the type of document
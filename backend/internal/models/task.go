@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TaskType represents why a task was created
+type TaskType string
+
+const (
+	TaskEscalation   TaskType = "escalation"    // Automated relances exhausted or escalated by a rule
+	TaskNotification TaskType = "notification"  // Campaign step on the notification channel
+	TaskCallResponse TaskType = "call_response" // Key pressed by the client during a phone call
+	TaskButtonReply  TaskType = "button_reply"  // Quick-reply button pressed by the client on a relance
+)
+
+// TaskStatus represents the state of a task
+type TaskStatus string
+
+const (
+	TaskOpen      TaskStatus = "open"
+	TaskDone      TaskStatus = "done"
+	TaskCancelled TaskStatus = "cancelled"
+)
+
+// Task is a manual action requested from a collaborator
+type Task struct {
+	ID                  uuid.UUID  `json:"id"`
+	CabinetID           uuid.UUID  `json:"cabinet_id"`
+	Type                TaskType   `json:"type"`
+	Status              TaskStatus `json:"status"`
+	Title               string     `json:"title"`
+	Description         *string    `json:"description,omitempty"`
+	PendingLineID       *uuid.UUID `json:"pending_line_id,omitempty"`
+	ClientID            *uuid.UUID `json:"client_id,omitempty"`
+	AssignedTo          *uuid.UUID `json:"assigned_to,omitempty"` // Collaborator
+	CampaignExecutionID *uuid.UUID `json:"campaign_execution_id,omitempty"`
+	CompletedBy         *uuid.UUID `json:"completed_by,omitempty"` // User
+	CompletedAt         *time.Time `json:"completed_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrJobNotFound is returned when a job is not in the dead-letter set
+var ErrJobNotFound = errors.New("job not found")
+
+// DeadJob is a job that failed too many times or permanently, with its last error
+type DeadJob struct {
+	Job      MessageJob `json:"job"`
+	Error    string     `json:"error"`
+	Attempts int        `json:"attempts"`
+	FailedAt time.Time  `json:"failed_at"`
+}
+
+// deadInfo is the failure stored next to a dead-lettered payload
+type deadInfo struct {
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// permanentError marks a send failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the pool dead-letters the job instead of retrying it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// DeadLetter moves a job to the dead-letter set with the error of its last attempt
+func (q *MessageQueue) DeadLetter(ctx context.Context, job *MessageJob, cause error) error {
+	now := time.Now()
+	info, err := json.Marshal(deadInfo{Error: cause.Error(), Attempts: job.Attempts + 1, FailedAt: now})
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead job: %w", err)
+	}
+	if err := deadLetterScript.Run(ctx, q.client,
+		[]string{q.leasesKey, q.readyKey, q.delayedKey, q.deadKey, q.deadInfoKey, q.jobsKey},
+		job.ID, now.UnixMilli(), info, q.groupPrefix,
+	).Err(); err != nil {
+		return fmt.Errorf("failed to dead-letter job: %w", err)
+	}
+	return nil
+}
+
+// ListDead returns the dead-lettered jobs of a cabinet, most recent first, and
+// their total count
+func (q *MessageQueue) ListDead(ctx context.Context, cabinetID string, offset, limit int) ([]DeadJob, int, error) {
+	ids, err := q.client.ZRevRange(ctx, q.deadKey, 0, -1).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list dead jobs: %w", err)
+	}
+	jobs, err := q.loadDead(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matching := make([]DeadJob, 0)
+	for _, j := range jobs {
+		if j.Job.CabinetID == cabinetID {
+			matching = append(matching, j)
+		}
+	}
+	total := len(matching)
+	if offset >= total {
+		return []DeadJob{}, total, nil
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return matching[offset:end], total, nil
+}
+
+// GetDead returns a dead-lettered job of a cabinet, ErrJobNotFound when there is none
+func (q *MessageQueue) GetDead(ctx context.Context, cabinetID, id string) (*DeadJob, error) {
+	jobs, err := q.loadDead(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 || jobs[0].Job.CabinetID != cabinetID {
+		return nil, ErrJobNotFound
+	}
+	return &jobs[0], nil
+}
+
+// Replay puts a dead-lettered job of a cabinet back in the queue for immediate
+// sending, with its attempts reset
+func (q *MessageQueue) Replay(ctx context.Context, cabinetID, id string) (*MessageJob, error) {
+	dead, err := q.GetDead(ctx, cabinetID, id)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := replayScript.Run(ctx, q.client,
+		[]string{q.deadKey, q.deadInfoKey, q.deliveriesKey, q.readyKey, q.jobsKey},
+		id, time.Now().UnixMilli(), dead.Job.CreatedAt.UnixMilli(), q.groupPrefix,
+	).Int()
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay job: %w", err)
+	}
+	if replayed == 0 {
+		return nil, ErrJobNotFound
+	}
+	return &dead.Job, nil
+}
+
+// Discard deletes a dead-lettered job of a cabinet
+func (q *MessageQueue) Discard(ctx context.Context, cabinetID, id string) (*MessageJob, error) {
+	dead, err := q.GetDead(ctx, cabinetID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.complete(ctx, id); err != nil {
+		return nil, err
+	}
+	return &dead.Job, nil
+}
+
+// loadDead reads the payloads and failures of dead-lettered IDs, skipping the IDs
+// that are not dead-lettered
+func (q *MessageQueue) loadDead(ctx context.Context, ids []string) ([]DeadJob, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	pipe := q.client.Pipeline()
+	payloads := pipe.HMGet(ctx, q.jobsKey, ids...)
+	infos := pipe.HMGet(ctx, q.deadInfoKey, ids...)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to load dead jobs: %w", err)
+	}
+
+	jobs := make([]DeadJob, 0, len(ids))
+	for i, id := range ids {
+		payload, _ := payloads.Val()[i].(string)
+		info, _ := infos.Val()[i].(string)
+		if payload == "" || info == "" {
+			continue
+		}
+		var d DeadJob
+		var di deadInfo
+		if json.Unmarshal([]byte(payload), &d.Job) != nil || json.Unmarshal([]byte(info), &di) != nil {
+			continue
+		}
+		d.Job.ID = id
+		d.Job.Attempts = di.Attempts
+		d.Error, d.Attempts, d.FailedAt = di.Error, di.Attempts, di.FailedAt
+		jobs = append(jobs, d)
+	}
+	return jobs, nil
+}
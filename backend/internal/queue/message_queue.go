@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"math/rand"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,26 +12,40 @@ import (
 
 // MessageJob represents a WhatsApp message to send
 type MessageJob struct {
-	ID             string    `json:"id"`
-	PendingLineID  string    `json:"pending_line_id"`
-	ClientID       string    `json:"client_id"`
-	Phone          string    `json:"phone"`
-	MessageType    string    `json:"message_type"` // text, voice, template
-	Content        string    `json:"content"`
-	TemplateName   string    `json:"template_name,omitempty"`
-	TemplateParams []string  `json:"template_params,omitempty"`
-	AudioURL       string    `json:"audio_url,omitempty"`
-	ScheduledAt    time.Time `json:"scheduled_at"`
-	Attempts       int       `json:"attempts"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID             string        `json:"id"`
+	CabinetID      string        `json:"cabinet_id,omitempty"`
+	Provider       string        `json:"provider,omitempty"` // WhatsApp provider, the default one when empty
+	Sender         string        `json:"sender,omitempty"`   // Our sender number, throttled at dequeue
+	Limits         *SenderLimits `json:"limits,omitempty"`   // Limits of Sender, the queue ones when nil
+	TypingDelayMs  int           `json:"typing_delay_ms,omitempty"`
+	PendingLineID  string        `json:"pending_line_id"`
+	ClientID       string        `json:"client_id"`
+	Phone          string        `json:"phone"`
+	MessageType    string        `json:"message_type"` // text, voice, template, call
+	Content        string        `json:"content"`
+	TemplateName   string        `json:"template_name,omitempty"`
+	TemplateParams []string      `json:"template_params,omitempty"`
+	WindowTemplate string        `json:"window_template,omitempty"` // Sent instead of free text outside the client session window
+	AudioURL       string        `json:"audio_url,omitempty"`
+	ScheduledAt    time.Time     `json:"scheduled_at"`
+	Attempts       int           `json:"attempts"` // Previous deliveries, set by Dequeue
+	CreatedAt      time.Time     `json:"created_at"`
 }
 
-// MessageQueue handles message queueing with anti-ban logic
+// MessageQueue is the Redis implementation of Queue
 type MessageQueue struct {
 	client        *redis.Client
-	queueKey      string
+	readyKey      string
 	delayedKey    string
-	processingKey string
+	leasesKey     string
+	jobsKey       string
+	deliveriesKey string
+	deadKey       string
+	deadInfoKey   string
+	groupsKey     string
+	groupPrefix   string // Keys of the per-group index of the report
+	visibility    time.Duration
+	senderLimits  SenderLimits
 }
 
 // NewMessageQueue creates a new message queue
@@ -54,11 +67,19 @@ func NewMessageQueue(redisURL string) (*MessageQueue, error) {
 
 	slog.Info("connected to redis")
 
+	// The hash tag keeps all keys in one slot, as the scripts use several of them
 	return &MessageQueue{
 		client:        client,
-		queueKey:      "fiducia:messages:queue",
-		delayedKey:    "fiducia:messages:delayed",
-		processingKey: "fiducia:messages:processing",
+		readyKey:      "fiducia:{messages}:ready",
+		delayedKey:    "fiducia:{messages}:delayed",
+		leasesKey:     "fiducia:{messages}:leases",
+		jobsKey:       "fiducia:{messages}:jobs",
+		deliveriesKey: "fiducia:{messages}:deliveries",
+		deadKey:       "fiducia:{messages}:dead",
+		deadInfoKey:   "fiducia:{messages}:dead_info",
+		groupsKey:     "fiducia:{messages}:groups",
+		groupPrefix:   "fiducia:{messages}:group:",
+		visibility:    DefaultVisibilityTimeout,
 	}, nil
 }
 
@@ -67,41 +88,34 @@ func (q *MessageQueue) Close() error {
 	return q.client.Close()
 }
 
-// Anti-ban configuration
-const (
-	MinJitterSeconds  = 30   // Minimum delay between messages
-	MaxJitterSeconds  = 180  // Maximum delay between messages
-	MaxMessagesPerDay = 3    // Max messages per phone per day
-	TypingDelayMs     = 2000 // Simulated typing delay
-)
+// SetVisibilityTimeout sets how long a dequeued job is leased to its worker
+func (q *MessageQueue) SetVisibilityTimeout(d time.Duration) {
+	if d > 0 {
+		q.visibility = d
+	}
+}
 
-// Enqueue adds a message to the queue with anti-ban jittering
-func (q *MessageQueue) Enqueue(ctx context.Context, job *MessageJob) error {
+// VisibilityTimeout returns how long a dequeued job is leased to its worker
+func (q *MessageQueue) VisibilityTimeout() time.Duration {
+	return q.visibility
+}
+
+// Enqueue adds a message to the queue with anti-ban jittering.
+// A job whose ID is already queued is left unchanged.
+func (q *MessageQueue) Enqueue(ctx context.Context, job *MessageJob, policy Policy) error {
 	// Check rate limit for this phone
-	if limited, err := q.isRateLimited(ctx, job.Phone); err != nil {
+	if limited, err := q.isRateLimited(ctx, job.Phone, policy.MaxPerPhonePerDay); err != nil {
 		return fmt.Errorf("failed to check rate limit: %w", err)
 	} else if limited {
 		return fmt.Errorf("rate limit exceeded for phone %s", job.Phone)
 	}
 
 	// Calculate jittered delay
-	jitter := MinJitterSeconds + rand.Intn(MaxJitterSeconds-MinJitterSeconds)
-	job.ScheduledAt = time.Now().Add(time.Duration(jitter) * time.Second)
-	job.CreatedAt = time.Now()
-
-	// Serialize job
-	data, err := json.Marshal(job)
-	if err != nil {
-		return fmt.Errorf("failed to marshal job: %w", err)
-	}
+	jitter := policy.schedule(job)
 
-	// Add to delayed sorted set (score = scheduled timestamp)
-	score := float64(job.ScheduledAt.Unix())
-	if err := q.client.ZAdd(ctx, q.delayedKey, redis.Z{
-		Score:  score,
-		Member: data,
-	}).Err(); err != nil {
-		return fmt.Errorf("failed to enqueue job: %w", err)
+	added, err := q.add(ctx, job, true)
+	if err != nil || !added {
+		return err
 	}
 
 	// Increment rate limit counter
@@ -113,121 +127,154 @@ func (q *MessageQueue) Enqueue(ctx context.Context, job *MessageJob) error {
 		"job_id", job.ID,
 		"phone", job.Phone,
 		"scheduled_at", job.ScheduledAt,
-		"jitter_seconds", jitter,
+		"jitter_seconds", int(jitter.Seconds()),
 	)
 
 	return nil
 }
 
-// EnqueueImmediate adds a message for immediate sending (for testing)
-func (q *MessageQueue) EnqueueImmediate(ctx context.Context, job *MessageJob) error {
-	job.ScheduledAt = time.Now()
-	job.CreatedAt = time.Now()
+// EnqueueImmediate adds a message for immediate sending (for testing), without
+// jitter nor recipient limit
+func (q *MessageQueue) EnqueueImmediate(ctx context.Context, job *MessageJob, policy Policy) error {
+	policy.scheduleNow(job)
 
+	_, err := q.add(ctx, job, false)
+	return err
+}
+
+// add stores the payload of job and schedules it, in the delayed set or at the end
+// of the ready list. It returns false when the job ID was already queued.
+func (q *MessageQueue) add(ctx context.Context, job *MessageJob, delayed bool) (bool, error) {
 	data, err := json.Marshal(job)
 	if err != nil {
-		return fmt.Errorf("failed to marshal job: %w", err)
+		return false, fmt.Errorf("failed to marshal job: %w", err)
 	}
 
-	if err := q.client.RPush(ctx, q.queueKey, data).Err(); err != nil {
-		return fmt.Errorf("failed to enqueue job: %w", err)
+	list := "ready"
+	if delayed {
+		list = "delayed"
 	}
-
-	return nil
+	added, err := enqueueScript.Run(ctx, q.client,
+		[]string{q.jobsKey, q.delayedKey, q.readyKey, q.groupsKey},
+		job.ID, data, job.ScheduledAt.UnixMilli(), list, job.CreatedAt.UnixMilli(), q.groupPrefix,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	if added == 0 {
+		slog.Warn("message already queued", "job_id", job.ID)
+	}
+	return added == 1, nil
 }
 
-// Dequeue retrieves the next ready message from the queue
+// Dequeue leases the next ready message, nil when there is none. The job must be
+// completed or retried before the visibility timeout, or it is delivered again.
+// A job whose sender is over its limits is delayed and nil returned.
 func (q *MessageQueue) Dequeue(ctx context.Context) (*MessageJob, error) {
-	// First, move any ready delayed jobs to the main queue
-	if err := q.moveReadyJobs(ctx); err != nil {
-		slog.Warn("failed to move ready jobs", "error", err)
-	}
-
-	// Pop from main queue
-	result, err := q.client.BLPop(ctx, 1*time.Second, q.queueKey).Result()
+	now := time.Now()
+	result, err := dequeueScript.Run(ctx, q.client,
+		[]string{q.delayedKey, q.readyKey, q.leasesKey, q.jobsKey, q.deliveriesKey},
+		now.UnixMilli(), now.Add(q.visibility).UnixMilli(), q.groupPrefix,
+	).Slice()
 	if err == redis.Nil {
 		return nil, nil // No jobs available
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to dequeue job: %w", err)
 	}
-
-	if len(result) < 2 {
+	if len(result) < 3 {
 		return nil, nil
 	}
 
+	id, _ := result[0].(string)
+	payload, _ := result[1].(string)
+	deliveries, _ := result[2].(int64)
+
 	var job MessageJob
-	if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		// A payload that cannot be read will never succeed, drop it
+		if cerr := q.complete(ctx, id); cerr != nil {
+			slog.Warn("failed to drop invalid job", "job_id", id, "error", cerr)
+		}
+		return nil, fmt.Errorf("failed to unmarshal job %s: %w", id, err)
 	}
+	job.ID = id
+	job.Attempts = int(deliveries) - 1
 
-	// Add to processing set
-	q.client.SAdd(ctx, q.processingKey, result[1])
+	wait, err := q.throttle(ctx, &job, now)
+	if err != nil {
+		// Sending is safer than stalling the queue on a limiter failure
+		slog.Warn("sender limits not checked", "job_id", id, "error", err)
+	} else if wait > 0 {
+		if err := q.deferJob(ctx, &job, now.Add(wait)); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
 
 	return &job, nil
 }
 
-// Complete marks a job as completed
+// Complete removes a job from the queue, dead-letter included. It succeeds even when
+// the lease expired, so that a job re-queued meanwhile is not sent twice.
 func (q *MessageQueue) Complete(ctx context.Context, job *MessageJob) error {
-	data, _ := json.Marshal(job)
-	return q.client.SRem(ctx, q.processingKey, data).Err()
-}
-
-// Retry puts a failed job back in the queue with exponential backoff
-func (q *MessageQueue) Retry(ctx context.Context, job *MessageJob) error {
-	job.Attempts++
-
-	// Exponential backoff: 1min, 2min, 4min, 8min, 16min
-	backoff := time.Duration(1<<job.Attempts) * time.Minute
-	if backoff > 30*time.Minute {
-		backoff = 30 * time.Minute
+	if err := q.complete(ctx, job.ID); err != nil {
+		return err
 	}
+	q.recordSent(ctx, job)
+	return nil
+}
 
-	job.ScheduledAt = time.Now().Add(backoff)
-
-	data, err := json.Marshal(job)
+func (q *MessageQueue) complete(ctx context.Context, id string) error {
+	leased, err := completeScript.Run(ctx, q.client,
+		[]string{q.leasesKey, q.jobsKey, q.deliveriesKey, q.readyKey, q.delayedKey, q.deadKey, q.deadInfoKey, q.groupsKey},
+		id, q.groupPrefix,
+	).Int()
 	if err != nil {
-		return fmt.Errorf("failed to marshal job: %w", err)
+		return fmt.Errorf("failed to complete job: %w", err)
 	}
-
-	// Remove from processing
-	q.client.SRem(ctx, q.processingKey, data)
-
-	// Add back to delayed queue
-	score := float64(job.ScheduledAt.Unix())
-	return q.client.ZAdd(ctx, q.delayedKey, redis.Z{
-		Score:  score,
-		Member: data,
-	}).Err()
+	if leased == 0 {
+		slog.Warn("job completed after its lease expired", "job_id", id)
+	}
+	return nil
 }
 
-// moveReadyJobs moves jobs that are ready to be sent from delayed to main queue
-func (q *MessageQueue) moveReadyJobs(ctx context.Context) error {
-	now := float64(time.Now().Unix())
+// Retry releases the lease of a failed job and schedules it again with exponential
+// backoff. It returns ErrLeaseLost when the job was already re-queued by the reaper.
+func (q *MessageQueue) Retry(ctx context.Context, job *MessageJob) error {
+	job.ScheduledAt = time.Now().Add(retryBackoff(job))
 
-	// Get all jobs with score <= now
-	jobs, err := q.client.ZRangeByScore(ctx, q.delayedKey, &redis.ZRangeBy{
-		Min: "-inf",
-		Max: fmt.Sprintf("%f", now),
-	}).Result()
+	retried, err := retryScript.Run(ctx, q.client,
+		[]string{q.leasesKey, q.delayedKey, q.jobsKey},
+		job.ID, job.ScheduledAt.UnixMilli(), q.groupPrefix,
+	).Int()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to retry job: %w", err)
 	}
-
-	for _, jobData := range jobs {
-		// Move to main queue
-		if err := q.client.RPush(ctx, q.queueKey, jobData).Err(); err != nil {
-			continue
-		}
-		// Remove from delayed
-		q.client.ZRem(ctx, q.delayedKey, jobData)
+	if retried == 0 {
+		return ErrLeaseLost
 	}
-
 	return nil
 }
 
+// Reap puts the jobs whose lease expired back in the ready list, for instance
+// after a worker crashed. It returns the number of jobs re-queued.
+func (q *MessageQueue) Reap(ctx context.Context) (int, error) {
+	n, err := reapScript.Run(ctx, q.client,
+		[]string{q.leasesKey, q.readyKey, q.jobsKey},
+		time.Now().UnixMilli(), q.groupPrefix,
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap expired leases: %w", err)
+	}
+	return n, nil
+}
+
 // isRateLimited checks if a phone has exceeded the daily message limit
-func (q *MessageQueue) isRateLimited(ctx context.Context, phone string) (bool, error) {
+func (q *MessageQueue) isRateLimited(ctx context.Context, phone string, max int) (bool, error) {
+	if max <= 0 {
+		return false, nil
+	}
 	key := fmt.Sprintf("fiducia:ratelimit:%s:%s", phone, time.Now().Format("2006-01-02"))
 	count, err := q.client.Get(ctx, key).Int()
 	if err == redis.Nil {
@@ -236,7 +283,7 @@ func (q *MessageQueue) isRateLimited(ctx context.Context, phone string) (bool, e
 	if err != nil {
 		return false, err
 	}
-	return count >= MaxMessagesPerDay, nil
+	return count >= max, nil
 }
 
 // incrementRateLimit increments the daily message count for a phone
@@ -249,15 +296,26 @@ func (q *MessageQueue) incrementRateLimit(ctx context.Context, phone string) err
 	return err
 }
 
-// GetQueueStats returns queue statistics
-func (q *MessageQueue) GetQueueStats(ctx context.Context) (map[string]int64, error) {
-	pending, _ := q.client.LLen(ctx, q.queueKey).Result()
-	delayed, _ := q.client.ZCard(ctx, q.delayedKey).Result()
-	processing, _ := q.client.SCard(ctx, q.processingKey).Result()
+// Stats returns the number of jobs in each state. Delayed jobs already due are
+// pending: they are moved to the ready list by the next Dequeue.
+func (q *MessageQueue) Stats(ctx context.Context) (Stats, error) {
+	now := fmt.Sprint(time.Now().UnixMilli())
+	pipe := q.client.Pipeline()
+	ready := pipe.LLen(ctx, q.readyKey)
+	due := pipe.ZCount(ctx, q.delayedKey, "-inf", now)
+	delayed := pipe.ZCount(ctx, q.delayedKey, "("+now, "+inf")
+	processing := pipe.ZCard(ctx, q.leasesKey)
+	dead := pipe.ZCard(ctx, q.deadKey)
+	expired := pipe.ZCount(ctx, q.leasesKey, "-inf", now)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Stats{}, fmt.Errorf("failed to get queue stats: %w", err)
+	}
 
-	return map[string]int64{
-		"pending":    pending,
-		"delayed":    delayed,
-		"processing": processing,
+	return Stats{
+		Pending:    ready.Val() + due.Val(),
+		Delayed:    delayed.Val(),
+		Processing: processing.Val(),
+		Expired:    expired.Val(),
+		Dead:       dead.Val(),
 	}, nil
 }
@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Handler sends the message of a job. A returned error makes the job retried, or
+// dead-lettered when wrapped with Permanent.
+type Handler func(ctx context.Context, job *MessageJob) error
+
+// GiveUpHandler is called when a job is dead-lettered
+type GiveUpHandler func(ctx context.Context, job *MessageJob, err error)
+
+const (
+	// idleWait is the pause of a worker that found no ready job
+	idleWait = time.Second
+	// reapInterval is the delay between two checks for expired leases
+	reapInterval = 30 * time.Second
+	// leaseMargin is kept between the end of a send and the end of its lease
+	leaseMargin = 10 * time.Second
+)
+
+// Pool runs workers that dequeue jobs and hand them to a Handler, then complete
+// them or schedule a retry
+type Pool struct {
+	queue       Queue
+	handle      Handler
+	giveUp      GiveUpHandler
+	concurrency int
+	maxAttempts int
+	done        chan struct{}
+}
+
+// NewPool creates a pool of concurrency workers. Jobs are attempted at most
+// maxAttempts times, then dead-lettered and passed to giveUp.
+func NewPool(q Queue, handle Handler, giveUp GiveUpHandler, concurrency, maxAttempts int) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Pool{
+		queue:       q,
+		handle:      handle,
+		giveUp:      giveUp,
+		concurrency: concurrency,
+		maxAttempts: maxAttempts,
+		done:        make(chan struct{}),
+	}
+}
+
+// Run dequeues jobs until ctx is cancelled, then waits for the jobs in progress.
+// A cancelled context stops dequeuing but never interrupts a send.
+func (p *Pool) Run(ctx context.Context) {
+	defer close(p.done)
+	slog.Info("message workers started", "concurrency", p.concurrency)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.reap(ctx)
+	}()
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			p.work(ctx, worker)
+		}(i)
+	}
+	wg.Wait()
+
+	slog.Info("message workers stopped")
+}
+
+// Drain waits for Run to return after its context was cancelled, at most until ctx
+// is done. Jobs still running then are re-queued when their lease expires.
+func (p *Pool) Drain(ctx context.Context) error {
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work(ctx context.Context, worker int) {
+	for ctx.Err() == nil {
+		// The dequeue itself is not cancelled: a job leased but not received would
+		// only be sent again after its visibility timeout
+		job, err := p.queue.Dequeue(context.WithoutCancel(ctx))
+		if err != nil {
+			slog.Error("failed to dequeue message", "worker", worker, "error", err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(idleWait):
+			}
+			continue
+		}
+		p.process(job)
+	}
+}
+
+// reap re-queues the jobs of crashed workers until ctx is cancelled
+func (p *Pool) reap(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := p.queue.Reap(ctx)
+			if err != nil {
+				slog.Error("failed to reap message jobs", "error", err)
+			} else if n > 0 {
+				slog.Warn("message jobs re-queued after lease expiry", "count", n)
+			}
+		}
+	}
+}
+
+func (p *Pool) process(job *MessageJob) {
+	// The send must end before the lease, or another worker would send it again
+	timeout := p.queue.VisibilityTimeout() - leaseMargin
+	if timeout < leaseMargin {
+		timeout = leaseMargin
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Deliveries interrupted by a crash count as attempts
+	if job.Attempts >= p.maxAttempts {
+		p.fail(ctx, job, fmt.Errorf("worker stopped during the last %d attempts", job.Attempts))
+		return
+	}
+
+	err := p.handle(ctx, job)
+	if err == nil {
+		if err := p.queue.Complete(ctx, job); err != nil {
+			slog.Warn("failed to complete message job", "job_id", job.ID, "error", err)
+		}
+		return
+	}
+
+	if job.Attempts+1 >= p.maxAttempts || IsPermanent(err) {
+		p.fail(ctx, job, err)
+		return
+	}
+
+	slog.Warn("message job failed, retrying", "job_id", job.ID, "attempts", job.Attempts+1, "error", err)
+	if err := p.queue.Retry(ctx, job); errors.Is(err, ErrLeaseLost) {
+		slog.Warn("message job lease expired during the send", "job_id", job.ID)
+	} else if err != nil {
+		slog.Error("failed to schedule message retry", "job_id", job.ID, "error", err)
+	}
+}
+
+// fail dead-letters a job that will not be attempted again
+func (p *Pool) fail(ctx context.Context, job *MessageJob, err error) {
+	slog.Error("message job failed, dead-lettered", "job_id", job.ID, "attempts", job.Attempts+1, "error", err)
+	if err := p.queue.DeadLetter(ctx, job, err); err != nil {
+		slog.Error("failed to dead-letter message job", "job_id", job.ID, "error", err)
+	}
+	if p.giveUp != nil {
+		p.giveUp(ctx, job, err)
+	}
+}
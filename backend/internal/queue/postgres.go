@@ -0,0 +1,608 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresQueue is the Postgres implementation of Queue, for installations without
+// Redis. Jobs are rows of message_jobs, leased with SELECT ... FOR UPDATE SKIP LOCKED.
+type PostgresQueue struct {
+	pool         *pgxpool.Pool
+	visibility   time.Duration
+	senderLimits SenderLimits
+}
+
+// NewPostgresQueue creates a queue on the message_jobs table
+func NewPostgresQueue(pool *pgxpool.Pool) *PostgresQueue {
+	return &PostgresQueue{pool: pool, visibility: DefaultVisibilityTimeout}
+}
+
+// Close does nothing, the pool belongs to the database
+func (q *PostgresQueue) Close() error {
+	return nil
+}
+
+// SetVisibilityTimeout sets how long a dequeued job is leased to its worker
+func (q *PostgresQueue) SetVisibilityTimeout(d time.Duration) {
+	if d > 0 {
+		q.visibility = d
+	}
+}
+
+// VisibilityTimeout returns how long a dequeued job is leased to its worker
+func (q *PostgresQueue) VisibilityTimeout() time.Duration {
+	return q.visibility
+}
+
+// SetSenderLimits sets the throughput limits of jobs enqueued without limits
+func (q *PostgresQueue) SetSenderLimits(l SenderLimits) {
+	q.senderLimits = l
+}
+
+// Enqueue adds a message to the queue with anti-ban jittering.
+// A job whose ID is already queued is left unchanged.
+func (q *PostgresQueue) Enqueue(ctx context.Context, job *MessageJob, policy Policy) error {
+	day := time.Now().Format("2006-01-02")
+	if policy.MaxPerPhonePerDay > 0 {
+		var count int
+		err := q.pool.QueryRow(ctx,
+			`SELECT count FROM message_phone_counts WHERE phone = $1 AND day = $2`, job.Phone, day,
+		).Scan(&count)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to check rate limit: %w", err)
+		}
+		if count >= policy.MaxPerPhonePerDay {
+			return fmt.Errorf("rate limit exceeded for phone %s", job.Phone)
+		}
+	}
+
+	jitter := policy.schedule(job)
+	added, err := q.add(ctx, job)
+	if err != nil || !added {
+		return err
+	}
+
+	if _, err := q.pool.Exec(ctx, `
+		INSERT INTO message_phone_counts (phone, day, count) VALUES ($1, $2, 1)
+		ON CONFLICT (phone, day) DO UPDATE SET count = message_phone_counts.count + 1
+	`, job.Phone, day); err != nil {
+		slog.Warn("failed to increment rate limit", "error", err)
+	}
+
+	slog.Info("message enqueued",
+		"job_id", job.ID,
+		"phone", job.Phone,
+		"scheduled_at", job.ScheduledAt,
+		"jitter_seconds", int(jitter.Seconds()),
+	)
+	return nil
+}
+
+// EnqueueImmediate adds a message for immediate sending (for testing), without
+// jitter nor recipient limit
+func (q *PostgresQueue) EnqueueImmediate(ctx context.Context, job *MessageJob, policy Policy) error {
+	policy.scheduleNow(job)
+	_, err := q.add(ctx, job)
+	return err
+}
+
+// add inserts a job. It returns false when the job ID was already queued.
+func (q *PostgresQueue) add(ctx context.Context, job *MessageJob) (bool, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	result, err := q.pool.Exec(ctx, `
+		INSERT INTO message_jobs (id, cabinet_id, payload, scheduled_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO NOTHING
+	`, job.ID, job.CabinetID, data, job.ScheduledAt, job.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		slog.Warn("message already queued", "job_id", job.ID)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Dequeue leases the next ready message, nil when there is none. The job must be
+// completed or retried before the visibility timeout, or it is delivered again.
+// A job whose sender is over its limits is delayed and nil returned.
+func (q *PostgresQueue) Dequeue(ctx context.Context) (*MessageJob, error) {
+	now := time.Now()
+	var id string
+	var payload []byte
+	var deliveries int
+	err := q.pool.QueryRow(ctx, `
+		WITH next AS (
+			SELECT id FROM message_jobs
+			WHERE state = 'queued' AND scheduled_at <= $1
+			ORDER BY scheduled_at, created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE message_jobs j SET
+			state = 'leased',
+			lease_expires_at = $2,
+			deliveries = j.deliveries + 1
+		FROM next
+		WHERE j.id = next.id
+		RETURNING j.id, j.payload, j.deliveries
+	`, now, now.Add(q.visibility)).Scan(&id, &payload, &deliveries)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil // No jobs available
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dequeue job: %w", err)
+	}
+
+	var job MessageJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		// A payload that cannot be read will never succeed, drop it
+		if _, cerr := q.pool.Exec(ctx, `DELETE FROM message_jobs WHERE id = $1`, id); cerr != nil {
+			slog.Warn("failed to drop invalid job", "job_id", id, "error", cerr)
+		}
+		return nil, fmt.Errorf("failed to unmarshal job %s: %w", id, err)
+	}
+	job.ID = id
+	job.Attempts = deliveries - 1
+
+	wait, err := q.throttle(ctx, &job, now)
+	if err != nil {
+		// Sending is safer than stalling the queue on a limiter failure
+		slog.Warn("sender limits not checked", "job_id", id, "error", err)
+	} else if wait > 0 {
+		// Delay the job without counting the delivery as an attempt
+		if _, err := q.pool.Exec(ctx, `
+			UPDATE message_jobs SET
+				state = 'queued', scheduled_at = $2, lease_expires_at = NULL, deliveries = deliveries - 1
+			WHERE id = $1 AND state = 'leased'
+		`, id, now.Add(wait)); err != nil {
+			return nil, fmt.Errorf("failed to defer job: %w", err)
+		}
+		slog.Debug("message throttled", "job_id", id, "sender", job.Sender, "until", now.Add(wait))
+		return nil, nil
+	}
+
+	return &job, nil
+}
+
+// throttle takes a token for one message of the job sender, with the limits of the
+// job or else of the queue. It returns how long to wait when the sender is over one
+// of its limits.
+func (q *PostgresQueue) throttle(ctx context.Context, job *MessageJob, now time.Time) (time.Duration, error) {
+	if job.Sender == "" {
+		return 0, nil
+	}
+	l := job.limits(q.senderLimits)
+
+	since := now
+	if len(l.WarmUp) > 0 {
+		err := q.pool.QueryRow(ctx, `
+			WITH ins AS (
+				INSERT INTO message_senders (sender, since) VALUES ($1, $2)
+				ON CONFLICT (sender) DO NOTHING
+				RETURNING since
+			)
+			SELECT since FROM ins
+			UNION ALL
+			SELECT since FROM message_senders WHERE sender = $1
+			LIMIT 1
+		`, job.Sender, now).Scan(&since)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read sender start: %w", err)
+		}
+	}
+
+	buckets := l.buckets(since, now)
+	if len(buckets) == 0 {
+		return 0, nil
+	}
+	keys := make([]string, len(buckets))
+	capacities := make([]float64, len(buckets))
+	for i, b := range buckets {
+		keys[i] = job.Sender + ":" + b.name
+		capacities[i] = float64(b.capacity)
+	}
+
+	tx, err := q.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// New buckets start full
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO message_sender_buckets (key, tokens, updated_at)
+		SELECT k, c, $3 FROM unnest($1::text[], $2::float8[]) AS t(k, c)
+		ON CONFLICT (key) DO NOTHING
+	`, keys, capacities, now); err != nil {
+		return 0, fmt.Errorf("failed to create sender buckets: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT key, tokens, updated_at FROM message_sender_buckets
+		WHERE key = ANY($1)
+		ORDER BY key
+		FOR UPDATE
+	`, keys)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock sender buckets: %w", err)
+	}
+	stored := make(map[string]float64, len(keys))
+	updated := make(map[string]time.Time, len(keys))
+	for rows.Next() {
+		var key string
+		var tokens float64
+		var at time.Time
+		if err := rows.Scan(&key, &tokens, &at); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan sender bucket: %w", err)
+		}
+		stored[key], updated[key] = tokens, at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read sender buckets: %w", err)
+	}
+
+	var wait time.Duration
+	tokens := make([]float64, len(buckets))
+	for i, b := range buckets {
+		tokens[i] = b.refill(stored[keys[i]], updated[keys[i]], now)
+		if w := b.wait(tokens[i]); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	for i := range tokens {
+		tokens[i]--
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE message_sender_buckets b SET tokens = t.tokens, updated_at = $3
+		FROM unnest($1::text[], $2::float8[]) AS t(key, tokens)
+		WHERE b.key = t.key
+	`, keys, tokens, now); err != nil {
+		return 0, fmt.Errorf("failed to take sender tokens: %w", err)
+	}
+	return 0, tx.Commit(ctx)
+}
+
+// Complete removes a job from the queue, dead letter included. It succeeds even
+// when the lease expired, so that a job re-queued meanwhile is not sent twice.
+func (q *PostgresQueue) Complete(ctx context.Context, job *MessageJob) error {
+	var state string
+	err := q.pool.QueryRow(ctx, `DELETE FROM message_jobs WHERE id = $1 RETURNING state`, job.ID).Scan(&state)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	if state != "leased" {
+		slog.Warn("job completed after its lease expired", "job_id", job.ID)
+	}
+
+	if _, err := q.pool.Exec(ctx, `
+		INSERT INTO message_sent (minute, cabinet_id, sender, sent)
+		VALUES (date_trunc('minute', $1::timestamptz), $2, $3, 1)
+		ON CONFLICT (minute, cabinet_id, sender) DO UPDATE SET sent = message_sent.sent + 1
+	`, time.Now(), job.CabinetID, job.Sender); err != nil {
+		slog.Warn("failed to record sent message", "job_id", job.ID, "error", err)
+	}
+	return nil
+}
+
+// Retry releases the lease of a failed job and schedules it again with exponential
+// backoff. It returns ErrLeaseLost when the job was already re-queued by the reaper.
+func (q *PostgresQueue) Retry(ctx context.Context, job *MessageJob) error {
+	job.ScheduledAt = time.Now().Add(retryBackoff(job))
+
+	result, err := q.pool.Exec(ctx, `
+		UPDATE message_jobs SET state = 'queued', scheduled_at = $2, lease_expires_at = NULL
+		WHERE id = $1 AND state = 'leased'
+	`, job.ID, job.ScheduledAt)
+	if err != nil {
+		return fmt.Errorf("failed to retry job: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Reap puts the jobs whose lease expired back in the queue, for instance after a
+// worker crashed. It returns the number of jobs re-queued.
+func (q *PostgresQueue) Reap(ctx context.Context) (int, error) {
+	now := time.Now()
+	result, err := q.pool.Exec(ctx, `
+		UPDATE message_jobs SET state = 'queued', lease_expires_at = NULL
+		WHERE state = 'leased' AND lease_expires_at <= $1
+	`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap expired leases: %w", err)
+	}
+
+	// Recipient counters are only read for the current day
+	if _, err := q.pool.Exec(ctx, `DELETE FROM message_phone_counts WHERE day < $1`,
+		now.AddDate(0, 0, -1).Format("2006-01-02")); err != nil {
+		slog.Warn("failed to purge recipient counters", "error", err)
+	}
+	if _, err := q.pool.Exec(ctx, `DELETE FROM message_sent WHERE minute < $1`, now.Add(-2*time.Hour)); err != nil {
+		slog.Warn("failed to purge sent counters", "error", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// DeadLetter moves a job to the dead letters with the error of its last attempt
+func (q *PostgresQueue) DeadLetter(ctx context.Context, job *MessageJob, cause error) error {
+	if _, err := q.pool.Exec(ctx, `
+		UPDATE message_jobs SET
+			state = 'dead', lease_expires_at = NULL, last_error = $2, failed_at = $3, deliveries = $4
+		WHERE id = $1
+	`, job.ID, cause.Error(), time.Now(), job.Attempts+1); err != nil {
+		return fmt.Errorf("failed to dead-letter job: %w", err)
+	}
+	return nil
+}
+
+const deadColumns = `id, payload, COALESCE(last_error, ''), deliveries, COALESCE(failed_at, created_at)`
+
+// ListDead returns the dead letters of a cabinet, most recent first, and their
+// total count
+func (q *PostgresQueue) ListDead(ctx context.Context, cabinetID string, offset, limit int) ([]DeadJob, int, error) {
+	var total int
+	if err := q.pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM message_jobs WHERE state = 'dead' AND cabinet_id = $1`, cabinetID,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count dead jobs: %w", err)
+	}
+
+	var lim any // NULL: no limit
+	if limit > 0 {
+		lim = limit
+	}
+	rows, err := q.pool.Query(ctx, `
+		SELECT `+deadColumns+`
+		FROM message_jobs
+		WHERE state = 'dead' AND cabinet_id = $1
+		ORDER BY failed_at DESC
+		LIMIT $2 OFFSET $3
+	`, cabinetID, lim, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list dead jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]DeadJob, 0)
+	for rows.Next() {
+		d, err := scanDead(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, *d)
+	}
+	return jobs, total, rows.Err()
+}
+
+// GetDead returns a dead letter of a cabinet, ErrJobNotFound when there is none
+func (q *PostgresQueue) GetDead(ctx context.Context, cabinetID, id string) (*DeadJob, error) {
+	row := q.pool.QueryRow(ctx, `
+		SELECT `+deadColumns+`
+		FROM message_jobs
+		WHERE id = $1 AND cabinet_id = $2 AND state = 'dead'
+	`, id, cabinetID)
+	d, err := scanDead(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return d, err
+}
+
+// Replay puts a dead letter of a cabinet back in the queue for immediate sending,
+// with its attempts reset
+func (q *PostgresQueue) Replay(ctx context.Context, cabinetID, id string) (*MessageJob, error) {
+	var payload []byte
+	err := q.pool.QueryRow(ctx, `
+		UPDATE message_jobs SET
+			state = 'queued', scheduled_at = $3, deliveries = 0, last_error = NULL, failed_at = NULL
+		WHERE id = $1 AND cabinet_id = $2 AND state = 'dead'
+		RETURNING payload
+	`, id, cabinetID, time.Now()).Scan(&payload)
+	return decodeJob(id, payload, err)
+}
+
+// Discard deletes a dead letter of a cabinet
+func (q *PostgresQueue) Discard(ctx context.Context, cabinetID, id string) (*MessageJob, error) {
+	var payload []byte
+	err := q.pool.QueryRow(ctx, `
+		DELETE FROM message_jobs
+		WHERE id = $1 AND cabinet_id = $2 AND state = 'dead'
+		RETURNING payload
+	`, id, cabinetID).Scan(&payload)
+	return decodeJob(id, payload, err)
+}
+
+// Stats returns the number of jobs in each state
+func (q *PostgresQueue) Stats(ctx context.Context) (Stats, error) {
+	var s Stats
+	err := q.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE state = 'queued' AND scheduled_at <= $1),
+		       COUNT(*) FILTER (WHERE state = 'queued' AND scheduled_at > $1),
+		       COUNT(*) FILTER (WHERE state = 'leased'),
+		       COUNT(*) FILTER (WHERE state = 'leased' AND lease_expires_at <= $1),
+		       COUNT(*) FILTER (WHERE state = 'dead')
+		FROM message_jobs
+	`, time.Now()).Scan(&s.Pending, &s.Delayed, &s.Processing, &s.Expired, &s.Dead)
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to get queue stats: %w", err)
+	}
+	return s, nil
+}
+
+// Report returns the jobs by state, cabinet and sender, the throughput of the last
+// hour and the recipients at their daily limit
+func (q *PostgresQueue) Report(ctx context.Context, opts ReportOptions) (*Report, error) {
+	now := time.Now()
+	var cabinetID any // NULL: all cabinets
+	if opts.CabinetID != "" {
+		cabinetID = opts.CabinetID
+	}
+
+	rows, err := q.pool.Query(ctx, `
+		SELECT COALESCE(cabinet_id, ''), COALESCE(payload->>'sender', ''),
+		       COUNT(*) FILTER (WHERE state = 'queued' AND scheduled_at <= $1),
+		       COUNT(*) FILTER (WHERE state = 'queued' AND scheduled_at > $1),
+		       COUNT(*) FILTER (WHERE state = 'leased'),
+		       COUNT(*) FILTER (WHERE state = 'leased' AND lease_expires_at <= $1),
+		       COUNT(*) FILTER (WHERE state = 'dead'),
+		       MIN(created_at) FILTER (WHERE state <> 'dead')
+		FROM message_jobs
+		WHERE $2::text IS NULL OR cabinet_id = $2
+		GROUP BY 1, 2
+	`, now, cabinetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []groupCount
+	for rows.Next() {
+		var c groupCount
+		var oldest *time.Time
+		if err := rows.Scan(&c.cabinetID, &c.sender,
+			&c.stats.Pending, &c.stats.Delayed, &c.stats.Processing, &c.stats.Expired, &c.stats.Dead,
+			&oldest); err != nil {
+			return nil, fmt.Errorf("failed to scan queue counts: %w", err)
+		}
+		if oldest != nil {
+			c.oldest = *oldest
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	jobRows, err := q.pool.Query(ctx, `
+		SELECT id, payload
+		FROM message_jobs
+		WHERE state <> 'dead' AND ($1::text IS NULL OR cabinet_id = $1)
+		ORDER BY created_at
+		LIMIT $2
+	`, cabinetID, opts.jobs())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oldest jobs: %w", err)
+	}
+	defer jobRows.Close()
+
+	var oldest []MessageJob
+	for jobRows.Next() {
+		var id string
+		var payload []byte
+		if err := jobRows.Scan(&id, &payload); err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		var job MessageJob
+		if json.Unmarshal(payload, &job) != nil {
+			continue
+		}
+		job.ID = id
+		oldest = append(oldest, job)
+	}
+	if err := jobRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read oldest jobs: %w", err)
+	}
+
+	sentRows, err := q.pool.Query(ctx, `
+		SELECT cabinet_id, sender, SUM(sent)
+		FROM message_sent
+		WHERE minute > $1 AND ($2::text IS NULL OR cabinet_id = $2)
+		GROUP BY cabinet_id, sender
+	`, now.Add(-time.Hour), cabinetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read throughput: %w", err)
+	}
+	defer sentRows.Close()
+
+	var sent []sentCount
+	for sentRows.Next() {
+		var s sentCount
+		if err := sentRows.Scan(&s.cabinetID, &s.sender, &s.sent); err != nil {
+			return nil, fmt.Errorf("failed to scan throughput: %w", err)
+		}
+		sent = append(sent, s)
+	}
+	if err := sentRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read throughput: %w", err)
+	}
+
+	phones := make(map[string]int)
+	if opts.MaxPerPhone > 0 {
+		phoneRows, err := q.pool.Query(ctx,
+			`SELECT phone, count FROM message_phone_counts WHERE day = $1 AND count >= $2`,
+			now.Format("2006-01-02"), opts.MaxPerPhone)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rate limits: %w", err)
+		}
+		defer phoneRows.Close()
+		for phoneRows.Next() {
+			var phone string
+			var count int
+			if err := phoneRows.Scan(&phone, &count); err != nil {
+				return nil, fmt.Errorf("failed to scan rate limit: %w", err)
+			}
+			phones[phone] = count
+		}
+		if err := phoneRows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read rate limits: %w", err)
+		}
+	}
+
+	return buildReport(opts, counts, oldest, sent, phones, now), nil
+}
+
+func scanDead(row pgx.Row) (*DeadJob, error) {
+	var d DeadJob
+	var id string
+	var payload []byte
+	if err := row.Scan(&id, &payload, &d.Error, &d.Attempts, &d.FailedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan dead job: %w", err)
+	}
+	if err := json.Unmarshal(payload, &d.Job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job %s: %w", id, err)
+	}
+	d.Job.ID = id
+	d.Job.Attempts = d.Attempts
+	return &d, nil
+}
+
+// decodeJob returns the job of a payload returned by a dead letter update
+func decodeJob(id string, payload []byte, err error) (*MessageJob, error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update dead job: %w", err)
+	}
+	var job MessageJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job %s: %w", id, err)
+	}
+	job.ID = id
+	return &job, nil
+}
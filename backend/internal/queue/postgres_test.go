@@ -0,0 +1,43 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/fiducia/backend/internal/database"
+	"github.com/fiducia/backend/internal/queue"
+	"github.com/fiducia/backend/internal/queue/queuetest"
+)
+
+// TestPostgresQueue runs the conformance suite against the database of DATABASE_URL.
+// It is migrated, and its queue tables are emptied before each test.
+func TestPostgresQueue(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	db, err := database.New(databaseURL)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	queuetest.Run(t, func(t *testing.T, opts queue.Options) queue.Queue {
+		_, err := db.Pool.Exec(context.Background(), `
+			TRUNCATE message_jobs, message_phone_counts, message_sender_buckets, message_senders, message_sent
+		`)
+		if err != nil {
+			t.Fatalf("failed to clear queue tables: %v", err)
+		}
+		opts.Backend, opts.Pool = queue.BackendPostgres, db.Pool
+		q, err := queue.Open(opts)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		return q
+	})
+}
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Queue holds the outbound messages until the workers send them. Delivery is at
+// least once: a dequeued job is leased for the visibility timeout and re-queued by
+// Reap when the worker neither completes nor retries it in time.
+type Queue interface {
+	// Enqueue schedules a job after the anti-ban jitter of policy. A job whose ID is
+	// already queued is left unchanged.
+	Enqueue(ctx context.Context, job *MessageJob, policy Policy) error
+	// EnqueueImmediate schedules a job now, without jitter nor recipient limit
+	EnqueueImmediate(ctx context.Context, job *MessageJob, policy Policy) error
+	// Dequeue leases the next ready job, nil when there is none. A job whose sender
+	// is over its limits is delayed and nil returned.
+	Dequeue(ctx context.Context) (*MessageJob, error)
+	// Complete removes a sent job, even when its lease expired
+	Complete(ctx context.Context, job *MessageJob) error
+	// Retry releases the lease of a failed job and schedules it again with
+	// exponential backoff, or returns ErrLeaseLost
+	Retry(ctx context.Context, job *MessageJob) error
+	// Reap re-queues the jobs whose lease expired and returns their number
+	Reap(ctx context.Context) (int, error)
+	// DeadLetter moves a job that will not be attempted again to the dead letters
+	DeadLetter(ctx context.Context, job *MessageJob, cause error) error
+	// ListDead returns the dead letters of a cabinet, most recent first, and their count
+	ListDead(ctx context.Context, cabinetID string, offset, limit int) ([]DeadJob, int, error)
+	// GetDead returns a dead letter of a cabinet, or ErrJobNotFound
+	GetDead(ctx context.Context, cabinetID, id string) (*DeadJob, error)
+	// Replay queues a dead letter of a cabinet for immediate sending, attempts reset
+	Replay(ctx context.Context, cabinetID, id string) (*MessageJob, error)
+	// Discard deletes a dead letter of a cabinet
+	Discard(ctx context.Context, cabinetID, id string) (*MessageJob, error)
+	// Stats returns the number of jobs in each state
+	Stats(ctx context.Context) (Stats, error)
+	// Report returns the jobs by state, cabinet and sender, the jobs completed over
+	// the last hour and the recipients at their daily limit
+	Report(ctx context.Context, opts ReportOptions) (*Report, error)
+	// VisibilityTimeout returns how long a dequeued job is leased to its worker
+	VisibilityTimeout() time.Duration
+	Close() error
+}
+
+// Stats counts the jobs of a queue by state
+type Stats struct {
+	Pending    int64 `json:"pending"`    // Ready to be sent
+	Delayed    int64 `json:"delayed"`    // Scheduled later (jitter, backoff, throttling)
+	Processing int64 `json:"processing"` // Leased to a worker
+	Expired    int64 `json:"expired"`    // Leased, lease expired, not reaped yet
+	Dead       int64 `json:"dead"`       // Dead letters
+}
+
+// Backends
+const (
+	BackendRedis    = "redis"
+	BackendPostgres = "postgres"
+)
+
+// Options configure a queue
+type Options struct {
+	Backend           string        // redis (default) or postgres
+	RedisURL          string        // Redis backend
+	Pool              *pgxpool.Pool // Postgres backend
+	VisibilityTimeout time.Duration // DefaultVisibilityTimeout when zero
+	SenderLimits      SenderLimits  // Limits of jobs enqueued without a policy
+}
+
+// Open connects the queue of the configured backend
+func Open(opts Options) (Queue, error) {
+	switch opts.Backend {
+	case "", BackendRedis:
+		q, err := NewMessageQueue(opts.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		q.SetVisibilityTimeout(opts.VisibilityTimeout)
+		q.SetSenderLimits(opts.SenderLimits)
+		return q, nil
+	case BackendPostgres:
+		if opts.Pool == nil {
+			return nil, fmt.Errorf("postgres queue needs a database pool")
+		}
+		q := NewPostgresQueue(opts.Pool)
+		q.SetVisibilityTimeout(opts.VisibilityTimeout)
+		q.SetSenderLimits(opts.SenderLimits)
+		return q, nil
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", opts.Backend)
+	}
+}
+
+// ErrLeaseLost is returned by Retry when the lease of the job expired: the reaper
+// re-queued it and another worker may be sending it
+var ErrLeaseLost = errors.New("job lease expired")
+
+// DefaultVisibilityTimeout is how long a dequeued job stays invisible to other
+// workers before the reaper re-queues it
+const DefaultVisibilityTimeout = 5 * time.Minute
+
+// Policy is the anti-ban policy applied to a job
+type Policy struct {
+	MinJitter         time.Duration // Minimum delay before a message
+	MaxJitter         time.Duration // Maximum delay before a message
+	TypingDelay       time.Duration // Simulated typing delay
+	MaxPerPhonePerDay int           // Max messages per recipient phone per day, 0 for no limit
+	Sender            SenderLimits  // Throughput of the sender number
+}
+
+// DefaultPolicy suits a new WhatsApp number. It has no sender limits.
+var DefaultPolicy = Policy{
+	MinJitter:         30 * time.Second,
+	MaxJitter:         180 * time.Second,
+	TypingDelay:       2 * time.Second,
+	MaxPerPhonePerDay: 3,
+}
+
+// schedule schedules job after a random jitter, which it returns, and stamps the
+// parts of the policy used when the job is sent
+func (p Policy) schedule(job *MessageJob) time.Duration {
+	jitter := p.MinJitter
+	if p.MaxJitter > p.MinJitter {
+		jitter += time.Duration(rand.Int63n(int64(p.MaxJitter - p.MinJitter)))
+	}
+	p.scheduleNow(job)
+	job.ScheduledAt = job.CreatedAt.Add(jitter)
+	return jitter
+}
+
+// scheduleNow schedules job for immediate sending and stamps the parts of the
+// policy used when the job is sent
+func (p Policy) scheduleNow(job *MessageJob) {
+	job.CreatedAt = time.Now()
+	job.ScheduledAt = job.CreatedAt
+	limits := p.Sender
+	job.Limits = &limits
+	job.TypingDelayMs = int(p.TypingDelay.Milliseconds())
+}
+
+// retryBackoff returns the delay before the next attempt of a job:
+// 1min, 2min, 4min, 8min, 16min, then 30min
+func retryBackoff(job *MessageJob) time.Duration {
+	if job.Attempts < 5 {
+		return time.Duration(1<<job.Attempts) * time.Minute
+	}
+	return 30 * time.Minute
+}
@@ -0,0 +1,342 @@
+// Package queuetest is the conformance suite of the queue.Queue implementations.
+// Each backend runs it from a test with a factory returning an empty queue:
+//
+//	queuetest.Run(t, func(t *testing.T, opts queue.Options) queue.Queue {
+//		opts.Backend, opts.RedisURL = queue.BackendRedis, os.Getenv("REDIS_URL")
+//		q, err := queue.Open(opts)
+//		...
+//	})
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/queue"
+)
+
+// Factory returns an empty queue opened with opts. It registers its own cleanup.
+type Factory func(t *testing.T, opts queue.Options) queue.Queue
+
+// immediate is a policy without jitter nor limits
+var immediate = queue.Policy{}
+
+// Run runs the conformance suite against the queues of newQueue
+func Run(t *testing.T, newQueue Factory) {
+	tests := []struct {
+		name string
+		opts queue.Options
+		run  func(t *testing.T, q queue.Queue)
+	}{
+		{"EnqueueImmediateDequeue", queue.Options{}, testEnqueueImmediate},
+		{"EnqueueJitterDelays", queue.Options{}, testEnqueueJitter},
+		{"EnqueueDuplicateIgnored", queue.Options{}, testDuplicate},
+		{"EnqueuePhoneLimit", queue.Options{}, testPhoneLimit},
+		{"RetryBacksOff", queue.Options{}, testRetry},
+		{"ReapExpiredLease", queue.Options{VisibilityTimeout: 200 * time.Millisecond}, testReap},
+		{"DeadLetters", queue.Options{}, testDeadLetters},
+		{"SenderThrottle", queue.Options{}, testThrottle},
+		{"Report", queue.Options{}, testReport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newQueue(t, tt.opts))
+		})
+	}
+}
+
+func newJob(cabinetID string) *queue.MessageJob {
+	return &queue.MessageJob{
+		ID:          uuid.NewString(),
+		CabinetID:   cabinetID,
+		Phone:       "+33600000000",
+		MessageType: "text",
+		Content:     "Bonjour",
+	}
+}
+
+func mustEnqueue(t *testing.T, q queue.Queue, job *queue.MessageJob) {
+	t.Helper()
+	if err := q.EnqueueImmediate(context.Background(), job, immediate); err != nil {
+		t.Fatalf("EnqueueImmediate: %v", err)
+	}
+}
+
+func mustDequeue(t *testing.T, q queue.Queue, want *queue.MessageJob) *queue.MessageJob {
+	t.Helper()
+	got, err := q.Dequeue(context.Background())
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	if want == nil {
+		if got != nil {
+			t.Fatalf("Dequeue returned job %s, want none", got.ID)
+		}
+		return nil
+	}
+	if got == nil {
+		t.Fatalf("Dequeue returned no job, want %s", want.ID)
+	}
+	if got.ID != want.ID || got.Content != want.Content || got.CabinetID != want.CabinetID {
+		t.Fatalf("Dequeue returned %+v, want %+v", got, want)
+	}
+	return got
+}
+
+func checkStats(t *testing.T, q queue.Queue, want queue.Stats) {
+	t.Helper()
+	got, err := q.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func testEnqueueImmediate(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	job := newJob(uuid.NewString())
+	mustEnqueue(t, q, job)
+	checkStats(t, q, queue.Stats{Pending: 1})
+
+	got := mustDequeue(t, q, job)
+	if got.Attempts != 0 {
+		t.Errorf("Attempts = %d, want 0", got.Attempts)
+	}
+	checkStats(t, q, queue.Stats{Processing: 1})
+	mustDequeue(t, q, nil)
+
+	if err := q.Complete(ctx, got); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	checkStats(t, q, queue.Stats{})
+	mustDequeue(t, q, nil)
+}
+
+func testEnqueueJitter(t *testing.T, q queue.Queue) {
+	job := newJob(uuid.NewString())
+	policy := queue.Policy{MinJitter: time.Hour, MaxJitter: 2 * time.Hour}
+	if err := q.Enqueue(context.Background(), job, policy); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if d := time.Until(job.ScheduledAt); d < 59*time.Minute || d > 2*time.Hour {
+		t.Errorf("job scheduled in %s, want between 1h and 2h", d)
+	}
+	mustDequeue(t, q, nil)
+	checkStats(t, q, queue.Stats{Delayed: 1})
+}
+
+func testDuplicate(t *testing.T, q queue.Queue) {
+	job := newJob(uuid.NewString())
+	mustEnqueue(t, q, job)
+	dup := *job
+	dup.Content = "Changed"
+	mustEnqueue(t, q, &dup)
+	checkStats(t, q, queue.Stats{Pending: 1})
+
+	mustDequeue(t, q, job)
+	mustDequeue(t, q, nil)
+}
+
+func testPhoneLimit(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	policy := queue.Policy{MaxPerPhonePerDay: 1}
+	first := newJob(uuid.NewString())
+	first.Phone = "+336" + uuid.NewString()[:8]
+	if err := q.Enqueue(ctx, first, policy); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	second := newJob(first.CabinetID)
+	second.Phone = first.Phone
+	if err := q.Enqueue(ctx, second, policy); err == nil {
+		t.Fatal("second Enqueue to the same phone succeeded, want rate limit error")
+	}
+	checkStats(t, q, queue.Stats{Pending: 1})
+}
+
+func testRetry(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	job := newJob(uuid.NewString())
+	mustEnqueue(t, q, job)
+	got := mustDequeue(t, q, job)
+
+	if err := q.Retry(ctx, got); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if d := time.Until(got.ScheduledAt); d < 50*time.Second || d > time.Minute {
+		t.Errorf("first retry in %s, want 1m", d)
+	}
+	mustDequeue(t, q, nil)
+	checkStats(t, q, queue.Stats{Delayed: 1})
+
+	if err := q.Retry(ctx, got); !errors.Is(err, queue.ErrLeaseLost) {
+		t.Errorf("Retry of a released job = %v, want ErrLeaseLost", err)
+	}
+}
+
+func testReap(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	if got := q.VisibilityTimeout(); got != 200*time.Millisecond {
+		t.Fatalf("VisibilityTimeout = %s, want 200ms", got)
+	}
+	job := newJob(uuid.NewString())
+	mustEnqueue(t, q, job)
+	mustDequeue(t, q, job)
+
+	if n, err := q.Reap(ctx); err != nil || n != 0 {
+		t.Fatalf("Reap before expiry = %d, %v, want 0", n, err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	checkStats(t, q, queue.Stats{Processing: 1, Expired: 1})
+	if n, err := q.Reap(ctx); err != nil || n != 1 {
+		t.Fatalf("Reap after expiry = %d, %v, want 1", n, err)
+	}
+
+	again := mustDequeue(t, q, job)
+	if again.Attempts != 1 {
+		t.Errorf("Attempts after reap = %d, want 1", again.Attempts)
+	}
+	if err := q.Complete(ctx, again); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	checkStats(t, q, queue.Stats{})
+}
+
+func testDeadLetters(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	cabinetID := uuid.NewString()
+	job := newJob(cabinetID)
+	mustEnqueue(t, q, job)
+	got := mustDequeue(t, q, job)
+
+	if err := q.DeadLetter(ctx, got, errors.New("invalid number")); err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+	checkStats(t, q, queue.Stats{Dead: 1})
+	mustDequeue(t, q, nil)
+
+	dead, total, err := q.ListDead(ctx, cabinetID, 0, 10)
+	if err != nil {
+		t.Fatalf("ListDead: %v", err)
+	}
+	if total != 1 || len(dead) != 1 || dead[0].Job.ID != job.ID {
+		t.Fatalf("ListDead = %+v (total %d), want job %s", dead, total, job.ID)
+	}
+	if dead[0].Error != "invalid number" || dead[0].Attempts != 1 || dead[0].FailedAt.IsZero() {
+		t.Errorf("dead job = %+v, want error, 1 attempt and failure time", dead[0])
+	}
+	if _, total, err := q.ListDead(ctx, cabinetID, 1, 10); err != nil || total != 1 {
+		t.Errorf("ListDead past the end total = %d, %v, want 1", total, err)
+	}
+
+	other := uuid.NewString()
+	if _, total, err := q.ListDead(ctx, other, 0, 10); err != nil || total != 0 {
+		t.Errorf("ListDead of another cabinet total = %d, %v, want 0", total, err)
+	}
+	if _, err := q.GetDead(ctx, other, job.ID); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("GetDead of another cabinet = %v, want ErrJobNotFound", err)
+	}
+	if _, err := q.Replay(ctx, other, job.ID); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("Replay of another cabinet = %v, want ErrJobNotFound", err)
+	}
+
+	if _, err := q.Replay(ctx, cabinetID, job.ID); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	replayed := mustDequeue(t, q, job)
+	if replayed.Attempts != 0 {
+		t.Errorf("Attempts after replay = %d, want 0", replayed.Attempts)
+	}
+
+	if err := q.DeadLetter(ctx, replayed, errors.New("invalid number")); err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+	if _, err := q.Discard(ctx, cabinetID, job.ID); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	if _, err := q.GetDead(ctx, cabinetID, job.ID); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("GetDead after discard = %v, want ErrJobNotFound", err)
+	}
+	checkStats(t, q, queue.Stats{})
+}
+
+func testThrottle(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	policy := queue.Policy{Sender: queue.SenderLimits{PerMinute: 1}}
+	sender := "+337" + uuid.NewString()[:8]
+	first, second := newJob(uuid.NewString()), newJob(uuid.NewString())
+	first.Sender, second.Sender = sender, sender
+	for _, job := range []*queue.MessageJob{first, second} {
+		if err := q.EnqueueImmediate(ctx, job, policy); err != nil {
+			t.Fatalf("EnqueueImmediate: %v", err)
+		}
+	}
+
+	got, err := q.Dequeue(ctx)
+	if err != nil || got == nil {
+		t.Fatalf("Dequeue = %v, %v, want a job", got, err)
+	}
+	// The second job is delayed until the sender has a token again
+	if again, err := q.Dequeue(ctx); err != nil || again != nil {
+		t.Fatalf("Dequeue of a throttled sender = %v, %v, want none", again, err)
+	}
+	checkStats(t, q, queue.Stats{Delayed: 1, Processing: 1})
+	mustDequeue(t, q, nil)
+
+	if err := q.Complete(ctx, got); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+}
+
+func testReport(t *testing.T, q queue.Queue) {
+	ctx := context.Background()
+	cabinetID := uuid.NewString()
+	sender := "+337" + uuid.NewString()[:8]
+	sent, waiting, other := newJob(cabinetID), newJob(cabinetID), newJob(uuid.NewString())
+	sent.Sender, waiting.Sender = sender, sender
+	mustEnqueue(t, q, sent)
+	got := mustDequeue(t, q, sent)
+	if err := q.Complete(ctx, got); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	mustEnqueue(t, q, waiting)
+	mustEnqueue(t, q, other)
+
+	phone := "+336" + uuid.NewString()[:8]
+	limited := newJob(cabinetID)
+	limited.Phone = phone
+	if err := q.Enqueue(ctx, limited, queue.Policy{MaxPerPhonePerDay: 1}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	report, err := q.Report(ctx, queue.ReportOptions{CabinetID: cabinetID, MaxPerPhone: 1})
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if report.Pending != 2 || report.Processing != 0 || report.SentLastHour != 1 {
+		t.Errorf("report totals = %+v, want 2 pending and 1 sent", report.Breakdown)
+	}
+	if len(report.OldestJobs) != 2 {
+		t.Errorf("report oldest jobs = %d, want the 2 waiting jobs of the cabinet", len(report.OldestJobs))
+	}
+	if len(report.Cabinets) != 1 || report.Cabinets[cabinetID] == nil {
+		t.Errorf("report cabinets = %v, want only %s", report.Cabinets, cabinetID)
+	}
+	if s := report.Senders[sender]; s == nil || s.Pending != 1 || s.SentLastHour != 1 {
+		t.Errorf("report sender = %+v, want 1 pending and 1 sent", s)
+	}
+	found := false
+	for _, r := range report.Recipients {
+		if r.Phone == phone {
+			found = r.Sent == 1 && r.ResetAt.After(time.Now())
+		}
+	}
+	if !found {
+		t.Errorf("report recipients = %+v, want %s limited until tomorrow", report.Recipients, phone)
+	}
+}
@@ -0,0 +1,53 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/fiducia/backend/internal/queue"
+	"github.com/fiducia/backend/internal/queue/queuetest"
+)
+
+// TestRedisQueue runs the conformance suite against the Redis of REDIS_URL. The
+// fiducia keys of its database are deleted before each test.
+func TestRedisQueue(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL not set")
+	}
+
+	queuetest.Run(t, func(t *testing.T, opts queue.Options) queue.Queue {
+		clearRedis(t, redisURL)
+		opts.Backend, opts.RedisURL = queue.BackendRedis, redisURL
+		q, err := queue.Open(opts)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		t.Cleanup(func() { q.Close() })
+		return q
+	})
+}
+
+func clearRedis(t *testing.T, redisURL string) {
+	t.Helper()
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		t.Fatalf("invalid REDIS_URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+	defer client.Close()
+
+	ctx := context.Background()
+	iter := client.Scan(ctx, 0, "fiducia:*", 500).Iterator()
+	for iter.Next(ctx) {
+		if err := client.Del(ctx, iter.Val()).Err(); err != nil {
+			t.Fatalf("failed to clear redis: %v", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("failed to clear redis: %v", err)
+	}
+}
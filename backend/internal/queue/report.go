@@ -0,0 +1,316 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Report is the operations view of the queue
+type Report struct {
+	Breakdown
+	Cabinets    map[string]*Breakdown `json:"cabinets"`
+	Senders     map[string]*Breakdown `json:"senders"`
+	OldestJobs  []MessageJob          `json:"oldest_jobs"` // Oldest jobs not dead-lettered, at most ReportOptions.Jobs
+	Recipients  []LimitedRecipient    `json:"rate_limited_recipients"`
+	GeneratedAt time.Time             `json:"generated_at"`
+}
+
+// Breakdown is the state of the jobs of the whole queue, of a cabinet or of a sender
+type Breakdown struct {
+	Stats
+	OldestJobAgeSeconds int64 `json:"oldest_job_age_seconds"` // Oldest job not dead-lettered, 0 when none
+	SentLastHour        int64 `json:"sent_last_hour"`
+}
+
+// LimitedRecipient is a recipient phone that reached its daily message limit
+type LimitedRecipient struct {
+	Phone   string    `json:"phone"`
+	Sent    int       `json:"sent"`
+	Limit   int       `json:"limit"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// ReportOptions filter a report
+type ReportOptions struct {
+	CabinetID   string // Jobs of this cabinet only, all cabinets when empty
+	MaxPerPhone int    // Daily limit of a recipient phone, 0 to skip the recipients
+	Jobs        int    // Oldest jobs listed, defaultReportJobs when zero
+}
+
+// Bounds of the oldest jobs listed by a report
+const (
+	defaultReportJobs = 10
+	maxReportJobs     = 100
+)
+
+// jobs returns the number of oldest jobs to list
+func (o ReportOptions) jobs() int {
+	switch {
+	case o.Jobs <= 0:
+		return defaultReportJobs
+	case o.Jobs > maxReportJobs:
+		return maxReportJobs
+	}
+	return o.Jobs
+}
+
+// groupCount is the number of jobs in each state of a cabinet and sender, counted
+// by the backend
+type groupCount struct {
+	cabinetID string
+	sender    string
+	stats     Stats
+	oldest    time.Time // Creation of the oldest job not dead-lettered, zero when none
+}
+
+// sentCount is the number of jobs of a cabinet and sender completed over the last hour
+type sentCount struct {
+	cabinetID string
+	sender    string
+	sent      int64
+}
+
+// add counts the jobs of a group
+func (b *Breakdown) add(c groupCount, now time.Time) {
+	b.Pending += c.stats.Pending
+	b.Delayed += c.stats.Delayed
+	b.Processing += c.stats.Processing
+	b.Expired += c.stats.Expired
+	b.Dead += c.stats.Dead
+	if !c.oldest.IsZero() {
+		if age := int64(now.Sub(c.oldest).Seconds()); age > b.OldestJobAgeSeconds {
+			b.OldestJobAgeSeconds = age
+		}
+	}
+}
+
+// buildReport aggregates the group counts, completions and recipient counters read by
+// a backend
+func buildReport(opts ReportOptions, counts []groupCount, oldest []MessageJob, sent []sentCount, phones map[string]int, now time.Time) *Report {
+	r := &Report{
+		Cabinets:    make(map[string]*Breakdown),
+		Senders:     make(map[string]*Breakdown),
+		OldestJobs:  oldest,
+		Recipients:  make([]LimitedRecipient, 0),
+		GeneratedAt: now,
+	}
+	if r.OldestJobs == nil {
+		r.OldestJobs = make([]MessageJob, 0)
+	}
+	breakdown := func(m map[string]*Breakdown, key string) *Breakdown {
+		b, ok := m[key]
+		if !ok {
+			b = &Breakdown{}
+			m[key] = b
+		}
+		return b
+	}
+
+	for _, c := range counts {
+		if opts.CabinetID != "" && c.cabinetID != opts.CabinetID {
+			continue
+		}
+		r.add(c, now)
+		breakdown(r.Cabinets, c.cabinetID).add(c, now)
+		if c.sender != "" {
+			breakdown(r.Senders, c.sender).add(c, now)
+		}
+	}
+
+	for _, s := range sent {
+		if opts.CabinetID != "" && s.cabinetID != opts.CabinetID {
+			continue
+		}
+		r.SentLastHour += s.sent
+		breakdown(r.Cabinets, s.cabinetID).SentLastHour += s.sent
+		if s.sender != "" {
+			breakdown(r.Senders, s.sender).SentLastHour += s.sent
+		}
+	}
+
+	if opts.MaxPerPhone > 0 {
+		y, m, d := now.Date()
+		reset := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+		for phone, count := range phones {
+			if count >= opts.MaxPerPhone {
+				r.Recipients = append(r.Recipients, LimitedRecipient{
+					Phone: phone, Sent: count, Limit: opts.MaxPerPhone, ResetAt: reset,
+				})
+			}
+		}
+		sort.Slice(r.Recipients, func(i, j int) bool { return r.Recipients[i].Phone < r.Recipients[j].Phone })
+	}
+	return r
+}
+
+// sentKey returns the hash counting the jobs completed during the minute of t, by
+// cabinet and sender
+func sentKey(t time.Time) string {
+	return "fiducia:{messages}:sent:" + strconv.FormatInt(t.Unix()/60, 10)
+}
+
+// recordSent counts a completed job for the throughput of the report
+func (q *MessageQueue) recordSent(ctx context.Context, job *MessageJob) {
+	key := sentKey(time.Now())
+	pipe := q.client.Pipeline()
+	pipe.HIncrBy(ctx, key, job.CabinetID+"|"+job.Sender, 1)
+	pipe.Expire(ctx, key, 2*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Warn("failed to record sent message", "job_id", job.ID, "error", err)
+	}
+}
+
+// Report returns the jobs by state, cabinet and sender, the throughput of the last
+// hour and the recipients at their daily limit. Jobs are counted on the index of
+// their group, the cost growing with the number of cabinets and senders only.
+func (q *MessageQueue) Report(ctx context.Context, opts ReportOptions) (*Report, error) {
+	now := time.Now()
+	groups, err := q.client.SMembers(ctx, q.groupsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queue groups: %w", err)
+	}
+
+	type groupCmds struct {
+		count                                       groupCount
+		pending, delayed, leased, expired, deadJobs *redis.IntCmd
+		oldest                                      *redis.ZSliceCmd
+	}
+	nowMs := strconv.FormatInt(now.UnixMilli(), 10)
+	limit := opts.jobs()
+	pipe := q.client.Pipeline()
+	cmds := make([]groupCmds, 0, len(groups))
+	for _, g := range groups {
+		cabinetID, sender, _ := strings.Cut(g, "|")
+		if opts.CabinetID != "" && cabinetID != opts.CabinetID {
+			continue
+		}
+		key := q.groupPrefix + g
+		cmds = append(cmds, groupCmds{
+			count:    groupCount{cabinetID: cabinetID, sender: sender},
+			pending:  pipe.ZCount(ctx, key+":queued", "-inf", nowMs),
+			delayed:  pipe.ZCount(ctx, key+":queued", "("+nowMs, "+inf"),
+			leased:   pipe.ZCard(ctx, key+":leased"),
+			expired:  pipe.ZCount(ctx, key+":leased", "-inf", nowMs),
+			deadJobs: pipe.ZCard(ctx, key+":dead"),
+			oldest:   pipe.ZRangeWithScores(ctx, key+":created", 0, int64(limit-1)),
+		})
+	}
+	sent := make([]*redis.MapStringStringCmd, 60)
+	for i := range sent {
+		sent[i] = pipe.HGetAll(ctx, sentKey(now.Add(-time.Duration(i)*time.Minute)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	counts := make([]groupCount, 0, len(cmds))
+	var oldest []redis.Z
+	for _, c := range cmds {
+		c.count.stats = Stats{
+			Pending:    c.pending.Val(),
+			Delayed:    c.delayed.Val(),
+			Processing: c.leased.Val(),
+			Expired:    c.expired.Val(),
+			Dead:       c.deadJobs.Val(),
+		}
+		if first := c.oldest.Val(); len(first) > 0 {
+			c.count.oldest = time.UnixMilli(int64(first[0].Score))
+			oldest = append(oldest, first...)
+		}
+		counts = append(counts, c.count)
+	}
+	jobs, err := q.oldestJobs(ctx, oldest, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var sentCounts []sentCount
+	for _, cmd := range sent {
+		for field, v := range cmd.Val() {
+			cabinetID, sender, _ := strings.Cut(field, "|")
+			n, _ := strconv.ParseInt(v, 10, 64)
+			sentCounts = append(sentCounts, sentCount{cabinetID: cabinetID, sender: sender, sent: n})
+		}
+	}
+
+	var phones map[string]int
+	if opts.MaxPerPhone > 0 {
+		if phones, err = q.phoneCounts(ctx, now); err != nil {
+			return nil, err
+		}
+	}
+	return buildReport(opts, counts, jobs, sentCounts, phones, now), nil
+}
+
+// oldestJobs reads the payloads of the limit oldest of the jobs of several groups,
+// each scored by creation time
+func (q *MessageQueue) oldestJobs(ctx context.Context, candidates []redis.Z, limit int) ([]MessageJob, error) {
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score < candidates[j].Score })
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(candidates))
+	for i, z := range candidates {
+		ids[i], _ = z.Member.(string)
+	}
+	payloads, err := q.client.HMGet(ctx, q.jobsKey, ids...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oldest jobs: %w", err)
+	}
+
+	jobs := make([]MessageJob, 0, len(ids))
+	for i, id := range ids {
+		payload, _ := payloads[i].(string)
+		var job MessageJob
+		if payload == "" || json.Unmarshal([]byte(payload), &job) != nil {
+			continue
+		}
+		job.ID = id
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// phoneCounts returns the messages queued today per recipient phone
+func (q *MessageQueue) phoneCounts(ctx context.Context, now time.Time) (map[string]int, error) {
+	suffix := ":" + now.Format("2006-01-02")
+	counts := make(map[string]int)
+	iter := q.client.Scan(ctx, 0, "fiducia:ratelimit:*"+suffix, 500).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list rate limits: %w", err)
+	}
+	if len(keys) == 0 {
+		return counts, nil
+	}
+
+	values, err := q.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limits: %w", err)
+	}
+	for i, key := range keys {
+		s, _ := values[i].(string)
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			continue
+		}
+		phone := strings.TrimSuffix(strings.TrimPrefix(key, "fiducia:ratelimit:"), suffix)
+		counts[phone] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,198 @@
+package queue
+
+import "github.com/redis/go-redis/v9"
+
+// Lua scripts moving job IDs between the queue keys. Each script runs atomically,
+// so concurrent workers never see a job in two places.
+//
+// Keys hold job IDs only, the payloads live in the jobs hash:
+//   - ready:      list of IDs to send now
+//   - delayed:    sorted set of IDs scored by scheduled time (unix ms)
+//   - leases:     sorted set of IDs being sent, scored by lease expiry (unix ms)
+//   - jobs:       hash ID -> JSON payload
+//   - deliveries: hash ID -> number of times the job was dequeued
+//   - dead:       sorted set of dead-lettered IDs scored by failure time (unix ms)
+//   - dead_info:  hash ID -> JSON failure details
+//
+// The report counts the jobs of each group, the cabinet and sender of a job
+// ("cabinet|sender"), without reading the payloads. The scripts keep, per group:
+//   - group:<g>:queued:  sorted set of ready and delayed IDs scored by scheduled time
+//   - group:<g>:leased:  sorted set of IDs being sent, scored by lease expiry
+//   - group:<g>:dead:    sorted set of dead-lettered IDs scored by failure time
+//   - group:<g>:created: sorted set of the IDs not dead-lettered, scored by creation time
+//   - groups:            set of the groups having jobs
+
+// groupLua defines job_group, which returns the group of a payload, nil when the
+// payload is missing or unreadable
+const groupLua = `
+local function job_group(payload)
+  if not payload then
+    return nil
+  end
+  local ok, job = pcall(cjson.decode, payload)
+  if not ok or type(job) ~= 'table' then
+    return nil
+  end
+  local cabinet, sender = job.cabinet_id, job.sender
+  if type(cabinet) ~= 'string' then cabinet = '' end
+  if type(sender) ~= 'string' then sender = '' end
+  return cabinet .. '|' .. sender
+end
+`
+
+// enqueueScript stores a job and schedules it; it is a no-op when the ID is queued already.
+// KEYS: jobs, delayed, ready, groups. ARGV: id, payload, scheduled time, "ready" for
+// the ready list, creation time, group prefix.
+var enqueueScript = redis.NewScript(groupLua + `
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+  return 0
+end
+if ARGV[4] == 'ready' then
+  redis.call('RPUSH', KEYS[3], ARGV[1])
+else
+  redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+end
+local g = job_group(ARGV[2])
+if g then
+  redis.call('SADD', KEYS[4], g)
+  redis.call('ZADD', ARGV[6] .. g .. ':queued', ARGV[3], ARGV[1])
+  redis.call('ZADD', ARGV[6] .. g .. ':created', ARGV[5], ARGV[1])
+end
+return 1
+`)
+
+// dequeueScript promotes the due delayed jobs, then leases the next ready job.
+// IDs whose payload was deleted (completed while re-queued) are dropped.
+// KEYS: delayed, ready, leases, jobs, deliveries. ARGV: now, lease expiry, group prefix.
+// Returns {id, payload, deliveries} or nil.
+var dequeueScript = redis.NewScript(groupLua + `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, id in ipairs(due) do
+  redis.call('ZREM', KEYS[1], id)
+  redis.call('RPUSH', KEYS[2], id)
+end
+while true do
+  local id = redis.call('LPOP', KEYS[2])
+  if not id then
+    return nil
+  end
+  local payload = redis.call('HGET', KEYS[4], id)
+  if payload then
+    redis.call('ZADD', KEYS[3], ARGV[2], id)
+    local n = redis.call('HINCRBY', KEYS[5], id, 1)
+    local g = job_group(payload)
+    if g then
+      redis.call('ZREM', ARGV[3] .. g .. ':queued', id)
+      redis.call('ZADD', ARGV[3] .. g .. ':leased', ARGV[2], id)
+    end
+    return {id, payload, n}
+  end
+end
+`)
+
+// completeScript removes a job and its payload from every key, and its group once empty.
+// KEYS: leases, jobs, deliveries, ready, delayed, dead, dead_info, groups. ARGV: id,
+// group prefix. Returns 1 when the job was still leased.
+var completeScript = redis.NewScript(groupLua + `
+local g = job_group(redis.call('HGET', KEYS[2], ARGV[1]))
+local leased = redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('LREM', KEYS[4], 0, ARGV[1])
+redis.call('ZREM', KEYS[5], ARGV[1])
+redis.call('ZREM', KEYS[6], ARGV[1])
+redis.call('HDEL', KEYS[7], ARGV[1])
+if g then
+  local key = ARGV[2] .. g
+  redis.call('ZREM', key .. ':queued', ARGV[1])
+  redis.call('ZREM', key .. ':leased', ARGV[1])
+  redis.call('ZREM', key .. ':dead', ARGV[1])
+  redis.call('ZREM', key .. ':created', ARGV[1])
+  if redis.call('ZCARD', key .. ':created') == 0 and redis.call('ZCARD', key .. ':dead') == 0 then
+    redis.call('SREM', KEYS[8], g)
+  end
+end
+return leased
+`)
+
+// retryScript releases a lease and schedules the job again.
+// KEYS: leases, delayed, jobs. ARGV: id, score, group prefix.
+// Returns 0 when the lease had expired and the job was re-queued by the reaper.
+var retryScript = redis.NewScript(groupLua + `
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+  return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+local g = job_group(redis.call('HGET', KEYS[3], ARGV[1]))
+if g then
+  redis.call('ZREM', ARGV[3] .. g .. ':leased', ARGV[1])
+  redis.call('ZADD', ARGV[3] .. g .. ':queued', ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// reapScript puts the jobs whose lease expired back in the ready list.
+// KEYS: leases, ready, jobs. ARGV: now, group prefix. Returns the number of jobs re-queued.
+var reapScript = redis.NewScript(groupLua + `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+local n = 0
+for _, id in ipairs(expired) do
+  redis.call('ZREM', KEYS[1], id)
+  local payload = redis.call('HGET', KEYS[3], id)
+  if payload then
+    redis.call('RPUSH', KEYS[2], id)
+    n = n + 1
+    local g = job_group(payload)
+    if g then
+      redis.call('ZREM', ARGV[2] .. g .. ':leased', id)
+      redis.call('ZADD', ARGV[2] .. g .. ':queued', ARGV[1], id)
+    end
+  end
+end
+return n
+`)
+
+// deadLetterScript moves a job that will not be attempted again to the dead-letter
+// set, keeping its payload.
+// KEYS: leases, ready, delayed, dead, dead_info, jobs. ARGV: id, now, failure details,
+// group prefix.
+var deadLetterScript = redis.NewScript(groupLua + `
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('LREM', KEYS[2], 0, ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+redis.call('HSET', KEYS[5], ARGV[1], ARGV[3])
+local g = job_group(redis.call('HGET', KEYS[6], ARGV[1]))
+if g then
+  local key = ARGV[4] .. g
+  redis.call('ZREM', key .. ':queued', ARGV[1])
+  redis.call('ZREM', key .. ':leased', ARGV[1])
+  redis.call('ZREM', key .. ':created', ARGV[1])
+  redis.call('ZADD', key .. ':dead', ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// replayScript puts a dead-lettered job back in the ready list with its attempts reset.
+// KEYS: dead, dead_info, deliveries, ready, jobs. ARGV: id, now, creation time, group prefix.
+// Returns 0 when the job is not dead-lettered.
+var replayScript = redis.NewScript(groupLua + `
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+  return 0
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+local payload = redis.call('HGET', KEYS[5], ARGV[1])
+if not payload then
+  return 0
+end
+redis.call('RPUSH', KEYS[4], ARGV[1])
+local g = job_group(payload)
+if g then
+  local key = ARGV[4] .. g
+  redis.call('ZREM', key .. ':dead', ARGV[1])
+  redis.call('ZADD', key .. ':queued', ARGV[2], ARGV[1])
+  redis.call('ZADD', key .. ':created', ARGV[3], ARGV[1])
+end
+return 1
+`)
@@ -0,0 +1,237 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WarmUpStep caps the daily messages of a sender from its Day-th day of use
+type WarmUpStep struct {
+	Day   int `json:"day"`
+	Limit int `json:"limit"`
+}
+
+// SenderLimits are the token buckets applied to each sender number. Zero disables
+// a bucket. The daily bucket is capped by the warm-up step of the sender age.
+type SenderLimits struct {
+	PerMinute int          `json:"per_minute,omitempty"`
+	PerHour   int          `json:"per_hour,omitempty"`
+	PerDay    int          `json:"per_day,omitempty"`
+	WarmUp    []WarmUpStep `json:"warm_up,omitempty"`
+}
+
+// ParseWarmUp parses a warm-up schedule of "day:limit" steps, such as
+// "1:50,4:150,8:400": 50 messages a day from day 1, 150 from day 4, 400 from day 8.
+// "none" or an empty string disables the warm-up.
+func ParseWarmUp(s string) ([]WarmUpStep, error) {
+	if strings.EqualFold(strings.TrimSpace(s), "none") {
+		return nil, nil
+	}
+	var steps []WarmUpStep
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		day, limit, ok := strings.Cut(part, ":")
+		d, err1 := strconv.Atoi(strings.TrimSpace(day))
+		l, err2 := strconv.Atoi(strings.TrimSpace(limit))
+		if !ok || err1 != nil || err2 != nil || d < 1 || l < 1 {
+			return nil, fmt.Errorf("invalid warm-up step %q (expected day:limit)", part)
+		}
+		steps = append(steps, WarmUpStep{Day: d, Limit: l})
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].Day < steps[j].Day })
+	return steps, nil
+}
+
+// dailyLimit returns the daily limit of a sender used for the first time at since:
+// the limit of its current warm-up step when lower than PerDay
+func (l SenderLimits) dailyLimit(since, now time.Time) int {
+	day := int(now.Sub(since)/(24*time.Hour)) + 1
+	limit := l.PerDay
+	for _, step := range l.WarmUp {
+		if step.Day > day {
+			break
+		}
+		if l.PerDay <= 0 || step.Limit < l.PerDay {
+			limit = step.Limit
+		} else {
+			limit = l.PerDay
+		}
+	}
+	return limit
+}
+
+// limits returns the sender limits stamped on the job, fallback when there are none
+func (job *MessageJob) limits(fallback SenderLimits) SenderLimits {
+	if job.Limits != nil {
+		return *job.Limits
+	}
+	return fallback
+}
+
+// bucket is a token bucket of a sender, refilled continuously at capacity per window
+type bucket struct {
+	name     string
+	capacity int
+	window   time.Duration
+}
+
+// buckets returns the enabled buckets of a sender used for the first time at since
+func (l SenderLimits) buckets(since, now time.Time) []bucket {
+	perDay := l.PerDay
+	if len(l.WarmUp) > 0 {
+		perDay = l.dailyLimit(since, now)
+	}
+	var out []bucket
+	for _, b := range []bucket{
+		{"minute", l.PerMinute, time.Minute},
+		{"hour", l.PerHour, time.Hour},
+		{"day", perDay, 24 * time.Hour},
+	} {
+		if b.capacity > 0 {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// refill returns the tokens of the bucket at now, from tokens at last
+func (b bucket) refill(tokens float64, last, now time.Time) float64 {
+	if elapsed := now.Sub(last); elapsed > 0 {
+		tokens += float64(elapsed) * float64(b.capacity) / float64(b.window)
+	}
+	return math.Min(tokens, float64(b.capacity))
+}
+
+// wait returns how long until the bucket holds one token
+func (b bucket) wait(tokens float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - tokens) * float64(b.window) / float64(b.capacity)))
+}
+
+// tokenBucketScript takes one token from every bucket, or none when one of them is
+// empty. Buckets refill continuously at capacity per window.
+// KEYS: buckets. ARGV: now (unix ms), then capacity and window (ms) of each bucket.
+// Returns 0 when the tokens were taken, else the milliseconds to wait.
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local wait = 0
+local tokens = {}
+for i, key in ipairs(KEYS) do
+  local cap = tonumber(ARGV[i * 2])
+  local window = tonumber(ARGV[i * 2 + 1])
+  local rate = cap / window
+  local b = redis.call('HMGET', key, 'tokens', 'ts')
+  local t = tonumber(b[1]) or cap
+  local ts = tonumber(b[2]) or now
+  t = math.min(cap, t + math.max(0, now - ts) * rate)
+  tokens[i] = t
+  if t < 1 then
+    wait = math.max(wait, math.ceil((1 - t) / rate))
+  end
+end
+if wait > 0 then
+  return wait
+end
+for i, key in ipairs(KEYS) do
+  redis.call('HSET', key, 'tokens', tostring(tokens[i] - 1), 'ts', ARGV[1])
+  redis.call('PEXPIRE', key, tonumber(ARGV[i * 2 + 1]) * 2)
+end
+return 0
+`)
+
+// deferScript gives back the lease of a throttled job and delays it, without
+// counting the delivery as an attempt.
+// KEYS: leases, delayed, deliveries, jobs. ARGV: id, score, group prefix.
+var deferScript = redis.NewScript(groupLua + `
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+  return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+redis.call('HINCRBY', KEYS[3], ARGV[1], -1)
+local g = job_group(redis.call('HGET', KEYS[4], ARGV[1]))
+if g then
+  redis.call('ZREM', ARGV[3] .. g .. ':leased', ARGV[1])
+  redis.call('ZADD', ARGV[3] .. g .. ':queued', ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// SetSenderLimits sets the throughput limits of each sender number
+func (q *MessageQueue) SetSenderLimits(l SenderLimits) {
+	q.senderLimits = l
+}
+
+// throttle takes a token for one message of the job sender, with the limits of the
+// job or else of the queue. It returns how long to wait when the sender is over one
+// of its limits.
+func (q *MessageQueue) throttle(ctx context.Context, job *MessageJob, now time.Time) (time.Duration, error) {
+	if job.Sender == "" {
+		return 0, nil
+	}
+	l := job.limits(q.senderLimits)
+	prefix := "fiducia:{messages}:sender:" + job.Sender
+
+	since := now
+	if len(l.WarmUp) > 0 {
+		var err error
+		if since, err = q.senderSince(ctx, prefix, now); err != nil {
+			return 0, err
+		}
+	}
+
+	buckets := l.buckets(since, now)
+	if len(buckets) == 0 {
+		return 0, nil
+	}
+	keys := make([]string, 0, len(buckets))
+	args := []any{now.UnixMilli()}
+	for _, b := range buckets {
+		keys = append(keys, prefix+":"+b.name)
+		args = append(args, b.capacity, b.window.Milliseconds())
+	}
+
+	wait, err := tokenBucketScript.Run(ctx, q.client, keys, args...).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check sender limits: %w", err)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// senderSince returns when a sender number was first used, recording now the
+// first time
+func (q *MessageQueue) senderSince(ctx context.Context, prefix string, now time.Time) (time.Time, error) {
+	key := prefix + ":since"
+	if err := q.client.SetNX(ctx, key, now.Unix(), 0).Err(); err != nil {
+		return now, fmt.Errorf("failed to record sender start: %w", err)
+	}
+	unix, err := q.client.Get(ctx, key).Int64()
+	if err != nil {
+		return now, fmt.Errorf("failed to read sender start: %w", err)
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// deferJob delays a leased job until its sender may send again
+func (q *MessageQueue) deferJob(ctx context.Context, job *MessageJob, until time.Time) error {
+	if err := deferScript.Run(ctx, q.client,
+		[]string{q.leasesKey, q.delayedKey, q.deliveriesKey, q.jobsKey},
+		job.ID, until.UnixMilli(), q.groupPrefix,
+	).Err(); err != nil {
+		return fmt.Errorf("failed to defer job: %w", err)
+	}
+	slog.Debug("message throttled", "job_id", job.ID, "sender", job.Sender, "until", until)
+	return nil
+}
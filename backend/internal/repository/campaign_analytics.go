@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// analyticsBase selects the executions of a campaign enrolled in [$2, $3) with the
+// messages they sent, the replies and the first document received after the first
+// message. Replies and documents are attributed to the last message sent before them.
+const analyticsBase = `
+    WITH ex AS (
+        SELECT ce.id, ce.pending_line_id, ce.status,
+               COALESCE(ce.client_id, pl.client_id) AS client_id,
+               CASE WHEN ce.pending_line_id IS NOT NULL THEN pl.status = 'validated'
+                    ELSE NOT EXISTS (
+                        SELECT 1 FROM campaign_execution_lines cel
+                        JOIN pending_lines p ON p.id = cel.pending_line_id
+                        WHERE cel.execution_id = ce.id AND p.status <> 'validated')
+               END AS validated
+        FROM campaign_executions ce
+        LEFT JOIN pending_lines pl ON pl.id = ce.pending_line_id
+        WHERE ce.campaign_id = $1
+          AND ($2::timestamptz IS NULL OR ce.created_at >= $2)
+          AND ($3::timestamptz IS NULL OR ce.created_at < $3)
+    ),
+    sent AS (
+        SELECT m.campaign_execution_id AS ex_id, m.campaign_step_order AS step_order,
+               COALESCE(m.campaign_channel, 'whatsapp') AS channel,
+               m.campaign_variant AS variant,
+               m.status, m.created_at AS sent_at,
+               (m.delivered_at IS NOT NULL OR m.read_at IS NOT NULL OR m.status IN ('delivered', 'read')) AS delivered,
+               (m.read_at IS NOT NULL OR m.status = 'read') AS read,
+               LEAD(m.created_at) OVER (PARTITION BY m.campaign_execution_id ORDER BY m.created_at) AS next_at
+        FROM messages m
+        JOIN ex ON ex.id = m.campaign_execution_id
+        WHERE m.direction = 'outbound'
+    ),
+    first_sent AS (
+        SELECT ex_id, MIN(sent_at) AS at FROM sent GROUP BY ex_id
+    ),
+    replies AS (
+        SELECT ex.id AS ex_id, r.created_at AS at
+        FROM ex
+        JOIN first_sent f ON f.ex_id = ex.id
+        JOIN messages r ON r.client_id = ex.client_id AND r.direction = 'inbound' AND r.created_at >= f.at
+    ),
+    docs AS (
+        SELECT ex.id AS ex_id, MIN(d.created_at) AS at
+        FROM ex
+        JOIN first_sent f ON f.ex_id = ex.id
+        JOIN documents d ON d.created_at >= f.at
+         AND (d.pending_line_id = ex.pending_line_id
+              OR d.pending_line_id IN (SELECT cel.pending_line_id FROM campaign_execution_lines cel WHERE cel.execution_id = ex.id)
+              OR (d.pending_line_id IS NULL AND d.client_id = ex.client_id))
+        GROUP BY ex.id
+    )
+`
+
+type CampaignAnalyticsRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewCampaignAnalyticsRepository(pool *pgxpool.Pool) *CampaignAnalyticsRepository {
+	return &CampaignAnalyticsRepository{pool: pool}
+}
+
+// Get computes the analytics of a campaign for executions enrolled between from
+// (inclusive) and to (exclusive). Nil bounds are open.
+func (r *CampaignAnalyticsRepository) Get(ctx context.Context, campaignID uuid.UUID, from, to *time.Time) (*models.CampaignAnalytics, error) {
+	a := &models.CampaignAnalytics{CampaignID: campaignID, From: from, To: to}
+
+	if err := r.funnel(ctx, a, from, to); err != nil {
+		return nil, err
+	}
+	steps, err := r.steps(ctx, campaignID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.channels(ctx, campaignID, from, to, steps); err != nil {
+		return nil, err
+	}
+
+	a.Steps = make([]models.StepAnalytics, 0, len(steps))
+	for _, s := range steps {
+		a.Steps = append(a.Steps, *s)
+	}
+	return a, nil
+}
+
+func (r *CampaignAnalyticsRepository) funnel(ctx context.Context, a *models.CampaignAnalytics, from, to *time.Time) error {
+	f := &a.Funnel
+	err := r.pool.QueryRow(ctx, analyticsBase+`
+        SELECT COUNT(*),
+               COUNT(f.ex_id),
+               COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM sent s WHERE s.ex_id = ex.id AND s.delivered)),
+               COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM sent s WHERE s.ex_id = ex.id AND s.read)),
+               COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM replies rp WHERE rp.ex_id = ex.id)),
+               COUNT(d.ex_id),
+               COUNT(*) FILTER (WHERE ex.validated),
+               percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM d.at - f.at) / 3600)
+        FROM ex
+        LEFT JOIN first_sent f ON f.ex_id = ex.id
+        LEFT JOIN docs d ON d.ex_id = ex.id
+    `, a.CampaignID, from, to).Scan(
+		&f.Enrolled, &f.Contacted, &f.Delivered, &f.Read, &f.Replied,
+		&f.DocumentReceived, &f.Validated, &f.MedianTimeToDocumentHours,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to compute campaign funnel: %w", err)
+	}
+	return nil
+}
+
+// steps returns the progression of executions through the steps, by step order.
+// Steps on the notification channel send no message and are not reported.
+func (r *CampaignAnalyticsRepository) steps(ctx context.Context, campaignID uuid.UUID, from, to *time.Time) ([]*models.StepAnalytics, error) {
+	rows, err := r.pool.Query(ctx, analyticsBase+`,
+    last_step AS (
+        SELECT DISTINCT ON (ex_id) ex_id, step_order FROM sent ORDER BY ex_id, sent_at DESC
+    )
+        SELECT s.step_order,
+               COUNT(DISTINCT s.ex_id),
+               COUNT(DISTINCT s.ex_id) FILTER (
+                   WHERE l.step_order = s.step_order AND ex.status IN ('pending', 'running')),
+               COUNT(DISTINCT s.ex_id) FILTER (
+                   WHERE l.step_order = s.step_order AND ex.status NOT IN ('pending', 'running') AND d.ex_id IS NULL)
+        FROM sent s
+        JOIN ex ON ex.id = s.ex_id
+        JOIN last_step l ON l.ex_id = s.ex_id
+        LEFT JOIN docs d ON d.ex_id = s.ex_id
+        WHERE s.step_order IS NOT NULL
+        GROUP BY s.step_order
+        ORDER BY s.step_order
+    `, campaignID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute campaign steps: %w", err)
+	}
+	defer rows.Close()
+
+	var steps []*models.StepAnalytics
+	for rows.Next() {
+		s := &models.StepAnalytics{ByChannel: make([]models.StepChannelAnalytics, 0)}
+		if err := rows.Scan(&s.StepOrder, &s.Reached, &s.Active, &s.DropOff); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign step: %w", err)
+		}
+		steps = append(steps, s)
+	}
+	return steps, rows.Err()
+}
+
+// channels fills the per channel breakdown of each step
+func (r *CampaignAnalyticsRepository) channels(ctx context.Context, campaignID uuid.UUID, from, to *time.Time, steps []*models.StepAnalytics) error {
+	rows, err := r.pool.Query(ctx, analyticsBase+`
+        SELECT s.step_order, s.channel,
+               COUNT(*),
+               COUNT(*) FILTER (WHERE s.delivered),
+               COUNT(*) FILTER (WHERE s.read),
+               COUNT(*) FILTER (WHERE s.status = 'failed'),
+               COUNT(*) FILTER (WHERE EXISTS (
+                   SELECT 1 FROM replies rp
+                   WHERE rp.ex_id = s.ex_id AND rp.at >= s.sent_at AND (s.next_at IS NULL OR rp.at < s.next_at))),
+               COUNT(*) FILTER (WHERE d.at >= s.sent_at AND (s.next_at IS NULL OR d.at < s.next_at)),
+               percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM d.at - s.sent_at) / 3600)
+                   FILTER (WHERE d.at >= s.sent_at AND (s.next_at IS NULL OR d.at < s.next_at))
+        FROM sent s
+        LEFT JOIN docs d ON d.ex_id = s.ex_id
+        WHERE s.step_order IS NOT NULL
+        GROUP BY s.step_order, s.channel
+        ORDER BY s.step_order, s.channel
+    `, campaignID, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to compute campaign channels: %w", err)
+	}
+	defer rows.Close()
+
+	byOrder := make(map[int]*models.StepAnalytics, len(steps))
+	for _, s := range steps {
+		byOrder[s.StepOrder] = s
+	}
+	for rows.Next() {
+		var order int
+		var c models.StepChannelAnalytics
+		if err := rows.Scan(
+			&order, &c.Channel, &c.Sent, &c.Delivered, &c.Read, &c.Failed,
+			&c.Replied, &c.DocumentReceived, &c.MedianTimeToDocumentHours,
+		); err != nil {
+			return fmt.Errorf("failed to scan campaign channel: %w", err)
+		}
+		if s, ok := byOrder[order]; ok {
+			s.ByChannel = append(s.ByChannel, c)
+		}
+	}
+	return rows.Err()
+}
+
+// Variants counts the outcomes of the messages of each step variant, for executions
+// enrolled between from (inclusive) and to (exclusive). Rates are left to the caller.
+func (r *CampaignAnalyticsRepository) Variants(ctx context.Context, campaignID uuid.UUID, from, to *time.Time) ([]models.VariantResult, error) {
+	rows, err := r.pool.Query(ctx, analyticsBase+`
+        SELECT s.step_order, s.variant,
+               COUNT(*),
+               COUNT(*) FILTER (WHERE s.read),
+               COUNT(*) FILTER (WHERE EXISTS (
+                   SELECT 1 FROM replies rp
+                   WHERE rp.ex_id = s.ex_id AND rp.at >= s.sent_at AND (s.next_at IS NULL OR rp.at < s.next_at))),
+               COUNT(*) FILTER (WHERE d.at >= s.sent_at AND (s.next_at IS NULL OR d.at < s.next_at)),
+               percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM d.at - s.sent_at) / 3600)
+                   FILTER (WHERE d.at >= s.sent_at AND (s.next_at IS NULL OR d.at < s.next_at))
+        FROM sent s
+        LEFT JOIN docs d ON d.ex_id = s.ex_id
+        WHERE s.step_order IS NOT NULL AND s.variant IS NOT NULL
+        GROUP BY s.step_order, s.variant
+        ORDER BY s.step_order, s.variant
+    `, campaignID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute campaign variants: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]models.VariantResult, 0)
+	for rows.Next() {
+		var v models.VariantResult
+		if err := rows.Scan(
+			&v.StepOrder, &v.Variant, &v.Sent, &v.Read, &v.Replied,
+			&v.DocumentReceived, &v.MedianTimeToDocumentHours,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign variant: %w", err)
+		}
+		results = append(results, v)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// cycleRetention is how long the report of a worker that stopped running is kept
+const cycleRetention = 24 * time.Hour
+
+type CampaignCycleRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewCampaignCycleRepository(pool *pgxpool.Pool) *CampaignCycleRepository {
+	return &CampaignCycleRepository{pool: pool}
+}
+
+// Record stores the last cycle of a worker and prunes reports of stale workers
+func (r *CampaignCycleRepository) Record(ctx context.Context, c *models.CampaignCycle) error {
+	_, err := r.pool.Exec(ctx, `
+        INSERT INTO campaign_cycles (
+            worker_id, leader, started_at, finished_at, duration_ms,
+            enrolled, stopped, claimed, executed, completed, rescheduled, failed, error
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+        ON CONFLICT (worker_id) DO UPDATE SET
+            leader = EXCLUDED.leader, started_at = EXCLUDED.started_at, finished_at = EXCLUDED.finished_at,
+            duration_ms = EXCLUDED.duration_ms, enrolled = EXCLUDED.enrolled, stopped = EXCLUDED.stopped,
+            claimed = EXCLUDED.claimed, executed = EXCLUDED.executed, completed = EXCLUDED.completed,
+            rescheduled = EXCLUDED.rescheduled, failed = EXCLUDED.failed, error = EXCLUDED.error
+    `, c.WorkerID, c.Leader, c.StartedAt, c.FinishedAt, c.DurationMs,
+		c.Enrolled, c.Stopped, c.Claimed, c.Executed, c.Completed, c.Rescheduled, c.Failed, c.Error)
+	if err != nil {
+		return fmt.Errorf("failed to record campaign cycle: %w", err)
+	}
+
+	_, err = r.pool.Exec(ctx, `DELETE FROM campaign_cycles WHERE finished_at < $1`, time.Now().Add(-cycleRetention))
+	if err != nil {
+		return fmt.Errorf("failed to prune campaign cycles: %w", err)
+	}
+	return nil
+}
+
+// List returns the last cycle of every worker seen recently, most recent first
+func (r *CampaignCycleRepository) List(ctx context.Context) ([]models.CampaignCycle, error) {
+	rows, err := r.pool.Query(ctx, `
+        SELECT worker_id, leader, started_at, finished_at, duration_ms,
+               enrolled, stopped, claimed, executed, completed, rescheduled, failed, error
+        FROM campaign_cycles
+        ORDER BY finished_at DESC
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaign cycles: %w", err)
+	}
+	defer rows.Close()
+
+	cycles := make([]models.CampaignCycle, 0)
+	for rows.Next() {
+		var c models.CampaignCycle
+		if err := rows.Scan(&c.WorkerID, &c.Leader, &c.StartedAt, &c.FinishedAt, &c.DurationMs,
+			&c.Enrolled, &c.Stopped, &c.Claimed, &c.Executed, &c.Completed, &c.Rescheduled, &c.Failed, &c.Error); err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, c)
+	}
+	return cycles, rows.Err()
+}
@@ -2,14 +2,62 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/fiducia/backend/internal/models"
 )
 
+// ErrAlreadyEnrolled is returned when a line already has an execution for the campaign
+var ErrAlreadyEnrolled = errors.New("line already enrolled in campaign")
+
+// ErrExecutionLeased is returned when an execution is being processed by a worker
+var ErrExecutionLeased = errors.New("execution is being processed")
+
+// ErrLeaseLost is returned when a worker saves an execution whose lease expired and
+// was claimed by another worker
+var ErrLeaseLost = errors.New("execution lease lost")
+
+// ExecutionFilter selects executions; ClientID matches client executions and the
+// executions of the client's lines
+type ExecutionFilter struct {
+	CabinetID     uuid.UUID
+	CampaignID    *uuid.UUID
+	PendingLineID *uuid.UUID
+	ClientID      *uuid.UUID
+	Status        *models.ExecutionStatus
+	Limit         int
+	Offset        int
+}
+
+const executionColumns = `
+    ce.id, ce.campaign_id, ce.campaign_version, ce.pending_line_id, ce.client_id, ce.current_step_order,
+    ce.status, ce.stop_reason, ce.last_step_executed_at, ce.next_step_scheduled_at,
+    ce.paused_until, ce.locked_by, ce.locked_until, ce.created_at, ce.updated_at,
+    pl.status, c.name`
+
+func scanExecution(row pgx.Row) (*models.CampaignExecution, error) {
+	var ex models.CampaignExecution
+	err := row.Scan(
+		&ex.ID, &ex.CampaignID, &ex.CampaignVersion, &ex.PendingLineID, &ex.ClientID, &ex.CurrentStepOrder,
+		&ex.Status, &ex.StopReason, &ex.LastStepExecutedAt, &ex.NextStepScheduledAt,
+		&ex.PausedUntil, &ex.LockedBy, &ex.LockedUntil, &ex.CreatedAt, &ex.UpdatedAt,
+		&ex.LineStatus, &ex.CampaignName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ex, nil
+}
+
 type CampaignExecutionRepository struct {
 	pool *pgxpool.Pool
 }
@@ -26,38 +74,398 @@ func (r *CampaignExecutionRepository) Create(ctx context.Context, ex *models.Cam
 	ex.CreatedAt = time.Now()
 	ex.UpdatedAt = time.Now()
 
-	_, err := r.pool.Exec(ctx, `
+	res, err := r.pool.Exec(ctx, `
         INSERT INTO campaign_executions (
-            id, campaign_id, pending_line_id, current_step_order, 
-            status, stop_reason, last_step_executed_at, next_step_scheduled_at, 
+            id, campaign_id, campaign_version, pending_line_id, client_id, current_step_order,
+            status, stop_reason, last_step_executed_at, next_step_scheduled_at,
             created_at, updated_at
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-    `, ex.ID, ex.CampaignID, ex.PendingLineID, ex.CurrentStepOrder,
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+        ON CONFLICT DO NOTHING
+    `, ex.ID, ex.CampaignID, ex.CampaignVersion, ex.PendingLineID, ex.ClientID, ex.CurrentStepOrder,
 		ex.Status, ex.StopReason, ex.LastStepExecutedAt, ex.NextStepScheduledAt,
 		ex.CreatedAt, ex.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrAlreadyEnrolled
+	}
 
-	return err
+	return nil
 }
 
-// Update updates the execution state
-func (r *CampaignExecutionRepository) Update(ctx context.Context, ex *models.CampaignExecution) error {
+// Update updates the execution state and releases the lease of workerID. It fails
+// with ErrLeaseLost if another worker has claimed the execution since.
+func (r *CampaignExecutionRepository) Update(ctx context.Context, ex *models.CampaignExecution, workerID string) error {
 	ex.UpdatedAt = time.Now()
-	_, err := r.pool.Exec(ctx, `
+	res, err := r.pool.Exec(ctx, `
         UPDATE campaign_executions SET 
             current_step_order=$2, status=$3, stop_reason=$4, 
-            last_step_executed_at=$5, next_step_scheduled_at=$6, updated_at=$7
-        WHERE id=$1
+            last_step_executed_at=$5, next_step_scheduled_at=$6, updated_at=$7,
+            paused_until=$8, locked_by=NULL, locked_until=NULL
+        WHERE id=$1 AND locked_by=$9
     `, ex.ID, ex.CurrentStepOrder, ex.Status, ex.StopReason,
-		ex.LastStepExecutedAt, ex.NextStepScheduledAt, ex.UpdatedAt)
+		ex.LastStepExecutedAt, ex.NextStepScheduledAt, ex.UpdatedAt, ex.PausedUntil, workerID)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	ex.LockedBy = nil
+	ex.LockedUntil = nil
+	return nil
+}
+
+// ClaimDue leases up to limit executions whose next step is due, together with
+// the current status of their pending line (line executions only).
+// Rows locked by another transaction or leased by another worker are skipped,
+// so concurrent workers never receive the same execution.
+func (r *CampaignExecutionRepository) ClaimDue(ctx context.Context, workerID string, limit int, lease time.Duration) ([]models.CampaignExecution, error) {
+	rows, err := r.pool.Query(ctx, `
+        UPDATE campaign_executions ce SET
+            locked_by = $1,
+            locked_until = NOW() + make_interval(secs => $2)
+        WHERE ce.id IN (
+            SELECT id FROM campaign_executions
+            WHERE status IN ('pending', 'running')
+              AND next_step_scheduled_at <= NOW()
+              AND (locked_until IS NULL OR locked_until < NOW())
+            ORDER BY next_step_scheduled_at ASC
+            LIMIT $3
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING ce.id, ce.campaign_id, ce.campaign_version, ce.pending_line_id, ce.client_id, ce.current_step_order,
+               ce.status, ce.stop_reason, ce.last_step_executed_at, ce.next_step_scheduled_at,
+               ce.paused_until, ce.locked_by, ce.locked_until, ce.created_at, ce.updated_at,
+               (SELECT pl.status FROM pending_lines pl WHERE pl.id = ce.pending_line_id)
+    `, workerID, lease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.CampaignExecution
+	for rows.Next() {
+		var ex models.CampaignExecution
+		err := rows.Scan(
+			&ex.ID, &ex.CampaignID, &ex.CampaignVersion, &ex.PendingLineID, &ex.ClientID, &ex.CurrentStepOrder,
+			&ex.Status, &ex.StopReason, &ex.LastStepExecutedAt, &ex.NextStepScheduledAt,
+			&ex.PausedUntil, &ex.LockedBy, &ex.LockedUntil, &ex.CreatedAt, &ex.UpdatedAt, &ex.LineStatus,
+		)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ex)
+	}
+	return list, rows.Err()
+}
+
+// StopResolved stops, in bulk, every active execution whose pending line was validated,
+// rejected or has received a document. Resolved lines are removed from client executions,
+// which stop once they have no open line left. Leased rows are left to their worker.
+func (r *CampaignExecutionRepository) StopResolved(ctx context.Context) (int64, error) {
+	res, err := r.pool.Exec(ctx, `
+        UPDATE campaign_executions ce SET
+            status = 'stopped',
+            stop_reason = CASE pl.status
+                WHEN 'validated' THEN 'manual_validaton'
+                WHEN 'rejected' THEN 'client_refusal'
+                ELSE 'ocr_validated'
+            END,
+            next_step_scheduled_at = NULL,
+            updated_at = NOW()
+        FROM pending_lines pl
+        WHERE pl.id = ce.pending_line_id
+          AND ce.status IN ('pending', 'running', 'paused')
+          AND pl.status IN ('validated', 'rejected', 'received')
+          AND (ce.locked_until IS NULL OR ce.locked_until < NOW())
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stop resolved executions: %w", err)
+	}
+	stopped := res.RowsAffected()
+
+	_, err = r.pool.Exec(ctx, `
+        UPDATE campaign_execution_lines cel SET
+            resolved_at = NOW(),
+            resolution = pl.status
+        FROM pending_lines pl
+        WHERE pl.id = cel.pending_line_id
+          AND cel.resolved_at IS NULL
+          AND pl.status IN ('validated', 'rejected', 'received')
+    `)
+	if err != nil {
+		return stopped, fmt.Errorf("failed to resolve execution lines: %w", err)
+	}
+
+	res, err = r.pool.Exec(ctx, `
+        UPDATE campaign_executions ce SET
+            status = 'stopped',
+            stop_reason = 'lines_resolved',
+            next_step_scheduled_at = NULL,
+            updated_at = NOW()
+        WHERE ce.client_id IS NOT NULL
+          AND ce.status IN ('pending', 'running', 'paused')
+          AND (ce.locked_until IS NULL OR ce.locked_until < NOW())
+          AND NOT EXISTS (
+            SELECT 1 FROM campaign_execution_lines cel
+            WHERE cel.execution_id = ce.id AND cel.resolved_at IS NULL
+          )
+    `)
+	if err != nil {
+		return stopped, fmt.Errorf("failed to stop resolved client executions: %w", err)
+	}
+	return stopped + res.RowsAffected(), nil
+}
+
+// GetByID returns an execution with its campaign name, or nil if it does not exist
+func (r *CampaignExecutionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.CampaignExecution, error) {
+	ex, err := scanExecution(r.pool.QueryRow(ctx, `
+        SELECT `+executionColumns+`
+        FROM campaign_executions ce
+        JOIN campaigns c ON c.id = ce.campaign_id
+        LEFT JOIN pending_lines pl ON pl.id = ce.pending_line_id
+        WHERE ce.id = $1
+    `, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign execution: %w", err)
+	}
+	return ex, nil
+}
+
+// List returns the executions of a cabinet matching filter, most recent first
+func (r *CampaignExecutionRepository) List(ctx context.Context, filter ExecutionFilter) ([]models.CampaignExecution, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+
+	query := `
+        SELECT ` + executionColumns + `
+        FROM campaign_executions ce
+        JOIN campaigns c ON c.id = ce.campaign_id
+        LEFT JOIN pending_lines pl ON pl.id = ce.pending_line_id
+        WHERE c.cabinet_id = $1`
+	args := []any{filter.CabinetID}
+
+	if filter.CampaignID != nil {
+		args = append(args, *filter.CampaignID)
+		query += fmt.Sprintf(" AND ce.campaign_id = $%d", len(args))
+	}
+	if filter.PendingLineID != nil {
+		args = append(args, *filter.PendingLineID)
+		query += fmt.Sprintf(` AND (ce.pending_line_id = $%d OR EXISTS (
+            SELECT 1 FROM campaign_execution_lines cel
+            WHERE cel.execution_id = ce.id AND cel.pending_line_id = $%d))`, len(args), len(args))
+	}
+	if filter.ClientID != nil {
+		args = append(args, *filter.ClientID)
+		query += fmt.Sprintf(" AND (ce.client_id = $%d OR pl.client_id = $%d)", len(args), len(args))
+	}
+	if filter.Status != nil {
+		args = append(args, *filter.Status)
+		query += fmt.Sprintf(" AND ce.status = $%d", len(args))
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY ce.created_at DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaign executions: %w", err)
+	}
+	defer rows.Close()
+
+	list := make([]models.CampaignExecution, 0)
+	for rows.Next() {
+		ex, err := scanExecution(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan campaign execution: %w", err)
+		}
+		list = append(list, *ex)
+	}
+	return list, rows.Err()
+}
+
+// ApplyControl saves a manual change of an execution together with its audit event.
+// It fails with ErrExecutionLeased if a worker is processing the execution, and with
+// ErrAlreadyEnrolled if restarting it would give a client two active executions.
+func (r *CampaignExecutionRepository) ApplyControl(ctx context.Context, ex *models.CampaignExecution, event *models.CampaignExecutionEvent) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ex.UpdatedAt = time.Now()
+	res, err := tx.Exec(ctx, `
+        UPDATE campaign_executions SET
+            current_step_order=$2, status=$3, stop_reason=$4,
+            last_step_executed_at=$5, next_step_scheduled_at=$6, paused_until=$7, updated_at=$8
+        WHERE id=$1 AND (locked_until IS NULL OR locked_until < NOW())
+    `, ex.ID, ex.CurrentStepOrder, ex.Status, ex.StopReason,
+		ex.LastStepExecutedAt, ex.NextStepScheduledAt, ex.PausedUntil, ex.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrAlreadyEnrolled
+		}
+		return fmt.Errorf("failed to update campaign execution: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrExecutionLeased
+	}
+
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	event.ExecutionID = ex.ID
+	event.CreatedAt = time.Now()
+	_, err = tx.Exec(ctx, `
+        INSERT INTO campaign_execution_events (
+            id, execution_id, action, reason, actor_id,
+            from_status, to_status, from_step, to_step, created_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    `, event.ID, event.ExecutionID, event.Action, event.Reason, event.ActorID,
+		event.FromStatus, event.ToStatus, event.FromStep, event.ToStep, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record execution event: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListEvents returns the manual actions on an execution, oldest first
+func (r *CampaignExecutionRepository) ListEvents(ctx context.Context, executionID uuid.UUID) ([]models.CampaignExecutionEvent, error) {
+	rows, err := r.pool.Query(ctx, `
+        SELECT e.id, e.execution_id, e.action, e.reason, e.actor_id,
+               e.from_status, e.to_status, e.from_step, e.to_step, e.created_at, u.full_name
+        FROM campaign_execution_events e
+        LEFT JOIN users u ON u.id = e.actor_id
+        WHERE e.execution_id = $1
+        ORDER BY e.created_at
+    `, executionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list execution events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]models.CampaignExecutionEvent, 0)
+	for rows.Next() {
+		var e models.CampaignExecutionEvent
+		if err := rows.Scan(
+			&e.ID, &e.ExecutionID, &e.Action, &e.Reason, &e.ActorID,
+			&e.FromStatus, &e.ToStatus, &e.FromStep, &e.ToStep, &e.CreatedAt, &e.ActorName,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan execution event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ResumeExpiredPauses resumes, in one statement, paused executions whose pause date
+// has passed and records the event. Executions paused without a date stay paused.
+func (r *CampaignExecutionRepository) ResumeExpiredPauses(ctx context.Context) (int64, error) {
+	res, err := r.pool.Exec(ctx, `
+        WITH resumed AS (
+            UPDATE campaign_executions SET
+                status = CASE WHEN current_step_order = 0 THEN 'pending' ELSE 'running' END,
+                paused_until = NULL,
+                next_step_scheduled_at = COALESCE(next_step_scheduled_at, NOW()),
+                updated_at = NOW()
+            WHERE status = 'paused' AND paused_until <= NOW()
+            RETURNING id, status, current_step_order
+        )
+        INSERT INTO campaign_execution_events (
+            id, execution_id, action, reason, from_status, to_status, from_step, to_step, created_at
+        )
+        SELECT uuid_generate_v4(), id, 'resume', 'Fin de la pause', 'paused', status,
+               current_step_order, current_step_order, NOW()
+        FROM resumed
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resume paused executions: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// MigrateVersion moves the active executions of a campaign running fromVersion (any
+// other version when nil) to toVersion. stepMapping renumbers the last executed step
+// (old order -> new order); unmapped steps keep their order. Each move is recorded as
+// an event. Executions leased by a worker are left as is and counted as skipped.
+func (r *CampaignExecutionRepository) MigrateVersion(ctx context.Context, campaignID uuid.UUID, fromVersion *int, toVersion int, stepMapping map[int]int, actorID *uuid.UUID, reason *string) (migrated, skipped int64, err error) {
+	mapping := make(map[string]int, len(stepMapping))
+	for from, to := range stepMapping {
+		mapping[strconv.Itoa(from)] = to
+	}
+	mappingJSON, err := json.Marshal(mapping)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res, err := r.pool.Exec(ctx, `
+        WITH moved AS (
+            UPDATE campaign_executions ce SET
+                campaign_version = $2,
+                current_step_order = COALESCE(($4::jsonb ->> old.current_step_order::text)::int, old.current_step_order),
+                updated_at = NOW()
+            FROM (
+                SELECT id, current_step_order FROM campaign_executions
+                WHERE campaign_id = $1
+                  AND status IN ('pending', 'running', 'paused')
+                  AND campaign_version <> $2
+                  AND ($3::int IS NULL OR campaign_version = $3)
+                  AND (locked_until IS NULL OR locked_until < NOW())
+                FOR UPDATE SKIP LOCKED
+            ) old
+            WHERE ce.id = old.id
+            RETURNING ce.id, ce.status, old.current_step_order AS from_step, ce.current_step_order AS to_step
+        )
+        INSERT INTO campaign_execution_events (
+            id, execution_id, action, reason, actor_id, from_status, to_status, from_step, to_step, created_at
+        )
+        SELECT uuid_generate_v4(), id, 'migrate', $5, $6, status, status, from_step, to_step, NOW()
+        FROM moved
+    `, campaignID, toVersion, fromVersion, string(mappingJSON), reason, actorID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to migrate executions: %w", err)
+	}
+	migrated = res.RowsAffected()
+
+	err = r.pool.QueryRow(ctx, `
+        SELECT COUNT(*) FROM campaign_executions
+        WHERE campaign_id = $1
+          AND status IN ('pending', 'running', 'paused')
+          AND campaign_version <> $2
+          AND ($3::int IS NULL OR campaign_version = $3)
+    `, campaignID, toVersion, fromVersion).Scan(&skipped)
+	if err != nil {
+		return migrated, 0, fmt.Errorf("failed to count executions not migrated: %w", err)
+	}
+	return migrated, skipped, nil
+}
+
+// Release drops a lease when processing failed and pushes the step back to retryAt,
+// so that the execution is not claimed again at once
+func (r *CampaignExecutionRepository) Release(ctx context.Context, id uuid.UUID, workerID string, retryAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+        UPDATE campaign_executions
+        SET locked_by = NULL, locked_until = NULL, next_step_scheduled_at = $3
+        WHERE id = $1 AND locked_by = $2
+    `, id, workerID, retryAt)
 	return err
 }
 
 // FindActive returns all executions that are running or pending
 func (r *CampaignExecutionRepository) FindActive(ctx context.Context) ([]models.CampaignExecution, error) {
 	rows, err := r.pool.Query(ctx, `
-        SELECT id, campaign_id, pending_line_id, current_step_order, 
+        SELECT id, campaign_id, campaign_version, pending_line_id, client_id, current_step_order,
                status, stop_reason, last_step_executed_at, next_step_scheduled_at, 
-               created_at, updated_at
+               paused_until, locked_by, locked_until, created_at, updated_at
         FROM campaign_executions 
         WHERE status IN ('pending', 'running')
     `)
@@ -70,9 +478,9 @@ func (r *CampaignExecutionRepository) FindActive(ctx context.Context) ([]models.
 	for rows.Next() {
 		var ex models.CampaignExecution
 		err := rows.Scan(
-			&ex.ID, &ex.CampaignID, &ex.PendingLineID, &ex.CurrentStepOrder,
+			&ex.ID, &ex.CampaignID, &ex.CampaignVersion, &ex.PendingLineID, &ex.ClientID, &ex.CurrentStepOrder,
 			&ex.Status, &ex.StopReason, &ex.LastStepExecutedAt, &ex.NextStepScheduledAt,
-			&ex.CreatedAt, &ex.UpdatedAt,
+			&ex.PausedUntil, &ex.LockedBy, &ex.LockedUntil, &ex.CreatedAt, &ex.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -82,17 +490,136 @@ func (r *CampaignExecutionRepository) FindActive(ctx context.Context) ([]models.
 	return list, nil
 }
 
+// EnrollPendingLines creates, in one statement, an execution for every pending line of
+// the cabinet not yet enrolled in the campaign that the campaign targets and that is
+// not claimed by another campaign (see lineAvailable). It returns the number of lines enrolled.
+func (r *CampaignExecutionRepository) EnrollPendingLines(ctx context.Context, campaignID uuid.UUID, cabinetID uuid.UUID, firstStepAt time.Time) (int64, error) {
+	res, err := r.pool.Exec(ctx, `
+        INSERT INTO campaign_executions (
+            id, campaign_id, campaign_version, pending_line_id, current_step_order,
+            status, next_step_scheduled_at, created_at, updated_at
+        )
+        SELECT uuid_generate_v4(), $1, c.version, pl.id, 0, 'pending', $3, NOW(), NOW()
+        FROM pending_lines pl
+        JOIN campaigns c ON c.id = $1
+        WHERE pl.cabinet_id = $2
+          AND pl.status = 'pending'
+          AND `+lineAvailable+`
+        ON CONFLICT (campaign_id, pending_line_id) WHERE stop_reason IS DISTINCT FROM 'duplicate' DO NOTHING
+    `, campaignID, cabinetID, firstStepAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enroll lines: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// EnrollClientLines enrolls the pending lines of the cabinet not yet in the campaign that
+// are available to it (see lineAvailable), grouped by client: clients without an active
+// execution get one, and their lines are attached to it. Lines without a client cannot
+// be relanced and are left out.
+// It returns the number of lines enrolled.
+func (r *CampaignExecutionRepository) EnrollClientLines(ctx context.Context, campaignID uuid.UUID, cabinetID uuid.UUID, firstStepAt time.Time) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+        INSERT INTO campaign_executions (
+            id, campaign_id, campaign_version, client_id, current_step_order,
+            status, next_step_scheduled_at, created_at, updated_at
+        )
+        SELECT uuid_generate_v4(), $1, (SELECT version FROM campaigns WHERE id = $1), e.client_id, 0, 'pending', $3, NOW(), NOW()
+        FROM (
+            SELECT DISTINCT pl.client_id
+            FROM pending_lines pl
+            JOIN campaigns c ON c.id = $1
+            WHERE pl.cabinet_id = $2
+              AND pl.status = 'pending'
+              AND pl.client_id IS NOT NULL
+              AND NOT EXISTS (
+                SELECT 1 FROM campaign_execution_lines cel
+                WHERE cel.campaign_id = $1 AND cel.pending_line_id = pl.id
+              )
+              AND `+lineAvailable+`
+        ) e
+        ON CONFLICT (campaign_id, client_id) WHERE client_id IS NOT NULL AND status IN ('pending', 'running', 'paused')
+        DO NOTHING
+    `, campaignID, cabinetID, firstStepAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create client executions: %w", err)
+	}
+
+	res, err := tx.Exec(ctx, `
+        INSERT INTO campaign_execution_lines (execution_id, campaign_id, pending_line_id, added_at)
+        SELECT ce.id, $1, pl.id, NOW()
+        FROM pending_lines pl
+        JOIN campaigns c ON c.id = $1
+        JOIN campaign_executions ce ON ce.campaign_id = $1
+         AND ce.client_id = pl.client_id
+         AND ce.status IN ('pending', 'running', 'paused')
+        WHERE pl.cabinet_id = $2
+          AND pl.status = 'pending'
+          AND `+lineAvailable+`
+        ON CONFLICT (campaign_id, pending_line_id) DO NOTHING
+    `, campaignID, cabinetID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach lines to client executions: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// OpenLineIDs returns, for each client execution, the lines not resolved yet in the
+// order they were enrolled
+func (r *CampaignExecutionRepository) OpenLineIDs(ctx context.Context, executionIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	result := make(map[uuid.UUID][]uuid.UUID)
+	if len(executionIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := r.pool.Query(ctx, `
+        SELECT execution_id, pending_line_id
+        FROM campaign_execution_lines
+        WHERE execution_id = ANY($1) AND resolved_at IS NULL
+        ORDER BY added_at, pending_line_id
+    `, executionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get execution lines: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var exID, lineID uuid.UUID
+		if err := rows.Scan(&exID, &lineID); err != nil {
+			return nil, err
+		}
+		result[exID] = append(result[exID], lineID)
+	}
+	return result, rows.Err()
+}
+
 // FindUnenrolledLines finds pending lines that match the trigger but are NOT yet in campaign_executions
-// Simplified for MVP: finds all 'pending' lines not in executions table for this campaign
+// Only lines the campaign targets and that no other campaign claims are returned (see lineAvailable)
 func (r *CampaignExecutionRepository) FindUnenrolledLines(ctx context.Context, campaignID uuid.UUID, cabinetID uuid.UUID) ([]uuid.UUID, error) {
 	// join with pending_lines to filter by cabinet_id and status=pending
 	rows, err := r.pool.Query(ctx, `
         SELECT pl.id
         FROM pending_lines pl
+        JOIN campaigns c ON c.id = $1
         LEFT JOIN campaign_executions ce ON pl.id = ce.pending_line_id AND ce.campaign_id = $1
         WHERE pl.cabinet_id = $2 
           AND pl.status = 'pending' 
           AND ce.id IS NULL
+          AND NOT EXISTS (
+            SELECT 1 FROM campaign_execution_lines cel
+            WHERE cel.campaign_id = $1 AND cel.pending_line_id = pl.id
+          )
+          AND `+lineAvailable+`
     `, campaignID, cabinetID)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,48 @@
+package repository
+
+import "fmt"
+
+// targetingMatch returns a condition true when the pending line pl matches the
+// targeting of the campaign aliased as alias. A campaign without targeting matches
+// every line.
+func targetingMatch(alias string) string {
+	return fmt.Sprintf(`(%[1]s.targeting IS NULL OR (
+        (%[1]s.targeting->>'min_amount' IS NULL OR ABS(pl.amount) >= (%[1]s.targeting->>'min_amount')::numeric)
+        AND (%[1]s.targeting->>'max_amount' IS NULL OR ABS(pl.amount) <= (%[1]s.targeting->>'max_amount')::numeric)
+        AND (jsonb_array_length(COALESCE(%[1]s.targeting->'account_prefixes', '[]')) = 0 OR EXISTS (
+            SELECT 1 FROM jsonb_array_elements_text(%[1]s.targeting->'account_prefixes') p(prefix)
+            WHERE left(pl.account_number, length(p.prefix)) = p.prefix))
+        AND (jsonb_array_length(COALESCE(%[1]s.targeting->'client_tags', '[]')) = 0 OR EXISTS (
+            SELECT 1 FROM clients cl, unnest(cl.tags) t(tag), jsonb_array_elements_text(%[1]s.targeting->'client_tags') w(tag)
+            WHERE cl.id = pl.client_id AND lower(t.tag) = lower(w.tag)))
+        AND (jsonb_array_length(COALESCE(%[1]s.targeting->'assigned_to', '[]')) = 0
+            OR pl.assigned_to::text IN (SELECT jsonb_array_elements_text(%[1]s.targeting->'assigned_to')))
+        AND (COALESCE((%[1]s.targeting->>'min_age_days')::int, 0) = 0
+            OR pl.transaction_date <= CURRENT_DATE - (%[1]s.targeting->>'min_age_days')::int)
+    ))`, alias)
+}
+
+// lineAvailable is true when the pending line pl can be enrolled in the campaign c:
+// it matches its targeting, is not in an active execution of another campaign, and no
+// active on_pending campaign of the cabinet with precedence over c targets it.
+// Precedence is the highest priority, then the oldest campaign, so conflicts always
+// resolve the same way. A line targeted by a campaign with precedence is left to it,
+// even once its sequence there is over.
+var lineAvailable = targetingMatch("c") + `
+    AND NOT EXISTS (
+        SELECT 1 FROM campaign_executions ox
+        WHERE ox.campaign_id <> c.id
+          AND ox.status IN ('pending', 'running', 'paused')
+          AND (ox.pending_line_id = pl.id OR EXISTS (
+              SELECT 1 FROM campaign_execution_lines ol
+              WHERE ol.execution_id = ox.id AND ol.pending_line_id = pl.id AND ol.resolved_at IS NULL))
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM campaigns oc
+        WHERE oc.cabinet_id = pl.cabinet_id
+          AND oc.id <> c.id
+          AND oc.is_active AND oc.trigger_type = 'on_pending'
+          AND (oc.priority > c.priority OR (oc.priority = c.priority AND (oc.created_at, oc.id) < (c.created_at, c.id)))
+          AND (oc.group_by <> 'client' OR pl.client_id IS NOT NULL)
+          AND ` + targetingMatch("oc") + `
+    )`
@@ -1,8 +1,11 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -33,12 +36,13 @@ func (r *CampaignRepository) Create(ctx context.Context, c *models.Campaign) err
 	}
 	c.CreatedAt = time.Now()
 	c.UpdatedAt = time.Now()
+	c.Version = 1
 
 	// Insert Campaign
 	_, err = tx.Exec(ctx, `
-        INSERT INTO campaigns (id, cabinet_id, name, trigger_type, is_active, quiet_hours_enabled, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-    `, c.ID, c.CabinetID, c.Name, c.TriggerType, c.IsActive, c.QuietHoursEnabled, c.CreatedAt, c.UpdatedAt)
+        INSERT INTO campaigns (id, cabinet_id, name, trigger_type, group_by, priority, targeting, version, is_active, quiet_hours_enabled, escalate_on_exhaustion, escalate_after_hours, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+    `, c.ID, c.CabinetID, c.Name, c.TriggerType, c.GroupBy, c.Priority, c.Targeting, c.Version, c.IsActive, c.QuietHoursEnabled, c.EscalateOnExhaustion, c.EscalateAfterHours, c.CreatedAt, c.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to insert campaign: %w", err)
 	}
@@ -53,14 +57,18 @@ func (r *CampaignRepository) Create(ctx context.Context, c *models.Campaign) err
 		s.CreatedAt = time.Now()
 
 		_, err = tx.Exec(ctx, `
-            INSERT INTO campaign_steps (id, campaign_id, step_order, delay_hours, channel, template_id, config, created_at)
-            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-        `, s.ID, s.CampaignID, s.StepOrder, s.DelayHours, s.Channel, s.TemplateID, s.Config, s.CreatedAt)
+            INSERT INTO campaign_steps (id, campaign_id, step_order, delay_hours, channel, template_id, config, rules, variants, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        `, s.ID, s.CampaignID, s.StepOrder, s.DelayHours, s.Channel, s.TemplateID, s.Config, stepRules(s.Rules), stepVariants(s.Variants), s.CreatedAt)
 		if err != nil {
 			return fmt.Errorf("failed to insert step %d: %w", i, err)
 		}
 	}
 
+	if err := insertVersion(ctx, tx, c.ID, c.Version, c.Steps); err != nil {
+		return err
+	}
+
 	return tx.Commit(ctx)
 }
 
@@ -68,9 +76,9 @@ func (r *CampaignRepository) Create(ctx context.Context, c *models.Campaign) err
 func (r *CampaignRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Campaign, error) {
 	var c models.Campaign
 	err := r.pool.QueryRow(ctx, `
-        SELECT id, cabinet_id, name, trigger_type, is_active, quiet_hours_enabled, created_at, updated_at
+        SELECT id, cabinet_id, name, trigger_type, group_by, priority, targeting, version, is_active, quiet_hours_enabled, escalate_on_exhaustion, escalate_after_hours, created_at, updated_at
         FROM campaigns WHERE id = $1
-    `, id).Scan(&c.ID, &c.CabinetID, &c.Name, &c.TriggerType, &c.IsActive, &c.QuietHoursEnabled, &c.CreatedAt, &c.UpdatedAt)
+    `, id).Scan(&c.ID, &c.CabinetID, &c.Name, &c.TriggerType, &c.GroupBy, &c.Priority, &c.Targeting, &c.Version, &c.IsActive, &c.QuietHoursEnabled, &c.EscalateOnExhaustion, &c.EscalateAfterHours, &c.CreatedAt, &c.UpdatedAt)
 	if err == pgx.ErrNoRows {
 		return nil, nil // Not found
 	}
@@ -80,7 +88,7 @@ func (r *CampaignRepository) GetByID(ctx context.Context, id uuid.UUID) (*models
 
 	// Get Steps
 	rows, err := r.pool.Query(ctx, `
-        SELECT id, campaign_id, step_order, delay_hours, channel, template_id, config, created_at
+        SELECT id, campaign_id, step_order, delay_hours, channel, template_id, config, rules, variants, created_at
         FROM campaign_steps WHERE campaign_id = $1 ORDER BY step_order ASC
     `, id)
 	if err != nil {
@@ -91,7 +99,7 @@ func (r *CampaignRepository) GetByID(ctx context.Context, id uuid.UUID) (*models
 	c.Steps = make([]models.CampaignStep, 0)
 	for rows.Next() {
 		var s models.CampaignStep
-		if err := rows.Scan(&s.ID, &s.CampaignID, &s.StepOrder, &s.DelayHours, &s.Channel, &s.TemplateID, &s.Config, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.CampaignID, &s.StepOrder, &s.DelayHours, &s.Channel, &s.TemplateID, &s.Config, &s.Rules, &s.Variants, &s.CreatedAt); err != nil {
 			return nil, err
 		}
 		c.Steps = append(c.Steps, s)
@@ -100,10 +108,59 @@ func (r *CampaignRepository) GetByID(ctx context.Context, id uuid.UUID) (*models
 	return &c, nil
 }
 
+// GetByIDs returns campaigns with their steps, loading all of them in two queries
+func (r *CampaignRepository) GetByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.Campaign, error) {
+	campaigns := make(map[uuid.UUID]*models.Campaign, len(ids))
+	if len(ids) == 0 {
+		return campaigns, nil
+	}
+
+	rows, err := r.pool.Query(ctx, `
+        SELECT id, cabinet_id, name, trigger_type, group_by, priority, targeting, version, is_active, quiet_hours_enabled, escalate_on_exhaustion, escalate_after_hours, created_at, updated_at
+        FROM campaigns WHERE id = ANY($1)
+    `, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load campaigns: %w", err)
+	}
+	for rows.Next() {
+		c := &models.Campaign{Steps: make([]models.CampaignStep, 0)}
+		if err := rows.Scan(&c.ID, &c.CabinetID, &c.Name, &c.TriggerType, &c.GroupBy, &c.Priority, &c.Targeting, &c.Version, &c.IsActive, &c.QuietHoursEnabled, &c.EscalateOnExhaustion, &c.EscalateAfterHours, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		campaigns[c.ID] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.pool.Query(ctx, `
+        SELECT id, campaign_id, step_order, delay_hours, channel, template_id, config, rules, variants, created_at
+        FROM campaign_steps WHERE campaign_id = ANY($1) ORDER BY campaign_id, step_order ASC
+    `, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load campaign steps: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.CampaignStep
+		if err := rows.Scan(&s.ID, &s.CampaignID, &s.StepOrder, &s.DelayHours, &s.Channel, &s.TemplateID, &s.Config, &s.Rules, &s.Variants, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		if c, ok := campaigns[s.CampaignID]; ok {
+			c.Steps = append(c.Steps, s)
+		}
+	}
+
+	return campaigns, rows.Err()
+}
+
 // List returns all campaigns for a cabinet
 func (r *CampaignRepository) List(ctx context.Context, cabinetID uuid.UUID) ([]models.Campaign, error) {
 	rows, err := r.pool.Query(ctx, `
-        SELECT id, cabinet_id, name, trigger_type, is_active, quiet_hours_enabled, created_at, updated_at
+        SELECT id, cabinet_id, name, trigger_type, group_by, priority, targeting, version, is_active, quiet_hours_enabled, escalate_on_exhaustion, escalate_after_hours, created_at, updated_at
         FROM campaigns WHERE cabinet_id = $1 ORDER BY created_at DESC
     `, cabinetID)
 	if err != nil {
@@ -114,7 +171,7 @@ func (r *CampaignRepository) List(ctx context.Context, cabinetID uuid.UUID) ([]m
 	var campaigns []models.Campaign
 	for rows.Next() {
 		var c models.Campaign
-		if err := rows.Scan(&c.ID, &c.CabinetID, &c.Name, &c.TriggerType, &c.IsActive, &c.QuietHoursEnabled, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.CabinetID, &c.Name, &c.TriggerType, &c.GroupBy, &c.Priority, &c.Targeting, &c.Version, &c.IsActive, &c.QuietHoursEnabled, &c.EscalateOnExhaustion, &c.EscalateAfterHours, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		campaigns = append(campaigns, c)
@@ -131,11 +188,35 @@ func (r *CampaignRepository) Update(ctx context.Context, c *models.Campaign) err
 	}
 	defer tx.Rollback(ctx)
 
+	// Changed steps make a new version; running executions keep theirs
+	if c.Steps != nil {
+		var version int
+		var stored []models.StepDefinition
+		err := tx.QueryRow(ctx, `
+            SELECT c.version, v.steps
+            FROM campaigns c
+            JOIN campaign_versions v ON v.campaign_id = c.id AND v.version = c.version
+            WHERE c.id = $1
+            FOR UPDATE OF c
+        `, c.ID).Scan(&version, &stored)
+		if err != nil && err != pgx.ErrNoRows {
+			return fmt.Errorf("failed to get campaign version: %w", err)
+		}
+		c.Version = version
+		if err == pgx.ErrNoRows || !sameSteps(stored, c.Steps) {
+			c.Version++
+			if err := insertVersion(ctx, tx, c.ID, c.Version, c.Steps); err != nil {
+				return err
+			}
+		}
+	}
+
 	c.UpdatedAt = time.Now()
 	res, err := tx.Exec(ctx, `
-        UPDATE campaigns SET name=$2, trigger_type=$3, is_active=$4, quiet_hours_enabled=$5, updated_at=$6
+        UPDATE campaigns SET name=$2, trigger_type=$3, is_active=$4, quiet_hours_enabled=$5, escalate_on_exhaustion=$6, escalate_after_hours=$7, updated_at=$8, group_by=$9,
+            version=GREATEST(version, $10), priority=$11, targeting=$12
         WHERE id=$1
-    `, c.ID, c.Name, c.TriggerType, c.IsActive, c.QuietHoursEnabled, c.UpdatedAt)
+    `, c.ID, c.Name, c.TriggerType, c.IsActive, c.QuietHoursEnabled, c.EscalateOnExhaustion, c.EscalateAfterHours, c.UpdatedAt, c.GroupBy, c.Version, c.Priority, c.Targeting)
 	if err != nil {
 		return err
 	}
@@ -157,9 +238,9 @@ func (r *CampaignRepository) Update(ctx context.Context, c *models.Campaign) err
 			}
 			s.CampaignID = c.ID
 			_, err = tx.Exec(ctx, `
-                INSERT INTO campaign_steps (id, campaign_id, step_order, delay_hours, channel, template_id, config, created_at)
-                VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-            `, s.ID, s.CampaignID, s.StepOrder, s.DelayHours, s.Channel, s.TemplateID, s.Config, time.Now())
+                INSERT INTO campaign_steps (id, campaign_id, step_order, delay_hours, channel, template_id, config, rules, variants, created_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+            `, s.ID, s.CampaignID, s.StepOrder, s.DelayHours, s.Channel, s.TemplateID, s.Config, stepRules(s.Rules), stepVariants(s.Variants), time.Now())
 			if err != nil {
 				return err
 			}
@@ -184,8 +265,9 @@ func (r *CampaignRepository) Delete(ctx context.Context, id uuid.UUID) error {
 // ListAllActive returns all active campaigns across all cabinets
 func (r *CampaignRepository) ListAllActive(ctx context.Context) ([]models.Campaign, error) {
 	rows, err := r.pool.Query(ctx, `
-        SELECT id, cabinet_id, name, trigger_type, is_active, quiet_hours_enabled, created_at, updated_at
+        SELECT id, cabinet_id, name, trigger_type, group_by, priority, targeting, version, is_active, quiet_hours_enabled, escalate_on_exhaustion, escalate_after_hours, created_at, updated_at
         FROM campaigns WHERE is_active = true
+        ORDER BY priority DESC, created_at, id
     `)
 	if err != nil {
 		return nil, err
@@ -195,7 +277,7 @@ func (r *CampaignRepository) ListAllActive(ctx context.Context) ([]models.Campai
 	var campaigns []models.Campaign
 	for rows.Next() {
 		var c models.Campaign
-		if err := rows.Scan(&c.ID, &c.CabinetID, &c.Name, &c.TriggerType, &c.IsActive, &c.QuietHoursEnabled, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.CabinetID, &c.Name, &c.TriggerType, &c.GroupBy, &c.Priority, &c.Targeting, &c.Version, &c.IsActive, &c.QuietHoursEnabled, &c.EscalateOnExhaustion, &c.EscalateAfterHours, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		// Also fetch steps? For now, we fetch steps only when processing execution to save memory,
@@ -206,3 +288,125 @@ func (r *CampaignRepository) ListAllActive(ctx context.Context) ([]models.Campai
 	}
 	return campaigns, nil
 }
+
+// GetVersion returns a version of a campaign, or nil if it does not exist
+func (r *CampaignRepository) GetVersion(ctx context.Context, campaignID uuid.UUID, version int) (*models.CampaignVersion, error) {
+	v := models.CampaignVersion{CampaignID: campaignID, Version: version}
+	err := r.pool.QueryRow(ctx, `
+        SELECT v.steps, v.created_at,
+               (SELECT COUNT(*) FROM campaign_executions ce
+                WHERE ce.campaign_id = v.campaign_id AND ce.campaign_version = v.version
+                  AND ce.status IN ('pending', 'running', 'paused'))
+        FROM campaign_versions v
+        WHERE v.campaign_id = $1 AND v.version = $2
+    `, campaignID, version).Scan(&v.Steps, &v.CreatedAt, &v.ActiveExecutions)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign version: %w", err)
+	}
+	return &v, nil
+}
+
+// ListVersions returns the versions of a campaign, most recent first, with the number
+// of active executions still running each of them
+func (r *CampaignRepository) ListVersions(ctx context.Context, campaignID uuid.UUID) ([]models.CampaignVersion, error) {
+	rows, err := r.pool.Query(ctx, `
+        SELECT v.version, v.steps, v.created_at, COUNT(ce.id)
+        FROM campaign_versions v
+        LEFT JOIN campaign_executions ce ON ce.campaign_id = v.campaign_id
+         AND ce.campaign_version = v.version
+         AND ce.status IN ('pending', 'running', 'paused')
+        WHERE v.campaign_id = $1
+        GROUP BY v.version, v.steps, v.created_at
+        ORDER BY v.version DESC
+    `, campaignID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaign versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make([]models.CampaignVersion, 0)
+	for rows.Next() {
+		v := models.CampaignVersion{CampaignID: campaignID}
+		if err := rows.Scan(&v.Version, &v.Steps, &v.CreatedAt, &v.ActiveExecutions); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetVersionSteps returns the steps of campaign versions, keyed by version
+func (r *CampaignRepository) GetVersionSteps(ctx context.Context, campaignID uuid.UUID, versions []int) (map[int][]models.CampaignStep, error) {
+	result := make(map[int][]models.CampaignStep, len(versions))
+	rows, err := r.pool.Query(ctx, `
+        SELECT version, steps FROM campaign_versions
+        WHERE campaign_id = $1 AND version = ANY($2)
+    `, campaignID, versions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load campaign versions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var defs []models.StepDefinition
+		if err := rows.Scan(&version, &defs); err != nil {
+			return nil, err
+		}
+		steps := make([]models.CampaignStep, 0, len(defs))
+		for _, d := range defs {
+			steps = append(steps, d.Step(campaignID))
+		}
+		result[version] = steps
+	}
+	return result, rows.Err()
+}
+
+func insertVersion(ctx context.Context, tx pgx.Tx, campaignID uuid.UUID, version int, steps []models.CampaignStep) error {
+	_, err := tx.Exec(ctx, `
+        INSERT INTO campaign_versions (campaign_id, version, steps, created_at)
+        VALUES ($1, $2, $3, NOW())
+    `, campaignID, version, stepDefinitions(steps))
+	if err != nil {
+		return fmt.Errorf("failed to insert campaign version: %w", err)
+	}
+	return nil
+}
+
+func stepDefinitions(steps []models.CampaignStep) []models.StepDefinition {
+	defs := make([]models.StepDefinition, 0, len(steps))
+	for _, s := range steps {
+		defs = append(defs, s.Definition())
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].StepOrder < defs[j].StepOrder })
+	return defs
+}
+
+// sameSteps compares the content of steps through their JSON form, which ignores
+// the differences between values decoded from the database and from a request
+func sameSteps(stored []models.StepDefinition, steps []models.CampaignStep) bool {
+	sorted := append([]models.StepDefinition(nil), stored...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].StepOrder < sorted[j].StepOrder })
+	a, errA := json.Marshal(sorted)
+	b, errB := json.Marshal(stepDefinitions(steps))
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// stepRules never stores a JSON null so that the column stays an array
+func stepRules(rules []models.StepRule) []models.StepRule {
+	if rules == nil {
+		return []models.StepRule{}
+	}
+	return rules
+}
+
+// stepVariants never stores a JSON null so that the column stays an array
+func stepVariants(variants []models.StepVariant) []models.StepVariant {
+	if variants == nil {
+		return []models.StepVariant{}
+	}
+	return variants
+}
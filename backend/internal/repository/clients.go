@@ -10,6 +10,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/pkg/whatsapp"
 )
 
 // ClientRepository handles database operations for clients
@@ -49,7 +50,7 @@ func (r *ClientRepository) List(ctx context.Context, filter ClientFilter) (*Clie
 	baseQuery := `
 		SELECT id, cabinet_id, name, siren, siret, phone, email, 
 			   contact_name, address, notes, whatsapp_opted_in, 
-			   whatsapp_opted_in_at, created_at, updated_at
+			   whatsapp_opted_in_at, last_inbound_at, tags, created_at, updated_at
 		FROM clients
 		WHERE cabinet_id = $1
 	`
@@ -96,11 +97,12 @@ func (r *ClientRepository) List(ctx context.Context, filter ClientFilter) (*Clie
 		err := rows.Scan(
 			&c.ID, &c.CabinetID, &c.Name, &c.SIREN, &c.SIRET,
 			&c.Phone, &c.Email, &c.ContactName, &c.Address, &c.Notes,
-			&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.CreatedAt, &c.UpdatedAt,
+			&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.LastInboundAt, &c.Tags, &c.CreatedAt, &c.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan client: %w", err)
 		}
+		c.SetSession(whatsapp.SessionWindow, time.Now())
 		items = append(items, c)
 	}
 
@@ -118,7 +120,7 @@ func (r *ClientRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.C
 	query := `
 		SELECT id, cabinet_id, name, siren, siret, phone, email,
 			   contact_name, address, notes, whatsapp_opted_in,
-			   whatsapp_opted_in_at, created_at, updated_at
+			   whatsapp_opted_in_at, last_inbound_at, tags, created_at, updated_at
 		FROM clients
 		WHERE id = $1
 	`
@@ -127,7 +129,7 @@ func (r *ClientRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.C
 	err := r.pool.QueryRow(ctx, query, id).Scan(
 		&c.ID, &c.CabinetID, &c.Name, &c.SIREN, &c.SIRET,
 		&c.Phone, &c.Email, &c.ContactName, &c.Address, &c.Notes,
-		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.CreatedAt, &c.UpdatedAt,
+		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.LastInboundAt, &c.Tags, &c.CreatedAt, &c.UpdatedAt,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -136,6 +138,7 @@ func (r *ClientRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.C
 		return nil, fmt.Errorf("failed to get client: %w", err)
 	}
 
+	c.SetSession(whatsapp.SessionWindow, time.Now())
 	return &c, nil
 }
 
@@ -144,7 +147,7 @@ func (r *ClientRepository) GetByPhone(ctx context.Context, cabinetID uuid.UUID,
 	query := `
 		SELECT id, cabinet_id, name, siren, siret, phone, email,
 			   contact_name, address, notes, whatsapp_opted_in,
-			   whatsapp_opted_in_at, created_at, updated_at
+			   whatsapp_opted_in_at, last_inbound_at, tags, created_at, updated_at
 		FROM clients
 		WHERE cabinet_id = $1 AND phone = $2
 	`
@@ -153,7 +156,7 @@ func (r *ClientRepository) GetByPhone(ctx context.Context, cabinetID uuid.UUID,
 	err := r.pool.QueryRow(ctx, query, cabinetID, phone).Scan(
 		&c.ID, &c.CabinetID, &c.Name, &c.SIREN, &c.SIRET,
 		&c.Phone, &c.Email, &c.ContactName, &c.Address, &c.Notes,
-		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.CreatedAt, &c.UpdatedAt,
+		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.LastInboundAt, &c.Tags, &c.CreatedAt, &c.UpdatedAt,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -162,6 +165,7 @@ func (r *ClientRepository) GetByPhone(ctx context.Context, cabinetID uuid.UUID,
 		return nil, fmt.Errorf("failed to get client by phone: %w", err)
 	}
 
+	c.SetSession(whatsapp.SessionWindow, time.Now())
 	return &c, nil
 }
 
@@ -170,7 +174,7 @@ func (r *ClientRepository) GetByPhoneGlobal(ctx context.Context, phone string) (
 	query := `
 		SELECT id, cabinet_id, name, siren, siret, phone, email,
 			   contact_name, address, notes, whatsapp_opted_in,
-			   whatsapp_opted_in_at, created_at, updated_at
+			   whatsapp_opted_in_at, last_inbound_at, tags, created_at, updated_at
 		FROM clients
 		WHERE phone = $1
 		LIMIT 1
@@ -180,7 +184,7 @@ func (r *ClientRepository) GetByPhoneGlobal(ctx context.Context, phone string) (
 	err := r.pool.QueryRow(ctx, query, phone).Scan(
 		&c.ID, &c.CabinetID, &c.Name, &c.SIREN, &c.SIRET,
 		&c.Phone, &c.Email, &c.ContactName, &c.Address, &c.Notes,
-		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.CreatedAt, &c.UpdatedAt,
+		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.LastInboundAt, &c.Tags, &c.CreatedAt, &c.UpdatedAt,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -189,31 +193,67 @@ func (r *ClientRepository) GetByPhoneGlobal(ctx context.Context, phone string) (
 		return nil, fmt.Errorf("failed to get client by phone: %w", err)
 	}
 
+	c.SetSession(whatsapp.SessionWindow, time.Now())
 	return &c, nil
 }
 
+// CabinetsByPhones returns the cabinets having a client with each of phones, within
+// cabinetID when not nil. Phones of no client are missing from the map.
+func (r *ClientRepository) CabinetsByPhones(ctx context.Context, cabinetID *uuid.UUID, phones []string) (map[string][]uuid.UUID, error) {
+	cabinets := make(map[string][]uuid.UUID)
+	if len(phones) == 0 {
+		return cabinets, nil
+	}
+
+	query := `
+		SELECT DISTINCT phone, cabinet_id
+		FROM clients
+		WHERE ($1::uuid IS NULL OR cabinet_id = $1) AND phone = ANY($2)
+	`
+
+	rows, err := r.pool.Query(ctx, query, cabinetID, phones)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clients by phone: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var phone string
+		var id uuid.UUID
+		if err := rows.Scan(&phone, &id); err != nil {
+			return nil, fmt.Errorf("failed to scan client phone: %w", err)
+		}
+		cabinets[phone] = append(cabinets[phone], id)
+	}
+
+	return cabinets, rows.Err()
+}
+
 // Create inserts a new client
 func (r *ClientRepository) Create(ctx context.Context, c *models.Client) error {
 	query := `
 		INSERT INTO clients (
 			id, cabinet_id, name, siren, siret, phone, email,
-			contact_name, address, notes, whatsapp_opted_in,
+			contact_name, address, notes, whatsapp_opted_in, tags,
 			created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14
 		)
 	`
 
 	if c.ID == uuid.Nil {
 		c.ID = uuid.New()
 	}
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
 	c.CreatedAt = time.Now()
 	c.UpdatedAt = time.Now()
 
 	_, err := r.pool.Exec(ctx, query,
 		c.ID, c.CabinetID, c.Name, c.SIREN, c.SIRET,
 		c.Phone, c.Email, c.ContactName, c.Address, c.Notes,
-		c.WhatsAppOptedIn, c.CreatedAt, c.UpdatedAt,
+		c.WhatsAppOptedIn, c.Tags, c.CreatedAt, c.UpdatedAt,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create client: %w", err)
@@ -228,16 +268,19 @@ func (r *ClientRepository) Update(ctx context.Context, c *models.Client) error {
 		UPDATE clients SET
 			name = $2, siren = $3, siret = $4, phone = $5, email = $6,
 			contact_name = $7, address = $8, notes = $9, whatsapp_opted_in = $10,
-			whatsapp_opted_in_at = $11, updated_at = $12
+			whatsapp_opted_in_at = $11, tags = $12, updated_at = $13
 		WHERE id = $1
 	`
 
 	c.UpdatedAt = time.Now()
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
 
 	result, err := r.pool.Exec(ctx, query,
 		c.ID, c.Name, c.SIREN, c.SIRET, c.Phone, c.Email,
 		c.ContactName, c.Address, c.Notes, c.WhatsAppOptedIn,
-		c.WhatsAppOptedInAt, c.UpdatedAt,
+		c.WhatsAppOptedInAt, c.Tags, c.UpdatedAt,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to update client: %w", err)
@@ -264,6 +307,18 @@ func (r *ClientRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
+// TouchInbound records a message received from a phone number at the given time,
+// opening the WhatsApp session window of its clients. Earlier times are ignored.
+func (r *ClientRepository) TouchInbound(ctx context.Context, phone string, at time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE clients SET last_inbound_at = GREATEST(last_inbound_at, $2)
+		WHERE phone = $1`, phone, at)
+	if err != nil {
+		return fmt.Errorf("failed to record inbound message: %w", err)
+	}
+	return nil
+}
+
 // SetWhatsAppOptIn updates the WhatsApp opt-in status
 func (r *ClientRepository) SetWhatsAppOptIn(ctx context.Context, id uuid.UUID, optedIn bool) error {
 	var optedInAt *time.Time
@@ -298,7 +353,7 @@ func (r *ClientRepository) FindOrCreateByName(ctx context.Context, cabinetID uui
 	query := `
 		SELECT id, cabinet_id, name, siren, siret, phone, email,
 			   contact_name, address, notes, whatsapp_opted_in,
-			   whatsapp_opted_in_at, created_at, updated_at
+			   whatsapp_opted_in_at, last_inbound_at, tags, created_at, updated_at
 		FROM clients
 		WHERE cabinet_id = $1 AND LOWER(name) = LOWER($2)
 		LIMIT 1
@@ -308,9 +363,10 @@ func (r *ClientRepository) FindOrCreateByName(ctx context.Context, cabinetID uui
 	err := r.pool.QueryRow(ctx, query, cabinetID, name).Scan(
 		&c.ID, &c.CabinetID, &c.Name, &c.SIREN, &c.SIRET,
 		&c.Phone, &c.Email, &c.ContactName, &c.Address, &c.Notes,
-		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.CreatedAt, &c.UpdatedAt,
+		&c.WhatsAppOptedIn, &c.WhatsAppOptedInAt, &c.LastInboundAt, &c.Tags, &c.CreatedAt, &c.UpdatedAt,
 	)
 	if err == nil {
+		c.SetSession(whatsapp.SessionWindow, time.Now())
 		return &c, false, nil // Found existing
 	}
 	if err != pgx.ErrNoRows {
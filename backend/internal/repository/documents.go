@@ -189,6 +189,15 @@ func (r *DocumentRepository) GetByClient(ctx context.Context, clientID uuid.UUID
 	return docs, nil
 }
 
+// CountByClientSince returns the number of documents received from a client since a given time
+func (r *DocumentRepository) CountByClientSince(ctx context.Context, clientID uuid.UUID, since time.Time) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM documents WHERE client_id = $1 AND created_at >= $2
+	`, clientID, since).Scan(&count)
+	return count, err
+}
+
 // GetUnmatched retrieves documents pending matching
 func (r *DocumentRepository) GetUnmatched(ctx context.Context) ([]*Document, error) {
 	query := `
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,9 +29,11 @@ func (r *MessageRepository) Create(ctx context.Context, msg *models.Message) err
 		INSERT INTO messages (
 			id, pending_line_id, client_id, direction, message_type,
 			content, media_url, template_name, template_params,
-			wa_message_id, status, scheduled_at, created_at
+			wa_message_id, status, scheduled_at, created_at,
+			campaign_execution_id, campaign_step_order, campaign_channel, campaign_variant,
+			auto_reply
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18
 		)
 	`
 
@@ -43,6 +46,8 @@ func (r *MessageRepository) Create(ctx context.Context, msg *models.Message) err
 		msg.ID, msg.PendingLineID, msg.ClientID, msg.Direction, msg.MessageType,
 		msg.Content, msg.MediaURL, msg.TemplateName, msg.TemplateParams,
 		msg.WAMessageID, msg.Status, msg.ScheduledAt, msg.CreatedAt,
+		msg.CampaignExecutionID, msg.CampaignStepOrder, msg.CampaignChannel, msg.CampaignVariant,
+		msg.AutoReply,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create message: %w", err)
@@ -56,8 +61,10 @@ func (r *MessageRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.
 	query := `
 		SELECT id, pending_line_id, client_id, direction, message_type,
 			   content, media_url, template_name, template_params,
-			   wa_message_id, wa_conversation_id, status, error_message,
-			   scheduled_at, sent_at, delivered_at, read_at, created_at
+			   wa_message_id, wa_conversation_id, status, error_message, error_code,
+			   scheduled_at, sent_at, delivered_at, read_at, created_at,
+			   campaign_execution_id, campaign_step_order, campaign_channel, campaign_variant,
+			   call_status, call_duration, recording_url, dtmf_response
 		FROM messages
 		WHERE id = $1
 	`
@@ -66,8 +73,10 @@ func (r *MessageRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.
 	err := r.pool.QueryRow(ctx, query, id).Scan(
 		&msg.ID, &msg.PendingLineID, &msg.ClientID, &msg.Direction, &msg.MessageType,
 		&msg.Content, &msg.MediaURL, &msg.TemplateName, &msg.TemplateParams,
-		&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage,
+		&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage, &msg.ErrorCode,
 		&msg.ScheduledAt, &msg.SentAt, &msg.DeliveredAt, &msg.ReadAt, &msg.CreatedAt,
+		&msg.CampaignExecutionID, &msg.CampaignStepOrder, &msg.CampaignChannel, &msg.CampaignVariant,
+		&msg.CallStatus, &msg.CallDuration, &msg.RecordingURL, &msg.DTMFResponse,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -84,8 +93,10 @@ func (r *MessageRepository) GetByWAMessageID(ctx context.Context, waMessageID st
 	query := `
 		SELECT id, pending_line_id, client_id, direction, message_type,
 			   content, media_url, template_name, template_params,
-			   wa_message_id, wa_conversation_id, status, error_message,
-			   scheduled_at, sent_at, delivered_at, read_at, created_at
+			   wa_message_id, wa_conversation_id, status, error_message, error_code,
+			   scheduled_at, sent_at, delivered_at, read_at, created_at,
+			   campaign_execution_id, campaign_step_order, campaign_channel, campaign_variant,
+			   call_status, call_duration, recording_url, dtmf_response
 		FROM messages
 		WHERE wa_message_id = $1
 	`
@@ -94,8 +105,10 @@ func (r *MessageRepository) GetByWAMessageID(ctx context.Context, waMessageID st
 	err := r.pool.QueryRow(ctx, query, waMessageID).Scan(
 		&msg.ID, &msg.PendingLineID, &msg.ClientID, &msg.Direction, &msg.MessageType,
 		&msg.Content, &msg.MediaURL, &msg.TemplateName, &msg.TemplateParams,
-		&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage,
+		&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage, &msg.ErrorCode,
 		&msg.ScheduledAt, &msg.SentAt, &msg.DeliveredAt, &msg.ReadAt, &msg.CreatedAt,
+		&msg.CampaignExecutionID, &msg.CampaignStepOrder, &msg.CampaignChannel, &msg.CampaignVariant,
+		&msg.CallStatus, &msg.CallDuration, &msg.RecordingURL, &msg.DTMFResponse,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -112,8 +125,10 @@ func (r *MessageRepository) ListByPendingLine(ctx context.Context, pendingLineID
 	query := `
 		SELECT id, pending_line_id, client_id, direction, message_type,
 			   content, media_url, template_name, template_params,
-			   wa_message_id, wa_conversation_id, status, error_message,
-			   scheduled_at, sent_at, delivered_at, read_at, created_at
+			   wa_message_id, wa_conversation_id, status, error_message, error_code,
+			   scheduled_at, sent_at, delivered_at, read_at, created_at,
+			   campaign_execution_id, campaign_step_order, campaign_channel, campaign_variant,
+			   call_status, call_duration, recording_url, dtmf_response
 		FROM messages
 		WHERE pending_line_id = $1
 		ORDER BY created_at ASC
@@ -131,8 +146,10 @@ func (r *MessageRepository) ListByPendingLine(ctx context.Context, pendingLineID
 		err := rows.Scan(
 			&msg.ID, &msg.PendingLineID, &msg.ClientID, &msg.Direction, &msg.MessageType,
 			&msg.Content, &msg.MediaURL, &msg.TemplateName, &msg.TemplateParams,
-			&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage,
+			&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage, &msg.ErrorCode,
 			&msg.ScheduledAt, &msg.SentAt, &msg.DeliveredAt, &msg.ReadAt, &msg.CreatedAt,
+			&msg.CampaignExecutionID, &msg.CampaignStepOrder, &msg.CampaignChannel, &msg.CampaignVariant,
+			&msg.CallStatus, &msg.CallDuration, &msg.RecordingURL, &msg.DTMFResponse,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
@@ -152,8 +169,10 @@ func (r *MessageRepository) ListByClient(ctx context.Context, clientID uuid.UUID
 	query := `
 		SELECT id, pending_line_id, client_id, direction, message_type,
 			   content, media_url, template_name, template_params,
-			   wa_message_id, wa_conversation_id, status, error_message,
-			   scheduled_at, sent_at, delivered_at, read_at, created_at
+			   wa_message_id, wa_conversation_id, status, error_message, error_code,
+			   scheduled_at, sent_at, delivered_at, read_at, created_at,
+			   campaign_execution_id, campaign_step_order, campaign_channel, campaign_variant,
+			   call_status, call_duration, recording_url, dtmf_response
 		FROM messages
 		WHERE client_id = $1
 		ORDER BY created_at DESC
@@ -172,8 +191,10 @@ func (r *MessageRepository) ListByClient(ctx context.Context, clientID uuid.UUID
 		err := rows.Scan(
 			&msg.ID, &msg.PendingLineID, &msg.ClientID, &msg.Direction, &msg.MessageType,
 			&msg.Content, &msg.MediaURL, &msg.TemplateName, &msg.TemplateParams,
-			&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage,
+			&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage, &msg.ErrorCode,
 			&msg.ScheduledAt, &msg.SentAt, &msg.DeliveredAt, &msg.ReadAt, &msg.CreatedAt,
+			&msg.CampaignExecutionID, &msg.CampaignStepOrder, &msg.CampaignChannel, &msg.CampaignVariant,
+			&msg.CallStatus, &msg.CallDuration, &msg.RecordingURL, &msg.DTMFResponse,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
@@ -184,19 +205,72 @@ func (r *MessageRepository) ListByClient(ctx context.Context, clientID uuid.UUID
 	return messages, nil
 }
 
-// UpdateStatus updates the status and timestamps of a message
+// LastByExecution returns the last message sent by a campaign execution
+func (r *MessageRepository) LastByExecution(ctx context.Context, executionID uuid.UUID) (*models.Message, error) {
+	query := `
+		SELECT id, pending_line_id, client_id, direction, message_type,
+			   content, media_url, template_name, template_params,
+			   wa_message_id, wa_conversation_id, status, error_message, error_code,
+			   scheduled_at, sent_at, delivered_at, read_at, created_at,
+			   campaign_execution_id, campaign_step_order, campaign_channel, campaign_variant,
+			   call_status, call_duration, recording_url, dtmf_response
+		FROM messages
+		WHERE campaign_execution_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	var msg models.Message
+	err := r.pool.QueryRow(ctx, query, executionID).Scan(
+		&msg.ID, &msg.PendingLineID, &msg.ClientID, &msg.Direction, &msg.MessageType,
+		&msg.Content, &msg.MediaURL, &msg.TemplateName, &msg.TemplateParams,
+		&msg.WAMessageID, &msg.WAConversationID, &msg.Status, &msg.ErrorMessage, &msg.ErrorCode,
+		&msg.ScheduledAt, &msg.SentAt, &msg.DeliveredAt, &msg.ReadAt, &msg.CreatedAt,
+		&msg.CampaignExecutionID, &msg.CampaignStepOrder, &msg.CampaignChannel, &msg.CampaignVariant,
+		&msg.CallStatus, &msg.CallDuration, &msg.RecordingURL, &msg.DTMFResponse,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last execution message: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// CountInboundSince returns the number of messages received from a client since a given time
+func (r *MessageRepository) CountInboundSince(ctx context.Context, clientID uuid.UUID, since time.Time) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM messages
+		WHERE client_id = $1 AND direction = 'inbound' AND created_at >= $2
+	`, clientID, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count inbound messages: %w", err)
+	}
+	return count, nil
+}
+
+// UpdateStatus updates the status and timestamps of a message as it goes through the
+// queue. A message the provider reported delivered or read keeps its status: its
+// callbacks may be applied before the worker saves the send.
 func (r *MessageRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status models.MessageStatus, waMessageID *string) error {
+	current := `COALESCE(array_position(s.ranks, m.status::text), 0)`
+	applies := `(array_position(s.ranks, $2::text) > ` + current + ` OR ` + current + ` < array_position(s.ranks, 'delivered'))`
 	query := `
-		UPDATE messages SET 
-			status = $2,
-			wa_message_id = COALESCE($3, wa_message_id),
-			sent_at = CASE WHEN $2 = 'sent' THEN NOW() ELSE sent_at END,
-			delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() ELSE delivered_at END,
-			read_at = CASE WHEN $2 = 'read' THEN NOW() ELSE read_at END
-		WHERE id = $1
+		WITH s AS (SELECT ` + statusRanks + ` AS ranks)
+		UPDATE messages m SET
+			status = CASE WHEN ` + applies + ` THEN $2::message_status ELSE m.status END,
+			wa_message_id = COALESCE($3, m.wa_message_id),
+			sent_at = CASE WHEN $2 = 'sent' THEN LEAST(m.sent_at, NOW()) ELSE m.sent_at END,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN LEAST(m.delivered_at, NOW()) ELSE m.delivered_at END,
+			read_at = CASE WHEN $2 = 'read' THEN LEAST(m.read_at, NOW()) ELSE m.read_at END
+		FROM s
+		WHERE m.id = $1
 	`
 
-	result, err := r.pool.Exec(ctx, query, id, status, waMessageID)
+	result, err := r.pool.Exec(ctx, query, id, string(status), waMessageID)
 	if err != nil {
 		return fmt.Errorf("failed to update message status: %w", err)
 	}
@@ -208,19 +282,117 @@ func (r *MessageRepository) UpdateStatus(ctx context.Context, id uuid.UUID, stat
 	return nil
 }
 
-// UpdateStatusByWAID updates message status using WhatsApp message ID
-func (r *MessageRepository) UpdateStatusByWAID(ctx context.Context, waMessageID string, status models.MessageStatus) error {
+// statusRanks orders the statuses of an outbound message: a status callback never
+// moves a message back. Read messages were delivered even when a failure arrives late.
+const statusRanks = `ARRAY['queued', 'sending', 'sent', 'failed', 'delivered', 'read']`
+
+// UpdateStatusByWAID applies a delivery status reported at the given time to the
+// message with a provider message ID. Callbacks may arrive out of order: the status
+// only moves forward, and timestamps keep the earliest report. errorCode and
+// errorMessage are recorded with a failed status. It returns false when no message
+// has this ID.
+func (r *MessageRepository) UpdateStatusByWAID(ctx context.Context, waMessageID string, status models.MessageStatus, at time.Time, errorCode, errorMessage *string) (bool, error) {
+	advances := `array_position(s.ranks, $2::text) > COALESCE(array_position(s.ranks, m.status::text), 0)`
 	query := `
-		UPDATE messages SET 
-			status = $2,
-			delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() ELSE delivered_at END,
-			read_at = CASE WHEN $2 = 'read' THEN NOW() ELSE read_at END
-		WHERE wa_message_id = $1
+		WITH s AS (SELECT ` + statusRanks + ` AS ranks)
+		UPDATE messages m SET
+			status = CASE WHEN ` + advances + ` THEN $2::message_status ELSE m.status END,
+			sent_at = CASE WHEN $2 IN ('sent', 'delivered', 'read') THEN LEAST(m.sent_at, $3) ELSE m.sent_at END,
+			delivered_at = CASE WHEN $2 IN ('delivered', 'read') THEN LEAST(m.delivered_at, $3) ELSE m.delivered_at END,
+			read_at = CASE WHEN $2 = 'read' THEN LEAST(m.read_at, $3) ELSE m.read_at END,
+			error_code = CASE WHEN $2 = 'failed' AND ` + advances + ` THEN $4 ELSE m.error_code END,
+			error_message = CASE WHEN $2 = 'failed' AND ` + advances + ` THEN $5 ELSE m.error_message END
+		FROM s
+		WHERE m.wa_message_id = $1
 	`
 
-	_, err := r.pool.Exec(ctx, query, waMessageID, status)
+	result, err := r.pool.Exec(ctx, query, waMessageID, string(status), at, errorCode, errorMessage)
 	if err != nil {
-		return fmt.Errorf("failed to update message status: %w", err)
+		return false, fmt.Errorf("failed to update message status: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// UpdateCallOutcome records the outcome of the phone call callSID. Answered calls are
+// delivered; calls that were not picked up are failed with the outcome as error.
+// It returns false when no call has this SID.
+func (r *MessageRepository) UpdateCallOutcome(ctx context.Context, callSID, callStatus string, duration *int, answered bool) (bool, error) {
+	query := `
+		UPDATE messages SET
+			call_status = $2,
+			call_duration = COALESCE($3, call_duration),
+			status = CASE WHEN $4 THEN (CASE WHEN status = 'read' THEN 'read' ELSE 'delivered' END)::message_status ELSE 'failed' END,
+			delivered_at = CASE WHEN $4 THEN COALESCE(delivered_at, NOW()) ELSE delivered_at END,
+			error_message = CASE WHEN $4 THEN error_message ELSE 'call ' || $2 END
+		WHERE wa_message_id = $1 AND message_type = 'call'
+	`
+
+	result, err := r.pool.Exec(ctx, query, callSID, callStatus, duration, answered)
+	if err != nil {
+		return false, fmt.Errorf("failed to update call outcome: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// SetCallRecording stores the recording of the phone call callSID
+func (r *MessageRepository) SetCallRecording(ctx context.Context, callSID, recordingURL string, duration *int) error {
+	query := `
+		UPDATE messages SET recording_url = $2, call_duration = COALESCE(call_duration, $3)
+		WHERE wa_message_id = $1 AND message_type = 'call'
+	`
+
+	_, err := r.pool.Exec(ctx, query, callSID, recordingURL, duration)
+	if err != nil {
+		return fmt.Errorf("failed to set call recording: %w", err)
+	}
+
+	return nil
+}
+
+// CountClientOutboundSince counts the messages sent or being sent to a client since
+// the given time, excluding the message exclude and automatic replies, and returns
+// the oldest of them
+func (r *MessageRepository) CountClientOutboundSince(ctx context.Context, clientID uuid.UUID, since time.Time, exclude *uuid.UUID) (int, *time.Time, error) {
+	query := `
+		SELECT COUNT(*), MIN(created_at)
+		FROM messages
+		WHERE client_id = $1
+		  AND direction = 'outbound'
+		  AND status <> 'failed'
+		  AND NOT auto_reply
+		  AND created_at >= $2
+		  AND ($3::uuid IS NULL OR id <> $3)
+	`
+
+	var count int
+	var oldest *time.Time
+	if err := r.pool.QueryRow(ctx, query, clientID, since, exclude).Scan(&count, &oldest); err != nil {
+		return 0, nil, fmt.Errorf("failed to count client messages: %w", err)
+	}
+	return count, oldest, nil
+}
+
+// SetDTMFResponse stores the key pressed during a phone call. The callee listened to
+// the script, so the call counts as read.
+func (r *MessageRepository) SetDTMFResponse(ctx context.Context, id uuid.UUID, digits string) error {
+	query := `
+		UPDATE messages SET
+			dtmf_response = $2,
+			status = 'read',
+			delivered_at = COALESCE(delivered_at, NOW()),
+			read_at = COALESCE(read_at, NOW())
+		WHERE id = $1 AND message_type = 'call'
+	`
+
+	result, err := r.pool.Exec(ctx, query, id, digits)
+	if err != nil {
+		return fmt.Errorf("failed to set DTMF response: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("call not found")
 	}
 
 	return nil
@@ -238,6 +410,21 @@ func (r *MessageRepository) SetError(ctx context.Context, id uuid.UUID, errorMsg
 	return nil
 }
 
+// SetTemplate records that a message was sent as a template, with its parameters
+// numbered from 1 as in the template
+func (r *MessageRepository) SetTemplate(ctx context.Context, id uuid.UUID, name string, params []string) error {
+	values := make(map[string]any, len(params))
+	for i, p := range params {
+		values[strconv.Itoa(i+1)] = p
+	}
+	query := `UPDATE messages SET message_type = 'template', template_name = $2, template_params = $3 WHERE id = $1`
+
+	if _, err := r.pool.Exec(ctx, query, id, name, values); err != nil {
+		return fmt.Errorf("failed to set template: %w", err)
+	}
+	return nil
+}
+
 // GetPendingCount returns the count of pending/queued messages
 func (r *MessageRepository) GetPendingCount(ctx context.Context, cabinetID uuid.UUID) (int, error) {
 	query := `
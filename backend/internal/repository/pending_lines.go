@@ -60,12 +60,15 @@ func (r *PendingLineRepository) List(ctx context.Context, filter PendingLineFilt
 			pl.id, pl.cabinet_id, pl.client_id, pl.amount, pl.transaction_date,
 			pl.bank_label, pl.account_number, pl.import_batch_id, pl.source_file,
 			pl.source_row_number, pl.status, pl.last_contacted_at, pl.contact_count,
-			pl.assigned_to, pl.created_at, pl.updated_at,
+			pl.assigned_to, pl.rejection_reason, pl.created_at, pl.updated_at,
 			c.id as client_id, c.name as client_name, c.phone as client_phone,
 			ce.status as campaign_status, ce.next_step_scheduled_at, ce.current_step_order
 		FROM pending_lines pl
 		LEFT JOIN clients c ON pl.client_id = c.id
-		LEFT JOIN campaign_executions ce ON pl.id = ce.pending_line_id AND ce.status IN ('pending', 'running', 'stopped', 'completed')
+		LEFT JOIN campaign_executions ce ON (
+			pl.id = ce.pending_line_id
+			OR ce.id IN (SELECT cel.execution_id FROM campaign_execution_lines cel WHERE cel.pending_line_id = pl.id)
+		) AND ce.status IN ('pending', 'running', 'stopped', 'completed')
 		WHERE pl.cabinet_id = $1
 	`
 
@@ -158,7 +161,7 @@ func (r *PendingLineRepository) List(ctx context.Context, filter PendingLineFilt
 			&pl.ID, &pl.CabinetID, &pl.ClientID, &pl.Amount, &pl.TransactionDate,
 			&pl.BankLabel, &pl.AccountNumber, &pl.ImportBatchID, &pl.SourceFile,
 			&pl.SourceRowNumber, &pl.Status, &pl.LastContactedAt, &pl.ContactCount,
-			&pl.AssignedTo, &pl.CreatedAt, &pl.UpdatedAt,
+			&pl.AssignedTo, &pl.RejectionReason, &pl.CreatedAt, &pl.UpdatedAt,
 			&clientID, &clientName, &clientPhone,
 			&pl.CampaignStatus, &pl.NextStepScheduledAt, &pl.CampaignCurrentStep,
 		)
@@ -195,7 +198,7 @@ func (r *PendingLineRepository) GetByID(ctx context.Context, id uuid.UUID) (*mod
 			pl.id, pl.cabinet_id, pl.client_id, pl.amount, pl.transaction_date,
 			pl.bank_label, pl.account_number, pl.import_batch_id, pl.source_file,
 			pl.source_row_number, pl.status, pl.last_contacted_at, pl.contact_count,
-			pl.assigned_to, pl.created_at, pl.updated_at,
+			pl.assigned_to, pl.rejection_reason, pl.created_at, pl.updated_at,
 			c.id as client_id, c.name as client_name, c.phone as client_phone
 		FROM pending_lines pl
 		LEFT JOIN clients c ON pl.client_id = c.id
@@ -209,7 +212,7 @@ func (r *PendingLineRepository) GetByID(ctx context.Context, id uuid.UUID) (*mod
 		&pl.ID, &pl.CabinetID, &pl.ClientID, &pl.Amount, &pl.TransactionDate,
 		&pl.BankLabel, &pl.AccountNumber, &pl.ImportBatchID, &pl.SourceFile,
 		&pl.SourceRowNumber, &pl.Status, &pl.LastContactedAt, &pl.ContactCount,
-		&pl.AssignedTo, &pl.CreatedAt, &pl.UpdatedAt,
+		&pl.AssignedTo, &pl.RejectionReason, &pl.CreatedAt, &pl.UpdatedAt,
 		&clientID, &clientName, &clientPhone,
 	)
 	if err == pgx.ErrNoRows {
@@ -236,6 +239,54 @@ func (r *PendingLineRepository) GetByID(ctx context.Context, id uuid.UUID) (*mod
 	return &pl, nil
 }
 
+// GetByIDs returns pending lines with their client, keyed by ID
+func (r *PendingLineRepository) GetByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.PendingLine, error) {
+	lines := make(map[uuid.UUID]*models.PendingLine, len(ids))
+	if len(ids) == 0 {
+		return lines, nil
+	}
+
+	query := `
+		SELECT 
+			pl.id, pl.cabinet_id, pl.client_id, pl.amount, pl.transaction_date,
+			pl.bank_label, pl.account_number, pl.import_batch_id, pl.source_file,
+			pl.source_row_number, pl.status, pl.last_contacted_at, pl.contact_count,
+			pl.assigned_to, pl.rejection_reason, pl.created_at, pl.updated_at,
+			c.name as client_name, c.phone as client_phone, c.last_inbound_at
+		FROM pending_lines pl
+		LEFT JOIN clients c ON pl.client_id = c.id
+		WHERE pl.id = ANY($1)
+	`
+
+	rows, err := r.pool.Query(ctx, query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending lines: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pl models.PendingLine
+		var clientName, clientPhone *string
+		var lastInboundAt *time.Time
+		err := rows.Scan(
+			&pl.ID, &pl.CabinetID, &pl.ClientID, &pl.Amount, &pl.TransactionDate,
+			&pl.BankLabel, &pl.AccountNumber, &pl.ImportBatchID, &pl.SourceFile,
+			&pl.SourceRowNumber, &pl.Status, &pl.LastContactedAt, &pl.ContactCount,
+			&pl.AssignedTo, &pl.RejectionReason, &pl.CreatedAt, &pl.UpdatedAt,
+			&clientName, &clientPhone, &lastInboundAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pending line: %w", err)
+		}
+		if pl.ClientID != nil && clientName != nil {
+			pl.Client = &models.Client{ID: *pl.ClientID, Name: *clientName, Phone: clientPhone, LastInboundAt: lastInboundAt}
+		}
+		lines[pl.ID] = &pl
+	}
+
+	return lines, rows.Err()
+}
+
 // Create inserts a new pending line
 func (r *PendingLineRepository) Create(ctx context.Context, pl *models.PendingLine) error {
 	query := `
@@ -360,6 +411,39 @@ func (r *PendingLineRepository) UpdateStatus(ctx context.Context, id uuid.UUID,
 	return nil
 }
 
+// Reject marks a pending line rejected with the reason given, ending its campaign
+func (r *PendingLineRepository) Reject(ctx context.Context, id uuid.UUID, reason string) error {
+	query := `UPDATE pending_lines SET status = 'rejected', rejection_reason = $2, updated_at = $3 WHERE id = $1`
+
+	result, err := r.pool.Exec(ctx, query, id, reason, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to reject pending line: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("pending line not found")
+	}
+
+	return nil
+}
+
+// MarkContacted records a contact attempt on a line, moving it from pending to contacted
+func (r *PendingLineRepository) MarkContacted(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE pending_lines SET
+			status = CASE WHEN status = 'pending' THEN 'contacted' ELSE status END,
+			contact_count = contact_count + 1,
+			last_contacted_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	if _, err := r.pool.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to mark pending line contacted: %w", err)
+	}
+	return nil
+}
+
 // Delete removes a pending line
 func (r *PendingLineRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	result, err := r.pool.Exec(ctx, "DELETE FROM pending_lines WHERE id = $1", id)
@@ -420,7 +504,7 @@ func (r *PendingLineRepository) ListByClient(ctx context.Context, clientID uuid.
 			id, cabinet_id, client_id, amount, transaction_date,
 			bank_label, account_number, import_batch_id, source_file,
 			source_row_number, status, last_contacted_at, contact_count,
-			assigned_to, created_at, updated_at
+			assigned_to, rejection_reason, created_at, updated_at
 		FROM pending_lines
 		WHERE client_id = $1
 		ORDER BY transaction_date DESC
@@ -439,7 +523,7 @@ func (r *PendingLineRepository) ListByClient(ctx context.Context, clientID uuid.
 			&pl.ID, &pl.CabinetID, &pl.ClientID, &pl.Amount, &pl.TransactionDate,
 			&pl.BankLabel, &pl.AccountNumber, &pl.ImportBatchID, &pl.SourceFile,
 			&pl.SourceRowNumber, &pl.Status, &pl.LastContactedAt, &pl.ContactCount,
-			&pl.AssignedTo, &pl.CreatedAt, &pl.UpdatedAt,
+			&pl.AssignedTo, &pl.RejectionReason, &pl.CreatedAt, &pl.UpdatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// TaskRepository handles database operations for collaborator tasks
+type TaskRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewTaskRepository creates a new repository
+func NewTaskRepository(pool *pgxpool.Pool) *TaskRepository {
+	return &TaskRepository{pool: pool}
+}
+
+// TaskFilter for listing tasks
+type TaskFilter struct {
+	CabinetID     uuid.UUID
+	Status        *models.TaskStatus
+	AssignedTo    *uuid.UUID
+	PendingLineID *uuid.UUID
+	Limit         int
+	Offset        int
+}
+
+const taskColumns = `
+	id, cabinet_id, type, status, title, description, pending_line_id, client_id,
+	assigned_to, campaign_execution_id, completed_by, completed_at, created_at, updated_at`
+
+func scanTask(row pgx.Row) (*models.Task, error) {
+	var t models.Task
+	err := row.Scan(
+		&t.ID, &t.CabinetID, &t.Type, &t.Status, &t.Title, &t.Description, &t.PendingLineID, &t.ClientID,
+		&t.AssignedTo, &t.CampaignExecutionID, &t.CompletedBy, &t.CompletedAt, &t.CreatedAt, &t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Create inserts a new task
+func (r *TaskRepository) Create(ctx context.Context, t *models.Task) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	if t.Status == "" {
+		t.Status = models.TaskOpen
+	}
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = t.CreatedAt
+
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO tasks (
+			id, cabinet_id, type, status, title, description, pending_line_id, client_id,
+			assigned_to, campaign_execution_id, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, t.ID, t.CabinetID, t.Type, t.Status, t.Title, t.Description, t.PendingLineID, t.ClientID,
+		t.AssignedTo, t.CampaignExecutionID, t.CreatedAt, t.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+	return nil
+}
+
+// GetByID returns a task by ID
+func (r *TaskRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Task, error) {
+	t, err := scanTask(r.pool.QueryRow(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	return t, nil
+}
+
+// List returns tasks matching the filter, newest first
+func (r *TaskRepository) List(ctx context.Context, filter TaskFilter) ([]models.Task, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE cabinet_id = $1`
+	args := []any{filter.CabinetID}
+
+	if filter.Status != nil {
+		args = append(args, *filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if filter.AssignedTo != nil {
+		args = append(args, *filter.AssignedTo)
+		query += fmt.Sprintf(" AND assigned_to = $%d", len(args))
+	}
+	if filter.PendingLineID != nil {
+		args = append(args, *filter.PendingLineID)
+		query += fmt.Sprintf(" AND pending_line_id = $%d", len(args))
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := make([]models.Task, 0)
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		tasks = append(tasks, *t)
+	}
+	return tasks, rows.Err()
+}
+
+// UpdateStatus closes or reopens a task
+func (r *TaskRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status models.TaskStatus, userID *uuid.UUID) error {
+	res, err := r.pool.Exec(ctx, `
+		UPDATE tasks SET
+			status = $2,
+			completed_by = CASE WHEN $2 = 'open' THEN NULL ELSE $3::uuid END,
+			completed_at = CASE WHEN $2 = 'open' THEN NULL ELSE NOW() END,
+			updated_at = NOW()
+		WHERE id = $1
+	`, id, status, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update task: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("task not found")
+	}
+	return nil
+}
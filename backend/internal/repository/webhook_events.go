@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WebhookEventRepository records the provider webhook events already received
+type WebhookEventRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewWebhookEventRepository creates a new repository
+func NewWebhookEventRepository(pool *pgxpool.Pool) *WebhookEventRepository {
+	return &WebhookEventRepository{pool: pool}
+}
+
+// Record stores the keys of a webhook event and returns those not stored before
+func (r *WebhookEventRepository) Record(ctx context.Context, provider string, keys []string) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		INSERT INTO webhook_events (provider, event_key)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+		RETURNING event_key`, provider, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record webhook event: %w", err)
+	}
+	defer rows.Close()
+
+	var recorded []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook event: %w", err)
+		}
+		recorded = append(recorded, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to record webhook event: %w", err)
+	}
+	return recorded, nil
+}
+
+// Forget deletes the keys of a webhook event, so that the provider retry of an event
+// that failed is processed
+func (r *WebhookEventRepository) Forget(ctx context.Context, provider string, keys []string) error {
+	_, err := r.pool.Exec(ctx, `
+		DELETE FROM webhook_events WHERE provider = $1 AND event_key = ANY($2::text[])
+	`, provider, keys)
+	if err != nil {
+		return fmt.Errorf("failed to forget webhook event: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes the events received before a time
+func (r *WebhookEventRepository) Purge(ctx context.Context, before time.Time) error {
+	if _, err := r.pool.Exec(ctx, `DELETE FROM webhook_events WHERE received_at < $1`, before); err != nil {
+		return fmt.Errorf("failed to purge webhook events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/config"
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/queue"
+)
+
+// ErrWeeklyCapReached is returned when a client already received the maximum number
+// of messages over the last 7 days
+var ErrWeeklyCapReached = errors.New("weekly message cap reached for this client")
+
+// WeeklyCapError tells when the client can be contacted again
+type WeeklyCapError struct {
+	Max   int
+	Until time.Time
+}
+
+func (e *WeeklyCapError) Error() string {
+	return fmt.Sprintf("%s (%d messages, next on %s)", ErrWeeklyCapReached, e.Max, e.Until.Format("02/01/2006 15:04"))
+}
+
+func (e *WeeklyCapError) Unwrap() error { return ErrWeeklyCapReached }
+
+// AntiBanPolicy is the anti-ban policy of a message: queue delays and limits, plus
+// the weekly cap per client enforced before queueing
+type AntiBanPolicy struct {
+	queue.Policy
+	MaxPerClientPerWeek int // 0 for no limit
+}
+
+// AntiBanDefaults returns the policy of cabinets without anti-ban settings: the
+// queue defaults with the sender limits and weekly cap of the configuration
+func AntiBanDefaults(cfg *config.Config) (AntiBanPolicy, error) {
+	warmUp, err := queue.ParseWarmUp(cfg.SenderWarmUp)
+	if err != nil {
+		return AntiBanPolicy{}, err
+	}
+	p := AntiBanPolicy{Policy: queue.DefaultPolicy, MaxPerClientPerWeek: cfg.MaxMessagesPerClientPerWeek}
+	p.Sender = queue.SenderLimits{
+		PerMinute: cfg.SenderPerMinute,
+		PerHour:   cfg.SenderPerHour,
+		PerDay:    cfg.SenderPerDay,
+		WarmUp:    warmUp,
+	}
+	return p, nil
+}
+
+// ParseAntiBanSettings extracts the anti-ban settings from a cabinet's settings map
+func ParseAntiBanSettings(settings map[string]any) (models.AntiBanSettings, error) {
+	var out models.AntiBanSettings
+	raw, ok := settings["anti_ban"]
+	if !ok || raw == nil {
+		return out, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("invalid anti-ban settings: %w", err)
+	}
+	return out, nil
+}
+
+// ValidateAntiBanSettings checks the settings of a cabinet and of its senders
+func ValidateAntiBanSettings(settings models.AntiBanSettings, defaults AntiBanPolicy) error {
+	p, err := defaults.with(settings.AntiBanLimits)
+	if err != nil {
+		return err
+	}
+	for sender, limits := range settings.Senders {
+		if _, err := p.with(limits); err != nil {
+			return fmt.Errorf("sender %s: %w", sender, err)
+		}
+	}
+	return nil
+}
+
+// AntiBanPolicyFor applies the anti-ban settings of a cabinet, then those of the
+// sender number, over defaults
+func AntiBanPolicyFor(cabinet *models.Cabinet, sender string, defaults AntiBanPolicy) (AntiBanPolicy, error) {
+	if cabinet == nil {
+		return defaults, nil
+	}
+	settings, err := ParseAntiBanSettings(cabinet.Settings)
+	if err != nil {
+		return defaults, err
+	}
+	p, err := defaults.with(settings.AntiBanLimits)
+	if err != nil {
+		return defaults, err
+	}
+	if limits, ok := settings.Senders[sender]; ok {
+		return p.with(limits)
+	}
+	return p, nil
+}
+
+// with returns the policy overridden by the non nil limits
+func (p AntiBanPolicy) with(l models.AntiBanLimits) (AntiBanPolicy, error) {
+	for _, v := range []*int{
+		l.MinJitterSeconds, l.MaxJitterSeconds, l.TypingDelayMs, l.MaxPerPhonePerDay,
+		l.MaxPerClientPerWeek, l.SenderPerMinute, l.SenderPerHour, l.SenderPerDay,
+	} {
+		if v != nil && *v < 0 {
+			return p, fmt.Errorf("anti-ban limits cannot be negative")
+		}
+	}
+
+	if l.MinJitterSeconds != nil {
+		p.MinJitter = time.Duration(*l.MinJitterSeconds) * time.Second
+	}
+	if l.MaxJitterSeconds != nil {
+		p.MaxJitter = time.Duration(*l.MaxJitterSeconds) * time.Second
+	}
+	if p.MaxJitter < p.MinJitter {
+		return p, fmt.Errorf("max jitter is lower than min jitter")
+	}
+	if l.TypingDelayMs != nil {
+		p.TypingDelay = time.Duration(*l.TypingDelayMs) * time.Millisecond
+	}
+	if l.MaxPerPhonePerDay != nil {
+		p.MaxPerPhonePerDay = *l.MaxPerPhonePerDay
+	}
+	if l.MaxPerClientPerWeek != nil {
+		p.MaxPerClientPerWeek = *l.MaxPerClientPerWeek
+	}
+	if l.SenderPerMinute != nil {
+		p.Sender.PerMinute = *l.SenderPerMinute
+	}
+	if l.SenderPerHour != nil {
+		p.Sender.PerHour = *l.SenderPerHour
+	}
+	if l.SenderPerDay != nil {
+		p.Sender.PerDay = *l.SenderPerDay
+	}
+	if l.SenderWarmUp != nil {
+		warmUp, err := queue.ParseWarmUp(*l.SenderWarmUp)
+		if err != nil {
+			return p, err
+		}
+		p.Sender.WarmUp = warmUp
+	}
+	return p, nil
+}
+
+// PolicyFor returns the anti-ban policy of a cabinet for the sender number of its
+// WhatsApp provider. Invalid settings fall back to the defaults.
+func (s *MessageService) PolicyFor(ctx context.Context, cabinetID uuid.UUID) AntiBanPolicy {
+	_, policy := s.route(ctx, cabinetID)
+	return policy
+}
+
+// CheckWeeklyCap returns a *WeeklyCapError when the client already received the
+// maximum number of messages of the policy over the last 7 days, manual and campaign
+// sends alike. exclude is a message not to count, the one being sent.
+func (s *MessageService) CheckWeeklyCap(ctx context.Context, policy AntiBanPolicy, clientID uuid.UUID, exclude *uuid.UUID) error {
+	if policy.MaxPerClientPerWeek <= 0 {
+		return nil
+	}
+	const week = 7 * 24 * time.Hour
+	count, oldest, err := s.msgRepo.CountClientOutboundSince(ctx, clientID, time.Now().Add(-week), exclude)
+	if err != nil {
+		return err
+	}
+	if count < policy.MaxPerClientPerWeek || oldest == nil {
+		return nil
+	}
+	return &WeeklyCapError{Max: policy.MaxPerClientPerWeek, Until: oldest.Add(week)}
+}
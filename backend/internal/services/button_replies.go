@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/repository"
+	"github.com/fiducia/backend/pkg/whatsapp"
+)
+
+// Quick-reply buttons offered on relances. The ID of a button is its action followed
+// by the ID of the relance message, "action:message-id", to find the lines it is about.
+const (
+	ButtonDocumentComing  = "doc_coming" // The client is sending the document
+	ButtonPersonalExpense = "personal"   // Personal expense: no document is needed
+	ButtonNoDocument      = "no_doc"     // The client has no document
+	ButtonCallMe          = "call_me"    // The client wants to be called by the cabinet
+)
+
+// relanceActions are the button actions in the order they are offered, Twilio
+// clients answering with their number
+var relanceActions = []string{ButtonDocumentComing, ButtonPersonalExpense, ButtonNoDocument, ButtonCallMe}
+
+// documentWait is how long the campaign of a client announcing a document is paused
+const documentWait = 48 * time.Hour
+
+// RelanceButtons returns the relance message messageID with its quick-reply buttons
+func RelanceButtons(messageID uuid.UUID, body string) whatsapp.InteractiveMessage {
+	id := func(action string) string { return action + ":" + messageID.String() }
+	return whatsapp.InteractiveMessage{
+		Type:      "button",
+		Body:      body,
+		ListLabel: "Répondre",
+		Buttons: []whatsapp.Button{
+			{ID: id(ButtonDocumentComing), Title: "J'envoie le justificatif"},
+			{ID: id(ButtonPersonalExpense), Title: "Dépense personnelle"},
+			{ID: id(ButtonNoDocument), Title: "Pas de justificatif", Description: "Je n'ai pas de justificatif"},
+			{ID: id(ButtonCallMe), Title: "Appelez-moi"},
+		},
+	}
+}
+
+// Data read and written by the button replies, implemented by the repositories
+type (
+	replyMessages interface {
+		GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error)
+		ListByClient(ctx context.Context, clientID uuid.UUID, limit int) ([]models.Message, error)
+		Create(ctx context.Context, msg *models.Message) error
+	}
+	replyLines interface {
+		GetByID(ctx context.Context, id uuid.UUID) (*models.PendingLine, error)
+		Reject(ctx context.Context, id uuid.UUID, reason string) error
+	}
+	replyClients interface {
+		GetByID(ctx context.Context, id uuid.UUID) (*models.Client, error)
+	}
+	replyTasks interface {
+		Create(ctx context.Context, t *models.Task) error
+	}
+	replyExecutions interface {
+		OpenLineIDs(ctx context.Context, executionIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error)
+	}
+)
+
+// ButtonReplyService applies the quick-reply buttons pressed by clients on relances
+type ButtonReplyService struct {
+	messages      *MessageService
+	engine        *CampaignEngine
+	msgRepo       replyMessages
+	lineRepo      replyLines
+	clientRepo    replyClients
+	taskRepo      replyTasks
+	executionRepo replyExecutions
+}
+
+// NewButtonReplyService creates a new button reply service
+func NewButtonReplyService(
+	messages *MessageService,
+	engine *CampaignEngine,
+	msgRepo *repository.MessageRepository,
+	lineRepo *repository.PendingLineRepository,
+	clientRepo *repository.ClientRepository,
+	taskRepo *repository.TaskRepository,
+	executionRepo *repository.CampaignExecutionRepository,
+) *ButtonReplyService {
+	return &ButtonReplyService{
+		messages:      messages,
+		engine:        engine,
+		msgRepo:       msgRepo,
+		lineRepo:      lineRepo,
+		clientRepo:    clientRepo,
+		taskRepo:      taskRepo,
+		executionRepo: executionRepo,
+	}
+}
+
+// HandleReply applies the button of an inbound message from a client. It returns
+// false when the message answers no relance button.
+func (s *ButtonReplyService) HandleReply(ctx context.Context, in whatsapp.InboundMessage, clientID uuid.UUID) (bool, error) {
+	buttonID := in.ButtonID
+	if buttonID == "" {
+		var err error
+		if buttonID, err = s.numberedReply(ctx, in.Body, clientID); err != nil || buttonID == "" {
+			return false, err
+		}
+	}
+	action, rawID, _ := strings.Cut(buttonID, ":")
+	messageID, err := uuid.Parse(rawID)
+	if err != nil {
+		return false, nil
+	}
+
+	relance, err := s.msgRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return false, err
+	}
+	if relance == nil || relance.ClientID == nil || *relance.ClientID != clientID {
+		return false, nil
+	}
+	lineIDs, err := s.relanceLines(ctx, relance)
+	if err != nil {
+		return false, err
+	}
+
+	slog.Info("relance button pressed", "action", action, "messageID", relance.ID, "clientID", clientID, "lines", len(lineIDs))
+
+	var ack string
+	switch action {
+	case ButtonDocumentComing:
+		until := time.Now().Add(documentWait)
+		s.pause(ctx, relance, &until, "Le client indique envoyer le justificatif")
+		ack = "Merci ! Vous pouvez nous envoyer la photo ou le PDF du justificatif directement ici."
+
+	case ButtonPersonalExpense:
+		// Rejected lines end their campaign
+		for _, id := range lineIDs {
+			if err := s.lineRepo.Reject(ctx, id, "Dépense personnelle selon le client"); err != nil {
+				return true, err
+			}
+		}
+		ack = "C'est noté, cette dépense est enregistrée comme personnelle. Merci !"
+
+	case ButtonNoDocument:
+		if err := s.createTask(ctx, relance, lineIDs, "Pas de justificatif selon le client",
+			"Le client indique ne pas avoir de justificatif pour cette opération."); err != nil {
+			return true, err
+		}
+		s.pause(ctx, relance, nil, "Le client indique ne pas avoir de justificatif")
+		ack = "C'est noté, votre cabinet va revenir vers vous."
+
+	case ButtonCallMe:
+		if err := s.createTask(ctx, relance, lineIDs, "Rappel demandé par le client",
+			"Le client a demandé à être rappelé depuis une relance WhatsApp."); err != nil {
+			return true, err
+		}
+		s.pause(ctx, relance, nil, "Le client a demandé à être rappelé")
+		ack = "C'est noté, votre cabinet vous rappellera prochainement."
+
+	default:
+		return false, nil
+	}
+
+	s.acknowledge(ctx, relance, lineIDs, in.From, ack)
+	return true, nil
+}
+
+// numberedReply returns the button a Twilio client chose by answering its number to
+// the last relance it received with buttons, empty when the message is no such answer
+func (s *ButtonReplyService) numberedReply(ctx context.Context, body string, clientID uuid.UUID) (string, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(body))
+	if err != nil || n < 1 || n > len(relanceActions) {
+		return "", nil
+	}
+	messages, err := s.msgRepo.ListByClient(ctx, clientID, 20)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range messages {
+		if m.Direction != models.DirectionOutbound {
+			continue
+		}
+		// Only the last message sent, if it carried the buttons within the session window
+		if m.MessageType != models.TypeInteractive || time.Since(m.CreatedAt) > whatsapp.SessionWindow {
+			return "", nil
+		}
+		return relanceActions[n-1] + ":" + m.ID.String(), nil
+	}
+	return "", nil
+}
+
+// relanceLines returns the open lines a relance message is about
+func (s *ButtonReplyService) relanceLines(ctx context.Context, relance *models.Message) ([]uuid.UUID, error) {
+	if relance.PendingLineID != nil {
+		return []uuid.UUID{*relance.PendingLineID}, nil
+	}
+	if relance.CampaignExecutionID == nil {
+		return nil, nil
+	}
+	lines, err := s.executionRepo.OpenLineIDs(ctx, []uuid.UUID{*relance.CampaignExecutionID})
+	if err != nil {
+		return nil, err
+	}
+	return lines[*relance.CampaignExecutionID], nil
+}
+
+// pause pauses the campaign execution of a relance, if any, until a date or until a
+// collaborator resumes it
+func (s *ButtonReplyService) pause(ctx context.Context, relance *models.Message, until *time.Time, reason string) {
+	if relance.CampaignExecutionID == nil || s.engine == nil {
+		return
+	}
+	if err := s.engine.PauseForClient(ctx, *relance.CampaignExecutionID, until, reason); err != nil {
+		slog.Warn("failed to pause campaign execution", "execID", *relance.CampaignExecutionID, "error", err)
+	}
+}
+
+// createTask asks the collaborator of the relance lines to follow up on a reply
+func (s *ButtonReplyService) createTask(ctx context.Context, relance *models.Message, lineIDs []uuid.UUID, title, description string) error {
+	task := &models.Task{
+		Type:                models.TaskButtonReply,
+		Title:               title,
+		Description:         &description,
+		PendingLineID:       relance.PendingLineID,
+		ClientID:            relance.ClientID,
+		CampaignExecutionID: relance.CampaignExecutionID,
+	}
+	cabinetID, assignedTo, err := s.owner(ctx, relance, lineIDs)
+	if err != nil {
+		return err
+	}
+	task.CabinetID, task.AssignedTo = cabinetID, assignedTo
+
+	if err := s.taskRepo.Create(ctx, task); err != nil {
+		return err
+	}
+	slog.Info("Task created from relance button", "taskID", task.ID, "messageID", relance.ID)
+	return nil
+}
+
+// acknowledge answers the client of a relance, failures being only logged. The answer
+// is not part of the campaign: it is neither a step of its execution nor counted in
+// the weekly cap of the client.
+func (s *ButtonReplyService) acknowledge(ctx context.Context, relance *models.Message, lineIDs []uuid.UUID, phone, text string) {
+	if s.messages == nil {
+		return
+	}
+	cabinetID, _, err := s.owner(ctx, relance, lineIDs)
+	if err != nil {
+		slog.Warn("relance reply not acknowledged", "messageID", relance.ID, "error", err)
+		return
+	}
+	msg := &models.Message{
+		ID:            uuid.New(),
+		PendingLineID: relance.PendingLineID,
+		ClientID:      relance.ClientID,
+		Direction:     models.DirectionOutbound,
+		MessageType:   models.TypeText,
+		Content:       &text,
+		Status:        models.MsgStatusQueued,
+		AutoReply:     true,
+	}
+	if err := s.msgRepo.Create(ctx, msg); err != nil {
+		slog.Warn("relance reply not acknowledged", "messageID", relance.ID, "error", err)
+		return
+	}
+	if err := s.messages.QueueMessage(ctx, cabinetID, msg, phone, true); err != nil {
+		slog.Warn("relance reply not acknowledged", "messageID", relance.ID, "error", err)
+	}
+}
+
+// owner returns the cabinet of a relance and the collaborator assigned to its lines
+func (s *ButtonReplyService) owner(ctx context.Context, relance *models.Message, lineIDs []uuid.UUID) (uuid.UUID, *uuid.UUID, error) {
+	if len(lineIDs) > 0 {
+		line, err := s.lineRepo.GetByID(ctx, lineIDs[0])
+		if err != nil {
+			return uuid.Nil, nil, err
+		}
+		if line != nil {
+			return line.CabinetID, line.AssignedTo, nil
+		}
+	}
+	client, err := s.clientRepo.GetByID(ctx, *relance.ClientID)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if client == nil {
+		return uuid.Nil, nil, fmt.Errorf("client not found")
+	}
+	return client.CabinetID, nil, nil
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/pkg/whatsapp"
+)
+
+// replyFixture is the data of a client who received relances, served by in-memory
+// stores to a ButtonReplyService
+type replyFixture struct {
+	messages   map[uuid.UUID]*models.Message
+	history    []models.Message // Messages of the client, most recent first
+	lines      map[uuid.UUID]*models.PendingLine
+	clients    map[uuid.UUID]*models.Client
+	openLines  map[uuid.UUID][]uuid.UUID
+	rejected   map[uuid.UUID]string
+	tasks      []*models.Task
+	newMessage []*models.Message
+}
+
+func (f *replyFixture) GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error) {
+	return f.messages[id], nil
+}
+
+func (f *replyFixture) ListByClient(ctx context.Context, clientID uuid.UUID, limit int) ([]models.Message, error) {
+	return f.history, nil
+}
+
+func (f *replyFixture) Create(ctx context.Context, msg *models.Message) error {
+	f.newMessage = append(f.newMessage, msg)
+	return nil
+}
+
+func (f *replyFixture) OpenLineIDs(ctx context.Context, executionIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	return f.openLines, nil
+}
+
+type fixtureLines struct{ *replyFixture }
+
+func (f fixtureLines) GetByID(ctx context.Context, id uuid.UUID) (*models.PendingLine, error) {
+	return f.lines[id], nil
+}
+
+func (f fixtureLines) Reject(ctx context.Context, id uuid.UUID, reason string) error {
+	f.rejected[id] = reason
+	return nil
+}
+
+type fixtureClients struct{ *replyFixture }
+
+func (f fixtureClients) GetByID(ctx context.Context, id uuid.UUID) (*models.Client, error) {
+	return f.clients[id], nil
+}
+
+type fixtureTasks struct{ *replyFixture }
+
+func (f fixtureTasks) Create(ctx context.Context, t *models.Task) error {
+	t.ID = uuid.New()
+	f.tasks = append(f.tasks, t)
+	return nil
+}
+
+func newReplyFixture() *replyFixture {
+	return &replyFixture{
+		messages:  make(map[uuid.UUID]*models.Message),
+		lines:     make(map[uuid.UUID]*models.PendingLine),
+		clients:   make(map[uuid.UUID]*models.Client),
+		openLines: make(map[uuid.UUID][]uuid.UUID),
+		rejected:  make(map[uuid.UUID]string),
+	}
+}
+
+// service returns a ButtonReplyService on the fixture, without engine nor sending
+func (f *replyFixture) service() *ButtonReplyService {
+	return &ButtonReplyService{
+		msgRepo:       f,
+		lineRepo:      fixtureLines{f},
+		clientRepo:    fixtureClients{f},
+		taskRepo:      fixtureTasks{f},
+		executionRepo: f,
+	}
+}
+
+// relance stores an interactive relance sent to clientID about a line, or about the
+// open lines of an execution when line is nil
+func (f *replyFixture) relance(clientID uuid.UUID, line *models.PendingLine, executionID *uuid.UUID, sentAgo time.Duration) *models.Message {
+	m := &models.Message{
+		ID:                  uuid.New(),
+		ClientID:            &clientID,
+		Direction:           models.DirectionOutbound,
+		MessageType:         models.TypeInteractive,
+		CampaignExecutionID: executionID,
+		CreatedAt:           time.Now().Add(-sentAgo),
+	}
+	if line != nil {
+		m.PendingLineID = &line.ID
+		f.lines[line.ID] = line
+	}
+	f.messages[m.ID] = m
+	f.history = append([]models.Message{*m}, f.history...)
+	return m
+}
+
+func TestHandleReply(t *testing.T) {
+	ctx := context.Background()
+	cabinetID, clientID, collaborator := uuid.New(), uuid.New(), uuid.New()
+	newLine := func() *models.PendingLine {
+		return &models.PendingLine{ID: uuid.New(), CabinetID: cabinetID, ClientID: &clientID, AssignedTo: &collaborator, Status: models.StatusContacted}
+	}
+
+	t.Run("personal expense rejects the line", func(t *testing.T) {
+		f := newReplyFixture()
+		line := newLine()
+		relance := f.relance(clientID, line, nil, time.Hour)
+
+		handled, err := f.service().HandleReply(ctx, whatsapp.InboundMessage{ButtonID: ButtonPersonalExpense + ":" + relance.ID.String()}, clientID)
+		if err != nil || !handled {
+			t.Fatalf("HandleReply = %v, %v, want handled", handled, err)
+		}
+		if _, ok := f.rejected[line.ID]; !ok || len(f.rejected) != 1 {
+			t.Errorf("rejected lines = %v, want %s only", f.rejected, line.ID)
+		}
+		if len(f.tasks) != 0 {
+			t.Errorf("%d tasks created, want none", len(f.tasks))
+		}
+	})
+
+	t.Run("personal expense rejects the open lines of a client relance", func(t *testing.T) {
+		f := newReplyFixture()
+		executionID := uuid.New()
+		l1, l2 := newLine(), newLine()
+		f.lines[l1.ID], f.lines[l2.ID] = l1, l2
+		f.openLines[executionID] = []uuid.UUID{l1.ID, l2.ID}
+		relance := f.relance(clientID, nil, &executionID, time.Hour)
+
+		handled, err := f.service().HandleReply(ctx, whatsapp.InboundMessage{ButtonID: ButtonPersonalExpense + ":" + relance.ID.String()}, clientID)
+		if err != nil || !handled {
+			t.Fatalf("HandleReply = %v, %v, want handled", handled, err)
+		}
+		if len(f.rejected) != 2 {
+			t.Errorf("rejected lines = %v, want both open lines", f.rejected)
+		}
+	})
+
+	for _, action := range []string{ButtonCallMe, ButtonNoDocument} {
+		t.Run(action+" creates a task for the collaborator", func(t *testing.T) {
+			f := newReplyFixture()
+			line := newLine()
+			relance := f.relance(clientID, line, nil, time.Hour)
+
+			handled, err := f.service().HandleReply(ctx, whatsapp.InboundMessage{ButtonID: action + ":" + relance.ID.String()}, clientID)
+			if err != nil || !handled {
+				t.Fatalf("HandleReply = %v, %v, want handled", handled, err)
+			}
+			if len(f.tasks) != 1 {
+				t.Fatalf("%d tasks created, want 1", len(f.tasks))
+			}
+			task := f.tasks[0]
+			if task.Type != models.TaskButtonReply || task.CabinetID != cabinetID {
+				t.Errorf("task type %s cabinet %s, want %s in %s", task.Type, task.CabinetID, models.TaskButtonReply, cabinetID)
+			}
+			if task.AssignedTo == nil || *task.AssignedTo != collaborator {
+				t.Errorf("task assigned to %v, want %s", task.AssignedTo, collaborator)
+			}
+			if len(f.rejected) != 0 {
+				t.Errorf("rejected lines = %v, want none", f.rejected)
+			}
+		})
+	}
+
+	t.Run("document coming changes no line", func(t *testing.T) {
+		f := newReplyFixture()
+		relance := f.relance(clientID, newLine(), nil, time.Hour)
+
+		handled, err := f.service().HandleReply(ctx, whatsapp.InboundMessage{ButtonID: ButtonDocumentComing + ":" + relance.ID.String()}, clientID)
+		if err != nil || !handled {
+			t.Fatalf("HandleReply = %v, %v, want handled", handled, err)
+		}
+		if len(f.rejected) != 0 || len(f.tasks) != 0 {
+			t.Errorf("rejected %v and %d tasks, want none", f.rejected, len(f.tasks))
+		}
+	})
+
+	t.Run("numbered answer to a Twilio relance", func(t *testing.T) {
+		f := newReplyFixture()
+		line := newLine()
+		f.relance(clientID, line, nil, time.Hour)
+
+		handled, err := f.service().HandleReply(ctx, whatsapp.InboundMessage{Body: " 2 "}, clientID)
+		if err != nil || !handled {
+			t.Fatalf("HandleReply = %v, %v, want handled", handled, err)
+		}
+		if _, ok := f.rejected[line.ID]; !ok {
+			t.Errorf("line not rejected by answer 2 (%s)", relanceActions[1])
+		}
+	})
+
+	ignored := []struct {
+		name string
+		in   func(f *replyFixture) whatsapp.InboundMessage
+	}{
+		{"relance of another client", func(f *replyFixture) whatsapp.InboundMessage {
+			relance := f.relance(uuid.New(), newLine(), nil, time.Hour)
+			return whatsapp.InboundMessage{ButtonID: ButtonPersonalExpense + ":" + relance.ID.String()}
+		}},
+		{"unknown relance", func(f *replyFixture) whatsapp.InboundMessage {
+			return whatsapp.InboundMessage{ButtonID: ButtonPersonalExpense + ":" + uuid.NewString()}
+		}},
+		{"unknown action", func(f *replyFixture) whatsapp.InboundMessage {
+			relance := f.relance(clientID, newLine(), nil, time.Hour)
+			return whatsapp.InboundMessage{ButtonID: "unsubscribe:" + relance.ID.String()}
+		}},
+		{"malformed button", func(f *replyFixture) whatsapp.InboundMessage {
+			return whatsapp.InboundMessage{ButtonID: ButtonPersonalExpense}
+		}},
+		{"free text", func(f *replyFixture) whatsapp.InboundMessage {
+			f.relance(clientID, newLine(), nil, time.Hour)
+			return whatsapp.InboundMessage{Body: "Bonjour, je regarde"}
+		}},
+	}
+	for _, tc := range ignored {
+		t.Run(tc.name+" is ignored", func(t *testing.T) {
+			f := newReplyFixture()
+			in := tc.in(f)
+			handled, err := f.service().HandleReply(ctx, in, clientID)
+			if err != nil || handled {
+				t.Fatalf("HandleReply = %v, %v, want not handled", handled, err)
+			}
+			if len(f.rejected) != 0 || len(f.tasks) != 0 || len(f.newMessage) != 0 {
+				t.Errorf("rejected %v, %d tasks, %d messages, want none", f.rejected, len(f.tasks), len(f.newMessage))
+			}
+		})
+	}
+}
+
+func TestNumberedReply(t *testing.T) {
+	ctx := context.Background()
+	clientID := uuid.New()
+	message := func(direction models.MessageDirection, typ models.MessageType, ago time.Duration) models.Message {
+		return models.Message{ID: uuid.New(), ClientID: &clientID, Direction: direction, MessageType: typ, CreatedAt: time.Now().Add(-ago)}
+	}
+	relance := message(models.DirectionOutbound, models.TypeInteractive, time.Hour)
+	inbound := message(models.DirectionInbound, models.TypeText, time.Minute)
+	text := message(models.DirectionOutbound, models.TypeText, time.Minute)
+	expired := message(models.DirectionOutbound, models.TypeInteractive, whatsapp.SessionWindow+time.Hour)
+
+	tests := []struct {
+		name    string
+		body    string
+		history []models.Message
+		want    string
+	}{
+		{"first button", "1", []models.Message{relance}, ButtonDocumentComing + ":" + relance.ID.String()},
+		{"last button", "4", []models.Message{relance}, ButtonCallMe + ":" + relance.ID.String()},
+		{"spaces around the number", " 3\n", []models.Message{relance}, ButtonNoDocument + ":" + relance.ID.String()},
+		{"inbound messages skipped", "2", []models.Message{inbound, relance}, ButtonPersonalExpense + ":" + relance.ID.String()},
+		{"zero", "0", []models.Message{relance}, ""},
+		{"beyond the buttons", "5", []models.Message{relance}, ""},
+		{"negative", "-1", []models.Message{relance}, ""},
+		{"not a number", "oui", []models.Message{relance}, ""},
+		{"last message sent has no buttons", "1", []models.Message{text, relance}, ""},
+		{"relance out of the session window", "1", []models.Message{expired}, ""},
+		{"no message sent", "1", []models.Message{inbound}, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newReplyFixture()
+			f.history = tc.history
+			got, err := f.service().numberedReply(ctx, tc.body, clientID)
+			if err != nil {
+				t.Fatalf("numberedReply: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("numberedReply(%q) = %q, want %q", tc.body, got, tc.want)
+			}
+		})
+	}
+}
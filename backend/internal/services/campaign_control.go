@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+var (
+	// ErrInvalidCommand is returned when a manual action is missing or has invalid parameters
+	ErrInvalidCommand = errors.New("invalid command")
+	// ErrInvalidTransition is returned when an action is not allowed in the current status
+	ErrInvalidTransition = errors.New("action not allowed in the current status")
+)
+
+// ExecutionCommand is a manual action requested by a collaborator
+type ExecutionCommand struct {
+	Action    models.ExecutionAction
+	Reason    string
+	StepOrder int        // Skip: step to run next
+	Until     *time.Time // Pause: resume automatically at this date
+	ActorID   *uuid.UUID
+}
+
+// Control applies a manual action to an execution and records who did it.
+// Executions being processed by a worker are not changed (repository.ErrExecutionLeased).
+func (e *CampaignEngine) Control(ctx context.Context, ex *models.CampaignExecution, campaign *models.Campaign, cmd ExecutionCommand) error {
+	now := time.Now()
+	event := &models.CampaignExecutionEvent{
+		Action:     cmd.Action,
+		ActorID:    cmd.ActorID,
+		FromStatus: ex.Status,
+		FromStep:   ex.CurrentStepOrder,
+	}
+	if reason := strings.TrimSpace(cmd.Reason); reason != "" {
+		event.Reason = &reason
+	}
+	active := ex.Status == models.ExecStatusPending || ex.Status == models.ExecStatusRunning
+
+	switch cmd.Action {
+	case models.ExecActionPause:
+		if !active {
+			return fmt.Errorf("%w: cannot pause a %s execution", ErrInvalidTransition, ex.Status)
+		}
+		if cmd.Until != nil && !cmd.Until.After(now) {
+			return fmt.Errorf("%w: pause end must be in the future", ErrInvalidCommand)
+		}
+		ex.Status = models.ExecStatusPaused
+		ex.PausedUntil = cmd.Until
+
+	case models.ExecActionResume:
+		if ex.Status != models.ExecStatusPaused {
+			return fmt.Errorf("%w: execution is not paused", ErrInvalidTransition)
+		}
+		ex.Status = resumedStatus(ex)
+		ex.PausedUntil = nil
+		if ex.NextStepScheduledAt == nil {
+			ex.NextStepScheduledAt = &now
+		}
+
+	case models.ExecActionSkip:
+		if !active && ex.Status != models.ExecStatusPaused {
+			return fmt.Errorf("%w: cannot skip steps of a %s execution", ErrInvalidTransition, ex.Status)
+		}
+		steps := campaign.Steps
+		if ex.CampaignVersion != campaign.Version {
+			pinned, err := e.campaignRepo.GetVersionSteps(ctx, campaign.ID, []int{ex.CampaignVersion})
+			if err != nil {
+				return err
+			}
+			steps = pinned[ex.CampaignVersion]
+		}
+		if findStep(steps, cmd.StepOrder) == nil {
+			return fmt.Errorf("%w: step %d does not exist", ErrInvalidCommand, cmd.StepOrder)
+		}
+		// CurrentStepOrder is the last executed step
+		ex.CurrentStepOrder = cmd.StepOrder - 1
+		ex.NextStepScheduledAt = &now
+		if ex.Status != models.ExecStatusPaused {
+			ex.Status = resumedStatus(ex)
+		}
+
+	case models.ExecActionRestart:
+		ex.Status = models.ExecStatusPending
+		ex.CurrentStepOrder = 0
+		ex.StopReason = nil
+		ex.LastStepExecutedAt = nil
+		ex.NextStepScheduledAt = &now
+		ex.PausedUntil = nil
+
+	case models.ExecActionStop:
+		if !active && ex.Status != models.ExecStatusPaused {
+			return fmt.Errorf("%w: execution is already %s", ErrInvalidTransition, ex.Status)
+		}
+		if event.Reason == nil {
+			return fmt.Errorf("%w: a reason is required to stop an execution", ErrInvalidCommand)
+		}
+		reason := models.StopManual
+		ex.Status = models.ExecStatusStopped
+		ex.StopReason = &reason
+		ex.NextStepScheduledAt = nil
+		ex.PausedUntil = nil
+
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidCommand, cmd.Action)
+	}
+
+	event.ToStatus = ex.Status
+	event.ToStep = ex.CurrentStepOrder
+	if err := e.executionRepo.ApplyControl(ctx, ex, event); err != nil {
+		return err
+	}
+
+	slog.Info("Campaign execution changed manually", "execID", ex.ID, "action", cmd.Action,
+		"from", event.FromStatus, "to", event.ToStatus, "actor", cmd.ActorID)
+	return nil
+}
+
+// PauseForClient pauses an execution after an answer of its client, until a date or,
+// when until is nil, until a collaborator resumes it. Executions that are not active
+// are left as they are.
+func (e *CampaignEngine) PauseForClient(ctx context.Context, executionID uuid.UUID, until *time.Time, reason string) error {
+	ex, err := e.executionRepo.GetByID(ctx, executionID)
+	if err != nil {
+		return err
+	}
+	if ex == nil {
+		return fmt.Errorf("execution not found")
+	}
+	if ex.Status != models.ExecStatusPending && ex.Status != models.ExecStatusRunning {
+		return nil
+	}
+	campaign, err := e.campaignRepo.GetByID(ctx, ex.CampaignID)
+	if err != nil {
+		return err
+	}
+	if campaign == nil {
+		return fmt.Errorf("campaign not found")
+	}
+	return e.Control(ctx, ex, campaign, ExecutionCommand{Action: models.ExecActionPause, Reason: reason, Until: until})
+}
+
+// resumedStatus is the status of an execution that is neither paused nor finished
+func resumedStatus(ex *models.CampaignExecution) models.ExecutionStatus {
+	if ex.CurrentStepOrder == 0 {
+		return models.ExecStatusPending
+	}
+	return models.ExecStatusRunning
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"sync/atomic"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// cycleOutcome is the result of processing one execution during a cycle
+type cycleOutcome int
+
+const (
+	outcomeExecuted cycleOutcome = iota
+	outcomeCompleted
+	outcomeStopped
+	outcomeRescheduled
+	outcomeFailed
+)
+
+// cycleCounters aggregates outcomes from concurrent executions
+type cycleCounters struct {
+	enrolled    atomic.Int64
+	stopped     atomic.Int64
+	claimed     atomic.Int64
+	executed    atomic.Int64
+	completed   atomic.Int64
+	rescheduled atomic.Int64
+	failed      atomic.Int64
+}
+
+func (c *cycleCounters) record(o cycleOutcome) {
+	switch o {
+	case outcomeExecuted:
+		c.executed.Add(1)
+	case outcomeCompleted:
+		// A completing step was executed as well
+		c.executed.Add(1)
+		c.completed.Add(1)
+	case outcomeStopped:
+		c.stopped.Add(1)
+	case outcomeRescheduled:
+		c.rescheduled.Add(1)
+	case outcomeFailed:
+		c.failed.Add(1)
+	}
+}
+
+func (c *cycleCounters) copyTo(cycle *models.CampaignCycle) {
+	cycle.Enrolled = c.enrolled.Load()
+	cycle.Stopped = c.stopped.Load()
+	cycle.Claimed = c.claimed.Load()
+	cycle.Executed = c.executed.Load()
+	cycle.Completed = c.completed.Load()
+	cycle.Rescheduled = c.rescheduled.Load()
+	cycle.Failed = c.failed.Load()
+}
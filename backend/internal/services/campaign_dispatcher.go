@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/repository"
+)
+
+// CampaignDispatcher performs the action of a campaign step for a pending line
+type CampaignDispatcher struct {
+	messages *MessageService
+	voiceSvc *VoiceService
+	voiceID  string // Default ElevenLabs voice
+	msgRepo  *repository.MessageRepository
+	lineRepo *repository.PendingLineRepository
+	taskRepo *repository.TaskRepository
+}
+
+func NewCampaignDispatcher(
+	messages *MessageService,
+	voiceSvc *VoiceService,
+	voiceID string,
+	msgRepo *repository.MessageRepository,
+	lineRepo *repository.PendingLineRepository,
+	taskRepo *repository.TaskRepository,
+) *CampaignDispatcher {
+	return &CampaignDispatcher{
+		messages: messages,
+		voiceSvc: voiceSvc,
+		voiceID:  voiceID,
+		msgRepo:  msgRepo,
+		lineRepo: lineRepo,
+		taskRepo: taskRepo,
+	}
+}
+
+// Dispatch runs a step on the given channel for the lines of group, with a single
+// message for all of them. Messages are sent by the message workers; failures are
+// recorded on the message and do not return an error, only storage errors do and a
+// *WeeklyCapError when the client cannot be contacted this week.
+func (d *CampaignDispatcher) Dispatch(ctx context.Context, ex *models.CampaignExecution, step *models.CampaignStep, channel models.CampaignChannel, group RelanceGroup) error {
+	data := NewRelanceData(group)
+	variant := AssignVariant(step, ex.ID)
+	content := StepContent(step, variant, channel, data)
+
+	switch channel {
+	case models.ChannelNotification:
+		return d.createTask(ctx, ex, group, models.TaskNotification, "Intervention manuelle requise", content)
+
+	case models.ChannelEmail:
+		// No email provider yet
+		slog.Warn("email channel not supported, step skipped", "execID", ex.ID, "step", step.StepOrder)
+		return nil
+	}
+
+	if group.ClientID() == nil {
+		slog.Warn("no client assigned, step skipped", "execID", ex.ID, "lineID", group.Primary().ID)
+		return nil
+	}
+
+	policy := d.messages.PolicyFor(ctx, group.CabinetID())
+	if err := d.messages.CheckWeeklyCap(ctx, policy, *group.ClientID(), nil); err != nil {
+		return err
+	}
+
+	stepOrder := step.StepOrder
+	channelName := string(channel)
+	msg := &models.Message{
+		PendingLineID:       group.LineID(),
+		ClientID:            group.ClientID(),
+		Direction:           models.DirectionOutbound,
+		MessageType:         models.TypeText,
+		Content:             &content,
+		Status:              models.MsgStatusQueued,
+		CampaignExecutionID: &ex.ID,
+		CampaignStepOrder:   &stepOrder,
+		CampaignChannel:     &channelName,
+	}
+	if variant != nil {
+		msg.CampaignVariant = &variant.Key
+	}
+	switch channel {
+	case models.ChannelWhatsApp:
+		if StepButtons(step, variant) {
+			msg.MessageType = models.TypeInteractive
+		}
+	case models.ChannelVoice:
+		msg.MessageType = models.TypeVoice
+		msg.MediaURL = d.voiceNote(ctx, content, group)
+	case models.ChannelCall:
+		msg.MessageType = models.TypeCall
+	}
+	if err := d.msgRepo.Create(ctx, msg); err != nil {
+		return err
+	}
+
+	for _, line := range group.Lines {
+		if err := d.lineRepo.MarkContacted(ctx, line.ID); err != nil {
+			slog.Warn("failed to update pending line status", "lineID", line.ID, "error", err)
+		}
+	}
+
+	client := group.Client()
+	if client == nil || client.Phone == nil || *client.Phone == "" {
+		slog.Warn("campaign message not delivered", "execID", ex.ID, "messageID", msg.ID, "error", "client has no phone number")
+		return d.msgRepo.SetError(ctx, msg.ID, "client has no phone number")
+	}
+
+	if err := d.messages.QueueMessage(ctx, group.CabinetID(), msg, *client.Phone, false); err != nil {
+		slog.Warn("campaign message not queued", "execID", ex.ID, "messageID", msg.ID, "error", err)
+	}
+	return nil
+}
+
+// voiceNote generates the audio of a voice step, nil when it cannot be generated
+// (the script is then sent as text)
+func (d *CampaignDispatcher) voiceNote(ctx context.Context, script string, group RelanceGroup) *string {
+	if d.voiceSvc == nil || d.voiceID == "" {
+		return nil
+	}
+	audio, err := d.voiceSvc.GenerateVoiceMessage(ctx, GenerateVoiceMessageRequest{
+		VoiceID:       d.voiceID,
+		Text:          script,
+		PendingLineID: group.Primary().ID,
+		ConvertToOpus: true,
+	})
+	if err != nil {
+		slog.Warn("voice generation failed, sending text", "lineID", group.Primary().ID, "error", err)
+		return nil
+	}
+	return &audio.AudioURL
+}
+
+// Escalate creates a task for the collaborator assigned to the lines
+func (d *CampaignDispatcher) Escalate(ctx context.Context, ex *models.CampaignExecution, group RelanceGroup, title, note string) error {
+	return d.createTask(ctx, ex, group, models.TaskEscalation, title, note)
+}
+
+func (d *CampaignDispatcher) createTask(ctx context.Context, ex *models.CampaignExecution, group RelanceGroup, taskType models.TaskType, title, description string) error {
+	line := group.Primary()
+	task := &models.Task{
+		CabinetID:           line.CabinetID,
+		Type:                taskType,
+		Title:               title,
+		PendingLineID:       group.LineID(),
+		ClientID:            group.ClientID(),
+		AssignedTo:          line.AssignedTo,
+		CampaignExecutionID: &ex.ID,
+	}
+	if description != "" {
+		task.Description = &description
+	}
+	if err := d.taskRepo.Create(ctx, task); err != nil {
+		return err
+	}
+	slog.Info("Task created", "taskID", task.ID, "type", taskType, "lineID", line.ID, "assignedTo", line.AssignedTo)
+	return nil
+}
@@ -2,31 +2,58 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/fiducia/backend/internal/database"
 	"github.com/fiducia/backend/internal/models"
 	"github.com/fiducia/backend/internal/repository"
 )
 
+const (
+	// claimBatchSize is the number of due executions leased per query
+	claimBatchSize = 100
+	// executionLease is how long a worker owns a claimed execution before others may retry it
+	executionLease = 5 * time.Minute
+	// releaseBackoff is how long an execution whose processing failed waits before
+	// being claimed again
+	releaseBackoff = 15 * time.Minute
+	// defaultConcurrency is the number of executions processed in parallel by a worker
+	defaultConcurrency = 4
+)
+
 type CampaignEngine struct {
+	workerID      string
+	concurrency   int
 	pool          *pgxpool.Pool
 	campaignRepo  *repository.CampaignRepository
 	lineRepo      *repository.PendingLineRepository
 	executionRepo *repository.CampaignExecutionRepository
-	voiceSvc      *VoiceService
+	cabinetRepo   *repository.CabinetRepository
+	cycleRepo     *repository.CampaignCycleRepository
+	dispatcher    *CampaignDispatcher
+	rules         *RuleEvaluator
 }
 
-func NewCampaignEngine(pool *pgxpool.Pool, campaignRepo *repository.CampaignRepository, lineRepo *repository.PendingLineRepository, executionRepo *repository.CampaignExecutionRepository, voiceSvc *VoiceService) *CampaignEngine {
+func NewCampaignEngine(pool *pgxpool.Pool, campaignRepo *repository.CampaignRepository, lineRepo *repository.PendingLineRepository, executionRepo *repository.CampaignExecutionRepository, cabinetRepo *repository.CabinetRepository, cycleRepo *repository.CampaignCycleRepository, dispatcher *CampaignDispatcher, rules *RuleEvaluator) *CampaignEngine {
 	return &CampaignEngine{
+		workerID:      newWorkerID(),
+		concurrency:   defaultConcurrency,
 		pool:          pool,
 		campaignRepo:  campaignRepo,
 		lineRepo:      lineRepo,
 		executionRepo: executionRepo,
-		voiceSvc:      voiceSvc,
+		cabinetRepo:   cabinetRepo,
+		cycleRepo:     cycleRepo,
+		dispatcher:    dispatcher,
+		rules:         rules,
 	}
 }
 
@@ -36,186 +63,563 @@ func (e *CampaignEngine) CheckStopCondition(ctx context.Context, lineID uuid.UUI
 	if err != nil {
 		return false, "", err
 	}
+	reason, stop := StopReasonForLine(line.Status)
+	return stop, reason, nil
+}
+
+// StopReasonForLine returns why a campaign must stop given the status of its line.
+// Keep in sync with CampaignExecutionRepository.StopResolved.
+func StopReasonForLine(status models.PendingLineStatus) (models.StopReason, bool) {
+	switch status {
+	case models.StatusValidated:
+		// Validated (Manual or Auto)
+		return models.StopManualValidated, true
+	case models.StatusRejected:
+		// Client Refusal (Rejected)
+		return models.StopClientRefusal, true
+	case models.StatusReceived:
+		// Document received but not validated yet: stop as well
+		return models.StopOCRValidated, true // Using this reason for now
+	}
+	return "", false
+}
 
-	// 1. Validated (Manual or Auto)
-	if line.Status == models.StatusValidated {
-		return true, models.StopManualValidated, nil
+// SendingScheduleFor returns the sending schedule of a cabinet, using cache to avoid
+// reloading the same cabinet several times within a cycle
+func (e *CampaignEngine) SendingScheduleFor(ctx context.Context, cabinetID uuid.UUID, cache map[uuid.UUID]*SendingSchedule) *SendingSchedule {
+	if s, ok := cache[cabinetID]; ok {
+		return s
 	}
 
-	// 2. Client Refusal (Rejected)
-	if line.Status == models.StatusRejected {
-		return true, models.StopClientRefusal, nil
+	cabinet, err := e.cabinetRepo.GetByID(ctx, cabinetID)
+	if err != nil {
+		slog.Error("failed to load cabinet for sending schedule", "cabinetID", cabinetID, "error", err)
 	}
 
-	// 3. Document received but not validated yet (StatusReceived)
-	// If the rule is "Stop if doc received", then stop.
-	if line.Status == models.StatusReceived {
-		return true, models.StopOCRValidated, nil // Using this reason for now
+	s, err := SendingScheduleForCabinet(cabinet)
+	if err != nil {
+		slog.Warn("invalid sending settings, using defaults", "cabinetID", cabinetID, "error", err)
+		s, _ = NewSendingSchedule(DefaultSendingSettings)
 	}
 
-	return false, "", nil
+	if cache != nil {
+		cache[cabinetID] = s
+	}
+	return s
 }
 
-// IsQuietHours returns true if it's currently quiet hours (after 18h or weekend)
-func (e *CampaignEngine) IsQuietHours(t time.Time) bool {
-	// Weekend (Saturday=6, Sunday=0)
-	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
-		return true
+// ExecuteCampaignCycle is the main entry point called by the worker.
+// It is safe to run concurrently from several replicas: enrollment is done by a
+// single leader, and due executions are leased before being processed.
+// The cycle report is recorded for monitoring and returned.
+func (e *CampaignEngine) ExecuteCampaignCycle(ctx context.Context) (*models.CampaignCycle, error) {
+	cycle := &models.CampaignCycle{WorkerID: e.workerID, StartedAt: time.Now()}
+	var counters cycleCounters
+
+	err := e.runCycle(ctx, cycle, &counters)
+
+	cycle.FinishedAt = time.Now()
+	cycle.DurationMs = cycle.FinishedAt.Sub(cycle.StartedAt).Milliseconds()
+	counters.copyTo(cycle)
+	if err != nil {
+		msg := err.Error()
+		cycle.Error = &msg
 	}
 
-	// After 18h or before 8h
-	hour := t.Hour()
-	if hour >= 18 || hour < 8 {
-		return true
+	slog.Info("Campaign cycle finished",
+		"worker", e.workerID, "leader", cycle.Leader, "duration_ms", cycle.DurationMs,
+		"enrolled", cycle.Enrolled, "stopped", cycle.Stopped, "claimed", cycle.Claimed,
+		"executed", cycle.Executed, "completed", cycle.Completed,
+		"rescheduled", cycle.Rescheduled, "failed", cycle.Failed)
+
+	// Record with a fresh context so that a shutdown still leaves a report
+	recordCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if recErr := e.cycleRepo.Record(recordCtx, cycle); recErr != nil {
+		slog.Warn("failed to record campaign cycle", "error", recErr)
 	}
 
-	return false
+	return cycle, err
 }
 
-// ExecuteCampaignCycle is the main entry point called by the worker
-func (e *CampaignEngine) ExecuteCampaignCycle(ctx context.Context) error {
-	slog.Info("Running campaign cycle...")
+func (e *CampaignEngine) runCycle(ctx context.Context, cycle *models.CampaignCycle, counters *cycleCounters) error {
+	// 1. Enroll new lines (leader only)
+	unlock, leader, err := database.TryAdvisoryLock(ctx, e.pool, database.LockCampaignEnrollment)
+	if err != nil {
+		slog.Error("failed to run enrollment leader election", "error", err)
+	} else if leader {
+		cycle.Leader = true
+		enrolled, err := e.enrollNewLines(ctx)
+		unlock()
+		counters.enrolled.Add(enrolled)
+		if err != nil {
+			// Executions already enrolled are still processed
+			slog.Error("failed to enroll new lines", "error", err)
+		}
+	}
 
-	// 1. Get all active campaigns
-	// 1. Get all active campaigns across ALL cabinets
-	campaigns, err := e.campaignRepo.ListAllActive(ctx)
+	// 2. Resume executions whose pause has ended; other paused executions are never claimed
+	resumed, err := e.executionRepo.ResumeExpiredPauses(ctx)
 	if err != nil {
 		return err
 	}
+	if resumed > 0 {
+		slog.Info("Resumed paused executions", "count", resumed)
+	}
 
+	// 3. Stop executions whose line has been resolved, in bulk
+	stopped, err := e.executionRepo.StopResolved(ctx)
+	if err != nil {
+		return err
+	}
+	counters.stopped.Add(stopped)
+
+	// 4. Process due executions leased to this worker
+	return e.processDueExecutions(ctx, counters)
+}
+
+// enrollNewLines creates executions for lines matching active campaign triggers
+func (e *CampaignEngine) enrollNewLines(ctx context.Context) (int64, error) {
+	// Get all active campaigns across ALL cabinets
+	campaigns, err := e.campaignRepo.ListAllActive(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
 	for _, campaign := range campaigns {
 		if !campaign.IsActive {
 			continue
 		}
 
-		// 1. Enroll New Lines (Trigger: OnPending)
+		// Enroll New Lines (Trigger: OnPending), first step scheduled immediately
 		if campaign.TriggerType == models.TriggerOnPending {
-			unrolledIDs, err := e.executionRepo.FindUnenrolledLines(ctx, campaign.ID, campaign.CabinetID)
+			enroll := e.executionRepo.EnrollPendingLines
+			if campaign.GroupBy == models.GroupByClient {
+				enroll = e.executionRepo.EnrollClientLines
+			}
+			enrolled, err := enroll(ctx, campaign.ID, campaign.CabinetID, time.Now())
 			if err != nil {
-				slog.Error("failed to find unenrolled lines", "campaign", campaign.Name, "error", err)
+				slog.Error("failed to enroll lines", "campaign", campaign.Name, "error", err)
 				continue
 			}
-			for _, lineID := range unrolledIDs {
-				// Create Execution
-				exec := models.CampaignExecution{
-					CampaignID:    campaign.ID,
-					PendingLineID: lineID,
-					Status:        models.ExecStatusPending,
-					// Schedule first step immediately or after delay? Step 1 usually has delay 0.
-					NextStepScheduledAt: ptrTo(time.Now()),
-				}
-				if err := e.executionRepo.Create(ctx, &exec); err != nil {
-					slog.Error("failed to enroll line", "lineID", lineID, "error", err)
-				} else {
-					slog.Info("Enrolled line in campaign", "lineID", lineID, "campaign", campaign.Name)
-				}
+			if enrolled > 0 {
+				slog.Info("Enrolled lines in campaign", "count", enrolled, "campaign", campaign.Name)
 			}
+			total += enrolled
 		}
 	}
 
-	// 2. Process Active Executions
-	executions, err := e.executionRepo.FindActive(ctx)
-	if err != nil {
-		return err
+	return total, nil
+}
+
+// processDueExecutions claims due executions in batches and runs their next step,
+// with at most e.concurrency executions processed at the same time
+func (e *CampaignEngine) processDueExecutions(ctx context.Context, counters *cycleCounters) error {
+	campaigns := make(map[uuid.UUID]*models.Campaign)
+	versions := make(map[versionKey]*models.Campaign)
+	schedules := make(map[uuid.UUID]*SendingSchedule)
+
+	for {
+		executions, err := e.executionRepo.ClaimDue(ctx, e.workerID, claimBatchSize, executionLease)
+		if err != nil {
+			return err
+		}
+		if len(executions) == 0 {
+			return nil
+		}
+		counters.claimed.Add(int64(len(executions)))
+
+		// Load campaigns and sending schedules for the whole batch up front
+		var missing []uuid.UUID
+		for _, ex := range executions {
+			if _, ok := campaigns[ex.CampaignID]; !ok {
+				campaigns[ex.CampaignID] = nil
+				missing = append(missing, ex.CampaignID)
+			}
+		}
+		loaded, err := e.campaignRepo.GetByIDs(ctx, missing)
+		if err != nil {
+			for _, ex := range executions {
+				e.release(ctx, ex)
+			}
+			return err
+		}
+		for id, c := range loaded {
+			campaigns[id] = c
+			e.SendingScheduleFor(ctx, c.CabinetID, schedules)
+		}
+		if err := e.loadVersions(ctx, executions, campaigns, versions); err != nil {
+			for _, ex := range executions {
+				e.release(ctx, ex)
+			}
+			return err
+		}
+
+		groups, err := e.loadGroups(ctx, executions)
+		if err != nil {
+			for _, ex := range executions {
+				e.release(ctx, ex)
+			}
+			return err
+		}
+
+		sem := make(chan struct{}, e.concurrency)
+		var wg sync.WaitGroup
+		for _, ex := range executions {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(ex models.CampaignExecution) {
+				defer func() { <-sem; wg.Done() }()
+				camp := pinnedCampaign(ex, campaigns, versions)
+				var schedule *SendingSchedule
+				if camp != nil {
+					schedule = schedules[camp.CabinetID]
+				}
+				counters.record(e.processExecution(ctx, ex, camp, groups[ex.ID], schedule))
+			}(ex)
+		}
+		wg.Wait()
+
+		if len(executions) < claimBatchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
 	}
+}
 
+// versionKey identifies a version of a campaign
+type versionKey struct {
+	campaignID uuid.UUID
+	version    int
+}
+
+// loadVersions loads the steps of the campaign versions that executions are pinned to,
+// when they are not the current version
+func (e *CampaignEngine) loadVersions(ctx context.Context, executions []models.CampaignExecution, campaigns map[uuid.UUID]*models.Campaign, versions map[versionKey]*models.Campaign) error {
+	missing := make(map[uuid.UUID][]int)
 	for _, ex := range executions {
-		// Load Campaign info to check QuietHours
-		camp, err := e.campaignRepo.GetByID(ctx, ex.CampaignID)
-		if err != nil || camp == nil {
+		c := campaigns[ex.CampaignID]
+		key := versionKey{ex.CampaignID, ex.CampaignVersion}
+		if c == nil || c.Version == ex.CampaignVersion {
 			continue
 		}
+		if _, ok := versions[key]; !ok {
+			versions[key] = nil
+			missing[ex.CampaignID] = append(missing[ex.CampaignID], ex.CampaignVersion)
+		}
+	}
 
-		// Check Stop Condition
-		shouldStop, reason, err := e.CheckStopCondition(ctx, ex.PendingLineID)
+	for campaignID, nums := range missing {
+		steps, err := e.campaignRepo.GetVersionSteps(ctx, campaignID, nums)
 		if err != nil {
-			slog.Error("failed to check stop condition", "execID", ex.ID, "error", err)
-			continue
+			return err
 		}
-		if shouldStop {
-			ex.Status = models.ExecStatusStopped
-			ex.StopReason = &reason
-			e.executionRepo.Update(ctx, &ex)
-			slog.Info("Campaign stopped", "execID", ex.ID, "reason", reason)
-			continue
+		for version, s := range steps {
+			pinned := *campaigns[campaignID]
+			pinned.Version = version
+			pinned.Steps = s
+			versions[versionKey{campaignID, version}] = &pinned
 		}
+	}
+	return nil
+}
 
-		// Check Schedule
-		if ex.NextStepScheduledAt == nil || time.Now().Before(*ex.NextStepScheduledAt) {
-			continue
+// pinnedCampaign returns the campaign with the steps of the version the execution runs,
+// nil if it could not be loaded
+func pinnedCampaign(ex models.CampaignExecution, campaigns map[uuid.UUID]*models.Campaign, versions map[versionKey]*models.Campaign) *models.Campaign {
+	c := campaigns[ex.CampaignID]
+	if c == nil || c.Version == ex.CampaignVersion {
+		return c
+	}
+	return versions[versionKey{ex.CampaignID, ex.CampaignVersion}]
+}
+
+// loadGroups loads the lines relanced by each execution: its line, or the open
+// lines of its client
+func (e *CampaignEngine) loadGroups(ctx context.Context, executions []models.CampaignExecution) (map[uuid.UUID]RelanceGroup, error) {
+	var lineIDs, clientExecIDs []uuid.UUID
+	for _, ex := range executions {
+		if ex.PendingLineID != nil {
+			lineIDs = append(lineIDs, *ex.PendingLineID)
+		} else {
+			clientExecIDs = append(clientExecIDs, ex.ID)
 		}
+	}
 
-		// Check Quiet Hours
-		if camp.QuietHoursEnabled && e.IsQuietHours(time.Now()) {
-			slog.Info("Skipping execution due to Quiet Hours", "execID", ex.ID)
-			continue
+	open, err := e.executionRepo.OpenLineIDs(ctx, clientExecIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, ids := range open {
+		lineIDs = append(lineIDs, ids...)
+	}
+	lines, err := e.lineRepo.GetByIDs(ctx, lineIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[uuid.UUID]RelanceGroup, len(executions))
+	for _, ex := range executions {
+		var g RelanceGroup
+		if ex.PendingLineID != nil {
+			if line, ok := lines[*ex.PendingLineID]; ok {
+				g.Lines = append(g.Lines, line)
+			}
+		} else {
+			for _, id := range open[ex.ID] {
+				// Lines resolved since the last cycle are marked by the next StopResolved
+				if line, ok := lines[id]; ok {
+					if _, resolved := StopReasonForLine(line.Status); !resolved {
+						g.Lines = append(g.Lines, line)
+					}
+				}
+			}
 		}
+		groups[ex.ID] = g
+	}
+	return groups, nil
+}
 
-		// Execute Step
-		if err := e.executeNextStep(ctx, &ex, *camp); err != nil {
-			slog.Error("failed to execute step", "execID", ex.ID, "error", err)
+// processExecution handles a single leased execution. Every path either updates
+// the execution (which releases the lease) or releases it explicitly.
+func (e *CampaignEngine) processExecution(ctx context.Context, ex models.CampaignExecution, camp *models.Campaign, group RelanceGroup, schedule *SendingSchedule) cycleOutcome {
+	if camp == nil || (len(group.Lines) == 0 && ex.ClientID == nil) {
+		e.release(ctx, ex)
+		return outcomeFailed
+	}
+
+	// Check Stop Condition (line status was loaded with the claim)
+	if ex.LineStatus != nil {
+		if reason, stop := StopReasonForLine(*ex.LineStatus); stop {
+			return e.stop(ctx, ex, reason)
 		}
 	}
+	if len(group.Lines) == 0 {
+		// Every line of the client has been resolved
+		return e.stop(ctx, ex, models.StopLinesResolved)
+	}
 
-	return nil
+	// Check Quiet Hours: push the step to the next allowed slot
+	if camp.QuietHoursEnabled && schedule.IsQuiet(time.Now()) {
+		next, ok := schedule.NextAllowed(time.Now())
+		if !ok {
+			slog.Warn("No sending window configured, execution retried later", "execID", ex.ID, "cabinetID", camp.CabinetID)
+			e.release(ctx, ex)
+			return outcomeFailed
+		}
+		ex.NextStepScheduledAt = &next
+		if err := e.executionRepo.Update(ctx, &ex, e.workerID); err != nil {
+			e.logSaveError("failed to reschedule execution", ex, err)
+			return outcomeFailed
+		}
+		slog.Info("Rescheduled execution due to Quiet Hours", "execID", ex.ID, "next", next)
+		return outcomeRescheduled
+	}
+
+	// Execute Step
+	if err := e.executeNextStep(ctx, &ex, *camp, group, schedule); err != nil {
+		if errors.Is(err, repository.ErrLeaseLost) {
+			// The worker holding the execution now owns its state: drop this result
+			e.logSaveError("failed to execute step", ex, err)
+			return outcomeFailed
+		}
+		slog.Error("failed to execute step", "execID", ex.ID, "error", err)
+		e.release(ctx, ex)
+		return outcomeFailed
+	}
+	switch ex.Status {
+	case models.ExecStatusCompleted:
+		return outcomeCompleted
+	case models.ExecStatusStopped:
+		return outcomeStopped
+	}
+	return outcomeExecuted
 }
 
-func (e *CampaignEngine) executeNextStep(ctx context.Context, ex *models.CampaignExecution, campaign models.Campaign) error {
-	// Find the step matching current_step_order + 1 (if starting) or next
-	// Logic: ex.CurrentStepOrder is the LAST executed step. So look for CurrentStepOrder + 1
-	nextOrder := ex.CurrentStepOrder + 1
+func (e *CampaignEngine) stop(ctx context.Context, ex models.CampaignExecution, reason models.StopReason) cycleOutcome {
+	ex.Status = models.ExecStatusStopped
+	ex.StopReason = &reason
+	ex.NextStepScheduledAt = nil
+	if err := e.executionRepo.Update(ctx, &ex, e.workerID); err != nil {
+		e.logSaveError("failed to stop execution", ex, err)
+		return outcomeFailed
+	}
+	slog.Info("Campaign stopped", "execID", ex.ID, "reason", reason)
+	return outcomeStopped
+}
 
-	// Find step in campaign.Steps
-	var nextStep *models.CampaignStep
-	for _, s := range campaign.Steps {
-		if s.StepOrder == nextOrder {
-			nextStep = &s
-			break
-		}
+// logSaveError logs a failed save of an execution, a lost lease being expected when
+// processing outlasted it
+func (e *CampaignEngine) logSaveError(msg string, ex models.CampaignExecution, err error) {
+	if errors.Is(err, repository.ErrLeaseLost) {
+		slog.Warn("execution lease lost, result dropped", "execID", ex.ID, "worker", e.workerID)
+		return
+	}
+	slog.Error(msg, "execID", ex.ID, "error", err)
+}
+
+// release drops the lease of an execution that could not be processed, retried after
+// releaseBackoff
+func (e *CampaignEngine) release(ctx context.Context, ex models.CampaignExecution) {
+	if err := e.executionRepo.Release(ctx, ex.ID, e.workerID, time.Now().Add(releaseBackoff)); err != nil {
+		slog.Warn("failed to release execution lease", "execID", ex.ID, "error", err)
 	}
+}
 
-	if nextStep == nil {
-		// No more steps -> Complete
-		ex.Status = models.ExecStatusCompleted
-		stopReason := models.StopCompleted
-		ex.StopReason = &stopReason
-		return e.executionRepo.Update(ctx, ex)
+func (e *CampaignEngine) executeNextStep(ctx context.Context, ex *models.CampaignExecution, campaign models.Campaign, group RelanceGroup, schedule *SendingSchedule) error {
+	now := time.Now()
+
+	// Logic: ex.CurrentStepOrder is the LAST executed step. So look for CurrentStepOrder + 1
+	step := findStep(campaign.Steps, ex.CurrentStepOrder+1)
+	if step == nil {
+		// No more steps -> Complete, or hand over to the collaborator
+		return e.finish(ctx, ex, campaign, group)
+	}
+
+	// Evaluate branching rules of the due step
+	channel := step.Channel
+	if len(step.Rules) > 0 {
+		rule, err := e.rules.Match(ctx, ex, group, step.Rules, now)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			slog.Info("Step rule matched", "execID", ex.ID, "step", step.StepOrder,
+				"condition", rule.Condition.Type, "action", rule.Action.Type)
+
+			switch rule.Action.Type {
+			case models.ActionGoto:
+				if target := findStep(campaign.Steps, rule.Action.StepOrder); target != nil && target.StepOrder > step.StepOrder {
+					step = target
+					channel = target.Channel
+				}
+			case models.ActionSwitchChannel:
+				channel = rule.Action.Channel
+			case models.ActionSkip:
+				ex.CurrentStepOrder = step.StepOrder
+				e.scheduleAfter(ex, campaign, step, schedule, now)
+				return e.executionRepo.Update(ctx, ex, e.workerID)
+			case models.ActionEscalate:
+				note := rule.Action.Note
+				if note == "" {
+					note = fmt.Sprintf("Règle déclenchée à l'étape %d (%s)", step.StepOrder, rule.Condition.Type)
+				}
+				if err := e.dispatcher.Escalate(ctx, ex, group, "Relance à reprendre manuellement", note); err != nil {
+					return err
+				}
+				stopReason := models.StopEscalated
+				ex.Status = models.ExecStatusStopped
+				ex.StopReason = &stopReason
+				ex.NextStepScheduledAt = nil
+				return e.executionRepo.Update(ctx, ex, e.workerID)
+			}
+		}
 	}
 
 	// Execute Action
-	slog.Info("EXECUTE ACTION", "channel", nextStep.Channel, "lineID", ex.PendingLineID)
-	// Here we would call EmailService or WhatsAppService
-	// e.g. e.msgSvc.Send(..., nextStep.TemplateID, ...)
+	slog.Info("EXECUTE ACTION", "channel", channel, "step", step.StepOrder, "execID", ex.ID, "lines", len(group.Lines))
+	if err := e.dispatcher.Dispatch(ctx, ex, step, channel, group); err != nil {
+		var capErr *WeeklyCapError
+		if !errors.As(err, &capErr) {
+			return err
+		}
+		// Run the step again when the client can be contacted
+		next := capErr.Until
+		if campaign.QuietHoursEnabled {
+			if allowed, ok := schedule.NextAllowed(next); ok {
+				next = allowed
+			}
+		}
+		slog.Info("Step postponed, weekly cap reached", "execID", ex.ID, "step", step.StepOrder, "until", next)
+		ex.NextStepScheduledAt = &next
+		return e.executionRepo.Update(ctx, ex, e.workerID)
+	}
 
 	// Advance State
-	ex.CurrentStepOrder = nextOrder
-	ex.LastStepExecutedAt = ptrTo(time.Now())
+	ex.CurrentStepOrder = step.StepOrder
+	ex.LastStepExecutedAt = &now
+	e.scheduleAfter(ex, campaign, step, schedule, now)
+
+	return e.executionRepo.Update(ctx, ex, e.workerID)
+}
 
-	// Schedule Next Step (if any)
-	futureOrder := nextOrder + 1
-	var futureStep *models.CampaignStep
-	for _, s := range campaign.Steps {
-		if s.StepOrder == futureOrder {
-			futureStep = &s
-			break
+// scheduleAfter schedules what follows step: the next step after its delay, an
+// escalation check, or completion
+func (e *CampaignEngine) scheduleAfter(ex *models.CampaignExecution, campaign models.Campaign, step *models.CampaignStep, schedule *SendingSchedule, now time.Time) {
+	futureStep := findStep(campaign.Steps, step.StepOrder+1)
+
+	switch {
+	case futureStep != nil:
+		// Schedule for Now + Delay, moved to the next sending window if needed
+		nextTime := now.Add(time.Duration(futureStep.DelayHours) * time.Hour)
+		if campaign.QuietHoursEnabled {
+			if allowed, ok := schedule.NextAllowed(nextTime); ok {
+				nextTime = allowed
+			}
 		}
-	}
+		ex.NextStepScheduledAt = &nextTime
+		ex.Status = models.ExecStatusRunning
 
-	if futureStep != nil {
-		// Schedule for Now + Delay
-		delay := time.Duration(futureStep.DelayHours) * time.Hour
-		nextTime := time.Now().Add(delay)
+	case campaign.EscalateOnExhaustion:
+		// Give the client time to answer the last step before escalating
+		nextTime := now.Add(time.Duration(campaign.EscalateAfterHours) * time.Hour)
 		ex.NextStepScheduledAt = &nextTime
 		ex.Status = models.ExecStatusRunning
-	} else {
+
+	default:
 		// Finished
 		ex.Status = models.ExecStatusCompleted
 		ex.NextStepScheduledAt = nil
 		stopReason := models.StopCompleted
 		ex.StopReason = &stopReason
 	}
+}
+
+// finish closes an execution that has no step left. An escalated execution is stopped,
+// as when a step rule escalates it.
+func (e *CampaignEngine) finish(ctx context.Context, ex *models.CampaignExecution, campaign models.Campaign, group RelanceGroup) error {
+	status, stopReason := models.ExecStatusCompleted, models.StopCompleted
+	if campaign.EscalateOnExhaustion {
+		note := fmt.Sprintf("Aucun justificatif reçu après les %d relances de la campagne %q.", ex.CurrentStepOrder, campaign.Name)
+		if err := e.dispatcher.Escalate(ctx, ex, group, "Relances automatiques épuisées", note); err != nil {
+			return err
+		}
+		status, stopReason = models.ExecStatusStopped, models.StopEscalated
+	}
+
+	ex.Status = status
+	ex.StopReason = &stopReason
+	ex.NextStepScheduledAt = nil
+	return e.executionRepo.Update(ctx, ex, e.workerID)
+}
+
+func findStep(steps []models.CampaignStep, order int) *models.CampaignStep {
+	for i := range steps {
+		if steps[i].StepOrder == order {
+			return &steps[i]
+		}
+	}
+	return nil
+}
 
-	return e.executionRepo.Update(ctx, ex)
+// SetConcurrency sets how many executions a worker processes in parallel
+func (e *CampaignEngine) SetConcurrency(n int) {
+	if n > 0 {
+		e.concurrency = n
+	}
+}
+
+// WorkerID identifies this engine instance in execution leases
+func (e *CampaignEngine) WorkerID() string {
+	return e.workerID
+}
+
+func newWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.New().String()[:8])
 }
 
 func ptrTo[T any](v T) *T {
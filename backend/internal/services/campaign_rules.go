@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/repository"
+)
+
+// ValidateCampaign checks the steps of a campaign and their rules before it is saved
+func ValidateCampaign(c *models.Campaign) error {
+	orders := make(map[int]bool, len(c.Steps))
+	for _, s := range c.Steps {
+		if s.StepOrder < 1 {
+			return fmt.Errorf("step order must be positive")
+		}
+		if orders[s.StepOrder] {
+			return fmt.Errorf("duplicate step order %d", s.StepOrder)
+		}
+		orders[s.StepOrder] = true
+		if s.DelayHours < 0 {
+			return fmt.Errorf("step %d: delay must not be negative", s.StepOrder)
+		}
+		if !validChannel(s.Channel) {
+			return fmt.Errorf("step %d: unknown channel %q", s.StepOrder, s.Channel)
+		}
+		if err := validateVariants(s.Variants); err != nil {
+			return fmt.Errorf("step %d: %w", s.StepOrder, err)
+		}
+	}
+	if c.EscalateAfterHours < 0 {
+		return fmt.Errorf("escalation delay must not be negative")
+	}
+	if c.GroupBy != models.GroupByLine && c.GroupBy != models.GroupByClient {
+		return fmt.Errorf("unknown grouping %q", c.GroupBy)
+	}
+	if err := validateTargeting(c.Targeting); err != nil {
+		return fmt.Errorf("targeting: %w", err)
+	}
+
+	for _, s := range c.Steps {
+		for i, rule := range s.Rules {
+			if err := validateRule(rule, s.StepOrder, orders); err != nil {
+				return fmt.Errorf("step %d, rule %d: %w", s.StepOrder, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateTargeting(t *models.CampaignTargeting) error {
+	if t == nil {
+		return nil
+	}
+	if t.MinAmount != nil && t.MinAmount.IsNegative() || t.MaxAmount != nil && t.MaxAmount.IsNegative() {
+		return fmt.Errorf("amounts must not be negative")
+	}
+	if t.MinAmount != nil && t.MaxAmount != nil && t.MinAmount.GreaterThan(*t.MaxAmount) {
+		return fmt.Errorf("min_amount must not exceed max_amount")
+	}
+	for _, p := range t.AccountPrefixes {
+		if p == "" {
+			return fmt.Errorf("account prefixes must not be empty")
+		}
+	}
+	for _, tag := range t.ClientTags {
+		if tag == "" {
+			return fmt.Errorf("client tags must not be empty")
+		}
+	}
+	if t.MinAgeDays < 0 {
+		return fmt.Errorf("min_age_days must not be negative")
+	}
+	return nil
+}
+
+func validateRule(rule models.StepRule, stepOrder int, orders map[int]bool) error {
+	switch rule.Condition.Type {
+	case models.CondNotReadAfter:
+		if rule.Condition.Hours <= 0 {
+			return fmt.Errorf("hours is required")
+		}
+	case models.CondRepliedWithoutDocument:
+	case models.CondAmountAbove, models.CondAmountBelow:
+		if rule.Condition.Amount == nil {
+			return fmt.Errorf("amount is required")
+		}
+	default:
+		return fmt.Errorf("unknown condition %q", rule.Condition.Type)
+	}
+
+	switch rule.Action.Type {
+	case models.ActionGoto:
+		// Forward jumps only, so that a sequence always ends
+		if rule.Action.StepOrder <= stepOrder || !orders[rule.Action.StepOrder] {
+			return fmt.Errorf("goto must target a later existing step")
+		}
+	case models.ActionSwitchChannel:
+		if !validChannel(rule.Action.Channel) {
+			return fmt.Errorf("unknown channel %q", rule.Action.Channel)
+		}
+	case models.ActionSkip, models.ActionEscalate:
+	default:
+		return fmt.Errorf("unknown action %q", rule.Action.Type)
+	}
+	return nil
+}
+
+func validChannel(c models.CampaignChannel) bool {
+	switch c {
+	case models.ChannelWhatsApp, models.ChannelEmail, models.ChannelVoice, models.ChannelCall, models.ChannelNotification:
+		return true
+	}
+	return false
+}
+
+// RuleEvaluator decides which step rule applies to an execution
+type RuleEvaluator struct {
+	msgRepo *repository.MessageRepository
+	docRepo *repository.DocumentRepository
+}
+
+func NewRuleEvaluator(msgRepo *repository.MessageRepository, docRepo *repository.DocumentRepository) *RuleEvaluator {
+	return &RuleEvaluator{msgRepo: msgRepo, docRepo: docRepo}
+}
+
+// Match returns the first rule whose condition holds at now, or nil.
+// Message history is only loaded when a condition needs it.
+func (r *RuleEvaluator) Match(ctx context.Context, ex *models.CampaignExecution, group RelanceGroup, rules []models.StepRule, now time.Time) (*models.StepRule, error) {
+	for i := range rules {
+		ok, err := r.holds(ctx, ex, group, rules[i].Condition, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *RuleEvaluator) holds(ctx context.Context, ex *models.CampaignExecution, group RelanceGroup, cond models.StepCondition, now time.Time) (bool, error) {
+	switch cond.Type {
+	case models.CondAmountAbove:
+		return cond.Amount != nil && group.Total().GreaterThan(*cond.Amount), nil
+
+	case models.CondAmountBelow:
+		return cond.Amount != nil && group.Total().LessThan(*cond.Amount), nil
+
+	case models.CondNotReadAfter:
+		last, err := r.msgRepo.LastByExecution(ctx, ex.ID)
+		if err != nil || last == nil {
+			return false, err
+		}
+		sentAt := last.CreatedAt
+		if last.SentAt != nil {
+			sentAt = *last.SentAt
+		}
+		unread := last.ReadAt == nil && last.Status != models.MsgStatusRead
+		return unread && now.Sub(sentAt) >= time.Duration(cond.Hours)*time.Hour, nil
+
+	case models.CondRepliedWithoutDocument:
+		clientID := group.ClientID()
+		if clientID == nil || ex.LastStepExecutedAt == nil {
+			return false, nil
+		}
+		replies, err := r.msgRepo.CountInboundSince(ctx, *clientID, *ex.LastStepExecutedAt)
+		if err != nil || replies == 0 {
+			return false, err
+		}
+		docs, err := r.docRepo.CountByClientSince(ctx, *clientID, *ex.LastStepExecutedAt)
+		if err != nil {
+			return false, err
+		}
+		return docs == 0, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/pkg/whatsapp"
+)
+
+// maxSimulatedSteps bounds a timeline in case of unexpected rule combinations
+const maxSimulatedSteps = 50
+
+// simulationAssumptions explains how conditions that depend on the client are evaluated
+var simulationAssumptions = []string{
+	"Le client ne réagit à aucune relance : aucun message n'est lu, aucune réponse ni aucun justificatif n'est reçu.",
+	"Les lignes éligibles sont celles en attente au moment de la simulation.",
+	"Aucun message n'est envoyé et aucune tâche n'est créée pendant la simulation.",
+	"Les variantes A/B sont affichées avec leur poids : chaque exécution en reçoit une, tirée à son inscription.",
+	"Le plafond hebdomadaire par client ne compte que les messages de la simulation, ni ceux déjà envoyés ni ceux des autres campagnes.",
+	"La fenêtre de session WhatsApp de 24 h n'est ouverte que par les messages déjà reçus du client : hors fenêtre, le modèle de session est envoyé.",
+}
+
+// simulationLimits are the sending limits of the cabinet applied by a dry run
+type simulationLimits struct {
+	maxPerWeek      int    // Weekly cap per client, 0 for none
+	sessionTemplate string // Template sent outside the session window, empty when none
+}
+
+// Simulate runs the enrollment and scheduling logic of a campaign on a virtual clock
+// starting at start. It returns the planned timeline of up to limit lines and never
+// sends anything nor writes to the database.
+func (e *CampaignEngine) Simulate(ctx context.Context, campaign *models.Campaign, start time.Time, limit int) (*models.CampaignSimulation, error) {
+	lineIDs, err := e.executionRepo.FindUnenrolledLines(ctx, campaign.ID, campaign.CabinetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find eligible lines: %w", err)
+	}
+
+	schedule := e.SendingScheduleFor(ctx, campaign.CabinetID, nil)
+	sim := &models.CampaignSimulation{
+		CampaignID:  campaign.ID,
+		StartAt:     start,
+		Timezone:    schedule.Location().String(),
+		TotalLines:  len(lineIDs),
+		Lines:       make([]models.SimulatedLine, 0),
+		Assumptions: simulationAssumptions,
+	}
+
+	if limit > 0 && len(lineIDs) > limit {
+		lineIDs = lineIDs[:limit]
+	}
+	lines, err := e.lineRepo.GetByIDs(ctx, lineIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var limits simulationLimits
+	if e.dispatcher != nil && e.dispatcher.messages != nil {
+		provider, policy := e.dispatcher.messages.route(ctx, campaign.CabinetID)
+		limits = simulationLimits{maxPerWeek: policy.MaxPerClientPerWeek, sessionTemplate: provider.SessionTemplate}
+	}
+
+	for _, group := range simulationGroups(campaign.GroupBy, lineIDs, lines) {
+		sim.Lines = append(sim.Lines, simulateGroup(*campaign, group, schedule, limits, start))
+	}
+	return sim, nil
+}
+
+// simulationGroups groups lines the way enrollment does: one group per line, or one
+// per client (lines without a client are not enrolled) for client campaigns
+func simulationGroups(groupBy models.CampaignGroupBy, lineIDs []uuid.UUID, lines map[uuid.UUID]*models.PendingLine) []RelanceGroup {
+	var groups []RelanceGroup
+	byClient := make(map[uuid.UUID]int)
+	for _, id := range lineIDs {
+		line, ok := lines[id]
+		if !ok {
+			continue
+		}
+		if groupBy != models.GroupByClient {
+			groups = append(groups, SingleLine(line))
+			continue
+		}
+		if line.ClientID == nil {
+			continue
+		}
+		if i, ok := byClient[*line.ClientID]; ok {
+			groups[i].Lines = append(groups[i].Lines, line)
+			continue
+		}
+		byClient[*line.ClientID] = len(groups)
+		groups = append(groups, SingleLine(line))
+	}
+	return groups
+}
+
+// simulateGroup mirrors executeNextStep and scheduleAfter for a single execution
+func simulateGroup(campaign models.Campaign, group RelanceGroup, schedule *SendingSchedule, limits simulationLimits, start time.Time) models.SimulatedLine {
+	line := group.Primary()
+	out := models.SimulatedLine{
+		PendingLineID: line.ID,
+		ClientID:      line.ClientID,
+		Amount:        group.Total(),
+		Timeline:      make([]models.SimulatedStep, 0),
+	}
+	if len(group.Lines) > 1 {
+		for _, l := range group.Lines {
+			out.PendingLineIDs = append(out.PendingLineIDs, l.ID)
+		}
+	}
+	if line.Client != nil {
+		out.ClientName = line.Client.Name
+		out.Phone = line.Client.Phone
+	}
+	if line.ClientID == nil {
+		out.Warnings = append(out.Warnings, "Aucun client associé : les messages ne seront pas envoyés.")
+	} else if line.Client == nil || line.Client.Phone == nil || *line.Client.Phone == "" {
+		out.Warnings = append(out.Warnings, "Le client n'a pas de numéro de téléphone : les messages échoueront.")
+	}
+
+	data := NewRelanceData(group)
+	current := 0 // Last executed step order
+	due := start // Enrollment schedules the first step immediately
+	var lastSent *time.Time
+	var sent []time.Time // Messages sent to the client, for the weekly cap
+	postponed := ""      // Why the step was postponed by the weekly cap
+
+	for i := 0; i < maxSimulatedSteps; i++ {
+		at, moved := due, postponed != ""
+		step := findStep(campaign.Steps, current+1)
+
+		// The engine checks quiet hours before anything else, escalation included
+		if campaign.QuietHoursEnabled {
+			if next, ok := schedule.NextAllowed(due); ok {
+				at, moved = next, moved || !next.Equal(due)
+			}
+		}
+
+		if step == nil {
+			if campaign.EscalateOnExhaustion {
+				out.Timeline = append(out.Timeline, models.SimulatedStep{
+					StepOrder:   current,
+					Action:      models.SimEscalate,
+					ScheduledAt: at,
+					Note:        "Relances automatiques épuisées : tâche créée pour le collaborateur",
+				})
+			}
+			return out
+		}
+
+		entry := models.SimulatedStep{StepOrder: step.StepOrder, Channel: step.Channel, ScheduledAt: at, Rescheduled: moved, Note: postponed}
+		postponed = ""
+
+		if rule := simulateRules(step.Rules, group, lastSent, at); rule != nil {
+			entry.Rule = rule
+			switch rule.Action.Type {
+			case models.ActionGoto:
+				if target := findStep(campaign.Steps, rule.Action.StepOrder); target != nil && target.StepOrder > step.StepOrder {
+					step = target
+					entry.StepOrder, entry.Channel = target.StepOrder, target.Channel
+				}
+			case models.ActionSwitchChannel:
+				entry.Channel = rule.Action.Channel
+			case models.ActionSkip:
+				entry.Action = models.SimSkip
+				out.Timeline = append(out.Timeline, entry)
+				current = step.StepOrder
+				due = simulatedNext(campaign, step, at)
+				continue
+			case models.ActionEscalate:
+				entry.Action = models.SimEscalate
+				entry.Note = rule.Action.Note
+				out.Timeline = append(out.Timeline, entry)
+				return out
+			}
+		}
+
+		switch entry.Channel {
+		case models.ChannelNotification:
+			entry.Action = models.SimTask
+		case models.ChannelEmail:
+			entry.Action = models.SimSkip
+			entry.Note = "Canal email non disponible"
+		default:
+			entry.Action = models.SimSend
+			if line.ClientID == nil {
+				entry.Action = models.SimSkip
+				entry.Note = "Aucun client associé"
+				break
+			}
+			// The dispatcher runs the step again once the client can be contacted
+			if until, capped := weeklyCapUntil(sent, at, limits.maxPerWeek); capped {
+				due = until
+				postponed = fmt.Sprintf("Reportée : plafond de %d messages par semaine atteint", limits.maxPerWeek)
+				continue
+			}
+			sentAt := at
+			lastSent = &sentAt
+			sent = append(sent, at)
+			if entry.Channel != models.ChannelCall && !sessionOpen(line.Client, at) {
+				entry.Note = joinNotes(entry.Note, sessionNote(limits.sessionTemplate))
+			}
+		}
+		entry.Variants = simulateVariants(step, entry.Channel, data)
+		if entry.Variants == nil {
+			entry.Content = StepContent(step, nil, entry.Channel, data)
+		}
+		out.Timeline = append(out.Timeline, entry)
+
+		current = step.StepOrder
+		due = simulatedNext(campaign, step, at)
+	}
+
+	return out
+}
+
+// simulateVariants returns the variants of step an execution may receive, with their
+// share of the executions, nil when the step has none
+func simulateVariants(step *models.CampaignStep, channel models.CampaignChannel, data RelanceData) []models.SimulatedVariant {
+	total := 0
+	for _, v := range step.Variants {
+		total += max(v.Weight, 0)
+	}
+	if total == 0 {
+		return nil
+	}
+	var out []models.SimulatedVariant
+	for i := range step.Variants {
+		v := &step.Variants[i]
+		if v.Weight <= 0 {
+			continue // Never assigned
+		}
+		out = append(out, models.SimulatedVariant{
+			Key:     v.Key,
+			Weight:  v.Weight,
+			Share:   float64(v.Weight) / float64(total),
+			Content: StepContent(step, v, channel, data),
+		})
+	}
+	return out
+}
+
+// weeklyCapUntil mirrors CheckWeeklyCap on the messages sent during the simulation: it
+// reports whether the client already received limit messages over the 7 days before at,
+// and until when
+func weeklyCapUntil(sent []time.Time, at time.Time, limit int) (time.Time, bool) {
+	if limit <= 0 {
+		return time.Time{}, false
+	}
+	const week = 7 * 24 * time.Hour
+	count := 0
+	var oldest time.Time
+	for _, t := range sent {
+		if at.Sub(t) >= week {
+			continue
+		}
+		if count == 0 || t.Before(oldest) {
+			oldest = t
+		}
+		count++
+	}
+	if count < limit {
+		return time.Time{}, false
+	}
+	return oldest.Add(week), true
+}
+
+// sessionOpen reports whether the WhatsApp session window of client is open at at,
+// the client sending nothing during the simulation
+func sessionOpen(client *models.Client, at time.Time) bool {
+	return client != nil && client.LastInboundAt != nil && at.Before(client.LastInboundAt.Add(whatsapp.SessionWindow))
+}
+
+// sessionNote tells how a message is sent outside the session window
+func sessionNote(template string) string {
+	if template == "" {
+		return "Hors fenêtre de session de 24 h et sans modèle de session configuré : le message échouera."
+	}
+	return fmt.Sprintf("Hors fenêtre de session de 24 h : envoyé avec le modèle %s.", template)
+}
+
+// joinNotes appends note to the notes of a step
+func joinNotes(notes, note string) string {
+	if notes == "" {
+		return note
+	}
+	return notes + " " + note
+}
+
+// simulatedNext returns when the engine would run again after step executed at at
+func simulatedNext(campaign models.Campaign, step *models.CampaignStep, at time.Time) time.Time {
+	if next := findStep(campaign.Steps, step.StepOrder+1); next != nil {
+		return at.Add(time.Duration(next.DelayHours) * time.Hour)
+	}
+	if campaign.EscalateOnExhaustion {
+		return at.Add(time.Duration(campaign.EscalateAfterHours) * time.Hour)
+	}
+	return at
+}
+
+// simulateRules evaluates step rules assuming the client never reacts
+func simulateRules(rules []models.StepRule, group RelanceGroup, lastSent *time.Time, at time.Time) *models.StepRule {
+	for i := range rules {
+		cond := rules[i].Condition
+		var holds bool
+		switch cond.Type {
+		case models.CondAmountAbove:
+			holds = cond.Amount != nil && group.Total().GreaterThan(*cond.Amount)
+		case models.CondAmountBelow:
+			holds = cond.Amount != nil && group.Total().LessThan(*cond.Amount)
+		case models.CondNotReadAfter:
+			holds = lastSent != nil && at.Sub(*lastSent) >= time.Duration(cond.Hours)*time.Hour
+		case models.CondRepliedWithoutDocument:
+			holds = false
+		}
+		if holds {
+			return &rules[i]
+		}
+	}
+	return nil
+}
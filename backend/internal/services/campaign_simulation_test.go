@@ -0,0 +1,116 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// simulatedClientLine is a pending line of a client with a phone number
+func simulatedClientLine(lastInboundAt *time.Time) *models.PendingLine {
+	clientID := uuid.New()
+	phone := "+33600000000"
+	return &models.PendingLine{
+		ID:       uuid.New(),
+		ClientID: &clientID,
+		Amount:   decimal.NewFromInt(-120),
+		Client:   &models.Client{ID: clientID, Name: "Client", Phone: &phone, LastInboundAt: lastInboundAt},
+	}
+}
+
+func TestSimulateGroupVariants(t *testing.T) {
+	campaign := models.Campaign{Steps: []models.CampaignStep{{
+		StepOrder: 1,
+		Channel:   models.ChannelWhatsApp,
+		Config:    map[string]any{"message": "Bonjour"},
+		Variants: []models.StepVariant{
+			{Key: "a", Weight: 3, Config: map[string]any{"message": "Version A"}},
+			{Key: "b", Weight: 1, Config: map[string]any{"message": "Version B"}},
+			{Key: "off", Weight: 0, Config: map[string]any{"message": "Jamais"}},
+		},
+	}}}
+	start := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+
+	out := simulateGroup(campaign, SingleLine(simulatedClientLine(nil)), nil, simulationLimits{}, start)
+	if len(out.Timeline) != 1 {
+		t.Fatalf("%d steps planned, want 1", len(out.Timeline))
+	}
+	step := out.Timeline[0]
+	if step.Content != "" || len(step.Variants) != 2 {
+		t.Fatalf("content %q and variants %+v, want the 2 variants with a weight", step.Content, step.Variants)
+	}
+	a, b := step.Variants[0], step.Variants[1]
+	if a.Key != "a" || a.Weight != 3 || a.Share != 0.75 || a.Content != "Version A" {
+		t.Errorf("variant a = %+v", a)
+	}
+	if b.Key != "b" || b.Weight != 1 || b.Share != 0.25 || b.Content != "Version B" {
+		t.Errorf("variant b = %+v", b)
+	}
+
+	campaign.Steps[0].Variants = nil
+	out = simulateGroup(campaign, SingleLine(simulatedClientLine(nil)), nil, simulationLimits{}, start)
+	if step := out.Timeline[0]; step.Content != "Bonjour" || step.Variants != nil {
+		t.Errorf("step without variants = %+v", step)
+	}
+}
+
+func TestSimulateGroupWeeklyCap(t *testing.T) {
+	message := map[string]any{"message": "Bonjour"}
+	campaign := models.Campaign{Steps: []models.CampaignStep{
+		{StepOrder: 1, Channel: models.ChannelWhatsApp, Config: message},
+		{StepOrder: 2, DelayHours: 24, Channel: models.ChannelWhatsApp, Config: message},
+		{StepOrder: 3, DelayHours: 24, Channel: models.ChannelWhatsApp, Config: message},
+	}}
+	start := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+
+	out := simulateGroup(campaign, SingleLine(simulatedClientLine(nil)), nil, simulationLimits{maxPerWeek: 2}, start)
+	if len(out.Timeline) != 3 {
+		t.Fatalf("%d steps planned, want 3", len(out.Timeline))
+	}
+	for i, want := range []time.Time{start, start.Add(24 * time.Hour), start.Add(7 * 24 * time.Hour)} {
+		if got := out.Timeline[i].ScheduledAt; !got.Equal(want) {
+			t.Errorf("step %d at %s, want %s", i+1, got, want)
+		}
+	}
+	last := out.Timeline[2]
+	if !last.Rescheduled || !strings.Contains(last.Note, "plafond de 2 messages") {
+		t.Errorf("third step rescheduled %v with note %q, want postponed by the weekly cap", last.Rescheduled, last.Note)
+	}
+	if out.Timeline[1].Rescheduled {
+		t.Error("second step rescheduled, want it within the cap")
+	}
+}
+
+func TestSimulateGroupSessionWindow(t *testing.T) {
+	campaign := models.Campaign{Steps: []models.CampaignStep{
+		{StepOrder: 1, Channel: models.ChannelWhatsApp, Config: map[string]any{"message": "Bonjour"}},
+		{StepOrder: 2, DelayHours: 48, Channel: models.ChannelWhatsApp, Config: map[string]any{"message": "Bonjour"}},
+		{StepOrder: 3, DelayHours: 1, Channel: models.ChannelCall, Config: map[string]any{"script": "Bonjour"}},
+	}}
+	start := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	wroteAt := start.Add(-time.Hour)
+
+	out := simulateGroup(campaign, SingleLine(simulatedClientLine(&wroteAt)), nil, simulationLimits{sessionTemplate: "relance_session"}, start)
+	if len(out.Timeline) != 3 {
+		t.Fatalf("%d steps planned, want 3", len(out.Timeline))
+	}
+	if note := out.Timeline[0].Note; note != "" {
+		t.Errorf("first step within the session window has note %q", note)
+	}
+	if note := out.Timeline[1].Note; !strings.Contains(note, "modèle relance_session") {
+		t.Errorf("second step out of the session window has note %q, want the session template", note)
+	}
+	if note := out.Timeline[2].Note; note != "" {
+		t.Errorf("call has note %q, want none", note)
+	}
+
+	out = simulateGroup(campaign, SingleLine(simulatedClientLine(nil)), nil, simulationLimits{}, start)
+	if note := out.Timeline[0].Note; !strings.Contains(note, "le message échouera") {
+		t.Errorf("step without session template has note %q, want a failure", note)
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// confidenceZ is the normal quantile of the 95% confidence intervals
+const confidenceZ = 1.96
+
+// AssignVariant returns the variant of step for the execution identified by seed, or nil
+// when the step has no variant. The same seed always falls at the same point of the
+// weight distribution, so steps with the same variants keep an execution on one variant.
+func AssignVariant(step *models.CampaignStep, seed uuid.UUID) *models.StepVariant {
+	total := 0
+	for _, v := range step.Variants {
+		total += max(v.Weight, 0)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	h := fnv.New64a()
+	h.Write(seed[:])
+	point := int(h.Sum64() % uint64(total))
+	for i := range step.Variants {
+		w := max(step.Variants[i].Weight, 0)
+		if point < w {
+			return &step.Variants[i]
+		}
+		point -= w
+	}
+	return nil
+}
+
+func validateVariants(variants []models.StepVariant) error {
+	if len(variants) == 0 {
+		return nil
+	}
+	keys := make(map[string]bool, len(variants))
+	total := 0
+	for _, v := range variants {
+		if v.Key == "" || len(v.Key) > 50 {
+			return fmt.Errorf("variant key must be 1 to 50 characters")
+		}
+		if keys[v.Key] {
+			return fmt.Errorf("duplicate variant %q", v.Key)
+		}
+		keys[v.Key] = true
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %q: weight must not be negative", v.Key)
+		}
+		total += v.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one variant must have a positive weight")
+	}
+	return nil
+}
+
+// ScoreVariants fills the rates of variant results with their confidence intervals
+func ScoreVariants(results []models.VariantResult) {
+	for i := range results {
+		r := &results[i]
+		r.ReadRate = wilsonRate(r.Read, r.Sent)
+		r.ReplyRate = wilsonRate(r.Replied, r.Sent)
+		r.ConversionRate = wilsonRate(r.DocumentReceived, r.Sent)
+	}
+}
+
+// wilsonRate returns successes/n with its Wilson score interval, which stays within
+// [0, 1] and behaves well on the small samples of a cabinet
+func wilsonRate(successes, n int64) models.Rate {
+	if n == 0 {
+		return models.Rate{}
+	}
+	p := float64(successes) / float64(n)
+	nf := float64(n)
+	z2 := confidenceZ * confidenceZ
+
+	denom := 1 + z2/nf
+	center := (p + z2/(2*nf)) / denom
+	margin := confidenceZ * math.Sqrt(p*(1-p)/nf+z2/(4*nf*nf)) / denom
+	return models.Rate{
+		Value: p,
+		Low:   math.Max(0, center-margin),
+		High:  math.Min(1, center+margin),
+	}
+}
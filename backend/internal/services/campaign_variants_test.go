@@ -0,0 +1,150 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// variantStep returns a step with variants of the given keys and weights
+func variantStep(variants ...models.StepVariant) *models.CampaignStep {
+	return &models.CampaignStep{StepOrder: 1, Channel: models.ChannelWhatsApp, Variants: variants}
+}
+
+// variantSeeds returns n execution IDs, the same on every run
+func variantSeeds(n int) []uuid.UUID {
+	out := make([]uuid.UUID, n)
+	for i := range out {
+		out[i] = uuid.NewSHA1(uuid.NameSpaceOID, []byte(strconv.Itoa(i)))
+	}
+	return out
+}
+
+func TestAssignVariantStable(t *testing.T) {
+	step := variantStep(
+		models.StepVariant{Key: "a", Weight: 1},
+		models.StepVariant{Key: "b", Weight: 1},
+		models.StepVariant{Key: "c", Weight: 2},
+	)
+
+	// FNV-1a of the execution ID modulo the total weight: ongoing executions keep their
+	// variant across releases
+	tests := []struct {
+		seed string
+		want string
+	}{
+		{"00000000-0000-0000-0000-000000000000", "b"},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", "a"},
+		{"f47ac10b-58cc-4372-a567-0e02b2c3d479", "c"},
+		{"123e4567-e89b-12d3-a456-426614174000", "a"},
+	}
+	for _, tc := range tests {
+		if got := AssignVariant(step, uuid.MustParse(tc.seed)); got == nil || got.Key != tc.want {
+			t.Errorf("AssignVariant(%s) = %v, want %s", tc.seed, got, tc.want)
+		}
+	}
+
+	// Every step with the same variants keeps an execution on one variant
+	next := variantStep(step.Variants...)
+	next.StepOrder = 2
+	for _, seed := range variantSeeds(1000) {
+		first, again, later := AssignVariant(step, seed), AssignVariant(step, seed), AssignVariant(next, seed)
+		if first.Key != again.Key || first.Key != later.Key {
+			t.Fatalf("seed %s assigned %s, %s then %s", seed, first.Key, again.Key, later.Key)
+		}
+	}
+}
+
+func TestAssignVariantDistribution(t *testing.T) {
+	step := variantStep(
+		models.StepVariant{Key: "a", Weight: 70},
+		models.StepVariant{Key: "b", Weight: 20},
+		models.StepVariant{Key: "c", Weight: 10},
+	)
+	const n = 20000
+	counts := make(map[string]int)
+	for _, seed := range variantSeeds(n) {
+		counts[AssignVariant(step, seed).Key]++
+	}
+	for _, v := range step.Variants {
+		share := float64(counts[v.Key]) / n
+		if want := float64(v.Weight) / 100; math.Abs(share-want) > 0.015 {
+			t.Errorf("variant %s assigned to %.3f of the executions, want %.2f", v.Key, share, want)
+		}
+	}
+}
+
+func TestAssignVariantWeights(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []models.StepVariant
+		want     map[string]bool // Keys that may be assigned, nil for no variant
+	}{
+		{"no variant", nil, nil},
+		{"all weights zero", []models.StepVariant{{Key: "a"}, {Key: "b"}}, nil},
+		{"negative weights only", []models.StepVariant{{Key: "a", Weight: -1}, {Key: "b", Weight: -5}}, nil},
+		{"zero weight never assigned", []models.StepVariant{{Key: "a", Weight: 0}, {Key: "b", Weight: 1}}, map[string]bool{"b": true}},
+		{"negative weight never assigned", []models.StepVariant{{Key: "a", Weight: 2}, {Key: "b", Weight: -3}, {Key: "c", Weight: 1}}, map[string]bool{"a": true, "c": true}},
+		{"single variant", []models.StepVariant{{Key: "a", Weight: 5}}, map[string]bool{"a": true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			step := variantStep(tc.variants...)
+			assigned := make(map[string]bool)
+			for _, seed := range variantSeeds(500) {
+				got := AssignVariant(step, seed)
+				switch {
+				case tc.want == nil && got != nil:
+					t.Fatalf("AssignVariant = %s, want none", got.Key)
+				case tc.want != nil && got == nil:
+					t.Fatalf("AssignVariant = none, want one of %v", tc.want)
+				case got != nil && !tc.want[got.Key]:
+					t.Fatalf("AssignVariant = %s, want one of %v", got.Key, tc.want)
+				case got != nil:
+					assigned[got.Key] = true
+				}
+			}
+			if len(assigned) != len(tc.want) {
+				t.Errorf("assigned %v, want all of %v", assigned, tc.want)
+			}
+		})
+	}
+}
+
+func TestWilsonRate(t *testing.T) {
+	tests := []struct {
+		name         string
+		successes, n int64
+		want         models.Rate
+	}{
+		{"no sample", 0, 0, models.Rate{}},
+		{"one failure", 0, 1, models.Rate{Value: 0, Low: 0, High: 0.793457}},
+		{"one success", 1, 1, models.Rate{Value: 1, Low: 0.206543, High: 1}},
+		{"half of ten", 5, 10, models.Rate{Value: 0.5, Low: 0.236590, High: 0.763410}},
+		{"no success out of a hundred", 0, 100, models.Rate{Value: 0, Low: 0, High: 0.036995}},
+		{"all successes out of a hundred", 100, 100, models.Rate{Value: 1, Low: 0.963005, High: 1}},
+		{"forty percent of fifty", 20, 50, models.Rate{Value: 0.4, Low: 0.276082, High: 0.538188}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := wilsonRate(tc.successes, tc.n)
+			if math.Abs(got.Value-tc.want.Value) > 1e-6 || math.Abs(got.Low-tc.want.Low) > 1e-6 || math.Abs(got.High-tc.want.High) > 1e-6 {
+				t.Errorf("wilsonRate(%d, %d) = %+v, want %+v", tc.successes, tc.n, got, tc.want)
+			}
+		})
+	}
+
+	// The interval stays within [0, 1] around the observed rate
+	for n := int64(1); n <= 50; n++ {
+		for s := int64(0); s <= n; s++ {
+			r := wilsonRate(s, n)
+			if r.Low < 0 || r.High > 1 || r.Low > r.Value+1e-12 || r.High < r.Value-1e-12 {
+				t.Fatalf("wilsonRate(%d, %d) = %+v", s, n, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// CampaignExportFormat identifies the JSON documents produced by ExportCampaign
+const CampaignExportFormat = "fiducia.campaign/v1"
+
+// ValidateStepMapping checks that a migration mapping only targets steps of the
+// campaign's current version (0 restarts the sequence)
+func ValidateStepMapping(campaign *models.Campaign, mapping map[int]int) error {
+	for from, to := range mapping {
+		if from < 0 || to < 0 {
+			return fmt.Errorf("%w: step orders must not be negative", ErrInvalidCommand)
+		}
+		if to > 0 && findStep(campaign.Steps, to) == nil {
+			return fmt.Errorf("%w: step %d does not exist in version %d", ErrInvalidCommand, to, campaign.Version)
+		}
+	}
+	return nil
+}
+
+// ExportCampaign returns the portable form of a campaign, without any identifier
+func ExportCampaign(c *models.Campaign) models.CampaignExport {
+	steps := make([]models.StepDefinition, 0, len(c.Steps))
+	for _, s := range c.Steps {
+		steps = append(steps, s.Definition())
+	}
+	return models.CampaignExport{
+		Format:               CampaignExportFormat,
+		ExportedAt:           time.Now(),
+		Name:                 c.Name,
+		TriggerType:          c.TriggerType,
+		GroupBy:              c.GroupBy,
+		Priority:             c.Priority,
+		Targeting:            c.Targeting,
+		QuietHoursEnabled:    c.QuietHoursEnabled,
+		EscalateOnExhaustion: c.EscalateOnExhaustion,
+		EscalateAfterHours:   c.EscalateAfterHours,
+		Steps:                steps,
+	}
+}
+
+// ImportCampaign builds a new campaign of cabinetID from an export. The campaign is
+// inactive so that it can be reviewed before it starts relancing.
+func ImportCampaign(doc models.CampaignExport, cabinetID uuid.UUID) (*models.Campaign, error) {
+	if doc.Format != CampaignExportFormat {
+		return nil, fmt.Errorf("unsupported format %q (expected %q)", doc.Format, CampaignExportFormat)
+	}
+	if doc.Name == "" {
+		return nil, fmt.Errorf("campaign name is required")
+	}
+
+	c := &models.Campaign{
+		ID:                   uuid.New(),
+		CabinetID:            cabinetID,
+		Name:                 doc.Name,
+		TriggerType:          doc.TriggerType,
+		GroupBy:              doc.GroupBy,
+		Priority:             doc.Priority,
+		Targeting:            doc.Targeting,
+		IsActive:             false,
+		QuietHoursEnabled:    doc.QuietHoursEnabled,
+		EscalateOnExhaustion: doc.EscalateOnExhaustion,
+		EscalateAfterHours:   doc.EscalateAfterHours,
+		Steps:                make([]models.CampaignStep, 0, len(doc.Steps)),
+	}
+	if c.TriggerType == "" {
+		c.TriggerType = models.TriggerOnPending
+	}
+	if c.GroupBy == "" {
+		c.GroupBy = models.GroupByLine
+	}
+	if c.EscalateAfterHours == 0 {
+		c.EscalateAfterHours = 48
+	}
+	if c.Targeting != nil {
+		// Collaborators belong to the source cabinet
+		c.Targeting.AssignedTo = nil
+	}
+	for _, d := range doc.Steps {
+		c.Steps = append(c.Steps, d.Step(c.ID))
+	}
+
+	if err := ValidateCampaign(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
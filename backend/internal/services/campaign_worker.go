@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// CampaignWorker runs the campaign cycle periodically.
+// Several workers may run at once (API replicas or cmd/worker): the engine
+// leases executions so each one is only processed by a single worker.
+type CampaignWorker struct {
+	engine   *CampaignEngine
+	interval time.Duration
+}
+
+func NewCampaignWorker(engine *CampaignEngine, interval time.Duration) *CampaignWorker {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &CampaignWorker{engine: engine, interval: interval}
+}
+
+// Run executes a cycle immediately, then on every tick until ctx is cancelled
+func (w *CampaignWorker) Run(ctx context.Context) {
+	slog.Info("Starting Campaign Worker...", "worker", w.engine.WorkerID(), "interval", w.interval)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.runCycle(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopping Campaign Worker")
+			return
+		case <-ticker.C:
+			w.runCycle(ctx)
+		}
+	}
+}
+
+func (w *CampaignWorker) runCycle(ctx context.Context) {
+	if _, err := w.engine.ExecuteCampaignCycle(ctx); err != nil && ctx.Err() == nil {
+		slog.Error("Campaign Cycle Error", "error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/queue"
+)
+
+// ErrQueueUnavailable is returned when the message queue is not connected
+var ErrQueueUnavailable = errors.New("message queue not available")
+
+// DeadLetters returns the dead-lettered messages of a cabinet, most recent first,
+// and their total count
+func (s *MessageService) DeadLetters(ctx context.Context, cabinetID uuid.UUID, offset, limit int) ([]queue.DeadJob, int, error) {
+	if s.queue == nil {
+		return nil, 0, ErrQueueUnavailable
+	}
+	return s.queue.ListDead(ctx, cabinetID.String(), offset, limit)
+}
+
+// DeadLetter returns a dead-lettered message of a cabinet (queue.ErrJobNotFound)
+func (s *MessageService) DeadLetter(ctx context.Context, cabinetID uuid.UUID, id string) (*queue.DeadJob, error) {
+	if s.queue == nil {
+		return nil, ErrQueueUnavailable
+	}
+	return s.queue.GetDead(ctx, cabinetID.String(), id)
+}
+
+// ReplayDeadLetter sends a dead-lettered message again, immediately and with its
+// attempts reset. The message goes back to the queued status.
+func (s *MessageService) ReplayDeadLetter(ctx context.Context, cabinetID uuid.UUID, id string) error {
+	if s.queue == nil {
+		return ErrQueueUnavailable
+	}
+	job, err := s.queue.Replay(ctx, cabinetID.String(), id)
+	if err != nil {
+		return err
+	}
+	if msgID, err := uuid.Parse(job.ID); err == nil {
+		if err := s.msgRepo.UpdateStatus(ctx, msgID, models.MsgStatusQueued, nil); err != nil {
+			slog.Warn("failed to update replayed message status", "message_id", msgID, "error", err)
+		}
+	}
+	slog.Info("dead-lettered message replayed", "job_id", id)
+	return nil
+}
+
+// DiscardDeadLetter deletes a dead-lettered message from the queue. The message
+// stays failed.
+func (s *MessageService) DiscardDeadLetter(ctx context.Context, cabinetID uuid.UUID, id string) error {
+	if s.queue == nil {
+		return ErrQueueUnavailable
+	}
+	if _, err := s.queue.Discard(ctx, cabinetID.String(), id); err != nil {
+		return err
+	}
+	slog.Info("dead-lettered message discarded", "job_id", id)
+	return nil
+}
@@ -2,8 +2,10 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,38 +16,63 @@ import (
 	"github.com/fiducia/backend/pkg/whatsapp"
 )
 
-// MessageService handles sending WhatsApp messages
+// ErrSessionClosed is returned when a free-form message is sent to a client outside
+// its WhatsApp session window and no template is configured to replace it
+var ErrSessionClosed = errors.New("client session window closed and no session template configured")
+
+// maxTemplateParam is the length of a template parameter accepted by WhatsApp
+const maxTemplateParam = 1024
+
+// MessageService handles sending WhatsApp messages. Every outbound message goes
+// through the queue and is sent by the message workers (ProcessQueuedMessage).
 type MessageService struct {
-	waClient   whatsapp.Client
-	msgRepo    *repository.MessageRepository
-	lineRepo   *repository.PendingLineRepository
-	clientRepo *repository.ClientRepository
-	queue      *queue.MessageQueue
+	providers   *whatsapp.Providers
+	calls       *PhoneCallService // nil when no telephony provider is configured
+	msgRepo     *repository.MessageRepository
+	lineRepo    *repository.PendingLineRepository
+	clientRepo  *repository.ClientRepository
+	cabinetRepo *repository.CabinetRepository
+	queue       queue.Queue
+	policy      AntiBanPolicy // Default anti-ban policy, overridden by cabinet settings
 }
 
 // NewMessageService creates a new message service
 func NewMessageService(
-	waClient whatsapp.Client,
+	providers *whatsapp.Providers,
+	calls *PhoneCallService,
 	msgRepo *repository.MessageRepository,
 	lineRepo *repository.PendingLineRepository,
 	clientRepo *repository.ClientRepository,
-	q *queue.MessageQueue,
+	cabinetRepo *repository.CabinetRepository,
+	q queue.Queue,
 ) *MessageService {
 	return &MessageService{
-		waClient:   waClient,
-		msgRepo:    msgRepo,
-		lineRepo:   lineRepo,
-		clientRepo: clientRepo,
-		queue:      q,
+		providers:   providers,
+		calls:       calls,
+		msgRepo:     msgRepo,
+		lineRepo:    lineRepo,
+		clientRepo:  clientRepo,
+		cabinetRepo: cabinetRepo,
+		queue:       q,
+		policy:      AntiBanPolicy{Policy: queue.DefaultPolicy},
 	}
 }
 
+// SetDefaultPolicy sets the anti-ban policy of cabinets without anti-ban settings
+func (s *MessageService) SetDefaultPolicy(p AntiBanPolicy) {
+	s.policy = p
+}
+
+// DefaultPolicy returns the anti-ban policy of cabinets without anti-ban settings
+func (s *MessageService) DefaultPolicy() AntiBanPolicy {
+	return s.policy
+}
+
 // SendRelanceRequest represents a request to send a relance
 type SendRelanceRequest struct {
 	PendingLineID uuid.UUID `json:"pending_line_id"`
-	MessageType   string    `json:"message_type"` // text, voice, template
+	MessageType   string    `json:"message_type"` // text, voice, template, interactive (with reply buttons)
 	CustomMessage string    `json:"custom_message,omitempty"`
-	Immediate     bool      `json:"immediate,omitempty"` // Skip queue for testing
 }
 
 // SendRelance queues a relance message for a pending line
@@ -99,25 +126,9 @@ func (s *MessageService) SendRelance(ctx context.Context, req SendRelanceRequest
 		return nil, fmt.Errorf("failed to create message: %w", err)
 	}
 
-	// Create queue job
-	job := &queue.MessageJob{
-		ID:            msg.ID.String(),
-		PendingLineID: req.PendingLineID.String(),
-		ClientID:      line.ClientID.String(),
-		Phone:         *client.Phone,
-		MessageType:   req.MessageType,
-		Content:       content,
-	}
-
-	// Enqueue (with or without jitter)
-	if req.Immediate {
-		if err := s.queue.EnqueueImmediate(ctx, job); err != nil {
-			return nil, fmt.Errorf("failed to enqueue message: %w", err)
-		}
-	} else {
-		if err := s.queue.Enqueue(ctx, job); err != nil {
-			return nil, fmt.Errorf("failed to enqueue message: %w", err)
-		}
+	// Enqueue with the anti-ban delay
+	if err := s.QueueMessage(ctx, line.CabinetID, msg, *client.Phone, false); err != nil {
+		return nil, fmt.Errorf("failed to enqueue message: %w", err)
 	}
 
 	// Update pending line status
@@ -132,11 +143,69 @@ func (s *MessageService) SendRelance(ctx context.Context, req SendRelanceRequest
 	return msg, nil
 }
 
-// ProcessQueuedMessage processes a message from the queue
+// QueueMessage enqueues an outbound message of a cabinet already stored with the
+// queued status, with the anti-ban policy of the cabinet. Immediate messages skip the
+// anti-ban delay and automatic replies the weekly cap, both reserved to internal
+// callers: messages requested through the API always wait for the anti-ban delay.
+// When the message cannot be queued, for instance because the client reached its
+// weekly cap, it is marked failed and the error returned.
+func (s *MessageService) QueueMessage(ctx context.Context, cabinetID uuid.UUID, msg *models.Message, phone string, immediate bool) error {
+	job := &queue.MessageJob{
+		ID:          msg.ID.String(),
+		CabinetID:   cabinetID.String(),
+		Phone:       phone,
+		MessageType: string(msg.MessageType),
+	}
+
+	if msg.PendingLineID != nil {
+		job.PendingLineID = msg.PendingLineID.String()
+	}
+	if msg.ClientID != nil {
+		job.ClientID = msg.ClientID.String()
+	}
+	if msg.Content != nil {
+		job.Content = *msg.Content
+	}
+	if msg.MediaURL != nil {
+		job.AudioURL = *msg.MediaURL
+	}
+	if msg.TemplateName != nil {
+		job.TemplateName = *msg.TemplateName
+	}
+
+	provider, policy := s.route(ctx, cabinetID)
+	if msg.MessageType != models.TypeCall {
+		job.Provider, job.Sender = provider.Name, provider.Sender
+		job.WindowTemplate = provider.SessionTemplate
+	}
+	var err error
+	if msg.ClientID != nil && !msg.AutoReply {
+		err = s.CheckWeeklyCap(ctx, policy, *msg.ClientID, &msg.ID)
+	}
+	switch {
+	case err != nil:
+	case s.queue == nil:
+		err = ErrQueueUnavailable
+	case immediate:
+		err = s.queue.EnqueueImmediate(ctx, job, policy.Policy)
+	default:
+		err = s.queue.Enqueue(ctx, job, policy.Policy)
+	}
+	if err != nil {
+		if setErr := s.msgRepo.SetError(ctx, msg.ID, err.Error()); setErr != nil {
+			slog.Warn("failed to mark message failed", "message_id", msg.ID, "error", setErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// ProcessQueuedMessage processes a message from the queue. On failure the message
+// goes back to queued, the worker pool retries it.
 func (s *MessageService) ProcessQueuedMessage(ctx context.Context, job *queue.MessageJob) error {
 	msgID, err := uuid.Parse(job.ID)
 	if err != nil {
-		return fmt.Errorf("invalid message ID: %w", err)
+		return queue.Permanent(fmt.Errorf("invalid message ID: %w", err))
 	}
 
 	// Update status to sending
@@ -144,45 +213,137 @@ func (s *MessageService) ProcessQueuedMessage(ctx context.Context, job *queue.Me
 		slog.Warn("failed to update message status to sending", "error", err)
 	}
 
-	// Simulate typing delay (anti-ban)
-	time.Sleep(2 * time.Second)
-
-	// Send via Twilio
-	var response *whatsapp.MessageResponse
-	var sendErr error
-
-	switch job.MessageType {
-	case "text":
-		response, sendErr = s.waClient.SendText(job.Phone, job.Content)
-	case "voice":
-		response, sendErr = s.waClient.SendVoice(job.Phone, job.AudioURL)
-	case "template":
-		response, sendErr = s.waClient.SendTemplate(job.Phone, job.TemplateName, job.TemplateParams)
-	default:
-		response, sendErr = s.waClient.SendText(job.Phone, job.Content)
-	}
-
+	sid, sendErr := s.send(ctx, msgID, job)
 	if sendErr != nil {
-		// Mark as failed
-		s.msgRepo.SetError(ctx, msgID, sendErr.Error())
+		if err := s.msgRepo.UpdateStatus(ctx, msgID, models.MsgStatusQueued, nil); err != nil {
+			slog.Warn("failed to update message status to queued", "error", err)
+		}
 		return fmt.Errorf("failed to send message: %w", sendErr)
 	}
 
-	// Update with WhatsApp message ID
-	waID := response.MessageSID
-	if err := s.msgRepo.UpdateStatus(ctx, msgID, models.MsgStatusSent, &waID); err != nil {
+	// Update with provider message ID
+	if err := s.msgRepo.UpdateStatus(ctx, msgID, models.MsgStatusSent, &sid); err != nil {
 		slog.Warn("failed to update message status to sent", "error", err)
 	}
 
 	slog.Info("message sent successfully",
 		"message_id", msgID,
-		"wa_message_id", waID,
+		"wa_message_id", sid,
 		"phone", job.Phone,
 	)
 
 	return nil
 }
 
+// FailQueuedMessage marks the message of a dead-lettered job as failed with the final reason
+func (s *MessageService) FailQueuedMessage(ctx context.Context, job *queue.MessageJob, sendErr error) {
+	msgID, err := uuid.Parse(job.ID)
+	if err != nil {
+		return
+	}
+	if err := s.msgRepo.SetError(ctx, msgID, sendErr.Error()); err != nil {
+		slog.Warn("failed to mark message failed", "message_id", msgID, "error", err)
+	}
+}
+
+// send delivers a job with its provider and returns the provider message ID
+func (s *MessageService) send(ctx context.Context, msgID uuid.UUID, job *queue.MessageJob) (string, error) {
+	if job.MessageType == string(models.TypeCall) {
+		if s.calls == nil {
+			return "", fmt.Errorf("telephony provider not configured")
+		}
+		call, err := s.calls.Call(ctx, msgID, job.Content, job.Phone)
+		if err != nil {
+			return "", err
+		}
+		return call.SID, nil
+	}
+
+	provider, err := s.providers.Get(job.Provider)
+	if err != nil {
+		return "", err
+	}
+	client := provider.Client
+
+	// Outside the session window of the client, free text and media are not
+	// delivered: the text is sent in the session template instead
+	if job.MessageType != string(models.TypeTemplate) {
+		open, err := s.sessionOpen(ctx, job.ClientID)
+		if err != nil {
+			return "", err
+		}
+		if !open {
+			if job.WindowTemplate == "" {
+				return "", queue.Permanent(ErrSessionClosed)
+			}
+			var params []string
+			if p := templateParam(job.Content); p != "" {
+				params = []string{p}
+			}
+			if err := s.msgRepo.SetTemplate(ctx, msgID, job.WindowTemplate, params); err != nil {
+				slog.Warn("failed to record session template", "message_id", msgID, "error", err)
+			}
+			job.MessageType, job.TemplateName, job.TemplateParams = string(models.TypeTemplate), job.WindowTemplate, params
+		}
+	}
+
+	// Simulate typing delay (anti-ban)
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(time.Duration(job.TypingDelayMs) * time.Millisecond):
+	}
+
+	var response *whatsapp.MessageResponse
+	switch job.MessageType {
+	case "voice":
+		if job.AudioURL == "" {
+			// Audio could not be generated, send the script
+			response, err = client.SendText(ctx, job.Phone, job.Content)
+		} else {
+			response, err = client.SendVoice(ctx, job.Phone, job.AudioURL)
+		}
+	case "template":
+		response, err = client.SendTemplate(ctx, job.Phone, job.TemplateName, job.TemplateParams)
+	case "interactive":
+		response, err = client.SendInteractive(ctx, job.Phone, RelanceButtons(msgID, job.Content))
+	default:
+		response, err = client.SendText(ctx, job.Phone, job.Content)
+	}
+	var apiErr *whatsapp.APIError
+	if errors.As(err, &apiErr) && apiErr.Permanent() {
+		return "", queue.Permanent(err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return response.MessageSID, nil
+}
+
+// sessionOpen returns true when the WhatsApp session window of a client is open.
+// Messages without a known client are sent as they are.
+func (s *MessageService) sessionOpen(ctx context.Context, clientID string) (bool, error) {
+	id, err := uuid.Parse(clientID)
+	if err != nil {
+		return true, nil
+	}
+	client, err := s.clientRepo.GetByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return client == nil || client.Session.Open, nil
+}
+
+// templateParam turns a free-form text into a template parameter, which WhatsApp
+// rejects with line breaks, tabs or more than maxTemplateParam characters
+func templateParam(text string) string {
+	p := strings.Join(strings.Fields(text), " ")
+	if r := []rune(p); len(r) > maxTemplateParam {
+		p = string(r[:maxTemplateParam-1]) + "…"
+	}
+	return p
+}
+
 // generateRelanceMessage generates a default relance message
 func (s *MessageService) generateRelanceMessage(line *models.PendingLine, client *models.Client) string {
 	// Format amount
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// RelanceData holds the values available to campaign message templates.
+// For a client group, Amount is the total and Date and Label are those of the first line.
+type RelanceData struct {
+	ClientName string
+	Amount     string
+	Date       string
+	Label      string
+	Count      int    // Number of outstanding operations
+	Operations string // One line per outstanding operation
+}
+
+// NewRelanceData extracts template values from the lines of a group and their client
+func NewRelanceData(group RelanceGroup) RelanceData {
+	line := group.Primary()
+	data := RelanceData{
+		ClientName: "Madame, Monsieur",
+		Amount:     group.Total().StringFixed(2),
+		Date:       line.TransactionDate.Format("02/01/2006"),
+		Label:      lineLabel(line),
+		Count:      len(group.Lines),
+	}
+	if line.Client != nil {
+		data.ClientName = line.Client.Name
+	}
+
+	ops := make([]string, 0, len(group.Lines))
+	for _, l := range group.Lines {
+		ops = append(ops, fmt.Sprintf("• %s — %s € — %s",
+			l.TransactionDate.Format("02/01/2006"), l.Amount.Abs().StringFixed(2), lineLabel(l)))
+	}
+	data.Operations = strings.Join(ops, "\n")
+	return data
+}
+
+func lineLabel(line *models.PendingLine) string {
+	if line.BankLabel != nil && *line.BankLabel != "" {
+		return *line.BankLabel
+	}
+	return "une opération"
+}
+
+// RenderTemplate replaces the placeholders used in campaign steps
+// ([Client], [Montant], [Date], [Libellé], [Opérations], [Nombre], [Nom], [Cabinet])
+// with actual values
+func RenderTemplate(text string, data RelanceData) string {
+	return strings.NewReplacer(
+		"[Client]", data.ClientName,
+		"[Montant]", data.Amount+" €",
+		"[Date]", data.Date,
+		"[Libellé]", data.Label,
+		"[Libelle]", data.Label,
+		"[Opérations]", data.Operations,
+		"[Operations]", data.Operations,
+		"[Nombre]", strconv.Itoa(data.Count),
+		"[Nom]", "votre comptable",
+		"[Cabinet]", "votre cabinet comptable",
+	).Replace(text)
+}
+
+// DefaultRelanceText is used when a step has no content configured for its channel
+func DefaultRelanceText(data RelanceData) string {
+	if data.Count > 1 {
+		return fmt.Sprintf(
+			"Bonjour %s,\n\n"+
+				"Nous recherchons les justificatifs des %d opérations suivantes :\n\n"+
+				"%s\n\n"+
+				"Merci de nous envoyer les pièces justificatives (factures, tickets, reçus).\n\n"+
+				"Cordialement,\n"+
+				"Votre cabinet comptable",
+			data.ClientName, data.Count, data.Operations,
+		)
+	}
+	return fmt.Sprintf(
+		"Bonjour %s,\n\n"+
+			"Nous recherchons un justificatif pour l'opération suivante :\n\n"+
+			"📅 Date : %s\n"+
+			"💰 Montant : %s €\n"+
+			"📝 Libellé : %s\n\n"+
+			"Merci de nous envoyer la pièce justificative (facture, ticket, reçu).\n\n"+
+			"Cordialement,\n"+
+			"Votre cabinet comptable",
+		data.ClientName, data.Date, data.Amount, data.Label,
+	)
+}
+
+// stepContentKeys lists, per channel, the config keys holding the step content
+var stepContentKeys = map[models.CampaignChannel][]string{
+	models.ChannelWhatsApp:     {"whatsapp", "body", "message"},
+	models.ChannelVoice:        {"script", "whatsapp", "body"},
+	models.ChannelCall:         {"script", "body"},
+	models.ChannelEmail:        {"body", "email"},
+	models.ChannelNotification: {"msg", "message"},
+}
+
+// StepButtons returns true when the WhatsApp message of a step offers the relance
+// quick-reply buttons ("buttons": true in the step or variant config)
+func StepButtons(step *models.CampaignStep, variant *models.StepVariant) bool {
+	configs := []map[string]any{step.Config}
+	if variant != nil {
+		configs = []map[string]any{variant.Config, step.Config}
+	}
+	for _, config := range configs {
+		if v, ok := config["buttons"].(bool); ok {
+			return v
+		}
+	}
+	return false
+}
+
+// StepContent returns the rendered content of a step for a channel. Content of the
+// variant, if any, takes precedence over the step's.
+func StepContent(step *models.CampaignStep, variant *models.StepVariant, channel models.CampaignChannel, data RelanceData) string {
+	configs := []map[string]any{step.Config}
+	if variant != nil {
+		configs = []map[string]any{variant.Config, step.Config}
+	}
+	for _, config := range configs {
+		for _, key := range stepContentKeys[channel] {
+			if v, ok := config[key].(string); ok && strings.TrimSpace(v) != "" {
+				return RenderTemplate(v, data)
+			}
+		}
+	}
+	return DefaultRelanceText(data)
+}
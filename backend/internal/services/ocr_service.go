@@ -143,8 +143,13 @@ func (s *OCRService) DownloadAndProcess(ctx context.Context, mediaURL, accountSI
 		return nil, "", fmt.Errorf("failed to read media: %w", err)
 	}
 
+	return s.ProcessMedia(ctx, imageData, resp.Header.Get("Content-Type"))
+}
+
+// ProcessMedia stores a downloaded media and processes it. It returns the stored
+// file path, also when OCR fails.
+func (s *OCRService) ProcessMedia(ctx context.Context, imageData []byte, contentType string) (*OCRResult, string, error) {
 	// Determine file extension
-	contentType := resp.Header.Get("Content-Type")
 	ext := ".jpg"
 	switch {
 	case strings.Contains(contentType, "png"):
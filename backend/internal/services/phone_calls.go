@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/internal/repository"
+	"github.com/fiducia/backend/pkg/telephony"
+)
+
+// Keys offered to the client at the end of a campaign call
+const (
+	KeyAlreadySent = "1" // The document has already been sent
+	KeyCallBack    = "2" // The client wants to be called back by the cabinet
+)
+
+const (
+	callLanguage = "fr-FR"
+	callPrompt   = "Si vous avez déjà envoyé le justificatif, appuyez sur 1. Pour être rappelé par votre cabinet, appuyez sur 2."
+)
+
+// ErrUnknownCall is returned for a status of a call whose SID is not saved yet: the
+// provider may report an outcome before the worker saves the call it placed
+var ErrUnknownCall = errors.New("unknown call SID")
+
+// Data read and written by the phone calls, implemented by the repositories
+type (
+	callMessages interface {
+		GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error)
+		SetDTMFResponse(ctx context.Context, id uuid.UUID, digits string) error
+		UpdateCallOutcome(ctx context.Context, callSID, callStatus string, duration *int, answered bool) (bool, error)
+		SetCallRecording(ctx context.Context, callSID, recordingURL string, duration *int) error
+	}
+	callLines interface {
+		GetByID(ctx context.Context, id uuid.UUID) (*models.PendingLine, error)
+	}
+	callClients interface {
+		GetByID(ctx context.Context, id uuid.UUID) (*models.Client, error)
+	}
+	callTasks interface {
+		Create(ctx context.Context, t *models.Task) error
+	}
+)
+
+// PhoneCallService places campaign phone calls and records their outcome
+type PhoneCallService struct {
+	provider   telephony.Provider
+	voiceSvc   *VoiceService
+	voiceID    string // Default ElevenLabs voice
+	baseURL    string // Public URL receiving the provider callbacks
+	msgRepo    callMessages
+	lineRepo   callLines
+	clientRepo callClients
+	taskRepo   callTasks
+}
+
+func NewPhoneCallService(
+	provider telephony.Provider,
+	voiceSvc *VoiceService,
+	voiceID string,
+	baseURL string,
+	msgRepo *repository.MessageRepository,
+	lineRepo *repository.PendingLineRepository,
+	clientRepo *repository.ClientRepository,
+	taskRepo *repository.TaskRepository,
+) *PhoneCallService {
+	return &PhoneCallService{
+		provider:   provider,
+		voiceSvc:   voiceSvc,
+		voiceID:    voiceID,
+		baseURL:    baseURL,
+		msgRepo:    msgRepo,
+		lineRepo:   lineRepo,
+		clientRepo: clientRepo,
+		taskRepo:   taskRepo,
+	}
+}
+
+// Call places the phone call of message messageID reading script. The script is
+// played with the ElevenLabs voice when it can be generated, read by the provider
+// otherwise.
+func (s *PhoneCallService) Call(ctx context.Context, messageID uuid.UUID, script, phone string) (*telephony.Call, error) {
+	req := telephony.CallRequest{
+		To:                   phone,
+		Text:                 script,
+		Language:             callLanguage,
+		Prompt:               callPrompt,
+		GatherURL:            fmt.Sprintf("%s/webhook/voice/gather?message_id=%s", s.baseURL, messageID),
+		StatusCallbackURL:    s.baseURL + "/webhook/voice/status",
+		Record:               true,
+		RecordingCallbackURL: s.baseURL + "/webhook/voice/recording",
+	}
+
+	if s.voiceSvc != nil && s.voiceID != "" {
+		audio, err := s.voiceSvc.GenerateVoiceMessage(ctx, GenerateVoiceMessageRequest{
+			VoiceID:       s.voiceID,
+			Text:          script,
+			PendingLineID: messageID, // Names the audio file
+		})
+		if err == nil {
+			req.AudioURL = audio.AudioURL
+		} else {
+			slog.Warn("voice generation failed, script read by the provider", "messageID", messageID, "error", err)
+		}
+	}
+
+	return s.provider.PlaceCall(ctx, req)
+}
+
+// HandleKeypress records the key pressed during the call of message messageID and
+// returns the sentence read back to the client
+func (s *PhoneCallService) HandleKeypress(ctx context.Context, messageID uuid.UUID, digits string) (string, error) {
+	msg, err := s.msgRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return "", err
+	}
+	if msg == nil || msg.MessageType != models.TypeCall {
+		return "", fmt.Errorf("call not found")
+	}
+	if err := s.msgRepo.SetDTMFResponse(ctx, messageID, digits); err != nil {
+		return "", err
+	}
+
+	switch digits {
+	case KeyAlreadySent:
+		if err := s.createTask(ctx, msg, "Justificatif déjà envoyé selon le client",
+			"Le client indique par téléphone avoir déjà envoyé le justificatif. Vérifier sa réception."); err != nil {
+			return "", err
+		}
+		return "Merci. Votre cabinet va vérifier la réception de votre justificatif. Au revoir.", nil
+	case KeyCallBack:
+		if err := s.createTask(ctx, msg, "Rappel demandé par le client",
+			"Le client a demandé à être rappelé lors de l'appel automatique."); err != nil {
+			return "", err
+		}
+		return "Merci. Votre cabinet vous rappellera prochainement. Au revoir.", nil
+	}
+	return "Merci, au revoir.", nil
+}
+
+// HandleStatus records the outcome of a call; progress statuses are ignored. It
+// returns ErrUnknownCall when no call has this SID, for the provider to retry.
+func (s *PhoneCallService) HandleStatus(ctx context.Context, callSID, status string, duration *int) error {
+	if !telephony.Final(status) {
+		return nil
+	}
+	updated, err := s.msgRepo.UpdateCallOutcome(ctx, callSID, status, duration, telephony.Answered(status))
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrUnknownCall
+	}
+	return nil
+}
+
+// HandleRecording stores the recording of a call
+func (s *PhoneCallService) HandleRecording(ctx context.Context, callSID, recordingURL string, duration *int) error {
+	return s.msgRepo.SetCallRecording(ctx, callSID, recordingURL, duration)
+}
+
+// createTask asks the collaborator of the line, if any, to follow up on a keypress
+func (s *PhoneCallService) createTask(ctx context.Context, msg *models.Message, title, description string) error {
+	task := &models.Task{
+		Type:                models.TaskCallResponse,
+		Title:               title,
+		Description:         &description,
+		PendingLineID:       msg.PendingLineID,
+		ClientID:            msg.ClientID,
+		CampaignExecutionID: msg.CampaignExecutionID,
+	}
+
+	switch {
+	case msg.PendingLineID != nil:
+		line, err := s.lineRepo.GetByID(ctx, *msg.PendingLineID)
+		if err != nil {
+			return err
+		}
+		if line == nil {
+			return fmt.Errorf("pending line not found")
+		}
+		task.CabinetID = line.CabinetID
+		task.AssignedTo = line.AssignedTo
+	case msg.ClientID != nil:
+		client, err := s.clientRepo.GetByID(ctx, *msg.ClientID)
+		if err != nil {
+			return err
+		}
+		if client == nil {
+			return fmt.Errorf("client not found")
+		}
+		task.CabinetID = client.CabinetID
+	default:
+		return fmt.Errorf("call has no client")
+	}
+
+	if err := s.taskRepo.Create(ctx, task); err != nil {
+		return err
+	}
+	slog.Info("Task created from call", "taskID", task.ID, "messageID", msg.ID)
+	return nil
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/pkg/telephony"
+)
+
+// callFixture is the data of the campaign calls, served by in-memory stores to a
+// PhoneCallService
+type callFixture struct {
+	messages map[uuid.UUID]*models.Message
+	lines    map[uuid.UUID]*models.PendingLine
+	clients  map[uuid.UUID]*models.Client
+	tasks    []*models.Task
+}
+
+func (f *callFixture) GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error) {
+	return f.messages[id], nil
+}
+
+func (f *callFixture) SetDTMFResponse(ctx context.Context, id uuid.UUID, digits string) error {
+	msg := f.messages[id]
+	if msg == nil || msg.MessageType != models.TypeCall {
+		return errors.New("call not found")
+	}
+	msg.DTMFResponse = &digits
+	return nil
+}
+
+func (f *callFixture) UpdateCallOutcome(ctx context.Context, callSID, callStatus string, duration *int, answered bool) (bool, error) {
+	for _, msg := range f.messages {
+		if msg.WAMessageID == nil || *msg.WAMessageID != callSID || msg.MessageType != models.TypeCall {
+			continue
+		}
+		msg.CallStatus = &callStatus
+		if duration != nil {
+			msg.CallDuration = duration
+		}
+		if answered {
+			msg.Status = models.MsgStatusDelivered
+		} else {
+			msg.Status = models.MsgStatusFailed
+			errorMessage := "call " + callStatus
+			msg.ErrorMessage = &errorMessage
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (f *callFixture) SetCallRecording(ctx context.Context, callSID, recordingURL string, duration *int) error {
+	return nil
+}
+
+type callFixtureLines struct{ *callFixture }
+
+func (f callFixtureLines) GetByID(ctx context.Context, id uuid.UUID) (*models.PendingLine, error) {
+	return f.lines[id], nil
+}
+
+type callFixtureClients struct{ *callFixture }
+
+func (f callFixtureClients) GetByID(ctx context.Context, id uuid.UUID) (*models.Client, error) {
+	return f.clients[id], nil
+}
+
+type callFixtureTasks struct{ *callFixture }
+
+func (f callFixtureTasks) Create(ctx context.Context, t *models.Task) error {
+	t.ID = uuid.New()
+	f.tasks = append(f.tasks, t)
+	return nil
+}
+
+func newCallFixture() *callFixture {
+	return &callFixture{
+		messages: make(map[uuid.UUID]*models.Message),
+		lines:    make(map[uuid.UUID]*models.PendingLine),
+		clients:  make(map[uuid.UUID]*models.Client),
+	}
+}
+
+// service returns a PhoneCallService on the fixture placing its calls with provider,
+// without ElevenLabs voice
+func (f *callFixture) service(provider telephony.Provider) *PhoneCallService {
+	return &PhoneCallService{
+		provider:   provider,
+		baseURL:    "https://fiducia.example",
+		msgRepo:    f,
+		lineRepo:   callFixtureLines{f},
+		clientRepo: callFixtureClients{f},
+		taskRepo:   callFixtureTasks{f},
+	}
+}
+
+// call stores the call message messageID, placed with SID callSID
+func (f *callFixture) call(messageID uuid.UUID, callSID string, line *models.PendingLine, clientID *uuid.UUID) *models.Message {
+	msg := &models.Message{
+		ID:          messageID,
+		ClientID:    clientID,
+		Direction:   models.DirectionOutbound,
+		MessageType: models.TypeCall,
+		Status:      models.MsgStatusSent,
+		WAMessageID: &callSID,
+	}
+	if line != nil {
+		msg.PendingLineID = &line.ID
+		f.lines[line.ID] = line
+	}
+	f.messages[messageID] = msg
+	return msg
+}
+
+func TestPhoneCallServiceCall(t *testing.T) {
+	ctx := context.Background()
+	provider := telephony.NewFakeProvider()
+	f := newCallFixture()
+	svc := f.service(provider)
+
+	messageID := uuid.New()
+	call, err := svc.Call(ctx, messageID, "Bonjour, il manque le justificatif d'une dépense.", "+33600000000")
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	calls := provider.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("%d calls placed, want 1", len(calls))
+	}
+	req := calls[0]
+	if req.To != "+33600000000" || req.AudioURL != "" || req.Prompt != callPrompt {
+		t.Errorf("call request = %+v", req)
+	}
+	if !strings.HasSuffix(req.GatherURL, "/webhook/voice/gather?message_id="+messageID.String()) {
+		t.Errorf("gather URL = %s, want the message ID", req.GatherURL)
+	}
+	if req.StatusCallbackURL != "https://fiducia.example/webhook/voice/status" {
+		t.Errorf("status callback URL = %s", req.StatusCallbackURL)
+	}
+
+	// The provider reports the outcome of the call once saved with its SID
+	clientID := uuid.New()
+	msg := f.call(messageID, call.SID, nil, &clientID)
+	duration := 42
+	if err := svc.HandleStatus(ctx, call.SID, telephony.StatusCompleted, &duration); err != nil {
+		t.Fatalf("HandleStatus: %v", err)
+	}
+	if msg.Status != models.MsgStatusDelivered || msg.CallDuration == nil || *msg.CallDuration != 42 {
+		t.Errorf("call message status %s duration %v, want delivered in 42s", msg.Status, msg.CallDuration)
+	}
+
+	provider.Err = errors.New("trunk down")
+	if _, err := svc.Call(ctx, uuid.New(), "Bonjour", "+33600000000"); err == nil {
+		t.Error("Call succeeded with a failing provider")
+	}
+}
+
+func TestHandleKeypress(t *testing.T) {
+	ctx := context.Background()
+	cabinetID, clientCabinetID, collaborator := uuid.New(), uuid.New(), uuid.New()
+	newLine := func() *models.PendingLine {
+		return &models.PendingLine{ID: uuid.New(), CabinetID: cabinetID, AssignedTo: &collaborator}
+	}
+
+	tests := []struct {
+		name      string
+		digits    string
+		line      bool   // Call about a line, about the client otherwise
+		wantTask  string // Title of the task created, empty when none
+		wantReply string // Part of the reply
+	}{
+		{"document already sent", KeyAlreadySent, true, "Justificatif déjà envoyé selon le client", "vérifier la réception"},
+		{"call back", KeyCallBack, true, "Rappel demandé par le client", "vous rappellera"},
+		{"call back about the client", KeyCallBack, false, "Rappel demandé par le client", "vous rappellera"},
+		{"other key", "9", true, "", "Merci, au revoir."},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newCallFixture()
+			clientID := uuid.New()
+			f.clients[clientID] = &models.Client{ID: clientID, CabinetID: clientCabinetID}
+			var line *models.PendingLine
+			if tc.line {
+				line = newLine()
+			}
+			msg := f.call(uuid.New(), "CA1", line, &clientID)
+
+			reply, err := f.service(telephony.NewFakeProvider()).HandleKeypress(ctx, msg.ID, tc.digits)
+			if err != nil {
+				t.Fatalf("HandleKeypress: %v", err)
+			}
+			if !strings.Contains(reply, tc.wantReply) {
+				t.Errorf("reply %q, want %q", reply, tc.wantReply)
+			}
+			if msg.DTMFResponse == nil || *msg.DTMFResponse != tc.digits {
+				t.Errorf("DTMF response %v, want %s", msg.DTMFResponse, tc.digits)
+			}
+
+			if tc.wantTask == "" {
+				if len(f.tasks) != 0 {
+					t.Errorf("%d tasks created, want none", len(f.tasks))
+				}
+				return
+			}
+			if len(f.tasks) != 1 {
+				t.Fatalf("%d tasks created, want 1", len(f.tasks))
+			}
+			task := f.tasks[0]
+			if task.Type != models.TaskCallResponse || task.Title != tc.wantTask {
+				t.Errorf("task %s %q, want %s %q", task.Type, task.Title, models.TaskCallResponse, tc.wantTask)
+			}
+			switch {
+			case tc.line && (task.CabinetID != cabinetID || task.AssignedTo == nil || *task.AssignedTo != collaborator):
+				t.Errorf("task in cabinet %s assigned to %v, want the line's", task.CabinetID, task.AssignedTo)
+			case !tc.line && (task.CabinetID != clientCabinetID || task.AssignedTo != nil):
+				t.Errorf("task in cabinet %s assigned to %v, want the client's, unassigned", task.CabinetID, task.AssignedTo)
+			}
+		})
+	}
+
+	t.Run("unknown call", func(t *testing.T) {
+		f := newCallFixture()
+		if _, err := f.service(telephony.NewFakeProvider()).HandleKeypress(ctx, uuid.New(), KeyCallBack); err == nil {
+			t.Error("HandleKeypress accepted an unknown message")
+		}
+	})
+
+	t.Run("message that is no call", func(t *testing.T) {
+		f := newCallFixture()
+		msg := f.call(uuid.New(), "SM1", newLine(), nil)
+		msg.MessageType = models.TypeText
+		if _, err := f.service(telephony.NewFakeProvider()).HandleKeypress(ctx, msg.ID, KeyCallBack); err == nil || len(f.tasks) != 0 {
+			t.Errorf("HandleKeypress = %v with %d tasks, want an error", err, len(f.tasks))
+		}
+	})
+}
+
+func TestHandleStatus(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		callSID    string // SID reported, the call being saved as CA1
+		status     string
+		err        error
+		wantStatus models.MessageStatus
+	}{
+		{"answered", "CA1", telephony.StatusCompleted, nil, models.MsgStatusDelivered},
+		{"not picked up", "CA1", telephony.StatusNoAnswer, nil, models.MsgStatusFailed},
+		{"busy", "CA1", telephony.StatusBusy, nil, models.MsgStatusFailed},
+		{"progress ignored", "CA1", "ringing", nil, models.MsgStatusSent},
+		{"unknown call", "CA2", telephony.StatusCompleted, ErrUnknownCall, models.MsgStatusSent},
+		{"progress of an unknown call ignored", "CA2", "in-progress", nil, models.MsgStatusSent},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newCallFixture()
+			msg := f.call(uuid.New(), "CA1", nil, nil)
+
+			err := f.service(telephony.NewFakeProvider()).HandleStatus(ctx, tc.callSID, tc.status, nil)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("HandleStatus = %v, want %v", err, tc.err)
+			}
+			if msg.Status != tc.wantStatus {
+				t.Errorf("call message status %s, want %s", msg.Status, tc.wantStatus)
+			}
+			if tc.wantStatus == models.MsgStatusFailed && (msg.ErrorMessage == nil || *msg.ErrorMessage != "call "+tc.status) {
+				t.Errorf("error message %v, want the call outcome", msg.ErrorMessage)
+			}
+		})
+	}
+}
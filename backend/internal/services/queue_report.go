@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/queue"
+)
+
+// QueueReport returns the operations report of the message queue. With all, it
+// covers every cabinet; otherwise only the jobs of the cabinet and the rate-limited
+// phones of its clients. A phone is rate-limited once it reached the daily limit of
+// the cabinet of its client, the lowest one when several cabinets share it.
+func (s *MessageService) QueueReport(ctx context.Context, cabinetID uuid.UUID, all bool) (*queue.Report, error) {
+	if s.queue == nil {
+		return nil, ErrQueueUnavailable
+	}
+
+	limits := make(map[uuid.UUID]int)
+	limitOf := func(id uuid.UUID) int {
+		limit, ok := limits[id]
+		if !ok {
+			limit = s.PolicyFor(ctx, id).MaxPerPhonePerDay
+			limits[id] = limit
+		}
+		return limit
+	}
+
+	// Across cabinets, every phone contacted today is read and checked against the
+	// limit of its own cabinet
+	opts := queue.ReportOptions{MaxPerPhone: 1}
+	var scope *uuid.UUID
+	if !all {
+		opts = queue.ReportOptions{CabinetID: cabinetID.String(), MaxPerPhone: limitOf(cabinetID)}
+		scope = &cabinetID
+	}
+	report, err := s.queue.Report(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Recipient counters are shared by all cabinets
+	phones := make([]string, len(report.Recipients))
+	for i, r := range report.Recipients {
+		phones[i] = r.Phone
+	}
+	cabinets, err := s.clientRepo.CabinetsByPhones(ctx, scope, phones)
+	if err != nil {
+		return nil, err
+	}
+	defaultLimit := 0
+	if scope == nil {
+		defaultLimit = s.policy.MaxPerPhonePerDay // Phones of no client
+	}
+	report.Recipients = limitedRecipients(report.Recipients, cabinets, limitOf, defaultLimit)
+	return report, nil
+}
+
+// limitedRecipients keeps the recipients that reached the lowest daily limit of the
+// cabinets of their clients, with that limit. Phones of no client are checked against
+// defaultLimit, and dropped when it is 0.
+func limitedRecipients(recipients []queue.LimitedRecipient, cabinets map[string][]uuid.UUID, limitOf func(uuid.UUID) int, defaultLimit int) []queue.LimitedRecipient {
+	out := recipients[:0]
+	for _, r := range recipients {
+		limit := 0
+		if ids, ok := cabinets[r.Phone]; ok {
+			for _, id := range ids {
+				if l := limitOf(id); l > 0 && (limit == 0 || l < limit) {
+					limit = l
+				}
+			}
+		} else {
+			limit = defaultLimit
+		}
+		if limit <= 0 || r.Sent < limit {
+			continue
+		}
+		r.Limit = limit
+		out = append(out, r)
+	}
+	return out
+}
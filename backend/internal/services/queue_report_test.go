@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/queue"
+)
+
+func TestLimitedRecipients(t *testing.T) {
+	strict, loose, unlimited := uuid.New(), uuid.New(), uuid.New()
+	limits := map[uuid.UUID]int{strict: 2, loose: 5, unlimited: 0}
+	limitOf := func(id uuid.UUID) int { return limits[id] }
+	cabinets := map[string][]uuid.UUID{
+		"+33600000001": {strict},
+		"+33600000002": {loose},
+		"+33600000003": {loose},
+		"+33600000004": {loose, strict},
+		"+33600000005": {unlimited},
+	}
+	recipients := func() []queue.LimitedRecipient {
+		return []queue.LimitedRecipient{
+			{Phone: "+33600000001", Sent: 2},  // Strict cabinet limit reached
+			{Phone: "+33600000002", Sent: 3},  // Below the loose cabinet limit
+			{Phone: "+33600000003", Sent: 5},  // Loose cabinet limit reached
+			{Phone: "+33600000004", Sent: 3},  // Strict limit of the shared phone reached
+			{Phone: "+33600000005", Sent: 10}, // No limit
+			{Phone: "+33600000009", Sent: 3},  // No client
+		}
+	}
+
+	tests := []struct {
+		name         string
+		defaultLimit int
+		want         string
+	}{
+		{"without default", 0, "[{+33600000001 2} {+33600000003 5} {+33600000004 2}]"},
+		{"with default for unknown phones", 3, "[{+33600000001 2} {+33600000003 5} {+33600000004 2} {+33600000009 3}]"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, r := range limitedRecipients(recipients(), cabinets, limitOf, tc.defaultLimit) {
+				got = append(got, fmt.Sprintf("{%s %d}", r.Phone, r.Limit))
+			}
+			if s := fmt.Sprint(got); s != tc.want {
+				t.Errorf("limitedRecipients = %s, want %s", s, tc.want)
+			}
+		})
+	}
+}
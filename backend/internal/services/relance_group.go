@@ -0,0 +1,61 @@
+package services
+
+import (
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+// RelanceGroup is what a campaign execution relances: a single pending line, or all
+// the open lines of a client when the campaign is grouped by client.
+// Lines are expected to share the same cabinet and client.
+type RelanceGroup struct {
+	Lines []*models.PendingLine
+}
+
+// SingleLine returns the group of a line execution
+func SingleLine(line *models.PendingLine) RelanceGroup {
+	return RelanceGroup{Lines: []*models.PendingLine{line}}
+}
+
+// Primary returns the first line, which carries the cabinet, client and collaborator
+func (g RelanceGroup) Primary() *models.PendingLine {
+	if len(g.Lines) == 0 {
+		return nil
+	}
+	return g.Lines[0]
+}
+
+// CabinetID returns the cabinet of the lines
+func (g RelanceGroup) CabinetID() uuid.UUID {
+	return g.Primary().CabinetID
+}
+
+// ClientID returns the client relanced, nil when the line has no client
+func (g RelanceGroup) ClientID() *uuid.UUID {
+	return g.Primary().ClientID
+}
+
+// Client returns the client relanced with its contact details, if loaded
+func (g RelanceGroup) Client() *models.Client {
+	return g.Primary().Client
+}
+
+// LineID returns the line of a single line group, nil for a client group.
+// Messages and tasks are only attached to a line when there is exactly one.
+func (g RelanceGroup) LineID() *uuid.UUID {
+	if len(g.Lines) != 1 {
+		return nil
+	}
+	return &g.Lines[0].ID
+}
+
+// Total returns the sum of the absolute amounts of the lines
+func (g RelanceGroup) Total() decimal.Decimal {
+	total := decimal.Zero
+	for _, l := range g.Lines {
+		total = total.Add(l.Amount.Abs())
+	}
+	return total
+}
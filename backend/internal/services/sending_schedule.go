@@ -0,0 +1,196 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/pkg/holidays"
+)
+
+// DefaultSendingSettings is used when a cabinet has not configured its sending windows
+var DefaultSendingSettings = models.SendingSettings{
+	Timezone:        "Europe/Paris",
+	HolidayCalendar: string(holidays.CalendarFrance),
+	Windows: []models.SendingWindow{
+		{Days: []int{1, 2, 3, 4, 5}, Start: "08:00", End: "18:00"},
+	},
+}
+
+// SendingSchedule decides when automated messages may be sent for a cabinet
+type SendingSchedule struct {
+	location      *time.Location
+	calendar      holidays.Calendar
+	extraHolidays map[string]bool
+	// windows[weekday] holds [start, end) ranges in minutes since midnight, sorted
+	windows [7][][2]int
+}
+
+// NewSendingSchedule validates settings and builds a schedule
+func NewSendingSchedule(settings models.SendingSettings) (*SendingSchedule, error) {
+	if settings.Timezone == "" {
+		settings.Timezone = DefaultSendingSettings.Timezone
+	}
+	if settings.HolidayCalendar == "" {
+		settings.HolidayCalendar = DefaultSendingSettings.HolidayCalendar
+	}
+	if settings.Windows == nil {
+		settings.Windows = DefaultSendingSettings.Windows
+	}
+	if len(settings.Windows) == 0 {
+		// No window would hold every execution in quiet hours forever
+		return nil, fmt.Errorf("at least one sending window is required")
+	}
+
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", settings.Timezone, err)
+	}
+
+	cal := holidays.Calendar(settings.HolidayCalendar)
+	if !holidays.IsValid(cal) {
+		return nil, fmt.Errorf("unknown holiday calendar %q", settings.HolidayCalendar)
+	}
+
+	s := &SendingSchedule{
+		location:      loc,
+		calendar:      cal,
+		extraHolidays: make(map[string]bool),
+	}
+
+	for _, d := range settings.ExtraHolidays {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return nil, fmt.Errorf("invalid extra holiday %q: expected YYYY-MM-DD", d)
+		}
+		s.extraHolidays[d] = true
+	}
+
+	for i, w := range settings.Windows {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i, err)
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i, err)
+		}
+		if end <= start {
+			return nil, fmt.Errorf("window %d: end must be after start", i)
+		}
+		if len(w.Days) == 0 {
+			return nil, fmt.Errorf("window %d: at least one day is required", i)
+		}
+		for _, day := range w.Days {
+			if day < 1 || day > 7 {
+				return nil, fmt.Errorf("window %d: invalid day %d (1 = Monday ... 7 = Sunday)", i, day)
+			}
+			wd := day % 7 // ISO 7 (Sunday) -> time.Sunday (0)
+			s.windows[wd] = append(s.windows[wd], [2]int{start, end})
+		}
+	}
+
+	for wd := range s.windows {
+		sort.Slice(s.windows[wd], func(i, j int) bool { return s.windows[wd][i][0] < s.windows[wd][j][0] })
+	}
+
+	return s, nil
+}
+
+// ParseSendingSettings extracts the sending settings from a cabinet's settings map
+func ParseSendingSettings(settings map[string]any) (models.SendingSettings, error) {
+	var out models.SendingSettings
+	raw, ok := settings["sending"]
+	if !ok || raw == nil {
+		return DefaultSendingSettings, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("invalid sending settings: %w", err)
+	}
+	return out, nil
+}
+
+// SendingScheduleForCabinet builds the schedule configured for a cabinet
+func SendingScheduleForCabinet(cabinet *models.Cabinet) (*SendingSchedule, error) {
+	if cabinet == nil {
+		return NewSendingSchedule(DefaultSendingSettings)
+	}
+	settings, err := ParseSendingSettings(cabinet.Settings)
+	if err != nil {
+		return nil, err
+	}
+	return NewSendingSchedule(settings)
+}
+
+// Location returns the cabinet's timezone
+func (s *SendingSchedule) Location() *time.Location {
+	return s.location
+}
+
+// IsQuiet returns true if sending is not allowed at t
+func (s *SendingSchedule) IsQuiet(t time.Time) bool {
+	local := t.In(s.location)
+	if s.isHoliday(local) {
+		return true
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	for _, w := range s.windows[local.Weekday()] {
+		if minute >= w[0] && minute < w[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// NextAllowed returns t if sending is allowed at t, otherwise the start of the next
+// sending window. It returns false if no window opens within a year.
+func (s *SendingSchedule) NextAllowed(t time.Time) (time.Time, bool) {
+	local := t.In(s.location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.location)
+
+	for i := 0; i < 366; i++ {
+		if !s.isHoliday(day) {
+			for _, w := range s.windows[day.Weekday()] {
+				start := time.Date(day.Year(), day.Month(), day.Day(), w[0]/60, w[0]%60, 0, 0, s.location)
+				end := time.Date(day.Year(), day.Month(), day.Day(), w[1]/60, w[1]%60, 0, 0, s.location)
+				if !local.Before(end) {
+					continue
+				}
+				if local.Before(start) {
+					return start, true
+				}
+				return t, true
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return time.Time{}, false
+}
+
+func (s *SendingSchedule) isHoliday(local time.Time) bool {
+	if s.extraHolidays[local.Format("2006-01-02")] {
+		return true
+	}
+	_, ok := holidays.IsHoliday(s.calendar, local)
+	return ok
+}
+
+// parseClock parses "HH:MM" into minutes since midnight ("24:00" is allowed as an end)
+func parseClock(v string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", v)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", v)
+	}
+	return h*60 + m, nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiducia/backend/internal/models"
+)
+
+func mustSchedule(t *testing.T, settings models.SendingSettings) *SendingSchedule {
+	t.Helper()
+	s, err := NewSendingSchedule(settings)
+	if err != nil {
+		t.Fatalf("NewSendingSchedule: %v", err)
+	}
+	return s
+}
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestNewSendingSchedule(t *testing.T) {
+	weekdays := []models.SendingWindow{{Days: []int{1, 2, 3, 4, 5}, Start: "08:00", End: "18:00"}}
+	tests := []struct {
+		name     string
+		settings models.SendingSettings
+		err      string // Part of the expected error, empty when valid
+	}{
+		{"defaults", models.SendingSettings{}, ""},
+		{"window until midnight", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{7}, Start: "20:00", End: "24:00"}}}, ""},
+		{"no window", models.SendingSettings{Windows: []models.SendingWindow{}}, "at least one sending window"},
+		{"end before start", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{1}, Start: "18:00", End: "08:00"}}}, "end must be after start"},
+		{"empty window", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{1}, Start: "08:00", End: "08:00"}}}, "end must be after start"},
+		{"no day", models.SendingSettings{Windows: []models.SendingWindow{{Start: "08:00", End: "18:00"}}}, "at least one day"},
+		{"day zero", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{0}, Start: "08:00", End: "18:00"}}}, "invalid day 0"},
+		{"day eight", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{8}, Start: "08:00", End: "18:00"}}}, "invalid day 8"},
+		{"malformed time", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{1}, Start: "8h", End: "18:00"}}}, "invalid time"},
+		{"after midnight", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{1}, Start: "08:00", End: "24:30"}}}, "invalid time"},
+		{"minutes out of range", models.SendingSettings{Windows: []models.SendingWindow{{Days: []int{1}, Start: "08:60", End: "18:00"}}}, "invalid time"},
+		{"unknown timezone", models.SendingSettings{Timezone: "Europe/Lutece", Windows: weekdays}, "invalid timezone"},
+		{"unknown calendar", models.SendingSettings{HolidayCalendar: "gaul", Windows: weekdays}, "unknown holiday calendar"},
+		{"malformed extra holiday", models.SendingSettings{ExtraHolidays: []string{"14/07/2025"}, Windows: weekdays}, "invalid extra holiday"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSendingSchedule(tc.settings)
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("NewSendingSchedule: %v", err)
+			case tc.err != "" && err == nil:
+				t.Errorf("NewSendingSchedule accepted the settings, want %q", tc.err)
+			case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+				t.Errorf("NewSendingSchedule: %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSendingScheduleIsQuiet(t *testing.T) {
+	paris := mustLocation(t, "Europe/Paris")
+	reunion := mustLocation(t, "Indian/Reunion")
+	france := mustSchedule(t, DefaultSendingSettings)
+	// The same windows and calendar, in the timezone of Réunion (UTC+4, no DST)
+	reunionSettings := DefaultSendingSettings
+	reunionSettings.Timezone = "Indian/Reunion"
+	island := mustSchedule(t, reunionSettings)
+
+	tests := []struct {
+		name     string
+		schedule *SendingSchedule
+		t        time.Time
+		want     bool
+	}{
+		{"weekday morning", france, time.Date(2025, 6, 2, 9, 0, 0, 0, paris), false},
+		{"start of the window", france, time.Date(2025, 6, 2, 8, 0, 0, 0, paris), false},
+		{"before the window", france, time.Date(2025, 6, 2, 7, 59, 0, 0, paris), true},
+		{"end of the window excluded", france, time.Date(2025, 6, 2, 18, 0, 0, 0, paris), true},
+		{"saturday", france, time.Date(2025, 6, 7, 10, 0, 0, 0, paris), true},
+		{"public holiday", france, time.Date(2025, 5, 1, 10, 0, 0, 0, paris), true},
+		{"winter time, 07:30 in Paris", france, time.Date(2025, 3, 28, 6, 30, 0, 0, time.UTC), true},
+		{"summer time, 08:30 in Paris", france, time.Date(2025, 3, 31, 6, 30, 0, 0, time.UTC), false},
+		{"09:00 in Réunion, 07:00 in Paris", island, time.Date(2025, 6, 2, 5, 0, 0, 0, time.UTC), false},
+		{"07:00 in Paris, 09:00 in Réunion", france, time.Date(2025, 6, 2, 5, 0, 0, 0, time.UTC), true},
+		{"19:00 in Réunion", island, time.Date(2025, 6, 2, 19, 0, 0, 0, reunion), true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.schedule.IsQuiet(tc.t); got != tc.want {
+				t.Errorf("IsQuiet(%s) = %v, want %v", tc.t, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSendingScheduleNextAllowed(t *testing.T) {
+	paris := mustLocation(t, "Europe/Paris")
+	france := mustSchedule(t, DefaultSendingSettings)
+	split := mustSchedule(t, models.SendingSettings{
+		HolidayCalendar: "none",
+		Windows: []models.SendingWindow{
+			{Days: []int{1, 2, 3, 4, 5}, Start: "14:00", End: "17:00"},
+			{Days: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "12:00"},
+			{Days: []int{7}, Start: "10:00", End: "11:00"},
+		},
+	})
+	extra := mustSchedule(t, models.SendingSettings{
+		ExtraHolidays: []string{"2025-06-02"},
+		Windows:       DefaultSendingSettings.Windows,
+	})
+
+	tests := []struct {
+		name     string
+		schedule *SendingSchedule
+		t        time.Time
+		want     time.Time
+	}{
+		{"within the window", france, time.Date(2025, 6, 2, 9, 15, 0, 0, paris), time.Date(2025, 6, 2, 9, 15, 0, 0, paris)},
+		{"early morning", france, time.Date(2025, 6, 2, 6, 0, 0, 0, paris), time.Date(2025, 6, 2, 8, 0, 0, 0, paris)},
+		{"over the weekend", france, time.Date(2025, 6, 13, 19, 0, 0, 0, paris), time.Date(2025, 6, 16, 8, 0, 0, 0, paris)},
+		{"over the switch to summer time", france, time.Date(2025, 3, 29, 20, 0, 0, 0, paris), time.Date(2025, 3, 31, 6, 0, 0, 0, time.UTC)},
+		{"over the switch to winter time", france, time.Date(2025, 10, 24, 19, 0, 0, 0, paris), time.Date(2025, 10, 27, 7, 0, 0, 0, time.UTC)},
+		{"over a public holiday", france, time.Date(2025, 4, 30, 18, 30, 0, 0, paris), time.Date(2025, 5, 2, 8, 0, 0, 0, paris)},
+		{"over an extra holiday", extra, time.Date(2025, 6, 1, 12, 0, 0, 0, paris), time.Date(2025, 6, 3, 8, 0, 0, 0, paris)},
+		{"between two windows", split, time.Date(2025, 6, 2, 12, 30, 0, 0, paris), time.Date(2025, 6, 2, 14, 0, 0, 0, paris)},
+		{"sunday window", split, time.Date(2025, 6, 7, 18, 0, 0, 0, paris), time.Date(2025, 6, 8, 10, 0, 0, 0, paris)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tc.schedule.NextAllowed(tc.t)
+			if !ok || !got.Equal(tc.want) {
+				t.Errorf("NextAllowed(%s) = %s, %v, want %s", tc.t, got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSendingSettings(t *testing.T) {
+	got, err := ParseSendingSettings(map[string]any{})
+	if err != nil || got.Timezone != DefaultSendingSettings.Timezone {
+		t.Errorf("ParseSendingSettings(no settings) = %+v, %v, want the defaults", got, err)
+	}
+
+	got, err = ParseSendingSettings(map[string]any{"sending": map[string]any{
+		"timezone": "Africa/Casablanca",
+		"windows":  []any{map[string]any{"days": []any{1, 2}, "start": "09:00", "end": "17:00"}},
+	}})
+	if err != nil {
+		t.Fatalf("ParseSendingSettings: %v", err)
+	}
+	if got.Timezone != "Africa/Casablanca" || len(got.Windows) != 1 || len(got.Windows[0].Days) != 2 {
+		t.Errorf("ParseSendingSettings = %+v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/fiducia/backend/internal/config"
+	"github.com/fiducia/backend/internal/models"
+	"github.com/fiducia/backend/pkg/whatsapp"
+)
+
+// WhatsAppProviders returns the WhatsApp providers configured in cfg: Twilio when
+// its account is set, Meta when its access token and phone number ID are. Twilio
+// reports delivery statuses to the status webhook of BaseURL, Meta to its app webhook.
+func WhatsAppProviders(cfg *config.Config) *whatsapp.Providers {
+	providers := whatsapp.NewProviders(cfg.WhatsAppProvider)
+	if cfg.TwilioAccountSID != "" {
+		twilio := whatsapp.NewTwilioClient(cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioPhoneNumber)
+		if cfg.BaseURL != "" {
+			twilio.SetStatusCallback(strings.TrimRight(cfg.BaseURL, "/") + "/webhook/whatsapp/status")
+		}
+		providers.Register(whatsapp.Provider{
+			Name:            whatsapp.ProviderTwilio,
+			Client:          twilio,
+			Sender:          cfg.TwilioPhoneNumber,
+			SessionTemplate: cfg.TwilioSessionTemplate,
+		})
+	}
+	if cfg.MetaAccessToken != "" && cfg.MetaPhoneNumberID != "" {
+		sender := cfg.MetaPhoneNumber
+		if sender == "" {
+			sender = cfg.MetaPhoneNumberID
+		}
+		providers.Register(whatsapp.Provider{
+			Name:            whatsapp.ProviderMeta,
+			Client:          whatsapp.NewMetaClient(cfg.MetaAccessToken, cfg.MetaPhoneNumberID, cfg.MetaAPIVersion, cfg.MetaTemplateLanguage),
+			Sender:          sender,
+			SessionTemplate: cfg.MetaSessionTemplate,
+		})
+	}
+	return providers
+}
+
+// ParseWhatsAppSettings extracts the WhatsApp settings from a cabinet's settings map
+func ParseWhatsAppSettings(settings map[string]any) (models.WhatsAppSettings, error) {
+	var out models.WhatsAppSettings
+	raw, ok := settings["whatsapp"]
+	if !ok || raw == nil {
+		return out, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("invalid WhatsApp settings: %w", err)
+	}
+	return out, nil
+}
+
+// ValidateWhatsAppSettings checks that the provider chosen by a cabinet is configured
+func (s *MessageService) ValidateWhatsAppSettings(settings models.WhatsAppSettings) error {
+	if settings.Provider == "" {
+		return nil
+	}
+	_, err := s.providers.Get(settings.Provider)
+	return err
+}
+
+// route returns the WhatsApp provider of a cabinet, with the session template of the
+// cabinet if any, and its anti-ban policy for the provider sender number. An unconfigured provider is returned by name only, its
+// messages fail when sent.
+func (s *MessageService) route(ctx context.Context, cabinetID uuid.UUID) (whatsapp.Provider, AntiBanPolicy) {
+	var cabinet *models.Cabinet
+	if s.cabinetRepo != nil {
+		c, err := s.cabinetRepo.GetByID(ctx, cabinetID)
+		if err != nil {
+			slog.Warn("failed to load cabinet settings", "cabinetID", cabinetID, "error", err)
+		}
+		cabinet = c
+	}
+
+	var settings models.WhatsAppSettings
+	if cabinet != nil {
+		var err error
+		settings, err = ParseWhatsAppSettings(cabinet.Settings)
+		if err != nil {
+			slog.Warn("invalid WhatsApp settings, using the default provider", "cabinetID", cabinetID, "error", err)
+		}
+	}
+	provider, err := s.providers.Get(settings.Provider)
+	if err != nil {
+		provider = whatsapp.Provider{Name: settings.Provider}
+	}
+	if settings.SessionTemplate != "" {
+		provider.SessionTemplate = settings.SessionTemplate
+	}
+
+	policy, err := AntiBanPolicyFor(cabinet, provider.Sender, s.policy)
+	if err != nil {
+		slog.Warn("invalid anti-ban settings, using defaults", "cabinetID", cabinetID, "error", err)
+		policy = s.policy
+	}
+	return provider, policy
+}
@@ -0,0 +1,188 @@
+package holidays
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Calendar identifies a set of public holidays
+type Calendar string
+
+const (
+	CalendarNone          Calendar = "none"
+	CalendarFrance        Calendar = "fr"                // Metropolitan France
+	CalendarAlsaceMoselle Calendar = "fr-alsace-moselle" // Bas-Rhin, Haut-Rhin, Moselle
+	CalendarGuadeloupe    Calendar = "fr-971"
+	CalendarMartinique    Calendar = "fr-972"
+	CalendarGuyane        Calendar = "fr-973"
+	CalendarReunion       Calendar = "fr-974"
+	CalendarMayotte       Calendar = "fr-976"
+	CalendarMorocco       Calendar = "ma"
+)
+
+// Holiday is a single public holiday
+type Holiday struct {
+	Date time.Time `json:"date"`
+	Name string    `json:"name"`
+}
+
+var cache sync.Map // "calendar:year" -> []Holiday
+
+// IsValid returns true if the calendar is known
+func IsValid(c Calendar) bool {
+	switch c {
+	case CalendarNone, CalendarFrance, CalendarAlsaceMoselle,
+		CalendarGuadeloupe, CalendarMartinique, CalendarGuyane, CalendarReunion, CalendarMayotte,
+		CalendarMorocco:
+		return true
+	}
+	return false
+}
+
+// ForYear returns the holidays of a calendar for a given year, sorted by date
+func ForYear(c Calendar, year int) []Holiday {
+	key := fmt.Sprintf("%s:%d", c, year)
+	if cached, ok := cache.Load(key); ok {
+		return cached.([]Holiday)
+	}
+
+	var list []Holiday
+	switch c {
+	case CalendarFrance:
+		list = france(year)
+	case CalendarAlsaceMoselle:
+		list = append(france(year),
+			Holiday{Date: easter(year).AddDate(0, 0, -2), Name: "Vendredi saint"},
+			Holiday{Date: date(year, time.December, 26), Name: "Saint-Étienne"},
+		)
+	case CalendarGuadeloupe:
+		list = append(france(year), Holiday{Date: date(year, time.May, 27), Name: "Abolition de l'esclavage"})
+	case CalendarMartinique:
+		list = append(france(year), Holiday{Date: date(year, time.May, 22), Name: "Abolition de l'esclavage"})
+	case CalendarGuyane:
+		list = append(france(year), Holiday{Date: date(year, time.June, 10), Name: "Abolition de l'esclavage"})
+	case CalendarReunion:
+		list = append(france(year), Holiday{Date: date(year, time.December, 20), Name: "Abolition de l'esclavage"})
+	case CalendarMayotte:
+		list = append(france(year), Holiday{Date: date(year, time.April, 27), Name: "Abolition de l'esclavage"})
+	case CalendarMorocco:
+		list = morocco(year)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Date.Before(list[j].Date) })
+	cache.Store(key, list)
+	return list
+}
+
+// IsHoliday returns the holiday falling on the calendar day of t, if any.
+// Only the year, month and day of t are considered.
+func IsHoliday(c Calendar, t time.Time) (*Holiday, bool) {
+	day := date(t.Year(), t.Month(), t.Day())
+	for _, h := range ForYear(c, t.Year()) {
+		if h.Date.Equal(day) {
+			return &h, true
+		}
+	}
+	return nil, false
+}
+
+// france returns the national holidays of metropolitan France
+func france(year int) []Holiday {
+	e := easter(year)
+	return []Holiday{
+		{Date: date(year, time.January, 1), Name: "Jour de l'an"},
+		{Date: e.AddDate(0, 0, 1), Name: "Lundi de Pâques"},
+		{Date: date(year, time.May, 1), Name: "Fête du Travail"},
+		{Date: date(year, time.May, 8), Name: "Victoire 1945"},
+		{Date: e.AddDate(0, 0, 39), Name: "Ascension"},
+		{Date: e.AddDate(0, 0, 50), Name: "Lundi de Pentecôte"},
+		{Date: date(year, time.July, 14), Name: "Fête nationale"},
+		{Date: date(year, time.August, 15), Name: "Assomption"},
+		{Date: date(year, time.November, 1), Name: "Toussaint"},
+		{Date: date(year, time.November, 11), Name: "Armistice 1918"},
+		{Date: date(year, time.December, 25), Name: "Noël"},
+	}
+}
+
+// morocco returns the civil and religious holidays of Morocco.
+// Religious holidays follow the tabular Hijri calendar; the official dates
+// depend on moon sighting and may differ by a day.
+func morocco(year int) []Holiday {
+	list := []Holiday{
+		{Date: date(year, time.January, 1), Name: "Nouvel an"},
+		{Date: date(year, time.January, 11), Name: "Manifeste de l'indépendance"},
+		{Date: date(year, time.May, 1), Name: "Fête du Travail"},
+		{Date: date(year, time.July, 30), Name: "Fête du Trône"},
+		{Date: date(year, time.August, 14), Name: "Allégeance Oued Eddahab"},
+		{Date: date(year, time.August, 20), Name: "Révolution du Roi et du Peuple"},
+		{Date: date(year, time.August, 21), Name: "Fête de la Jeunesse"},
+		{Date: date(year, time.November, 6), Name: "Marche Verte"},
+		{Date: date(year, time.November, 18), Name: "Fête de l'Indépendance"},
+	}
+	if year >= 2024 {
+		list = append(list, Holiday{Date: date(year, time.January, 14), Name: "Nouvel an amazigh"})
+	}
+	if year >= 2025 {
+		list = append(list, Holiday{Date: date(year, time.October, 31), Name: "Fête de l'Unité"})
+	}
+
+	religious := []struct {
+		month, day int
+		name       string
+	}{
+		{1, 1, "Nouvel an de l'Hégire"},
+		{3, 12, "Aïd Al Mawlid"},
+		{3, 13, "Aïd Al Mawlid"},
+		{10, 1, "Aïd Al Fitr"},
+		{10, 2, "Aïd Al Fitr"},
+		{12, 10, "Aïd Al Adha"},
+		{12, 11, "Aïd Al Adha"},
+	}
+
+	// A Gregorian year overlaps two or three Hijri years
+	approx := (year - 622) * 33 / 32
+	for hy := approx - 1; hy <= approx+2; hy++ {
+		for _, r := range religious {
+			d := hijriToDate(hy, r.month, r.day)
+			if d.Year() == year {
+				list = append(list, Holiday{Date: d, Name: r.name})
+			}
+		}
+	}
+
+	return list
+}
+
+// easter computes Easter Sunday (anonymous Gregorian algorithm)
+func easter(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
+
+// hijriToDate converts a tabular Hijri date to a Gregorian date
+func hijriToDate(year, month, day int) time.Time {
+	jd := float64(day) + math.Ceil(29.5*float64(month-1)) + float64((year-1)*354) +
+		math.Floor(float64(3+11*year)/30) + 1948439.5 - 1
+	// Julian day 2440587.5 is 1970-01-01
+	return time.Unix(0, 0).UTC().AddDate(0, 0, int(math.Floor(jd-2440587.5)))
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
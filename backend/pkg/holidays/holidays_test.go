@@ -0,0 +1,126 @@
+package holidays
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEaster(t *testing.T) {
+	tests := []struct {
+		year int
+		want string
+	}{
+		{1818, "1818-03-22"}, // Earliest possible date
+		{1943, "1943-04-25"}, // Latest possible date
+		{2000, "2000-04-23"},
+		{2008, "2008-03-23"},
+		{2019, "2019-04-21"},
+		{2024, "2024-03-31"},
+		{2025, "2025-04-20"},
+		{2026, "2026-04-05"},
+		{2038, "2038-04-25"},
+		{2285, "2285-03-22"},
+	}
+	for _, tc := range tests {
+		if got := easter(tc.year).Format("2006-01-02"); got != tc.want {
+			t.Errorf("easter(%d) = %s, want %s", tc.year, got, tc.want)
+		}
+	}
+}
+
+func TestHijriToDate(t *testing.T) {
+	tests := []struct {
+		year, month, day int
+		want             string
+	}{
+		{1, 1, 1, "0622-07-19"}, // Epoch of the tabular calendar (16 July 622, Julian)
+		{1445, 10, 1, "2024-04-10"},
+		{1445, 12, 10, "2024-06-17"},
+		{1446, 1, 1, "2024-07-08"},
+		{1446, 10, 1, "2025-03-31"},
+		{1446, 12, 10, "2025-06-07"},
+	}
+	for _, tc := range tests {
+		if got := hijriToDate(tc.year, tc.month, tc.day).Format("2006-01-02"); got != tc.want {
+			t.Errorf("hijriToDate(%d, %d, %d) = %s, want %s", tc.year, tc.month, tc.day, got, tc.want)
+		}
+	}
+}
+
+func TestIsHoliday(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		calendar Calendar
+		t        time.Time
+		want     string // Name of the holiday, empty when none
+	}{
+		{"new year", CalendarFrance, time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC), "Jour de l'an"},
+		{"Easter Monday", CalendarFrance, time.Date(2025, 4, 21, 10, 0, 0, 0, time.UTC), "Lundi de Pâques"},
+		{"Easter Sunday is no extra holiday", CalendarFrance, time.Date(2025, 4, 20, 10, 0, 0, 0, time.UTC), ""},
+		{"Ascension", CalendarFrance, time.Date(2025, 5, 29, 10, 0, 0, 0, time.UTC), "Ascension"},
+		{"Whit Monday", CalendarFrance, time.Date(2025, 6, 9, 10, 0, 0, 0, time.UTC), "Lundi de Pentecôte"},
+		{"working day", CalendarFrance, time.Date(2025, 6, 10, 10, 0, 0, 0, time.UTC), ""},
+		{"local calendar day, not UTC", CalendarFrance, time.Date(2025, 7, 14, 0, 30, 0, 0, paris), "Fête nationale"},
+		{"Good Friday in France", CalendarFrance, time.Date(2025, 4, 18, 10, 0, 0, 0, time.UTC), ""},
+		{"Good Friday in Alsace-Moselle", CalendarAlsaceMoselle, time.Date(2025, 4, 18, 10, 0, 0, 0, time.UTC), "Vendredi saint"},
+		{"Saint Stephen in Alsace-Moselle", CalendarAlsaceMoselle, time.Date(2025, 12, 26, 10, 0, 0, 0, time.UTC), "Saint-Étienne"},
+		{"abolition in Réunion", CalendarReunion, time.Date(2025, 12, 20, 10, 0, 0, 0, time.UTC), "Abolition de l'esclavage"},
+		{"abolition in Martinique", CalendarMartinique, time.Date(2025, 5, 22, 10, 0, 0, 0, time.UTC), "Abolition de l'esclavage"},
+		{"Eid al-Fitr in Morocco", CalendarMorocco, time.Date(2025, 3, 31, 10, 0, 0, 0, time.UTC), "Aïd Al Fitr"},
+		{"Eid al-Adha second day in Morocco", CalendarMorocco, time.Date(2025, 6, 8, 10, 0, 0, 0, time.UTC), "Aïd Al Adha"},
+		{"Throne Day in Morocco", CalendarMorocco, time.Date(2025, 7, 30, 10, 0, 0, 0, time.UTC), "Fête du Trône"},
+		{"Amazigh new year before 2024", CalendarMorocco, time.Date(2023, 1, 14, 10, 0, 0, 0, time.UTC), ""},
+		{"Amazigh new year from 2024", CalendarMorocco, time.Date(2024, 1, 14, 10, 0, 0, 0, time.UTC), "Nouvel an amazigh"},
+		{"no calendar", CalendarNone, time.Date(2025, 12, 25, 10, 0, 0, 0, time.UTC), ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h, ok := IsHoliday(tc.calendar, tc.t)
+			switch {
+			case tc.want == "" && ok:
+				t.Errorf("IsHoliday = %s, want none", h.Name)
+			case tc.want != "" && !ok:
+				t.Errorf("IsHoliday = none, want %s", tc.want)
+			case ok && h.Name != tc.want:
+				t.Errorf("IsHoliday = %s, want %s", h.Name, tc.want)
+			}
+		})
+	}
+}
+
+func TestForYearMorocco(t *testing.T) {
+	// The Hijri year being 11 days shorter, the Hijri new year fell twice in 2008
+	count := 0
+	for _, h := range ForYear(CalendarMorocco, 2008) {
+		if h.Name == "Nouvel an de l'Hégire" {
+			count++
+		}
+	}
+	if count != 2 {
+		t.Errorf("%d Hijri new years in 2008, want 2", count)
+	}
+
+	list := ForYear(CalendarMorocco, 2025)
+	for i := 1; i < len(list); i++ {
+		if list[i].Date.Before(list[i-1].Date) {
+			t.Fatalf("holidays not sorted: %s before %s", list[i-1].Date, list[i].Date)
+		}
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	for _, c := range []Calendar{CalendarNone, CalendarFrance, CalendarAlsaceMoselle, CalendarMayotte, CalendarMorocco} {
+		if !IsValid(c) {
+			t.Errorf("IsValid(%q) = false", c)
+		}
+	}
+	for _, c := range []Calendar{"", "FR", "de", "fr-975"} {
+		if IsValid(c) {
+			t.Errorf("IsValid(%q) = true", c)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package telephony
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeProvider records the calls placed instead of dialing, for tests and local
+// development. Set Err to make calls fail.
+type FakeProvider struct {
+	mu    sync.Mutex
+	calls []CallRequest
+	Err   error
+}
+
+// NewFakeProvider creates a fake provider
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+// PlaceCall records the call and returns a fake SID
+func (p *FakeProvider) PlaceCall(ctx context.Context, req CallRequest) (*Call, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Err != nil {
+		return nil, p.Err
+	}
+	if _, err := TwiML(req); err != nil {
+		return nil, err
+	}
+	p.calls = append(p.calls, req)
+	return &Call{
+		SID:    fmt.Sprintf("CAFAKE%026d", len(p.calls)),
+		Status: "queued",
+	}, nil
+}
+
+// Calls returns the calls placed so far
+func (p *FakeProvider) Calls() []CallRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]CallRequest(nil), p.calls...)
+}
@@ -0,0 +1,70 @@
+package telephony
+
+import (
+	"context"
+	"fmt"
+)
+
+// Provider places automated outbound phone calls
+type Provider interface {
+	PlaceCall(ctx context.Context, req CallRequest) (*Call, error)
+}
+
+// CallRequest describes an outbound call: the script is played when the callee
+// answers, then a single keypress is collected and posted to GatherURL
+type CallRequest struct {
+	To       string
+	AudioURL string // Recorded script; Text is read by the provider when empty
+	Text     string
+	Language string // BCP 47 tag used to read Text and Prompt, e.g. fr-FR
+
+	Prompt    string // Read after the script to invite a keypress
+	GatherURL string // Receives the keypress (Digits)
+
+	StatusCallbackURL    string // Receives the call outcome and duration
+	Record               bool
+	RecordingCallbackURL string // Receives the recording URL once available
+}
+
+// Call is a call accepted by the provider
+type Call struct {
+	SID    string `json:"sid"`
+	Status string `json:"status"`
+}
+
+// Call outcomes reported by status callbacks
+const (
+	StatusCompleted = "completed" // Answered and finished
+	StatusBusy      = "busy"
+	StatusNoAnswer  = "no-answer"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+// Answered reports whether a final call status means the callee picked up
+func Answered(status string) bool {
+	return status == StatusCompleted
+}
+
+// Final reports whether a call status is an outcome rather than a progress step
+func Final(status string) bool {
+	switch status {
+	case StatusCompleted, StatusBusy, StatusNoAnswer, StatusFailed, StatusCanceled:
+		return true
+	}
+	return false
+}
+
+// NewProvider returns the provider named name ("twilio" or "fake"), or nil when name
+// is empty and calls are disabled
+func NewProvider(name, accountSID, authToken, phoneNumber string) (Provider, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "twilio":
+		return NewTwilioClient(accountSID, authToken, phoneNumber), nil
+	case "fake":
+		return NewFakeProvider(), nil
+	}
+	return nil, fmt.Errorf("unknown telephony provider %q", name)
+}
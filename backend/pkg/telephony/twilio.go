@@ -0,0 +1,152 @@
+package telephony
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TwilioClient places calls with the Twilio Programmable Voice API
+type TwilioClient struct {
+	accountSID  string
+	authToken   string
+	phoneNumber string
+	baseURL     string
+	httpClient  *http.Client
+}
+
+// NewTwilioClient creates a new Twilio Voice client calling from phoneNumber
+func NewTwilioClient(accountSID, authToken, phoneNumber string) *TwilioClient {
+	return &TwilioClient{
+		accountSID:  accountSID,
+		authToken:   authToken,
+		phoneNumber: phoneNumber,
+		baseURL:     fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Calls.json", accountSID),
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// PlaceCall starts the call with its TwiML inline, so no extra request is needed
+// when the callee answers
+func (c *TwilioClient) PlaceCall(ctx context.Context, req CallRequest) (*Call, error) {
+	twiml, err := TwiML(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("To", req.To)
+	data.Set("From", c.phoneNumber)
+	data.Set("Twiml", twiml)
+	if req.StatusCallbackURL != "" {
+		data.Set("StatusCallback", req.StatusCallbackURL)
+		data.Set("StatusCallbackEvent", "completed")
+	}
+	if req.Record {
+		data.Set("Record", "true")
+		if req.RecordingCallbackURL != "" {
+			data.Set("RecordingStatusCallback", req.RecordingCallbackURL)
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.SetBasicAuth(c.accountSID, c.authToken)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("Twilio API error %d: %s", apiErr.Code, apiErr.Message)
+		}
+		return nil, fmt.Errorf("Twilio API error: status %d", resp.StatusCode)
+	}
+
+	var call Call
+	if err := json.Unmarshal(body, &call); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &call, nil
+}
+
+type twimlResponse struct {
+	XMLName xml.Name     `xml:"Response"`
+	Gather  *twimlGather `xml:"Gather,omitempty"`
+	Play    string       `xml:"Play,omitempty"`
+	Say     *twimlSay    `xml:"Say,omitempty"`
+}
+
+type twimlGather struct {
+	Action    string     `xml:"action,attr"`
+	Method    string     `xml:"method,attr"`
+	NumDigits int        `xml:"numDigits,attr"`
+	Timeout   int        `xml:"timeout,attr"`
+	Play      string     `xml:"Play,omitempty"`
+	Say       []twimlSay `xml:"Say"`
+}
+
+type twimlSay struct {
+	Language string `xml:"language,attr,omitempty"`
+	Text     string `xml:",chardata"`
+}
+
+// TwiML returns the instructions of a call: the script, then the prompt inside a
+// Gather so that the callee can answer with a key at any time
+func TwiML(req CallRequest) (string, error) {
+	if req.AudioURL == "" && req.Text == "" {
+		return "", fmt.Errorf("call has no script")
+	}
+
+	var resp twimlResponse
+	if req.GatherURL == "" {
+		if req.AudioURL != "" {
+			resp.Play = req.AudioURL
+		} else {
+			resp.Say = &twimlSay{Language: req.Language, Text: req.Text}
+		}
+	} else {
+		g := &twimlGather{Action: req.GatherURL, Method: http.MethodPost, NumDigits: 1, Timeout: 8}
+		if req.AudioURL != "" {
+			g.Play = req.AudioURL
+		} else {
+			g.Say = append(g.Say, twimlSay{Language: req.Language, Text: req.Text})
+		}
+		if req.Prompt != "" {
+			g.Say = append(g.Say, twimlSay{Language: req.Language, Text: req.Prompt})
+		}
+		resp.Gather = g
+	}
+
+	out, err := xml.Marshal(resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to build TwiML: %w", err)
+	}
+	return xml.Header + string(out), nil
+}
+
+// SayTwiML returns instructions reading text, then hanging up
+func SayTwiML(text, language string) string {
+	out, _ := xml.Marshal(twimlResponse{Say: &twimlSay{Language: language, Text: text}})
+	return xml.Header + string(out)
+}
@@ -1,19 +1,28 @@
 package whatsapp
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Client interface for WhatsApp operations
+// requestTimeout bounds a call to the API of a provider, media downloads included,
+// so that a stalled call does not block its worker
+const requestTimeout = time.Minute
+
+// Client interface for WhatsApp operations. A send is abandoned when ctx is done.
 type Client interface {
-	SendText(to, body string) (*MessageResponse, error)
-	SendVoice(to, audioURL string) (*MessageResponse, error)
-	SendTemplate(to, template string, params []string) (*MessageResponse, error)
-	SendInteractive(to string, interactive InteractiveMessage) (*MessageResponse, error)
+	SendText(ctx context.Context, to, body string) (*MessageResponse, error)
+	SendVoice(ctx context.Context, to, audioURL string) (*MessageResponse, error)
+	SendTemplate(ctx context.Context, to, template string, params []string) (*MessageResponse, error)
+	SendInteractive(ctx context.Context, to string, interactive InteractiveMessage) (*MessageResponse, error)
 }
 
 // MessageResponse represents the Twilio API response
@@ -22,26 +31,60 @@ type MessageResponse struct {
 	Status     string `json:"status"`
 }
 
+// APIError is an error returned by the API of a provider
+type APIError struct {
+	Provider   string // ProviderTwilio or ProviderMeta
+	StatusCode int    // HTTP status
+	Code       int    `json:"code"` // Provider error code
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s API error: status %d", e.Provider, e.StatusCode)
+	}
+	return fmt.Sprintf("%s API error %d: %s", e.Provider, e.Code, e.Message)
+}
+
+// metaTransientCodes are the Meta error codes returned with a 400 status that a
+// later attempt may overcome: throttling and temporary unavailability
+var metaTransientCodes = map[int]bool{
+	1: true, 2: true, 4: true, 80007: true, 130429: true, 131000: true, 131016: true, 131056: true,
+}
+
+// Permanent reports whether sending again cannot succeed: the request was
+// rejected (invalid number, unknown template...) rather than throttled or failed
+func (e *APIError) Permanent() bool {
+	if e.Provider == ProviderMeta && metaTransientCodes[e.Code] {
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusTooManyRequests && e.StatusCode != http.StatusUnauthorized
+}
+
 // InteractiveMessage for buttons/lists
 type InteractiveMessage struct {
-	Type    string   `json:"type"`
-	Body    string   `json:"body"`
-	Buttons []Button `json:"buttons,omitempty"`
+	Type      string   `json:"type"`
+	Body      string   `json:"body"`
+	Buttons   []Button `json:"buttons,omitempty"`
+	ListLabel string   `json:"list_label,omitempty"` // Opens the list when there are more than 3 buttons
 }
 
 // Button for interactive messages
 type Button struct {
-	ID    string `json:"id"`
-	Title string `json:"title"`
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"` // Shown in lists only
 }
 
 // TwilioClient implements Client using Twilio API
 type TwilioClient struct {
-	accountSID  string
-	authToken   string
-	phoneNumber string
-	baseURL     string
-	httpClient  *http.Client
+	accountSID     string
+	authToken      string
+	phoneNumber    string
+	baseURL        string
+	statusCallback string // URL of the delivery status callbacks, none when empty
+	httpClient     *http.Client
 }
 
 // NewTwilioClient creates a new Twilio WhatsApp client
@@ -51,61 +94,92 @@ func NewTwilioClient(accountSID, authToken, phoneNumber string) *TwilioClient {
 		authToken:   authToken,
 		phoneNumber: phoneNumber,
 		baseURL:     fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", accountSID),
-		httpClient:  &http.Client{},
+		httpClient:  &http.Client{Timeout: requestTimeout},
 	}
 }
 
+// SetStatusCallback sets the URL Twilio calls with the delivery statuses of every
+// message sent
+func (c *TwilioClient) SetStatusCallback(url string) {
+	c.statusCallback = url
+}
+
 // SendText sends a text message via WhatsApp
-func (c *TwilioClient) SendText(to, body string) (*MessageResponse, error) {
+func (c *TwilioClient) SendText(ctx context.Context, to, body string) (*MessageResponse, error) {
 	data := url.Values{}
 	data.Set("To", "whatsapp:"+to)
 	data.Set("From", "whatsapp:"+c.phoneNumber)
 	data.Set("Body", body)
 
-	return c.makeRequest(data)
+	return c.makeRequest(ctx, data)
 }
 
 // SendVoice sends a voice message via WhatsApp
-func (c *TwilioClient) SendVoice(to, audioURL string) (*MessageResponse, error) {
+func (c *TwilioClient) SendVoice(ctx context.Context, to, audioURL string) (*MessageResponse, error) {
 	data := url.Values{}
 	data.Set("To", "whatsapp:"+to)
 	data.Set("From", "whatsapp:"+c.phoneNumber)
 	data.Set("MediaUrl", audioURL)
 	data.Set("Body", "🎙️ Note vocale") // Twilio sometimes requires Body with MediaUrl
 
-	return c.makeRequest(data)
+	return c.makeRequest(ctx, data)
 }
 
 // SendTemplate sends a pre-approved WhatsApp template message
-func (c *TwilioClient) SendTemplate(to, templateName string, params []string) (*MessageResponse, error) {
-	// Build ContentSid for template or use content variables
+func (c *TwilioClient) SendTemplate(ctx context.Context, to, templateName string, params []string) (*MessageResponse, error) {
 	data := url.Values{}
 	data.Set("To", "whatsapp:"+to)
 	data.Set("From", "whatsapp:"+c.phoneNumber)
 
-	// For Twilio, templates are sent via Content API or as regular messages
-	// This is a simplified implementation
-	body := fmt.Sprintf("Template: %s, Params: %v", templateName, params)
-	data.Set("Body", body)
+	// Twilio templates are Content API templates: the name is their content SID
+	// (HX...) and the parameters fill the variables {{1}}, {{2}}...
+	data.Set("ContentSid", templateName)
+	if len(params) > 0 {
+		variables := make(map[string]string, len(params))
+		for i, p := range params {
+			variables[strconv.Itoa(i+1)] = p
+		}
+		encoded, err := json.Marshal(variables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal content variables: %w", err)
+		}
+		data.Set("ContentVariables", string(encoded))
+	}
 
-	return c.makeRequest(data)
+	return c.makeRequest(ctx, data)
 }
 
-// SendInteractive sends an interactive message with buttons
-func (c *TwilioClient) SendInteractive(to string, interactive InteractiveMessage) (*MessageResponse, error) {
-	// Twilio uses different approach for interactive messages
-	// This is a placeholder implementation
+// SendInteractive sends an interactive message with buttons. Twilio only delivers
+// quick replies from Content API templates: the buttons are listed in the text and
+// the client answers with their number.
+func (c *TwilioClient) SendInteractive(ctx context.Context, to string, interactive InteractiveMessage) (*MessageResponse, error) {
+	body := interactive.Body
+	if len(interactive.Buttons) > 0 {
+		body += "\n"
+		for i, b := range interactive.Buttons {
+			title := b.Title
+			if b.Description != "" {
+				title = b.Description
+			}
+			body += fmt.Sprintf("\n%d. %s", i+1, title)
+		}
+	}
+
 	data := url.Values{}
 	data.Set("To", "whatsapp:"+to)
 	data.Set("From", "whatsapp:"+c.phoneNumber)
-	data.Set("Body", interactive.Body)
+	data.Set("Body", body)
 
-	return c.makeRequest(data)
+	return c.makeRequest(ctx, data)
 }
 
 // makeRequest makes an HTTP request to Twilio
-func (c *TwilioClient) makeRequest(data url.Values) (*MessageResponse, error) {
-	req, err := http.NewRequest("POST", c.baseURL, strings.NewReader(data.Encode()))
+func (c *TwilioClient) makeRequest(ctx context.Context, data url.Values) (*MessageResponse, error) {
+	if c.statusCallback != "" {
+		data.Set("StatusCallback", c.statusCallback)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -123,15 +197,48 @@ func (c *TwilioClient) makeRequest(data url.Values) (*MessageResponse, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("Twilio API error: status %d", resp.StatusCode)
+		apiErr := &APIError{Provider: ProviderTwilio, StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+		return nil, apiErr
 	}
 
-	// For now, return a mock response
-	// In production, parse the actual Twilio response
-	return &MessageResponse{
-		MessageSID: "SM" + fmt.Sprintf("%032d", 0), // Placeholder
-		Status:     "queued",
-	}, nil
+	// The message is accepted: an unreadable response must not make it sent twice,
+	// it only leaves the SID empty
+	result := MessageResponse{Status: "queued"}
+	_ = json.NewDecoder(resp.Body).Decode(&result)
+	return &result, nil
+}
+
+// DownloadMedia downloads a media received on the webhook, with the account credentials
+func (c *TwilioClient) DownloadMedia(ctx context.Context, media Media) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, media.URL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(c.accountSID, c.authToken)
+	return download(c.httpClient, req, media.ContentType)
+}
+
+// download reads the body of a media request and its content type, contentType
+// when the response has none
+func download(client *http.Client, req *http.Request, contentType string) ([]byte, string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download media: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("media download failed: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read media: %w", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		contentType = ct
+	}
+	return data, contentType, nil
 }
 
 // MockClient is a mock implementation for testing
@@ -143,7 +250,7 @@ func NewMockClient() *MockClient {
 }
 
 // SendText mock implementation
-func (c *MockClient) SendText(to, body string) (*MessageResponse, error) {
+func (c *MockClient) SendText(ctx context.Context, to, body string) (*MessageResponse, error) {
 	return &MessageResponse{
 		MessageSID: "MOCK_" + to,
 		Status:     "sent",
@@ -151,7 +258,7 @@ func (c *MockClient) SendText(to, body string) (*MessageResponse, error) {
 }
 
 // SendVoice mock implementation
-func (c *MockClient) SendVoice(to, audioURL string) (*MessageResponse, error) {
+func (c *MockClient) SendVoice(ctx context.Context, to, audioURL string) (*MessageResponse, error) {
 	return &MessageResponse{
 		MessageSID: "MOCK_VOICE_" + to,
 		Status:     "sent",
@@ -159,7 +266,7 @@ func (c *MockClient) SendVoice(to, audioURL string) (*MessageResponse, error) {
 }
 
 // SendTemplate mock implementation
-func (c *MockClient) SendTemplate(to, template string, params []string) (*MessageResponse, error) {
+func (c *MockClient) SendTemplate(ctx context.Context, to, template string, params []string) (*MessageResponse, error) {
 	return &MessageResponse{
 		MessageSID: "MOCK_TEMPLATE_" + to,
 		Status:     "sent",
@@ -167,7 +274,7 @@ func (c *MockClient) SendTemplate(to, template string, params []string) (*Messag
 }
 
 // SendInteractive mock implementation
-func (c *MockClient) SendInteractive(to string, interactive InteractiveMessage) (*MessageResponse, error) {
+func (c *MockClient) SendInteractive(ctx context.Context, to string, interactive InteractiveMessage) (*MessageResponse, error) {
 	return &MessageResponse{
 		MessageSID: "MOCK_INTERACTIVE_" + to,
 		Status:     "sent",
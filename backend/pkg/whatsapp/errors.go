@@ -0,0 +1,39 @@
+package whatsapp
+
+// errorDescriptions explain the provider error codes of failed deliveries that the
+// cabinet can act upon
+var errorDescriptions = map[string]map[string]string{
+	ProviderTwilio: {
+		"30003": "Recipient unreachable (phone off or out of coverage)",
+		"30005": "Unknown recipient number",
+		"30006": "Recipient number cannot receive messages",
+		"30007": "Message filtered by the carrier",
+		"30008": "Unknown delivery error",
+		"63003": "Recipient is not on WhatsApp",
+		"63016": "Outside the 24-hour session window, a template is required",
+		"63018": "WhatsApp rate limit exceeded",
+		"63024": "Invalid recipient",
+		"63049": "WhatsApp chose not to deliver this marketing message",
+	},
+	ProviderMeta: {
+		"130472": "Recipient is part of a WhatsApp experiment",
+		"131026": "Message undeliverable (recipient not on WhatsApp or outdated app)",
+		"131047": "Outside the 24-hour session window, a template is required",
+		"131049": "WhatsApp chose not to deliver this marketing message",
+		"131050": "Recipient stopped marketing messages",
+		"131051": "Unsupported message type",
+		"131053": "Media could not be uploaded",
+		"131056": "Too many messages to this recipient",
+		"132000": "Template parameters do not match",
+		"132001": "Template does not exist",
+	},
+}
+
+// ErrorDescription explains the error code of a failed delivery, fallback when
+// the code is unknown
+func ErrorDescription(provider, code, fallback string) string {
+	if d, ok := errorDescriptions[provider][code]; ok {
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,242 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InboundMessage is a message received from a client, whatever the provider
+type InboundMessage struct {
+	Provider  string
+	ID        string // Provider message ID
+	From      string // Phone of the client, with a leading +
+	To        string // Our number, Twilio only
+	Body      string // Text, media caption or title of the button pressed
+	ButtonID  string // Payload of the button pressed, empty for other messages
+	Media     []Media
+	Timestamp time.Time
+}
+
+// Media is a file attached to an inbound message. Twilio gives its URL, Meta its ID.
+type Media struct {
+	URL         string
+	ID          string
+	ContentType string
+}
+
+// StatusUpdate is a delivery status of an outbound message, whatever the provider
+type StatusUpdate struct {
+	Provider     string
+	MessageID    string // Provider message ID
+	Status       string // queued, sending, sent, delivered, read, failed, undelivered
+	ErrorCode    string
+	ErrorMessage string
+	Timestamp    time.Time
+}
+
+// Events are the messages and statuses of a webhook call
+type Events struct {
+	Messages []InboundMessage
+	Statuses []StatusUpdate
+}
+
+// MediaDownloader downloads the media of inbound messages
+type MediaDownloader interface {
+	DownloadMedia(ctx context.Context, media Media) (data []byte, contentType string, err error)
+}
+
+// normalizePhone returns a phone number with its whatsapp: prefix removed and a leading +
+func normalizePhone(phone string) string {
+	phone = strings.TrimPrefix(phone, "whatsapp:")
+	if phone != "" && !strings.HasPrefix(phone, "+") {
+		phone = "+" + phone
+	}
+	return phone
+}
+
+// maxTwilioMedia is the number of media Twilio attaches at most to a message
+const maxTwilioMedia = 10
+
+// ParseTwilioMessage reads an inbound message from the form of a Twilio webhook.
+// Without a valid NumMedia, the first media is still read.
+func ParseTwilioMessage(form url.Values) InboundMessage {
+	msg := InboundMessage{
+		Provider:  ProviderTwilio,
+		ID:        form.Get("MessageSid"),
+		From:      normalizePhone(form.Get("From")),
+		To:        normalizePhone(form.Get("To")),
+		Body:      form.Get("Body"),
+		ButtonID:  form.Get("ButtonPayload"),
+		Timestamp: time.Now(),
+	}
+	if msg.ButtonID != "" && form.Get("ButtonText") != "" {
+		msg.Body = form.Get("ButtonText")
+	}
+	n, err := strconv.Atoi(form.Get("NumMedia"))
+	if err != nil || n < 0 {
+		n = 1
+	}
+	n = min(n, maxTwilioMedia)
+	for i := 0; i < n; i++ {
+		u := form.Get(fmt.Sprintf("MediaUrl%d", i))
+		if u == "" {
+			continue
+		}
+		msg.Media = append(msg.Media, Media{URL: u, ContentType: form.Get(fmt.Sprintf("MediaContentType%d", i))})
+	}
+	return msg
+}
+
+// ParseTwilioStatus reads a delivery status from the form of a Twilio status callback
+func ParseTwilioStatus(form url.Values) StatusUpdate {
+	u := StatusUpdate{
+		Provider:     ProviderTwilio,
+		MessageID:    form.Get("MessageSid"),
+		Status:       form.Get("MessageStatus"),
+		ErrorCode:    form.Get("ErrorCode"),
+		ErrorMessage: form.Get("ErrorMessage"),
+		Timestamp:    time.Now(),
+	}
+	if u.MessageID == "" {
+		u.MessageID = form.Get("SmsSid")
+	}
+	return u
+}
+
+// metaWebhook is the payload of a Cloud API webhook
+type metaWebhook struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		Changes []struct {
+			Field string `json:"field"`
+			Value struct {
+				Messages []metaInbound      `json:"messages"`
+				Statuses []metaStatusUpdate `json:"statuses"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type metaInbound struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Text      struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Image       *metaMedia `json:"image"`
+	Document    *metaMedia `json:"document"`
+	Audio       *metaMedia `json:"audio"`
+	Video       *metaMedia `json:"video"`
+	Sticker     *metaMedia `json:"sticker"`
+	Interactive struct {
+		ButtonReply Button `json:"button_reply"`
+		ListReply   Button `json:"list_reply"`
+	} `json:"interactive"`
+	Button struct {
+		Payload string `json:"payload"`
+		Text    string `json:"text"`
+	} `json:"button"`
+}
+
+type metaMedia struct {
+	ID       string `json:"id"`
+	MimeType string `json:"mime_type"`
+	Caption  string `json:"caption"`
+}
+
+type metaStatusUpdate struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+	Errors    []struct {
+		Code    int    `json:"code"`
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// ParseMetaWebhook reads the messages and statuses of a Cloud API webhook body
+func ParseMetaWebhook(body []byte) (*Events, error) {
+	var payload metaWebhook
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid Meta webhook payload: %w", err)
+	}
+
+	events := &Events{}
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			if change.Field != "messages" {
+				continue
+			}
+			for _, m := range change.Value.Messages {
+				events.Messages = append(events.Messages, m.message())
+			}
+			for _, s := range change.Value.Statuses {
+				events.Statuses = append(events.Statuses, s.update())
+			}
+		}
+	}
+	return events, nil
+}
+
+func (m metaInbound) message() InboundMessage {
+	msg := InboundMessage{
+		Provider:  ProviderMeta,
+		ID:        m.ID,
+		From:      normalizePhone(m.From),
+		Body:      m.Text.Body,
+		Timestamp: unixTime(m.Timestamp),
+	}
+	switch m.Type {
+	case "interactive":
+		reply := m.Interactive.ButtonReply
+		if reply.ID == "" {
+			reply = m.Interactive.ListReply
+		}
+		msg.ButtonID, msg.Body = reply.ID, reply.Title
+	case "button":
+		msg.ButtonID, msg.Body = m.Button.Payload, m.Button.Text
+	}
+	for _, media := range []*metaMedia{m.Image, m.Document, m.Audio, m.Video, m.Sticker} {
+		if media != nil {
+			msg.Media = append(msg.Media, Media{ID: media.ID, ContentType: media.MimeType})
+			if msg.Body == "" {
+				msg.Body = media.Caption
+			}
+		}
+	}
+	return msg
+}
+
+func (s metaStatusUpdate) update() StatusUpdate {
+	u := StatusUpdate{
+		Provider:  ProviderMeta,
+		MessageID: s.ID,
+		Status:    s.Status,
+		Timestamp: unixTime(s.Timestamp),
+	}
+	if len(s.Errors) > 0 {
+		u.ErrorCode = strconv.Itoa(s.Errors[0].Code)
+		u.ErrorMessage = s.Errors[0].Message
+		if u.ErrorMessage == "" {
+			u.ErrorMessage = s.Errors[0].Title
+		}
+	}
+	return u
+}
+
+// unixTime parses a Cloud API timestamp, now when it is invalid
+func unixTime(s string) time.Time {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Unix(sec, 0)
+}
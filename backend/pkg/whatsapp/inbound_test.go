@@ -0,0 +1,72 @@
+package whatsapp
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestParseTwilioMessage(t *testing.T) {
+	form := url.Values{
+		"MessageSid": {"MM1"},
+		"From":       {"whatsapp:+33600000000"},
+		"To":         {"whatsapp:+33100000000"},
+		"Body":       {"Voici les factures"},
+		"NumMedia":   {"3"},
+	}
+	for i := 0; i < 3; i++ {
+		form.Set(fmt.Sprintf("MediaUrl%d", i), fmt.Sprintf("https://api.twilio.com/media/ME%d", i))
+		form.Set(fmt.Sprintf("MediaContentType%d", i), "image/jpeg")
+	}
+	form.Set("MediaContentType2", "application/pdf")
+
+	msg := ParseTwilioMessage(form)
+	if msg.ID != "MM1" || msg.From != "+33600000000" || msg.To != "+33100000000" || msg.Body != "Voici les factures" {
+		t.Errorf("ParseTwilioMessage = %+v", msg)
+	}
+	if len(msg.Media) != 3 {
+		t.Fatalf("%d media, want 3", len(msg.Media))
+	}
+	for i, m := range msg.Media {
+		if want := fmt.Sprintf("https://api.twilio.com/media/ME%d", i); m.URL != want {
+			t.Errorf("media %d URL = %s, want %s", i, m.URL, want)
+		}
+	}
+	if msg.Media[0].ContentType != "image/jpeg" || msg.Media[2].ContentType != "application/pdf" {
+		t.Errorf("media content types = %s, %s", msg.Media[0].ContentType, msg.Media[2].ContentType)
+	}
+}
+
+func TestParseTwilioMessageMediaCount(t *testing.T) {
+	withMedia := func(numMedia string, urls int) url.Values {
+		form := url.Values{"MessageSid": {"MM1"}}
+		if numMedia != "" {
+			form.Set("NumMedia", numMedia)
+		}
+		for i := 0; i < urls; i++ {
+			form.Set(fmt.Sprintf("MediaUrl%d", i), fmt.Sprintf("https://api.twilio.com/media/ME%d", i))
+		}
+		return form
+	}
+
+	tests := []struct {
+		name string
+		form url.Values
+		want int
+	}{
+		{"no media", withMedia("0", 0), 0},
+		{"missing NumMedia", withMedia("", 1), 1},
+		{"invalid NumMedia", withMedia("deux", 2), 1},
+		{"missing NumMedia without media", withMedia("", 0), 0},
+		{"missing media URL skipped", withMedia("3", 2), 2},
+		{"beyond the Twilio limit", withMedia("15", 15), maxTwilioMedia},
+		{"negative NumMedia", withMedia("-1", 1), 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := len(ParseTwilioMessage(tc.form).Media); got != tc.want {
+				t.Errorf("%d media, want %d", got, tc.want)
+			}
+		})
+	}
+}
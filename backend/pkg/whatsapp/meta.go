@@ -0,0 +1,227 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMetaAPIVersion is the Graph API version used when none is configured
+const DefaultMetaAPIVersion = "v21.0"
+
+// MetaClient implements Client using the Meta WhatsApp Cloud API
+type MetaClient struct {
+	accessToken   string
+	phoneNumberID string
+	language      string // Language of templates
+	graphURL      string
+	httpClient    *http.Client
+}
+
+// NewMetaClient creates a new Meta Cloud API client sending from a phone number ID
+func NewMetaClient(accessToken, phoneNumberID, apiVersion, templateLanguage string) *MetaClient {
+	if apiVersion == "" {
+		apiVersion = DefaultMetaAPIVersion
+	}
+	if templateLanguage == "" {
+		templateLanguage = "fr"
+	}
+	return &MetaClient{
+		accessToken:   accessToken,
+		phoneNumberID: phoneNumberID,
+		language:      templateLanguage,
+		graphURL:      "https://graph.facebook.com/" + apiVersion,
+		httpClient:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// metaMessage is the body of a Cloud API message request
+type metaMessage struct {
+	MessagingProduct string           `json:"messaging_product"`
+	RecipientType    string           `json:"recipient_type"`
+	To               string           `json:"to"`
+	Type             string           `json:"type"`
+	Text             *metaText        `json:"text,omitempty"`
+	Audio            *metaLink        `json:"audio,omitempty"`
+	Template         *metaTemplate    `json:"template,omitempty"`
+	Interactive      *metaInteractive `json:"interactive,omitempty"`
+}
+
+type metaText struct {
+	Body string `json:"body"`
+}
+
+type metaLink struct {
+	Link string `json:"link"`
+}
+
+type metaTemplate struct {
+	Name       string              `json:"name"`
+	Language   metaLanguage        `json:"language"`
+	Components []metaTemplateBlock `json:"components,omitempty"`
+}
+
+type metaLanguage struct {
+	Code string `json:"code"`
+}
+
+type metaTemplateBlock struct {
+	Type       string          `json:"type"`
+	Parameters []metaParameter `json:"parameters"`
+}
+
+type metaParameter struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type metaInteractive struct {
+	Type   string     `json:"type"`
+	Body   metaText   `json:"body"`
+	Action metaAction `json:"action"`
+}
+
+type metaAction struct {
+	Buttons  []metaButton  `json:"buttons,omitempty"`
+	Button   string        `json:"button,omitempty"` // Label opening a list
+	Sections []metaSection `json:"sections,omitempty"`
+}
+
+type metaSection struct {
+	Rows []Button `json:"rows"`
+}
+
+type metaButton struct {
+	Type  string `json:"type"`
+	Reply Button `json:"reply"`
+}
+
+// SendText sends a text message via WhatsApp
+func (c *MetaClient) SendText(ctx context.Context, to, body string) (*MessageResponse, error) {
+	return c.send(ctx, to, metaMessage{Type: "text", Text: &metaText{Body: body}})
+}
+
+// SendVoice sends an audio message from a public URL
+func (c *MetaClient) SendVoice(ctx context.Context, to, audioURL string) (*MessageResponse, error) {
+	return c.send(ctx, to, metaMessage{Type: "audio", Audio: &metaLink{Link: audioURL}})
+}
+
+// SendTemplate sends a pre-approved template, params filling its body variables
+func (c *MetaClient) SendTemplate(ctx context.Context, to, templateName string, params []string) (*MessageResponse, error) {
+	tpl := &metaTemplate{Name: templateName, Language: metaLanguage{Code: c.language}}
+	if len(params) > 0 {
+		block := metaTemplateBlock{Type: "body"}
+		for _, p := range params {
+			block.Parameters = append(block.Parameters, metaParameter{Type: "text", Text: p})
+		}
+		tpl.Components = []metaTemplateBlock{block}
+	}
+	return c.send(ctx, to, metaMessage{Type: "template", Template: tpl})
+}
+
+// SendInteractive sends a message with reply buttons, as a list beyond 3 buttons
+// (10 at most)
+func (c *MetaClient) SendInteractive(ctx context.Context, to string, interactive InteractiveMessage) (*MessageResponse, error) {
+	msg := &metaInteractive{Type: "button", Body: metaText{Body: interactive.Body}}
+	if len(interactive.Buttons) > 3 {
+		msg.Type = "list"
+		msg.Action.Button = interactive.ListLabel
+		if msg.Action.Button == "" {
+			msg.Action.Button = "Options"
+		}
+		msg.Action.Sections = []metaSection{{Rows: interactive.Buttons}}
+		return c.send(ctx, to, metaMessage{Type: "interactive", Interactive: msg})
+	}
+	for _, b := range interactive.Buttons {
+		reply := Button{ID: b.ID, Title: b.Title}
+		msg.Action.Buttons = append(msg.Action.Buttons, metaButton{Type: "reply", Reply: reply})
+	}
+	return c.send(ctx, to, metaMessage{Type: "interactive", Interactive: msg})
+}
+
+// send posts a message to the phone number of the client
+func (c *MetaClient) send(ctx context.Context, to string, msg metaMessage) (*MessageResponse, error) {
+	msg.MessagingProduct = "whatsapp"
+	msg.RecipientType = "individual"
+	msg.To = strings.TrimPrefix(to, "+") // Cloud API numbers have no leading +
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	var result struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+	}
+	url := fmt.Sprintf("%s/%s/messages", c.graphURL, c.phoneNumberID)
+	if err := c.do(ctx, http.MethodPost, url, bytes.NewReader(body), &result); err != nil {
+		return nil, err
+	}
+	response := &MessageResponse{Status: "queued"}
+	if len(result.Messages) > 0 {
+		response.MessageSID = result.Messages[0].ID
+	}
+	return response, nil
+}
+
+// DownloadMedia downloads a media received on the webhook: its URL is first read
+// from the media ID, then downloaded with the access token
+func (c *MetaClient) DownloadMedia(ctx context.Context, media Media) ([]byte, string, error) {
+	var info struct {
+		URL      string `json:"url"`
+		MimeType string `json:"mime_type"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.graphURL+"/"+media.ID, nil, &info); err != nil {
+		return nil, "", fmt.Errorf("failed to get media URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, info.URL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	contentType := media.ContentType
+	if contentType == "" {
+		contentType = info.MimeType
+	}
+	return download(c.httpClient, req, contentType)
+}
+
+// do sends an authenticated Graph API request and decodes its JSON response into out
+func (c *MetaClient) do(ctx context.Context, method, url string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return &APIError{Provider: ProviderMeta, StatusCode: resp.StatusCode, Code: e.Error.Code, Message: e.Error.Message}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
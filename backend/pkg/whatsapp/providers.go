@@ -0,0 +1,74 @@
+package whatsapp
+
+import (
+	"fmt"
+	"time"
+)
+
+// SessionWindow is the customer service window opened by each message of a client:
+// outside of it, only approved templates are delivered
+const SessionWindow = 24 * time.Hour
+
+// Providers
+const (
+	ProviderTwilio = "twilio"
+	ProviderMeta   = "meta"
+)
+
+// Provider is a configured WhatsApp provider
+type Provider struct {
+	Name            string
+	Client          Client
+	Sender          string // Our WhatsApp number, throttled by the queue
+	SessionTemplate string // Approved template sent outside the session window, with the text as parameter
+}
+
+// Providers are the configured providers, by name
+type Providers struct {
+	byName   map[string]Provider
+	fallback string
+}
+
+// NewProviders creates an empty set of providers. fallback is the provider of
+// cabinets that did not choose one.
+func NewProviders(fallback string) *Providers {
+	return &Providers{byName: make(map[string]Provider), fallback: fallback}
+}
+
+// Register adds a provider
+func (p *Providers) Register(provider Provider) {
+	p.byName[provider.Name] = provider
+}
+
+// Get returns a provider, the fallback one for an empty name
+func (p *Providers) Get(name string) (Provider, error) {
+	if p == nil {
+		return Provider{}, fmt.Errorf("WhatsApp provider not configured")
+	}
+	if name == "" {
+		name = p.fallback
+	}
+	provider, ok := p.byName[name]
+	if !ok {
+		return Provider{}, fmt.Errorf("WhatsApp provider %q not configured", name)
+	}
+	return provider, nil
+}
+
+// Downloader returns the media downloader of a provider
+func (p *Providers) Downloader(name string) (MediaDownloader, error) {
+	provider, err := p.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := provider.Client.(MediaDownloader)
+	if !ok {
+		return nil, fmt.Errorf("WhatsApp provider %q cannot download media", provider.Name)
+	}
+	return d, nil
+}
+
+// Fallback returns the provider of cabinets that did not choose one
+func (p *Providers) Fallback() string {
+	return p.fallback
+}
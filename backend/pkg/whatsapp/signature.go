@@ -0,0 +1,54 @@
+package whatsapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ValidateTwilioSignature checks the X-Twilio-Signature of a webhook: the base64
+// HMAC-SHA1, keyed by the auth token, of the full URL called by Twilio followed by
+// every POST parameter name and value, sorted by name
+func ValidateTwilioSignature(authToken, callURL string, params url.Values, signature string) bool {
+	if authToken == "" || signature == "" {
+		return false
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(callURL))
+	for _, k := range keys {
+		for _, v := range params[k] {
+			mac.Write([]byte(k + v))
+		}
+	}
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// ValidateMetaSignature checks the X-Hub-Signature-256 of a webhook: "sha256="
+// followed by the hex HMAC-SHA256 of the raw body, keyed by the app secret
+func ValidateMetaSignature(appSecret string, body []byte, signature string) bool {
+	if appSecret == "" {
+		return false
+	}
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(strings.ToLower(sig)), []byte(expected))
+}
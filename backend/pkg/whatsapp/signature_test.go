@@ -0,0 +1,90 @@
+package whatsapp
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestValidateTwilioSignature(t *testing.T) {
+	// Example of the Twilio webhook security documentation
+	const (
+		token     = "12345"
+		callURL   = "https://mycompany.com/myapp.php?foo=1&bar=2"
+		signature = "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+	)
+	params := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	with := func(key, value string) url.Values {
+		p := url.Values{}
+		for k, v := range params {
+			p[k] = v
+		}
+		p.Set(key, value)
+		return p
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		url       string
+		params    url.Values
+		signature string
+		want      bool
+	}{
+		{"documented example", token, callURL, params, signature, true},
+		{"wrong token", "54321", callURL, params, signature, false},
+		{"other URL", token, "https://mycompany.com/myapp.php?foo=1&bar=3", params, signature, false},
+		{"http instead of https", token, "http://mycompany.com/myapp.php?foo=1&bar=2", params, signature, false},
+		{"tampered parameter", token, callURL, with("Digits", "1235"), signature, false},
+		{"added parameter", token, callURL, with("Extra", "1"), signature, false},
+		{"missing signature", token, callURL, params, "", false},
+		{"missing token", "", callURL, params, signature, false},
+		{"hex instead of base64", token, callURL, params, "d3f2824d1e832e92a4900ff26aecd9aa8d4dc0e4", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ValidateTwilioSignature(tc.token, tc.url, tc.params, tc.signature); got != tc.want {
+				t.Errorf("ValidateTwilioSignature = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateMetaSignature(t *testing.T) {
+	// X-Hub-Signature-256 example of the GitHub webhook documentation, the scheme Meta uses
+	const (
+		secret    = "It's a Secret to Everybody"
+		body      = "Hello, World!"
+		signature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	)
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      string
+		signature string
+		want      bool
+	}{
+		{"documented example", secret, body, signature, true},
+		{"upper case hex", secret, body, "sha256=757107EA0EB2509FC211221CCE984B8A37570B6D7586C22C46F4379C8B043E17", true},
+		{"wrong secret", "It's a Secret to Nobody", body, signature, false},
+		{"tampered body", secret, "Hello, World?", signature, false},
+		{"body with trailing newline", secret, body + "\n", signature, false},
+		{"missing prefix", secret, body, "757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17", false},
+		{"SHA-1 prefix", secret, body, "sha1=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17", false},
+		{"missing signature", secret, body, "", false},
+		{"missing secret", "", body, signature, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ValidateMetaSignature(tc.secret, []byte(tc.body), tc.signature); got != tc.want {
+				t.Errorf("ValidateMetaSignature = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -20,6 +20,10 @@ interface Client {
     siret?: string;
     notes?: string;
     whatsapp_opted_in: boolean;
+    whatsapp_session: {
+        open: boolean;
+        expires_at?: string;
+    };
     created_at: string;
 }
 
@@ -151,6 +155,21 @@ export default function ClientDetailPage() {
                                 <div className="font-medium">{client.phone || '-'}</div>
                             </div>
                         </div>
+                        <div className="flex items-center gap-3 text-sm">
+                            <div className="w-8 h-8 rounded-full bg-slate-100 flex items-center justify-center">
+                                <Clock className="w-4 h-4 text-slate-500" />
+                            </div>
+                            <div>
+                                <div className="text-muted-foreground text-xs uppercase tracking-wider">Session WhatsApp</div>
+                                {client.whatsapp_session?.open && client.whatsapp_session.expires_at ? (
+                                    <div className="font-medium text-green-700">
+                                        Ouverte jusqu&apos;au {new Date(client.whatsapp_session.expires_at).toLocaleString('fr-FR', { dateStyle: 'short', timeStyle: 'short' })}
+                                    </div>
+                                ) : (
+                                    <div className="font-medium text-amber-700">Fermée — envoi par modèle</div>
+                                )}
+                            </div>
+                        </div>
                     </div>
 
                     <div className="grid grid-cols-2 gap-4">
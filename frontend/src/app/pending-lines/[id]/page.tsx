@@ -97,6 +97,7 @@ export default function PendingLineDetailPage() {
     const [sending, setSending] = useState(false);
     const [customMessage, setCustomMessage] = useState('');
     const [messageType, setMessageType] = useState<'text' | 'voice'>('text');
+    const [withButtons, setWithButtons] = useState(true);
     const [activeTab, setActiveTab] = useState<'history' | 'action'>('action');
 
     // Modals
@@ -177,7 +178,7 @@ export default function PendingLineDetailPage() {
         }
     };
 
-    const sendRelance = async (immediate: boolean = false) => {
+    const sendRelance = async () => {
         setSending(true);
         try {
             const res = await fetch(`/api/v1/pending-lines/${id}/messages`, {
@@ -187,9 +188,8 @@ export default function PendingLineDetailPage() {
                     'Authorization': `Bearer ${token}`
                 },
                 body: JSON.stringify({
-                    message_type: messageType,
+                    message_type: messageType === 'text' && withButtons ? 'interactive' : messageType,
                     custom_message: customMessage || undefined,
-                    immediate,
                 }),
             });
 
@@ -500,22 +500,26 @@ export default function PendingLineDetailPage() {
                                         )}
                                     </div>
 
+                                    {messageType === 'text' && (
+                                        <label className="flex items-center gap-2 mb-4 text-sm text-[#1A1A1A]/70 cursor-pointer">
+                                            <input
+                                                type="checkbox"
+                                                checked={withButtons}
+                                                onChange={(e) => setWithButtons(e.target.checked)}
+                                                className="rounded border-[#1A1A1A]/20"
+                                            />
+                                            Boutons de réponse (J&apos;envoie le justificatif, Dépense personnelle, Pas de justificatif, Appelez-moi)
+                                        </label>
+                                    )}
+
                                     <div className="flex flex-col md:flex-row gap-3 md:gap-4">
                                         <button
-                                            onClick={() => sendRelance(false)}
-                                            disabled={sending}
-                                            className="w-full md:flex-1 py-3 md:py-4 bg-white border border-[#1A1A1A]/10 text-[#1A1A1A] font-medium rounded-xl hover:bg-[#F9F8F6] transition-all flex items-center justify-center gap-2 text-sm md:text-base"
-                                        >
-                                            <Clock size={18} />
-                                            Programmer (Anti-ban)
-                                        </button>
-                                        <button
-                                            onClick={() => sendRelance(true)}
+                                            onClick={() => sendRelance()}
                                             disabled={sending}
-                                            className="w-full md:flex-[2] py-3 md:py-4 bg-[#1A1A1A] text-white font-medium rounded-xl hover:bg-[#1A4D2E] transition-all flex items-center justify-center gap-2 shadow-xl hover:shadow-2xl hover:-translate-y-1 transform disabled:opacity-70 text-sm md:text-base"
+                                            className="w-full md:flex-1 py-3 md:py-4 bg-[#1A1A1A] text-white font-medium rounded-xl hover:bg-[#1A4D2E] transition-all flex items-center justify-center gap-2 shadow-xl hover:shadow-2xl hover:-translate-y-1 transform disabled:opacity-70 text-sm md:text-base"
                                         >
                                             <Send size={18} />
-                                            {sending ? 'Envoi...' : 'Envoyer Immédiatement'}
+                                            {sending ? 'Envoi...' : 'Programmer (Anti-ban)'}
                                         </button>
                                     </div>
                                 </motion.div>